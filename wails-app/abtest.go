@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxABTests is how many A/B tests are kept for win rates
+const maxABTests = 1000
+
+// Votes on an A/B test
+const (
+	VoteA   = "a"
+	VoteB   = "b"
+	VoteTie = "tie"
+)
+
+// ABTest is a prompt answered by two providers, with the user's preference
+type ABTest struct {
+	ID     string           `json:"id"`
+	Prompt string           `json:"prompt"`
+	A      ComparisonResult `json:"a"`
+	B      ComparisonResult `json:"b"`
+	// Winner is "a", "b" or "tie", and empty until voted on
+	Winner    string    `json:"winner,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	VotedAt   time.Time `json:"votedAt,omitempty"`
+}
+
+// ModelWinRate is how a model fared in voted A/B tests
+type ModelWinRate struct {
+	Model  string `json:"model"`
+	Wins   int    `json:"wins"`
+	Losses int    `json:"losses"`
+	Ties   int    `json:"ties"`
+	// WinRate counts ties as half a win, from 0 to 1
+	WinRate float64 `json:"winRate"`
+}
+
+// abTestStore keeps A/B tests, oldest first, in one JSON file
+type abTestStore struct {
+	mu    sync.Mutex
+	path  string
+	tests []ABTest
+}
+
+func newABTestStore() *abTestStore {
+	return &abTestStore{}
+}
+
+func (st *abTestStore) open(path string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.path = path
+	if err := readJSONFile(path, &st.tests); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (st *abTestStore) saveLocked() error {
+	if st.path == "" {
+		return nil
+	}
+	return writeJSONFile(st.path, st.tests)
+}
+
+func (st *abTestStore) add(t ABTest) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.tests = append(st.tests, t)
+	if over := len(st.tests) - maxABTests; over > 0 {
+		st.tests = append([]ABTest(nil), st.tests[over:]...)
+	}
+	return st.saveLocked()
+}
+
+// ABTest sends a prompt to two providers at once, as ComparePrompt does,
+// and returns both replies for the user to pick the better one with VoteABTest
+func (a *App) ABTest(prompt string, providerA string, providerB string) (ABTest, error) {
+	a.telemetry.recordFeature("ab_test")
+	if strings.TrimSpace(prompt) == "" {
+		return ABTest{}, fmt.Errorf("prompt is empty")
+	}
+	results, err := a.ComparePrompt(prompt, []string{providerA, providerB})
+	if err != nil {
+		return ABTest{}, err
+	}
+	if len(results) != 2 {
+		return ABTest{}, fmt.Errorf("an A/B test needs two different providers")
+	}
+	t := ABTest{ID: newID(), Prompt: prompt, A: results[0], B: results[1], CreatedAt: time.Now().UTC()}
+	if err := a.abTests.add(t); err != nil {
+		return ABTest{}, err
+	}
+	return t, nil
+}
+
+// VoteABTest records which reply of an A/B test the user prefers: "a", "b"
+// or "tie". Voting again replaces the earlier vote.
+func (a *App) VoteABTest(testID string, winner string) error {
+	winner = strings.ToLower(strings.TrimSpace(winner))
+	if winner != VoteA && winner != VoteB && winner != VoteTie {
+		return fmt.Errorf("vote %q is not a, b or tie", winner)
+	}
+	st := a.abTests
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for i := range st.tests {
+		if st.tests[i].ID == testID {
+			t := &st.tests[i]
+			if winner != VoteTie && (t.A.Error != "" || t.B.Error != "") {
+				return fmt.Errorf("a reply in this test failed; only a tie can be recorded")
+			}
+			t.Winner, t.VotedAt = winner, time.Now().UTC()
+			return st.saveLocked()
+		}
+	}
+	return fmt.Errorf("A/B test %q not found", testID)
+}
+
+// ListABTests returns the kept A/B tests, newest first
+func (a *App) ListABTests() []ABTest {
+	st := a.abTests
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	out := make([]ABTest, 0, len(st.tests))
+	for i := len(st.tests) - 1; i >= 0; i-- {
+		out = append(out, st.tests[i])
+	}
+	return out
+}
+
+// GetModelWinRates aggregates voted A/B tests by model, best first. Tests
+// between two providers of the same model count for neither.
+func (a *App) GetModelWinRates() []ModelWinRate {
+	st := a.abTests
+	st.mu.Lock()
+	rates := make(map[string]*ModelWinRate)
+	rate := func(model string) *ModelWinRate {
+		if rates[model] == nil {
+			rates[model] = &ModelWinRate{Model: model}
+		}
+		return rates[model]
+	}
+	for _, t := range st.tests {
+		if t.Winner == "" || t.A.Model == t.B.Model {
+			continue
+		}
+		ra, rb := rate(t.A.Model), rate(t.B.Model)
+		switch t.Winner {
+		case VoteA:
+			ra.Wins++
+			rb.Losses++
+		case VoteB:
+			rb.Wins++
+			ra.Losses++
+		default:
+			ra.Ties++
+			rb.Ties++
+		}
+	}
+	st.mu.Unlock()
+
+	out := make([]ModelWinRate, 0, len(rates))
+	for _, r := range rates {
+		r.WinRate = (float64(r.Wins) + float64(r.Ties)/2) / float64(r.Wins+r.Losses+r.Ties)
+		out = append(out, *r)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].WinRate != out[j].WinRate {
+			return out[i].WinRate > out[j].WinRate
+		}
+		return out[i].Model < out[j].Model
+	})
+	return out
+}
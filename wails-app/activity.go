@@ -0,0 +1,161 @@
+package main
+
+import (
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// activityRetentionDays is how many days of project activity are kept
+const activityRetentionDays = 365
+
+// projectActivity counts what happened in one workspace on one day
+type projectActivity struct {
+	Prompts int `json:"prompts"`
+	// Applied counts diffs, code blocks and approved changes written to files
+	Applied int `json:"applied"`
+	// DiffsProposed counts replies suggesting a diff, and DiffsApplied the
+	// diffs applied with ApplyDiff
+	DiffsProposed int `json:"diffsProposed,omitempty"`
+	DiffsApplied  int `json:"diffsApplied,omitempty"`
+}
+
+func (p *projectActivity) add(delta projectActivity) {
+	p.Prompts += delta.Prompts
+	p.Applied += delta.Applied
+	p.DiffsProposed += delta.DiffsProposed
+	p.DiffsApplied += delta.DiffsApplied
+}
+
+// ProjectUsage is one workspace's share of the activity in GetInsights
+type ProjectUsage struct {
+	Workspace string `json:"workspace"`
+	Prompts   int    `json:"prompts"`
+	Applied   int    `json:"applied"`
+}
+
+// activityStore keeps per-workspace counts by day in memory and persists
+// them to a JSON file when a path is configured
+type activityStore struct {
+	mu   sync.Mutex
+	path string
+	// days maps a local date to counts by workspace
+	days map[string]map[string]*projectActivity
+}
+
+func newActivityStore() *activityStore {
+	return &activityStore{days: make(map[string]map[string]*projectActivity)}
+}
+
+func (st *activityStore) open(path string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.path = path
+	var days map[string]map[string]*projectActivity
+	if err := readJSONFile(path, &days); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for date, projects := range days {
+		for workspace, p := range projects {
+			st.dayLocked(date, workspace).add(*p)
+		}
+	}
+	return nil
+}
+
+func (st *activityStore) dayLocked(date, workspace string) *projectActivity {
+	projects, ok := st.days[date]
+	if !ok {
+		projects = make(map[string]*projectActivity)
+		st.days[date] = projects
+	}
+	p, ok := projects[workspace]
+	if !ok {
+		p = &projectActivity{}
+		projects[workspace] = p
+	}
+	return p
+}
+
+// record adds delta to the counts of workspace today; activity outside a
+// workspace is not counted
+func (st *activityStore) record(workspace string, delta projectActivity) {
+	if workspace == "" {
+		return
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.dayLocked(time.Now().Format("2006-01-02"), workspace).add(delta)
+
+	cutoff := time.Now().AddDate(0, 0, -activityRetentionDays).Format("2006-01-02")
+	for date := range st.days {
+		if date < cutoff {
+			delete(st.days, date)
+		}
+	}
+	if st.path != "" {
+		if err := writeJSONFile(st.path, st.days); err != nil {
+			println("Error saving activity:", err.Error())
+		}
+	}
+}
+
+// projects sums the days on or after since by workspace, most prompts first
+func (st *activityStore) projects(since string) []ProjectUsage {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	totals := make(map[string]*ProjectUsage)
+	for date, projects := range st.days {
+		if date < since {
+			continue
+		}
+		for workspace, p := range projects {
+			u, ok := totals[workspace]
+			if !ok {
+				u = &ProjectUsage{Workspace: workspace}
+				totals[workspace] = u
+			}
+			u.Prompts += p.Prompts
+			u.Applied += p.Applied
+		}
+	}
+	out := make([]ProjectUsage, 0, len(totals))
+	for _, u := range totals {
+		out = append(out, *u)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Prompts != out[j].Prompts {
+			return out[i].Prompts > out[j].Prompts
+		}
+		return out[i].Workspace < out[j].Workspace
+	})
+	return out
+}
+
+// diffs sums the diffs proposed and applied in all workspaces on or after since
+func (st *activityStore) diffs(since string) DiffStats {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	var d DiffStats
+	for date, projects := range st.days {
+		if date < since {
+			continue
+		}
+		for _, p := range projects {
+			d.Proposed += p.DiffsProposed
+			d.Applied += p.DiffsApplied
+		}
+	}
+	if d.Proposed > 0 {
+		d.AcceptanceRate = float64(d.Applied) / float64(d.Proposed)
+	}
+	return d
+}
@@ -0,0 +1,52 @@
+package main
+
+// UsageDashboard holds the figures shown on the dashboard tab
+type UsageDashboard struct {
+	// Since is the first day covered; empty means all history
+	Since  string          `json:"since,omitempty"`
+	Totals DashboardTotals `json:"totals"`
+	// Days has one entry per day from Since (or the first day with
+	// activity) to today, days without activity included, for charting
+	Days []DayCount `json:"days"`
+	// Models are sorted by requests, most used first
+	Models []ModelUsage `json:"models"`
+}
+
+// DashboardTotals sums the dashboard period
+type DashboardTotals struct {
+	Prompts       int   `json:"prompts"`
+	Replies       int   `json:"replies"`
+	Conversations int   `json:"conversations"`
+	ActiveDays    int   `json:"activeDays"`
+	TokensIn      int64 `json:"tokensIn"`
+	TokensOut     int64 `json:"tokensOut"`
+	// AverageResponseChars and AverageResponseTokens are the mean length of an assistant reply
+	AverageResponseChars  float64 `json:"averageResponseChars"`
+	AverageResponseTokens float64 `json:"averageResponseTokens"`
+}
+
+// GetUsageDashboard aggregates prompts and replies per day, tokens per day,
+// the most used models and the average reply length over the last days days
+// of history (all history when days <= 0). Prompts and replies are counted
+// from the saved conversations; tokens and models come from the request
+// metrics, which keep 90 days.
+func (a *App) GetUsageDashboard(days int) UsageDashboard {
+	a.telemetry.recordFeature("usage_dashboard")
+
+	u := a.aggregateUsage(days)
+	out := UsageDashboard{
+		Since: u.sinceDate,
+		Totals: DashboardTotals{
+			Prompts:       u.prompts,
+			Replies:       u.replies,
+			Conversations: u.conversations,
+			ActiveDays:    len(u.activeDays()),
+			TokensIn:      u.tokensIn,
+			TokensOut:     u.tokensOut,
+		},
+		Days:   u.filledDays(),
+		Models: append([]ModelUsage{}, u.models...),
+	}
+	out.Totals.AverageResponseChars, out.Totals.AverageResponseTokens = u.lengths.averages()
+	return out
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// AppliedBlock is a code block written to a workspace file
+type AppliedBlock struct {
+	// Path is relative to the conversation's directory
+	Path string `json:"path"`
+	// Created is set when the file did not exist
+	Created bool `json:"created,omitempty"`
+	// Unchanged is set when the file already held the code, and nothing was written
+	Unchanged bool `json:"unchanged,omitempty"`
+	// BackupPath is the copy of the replaced file, next to it
+	BackupPath string `json:"backupPath,omitempty"`
+	Diff       string `json:"diff"`
+}
+
+// codeBlockFile returns the code in a fenced code block, or the text as it
+// is when it has no fence, and the path named in the fence's info string
+func codeBlockFile(block string) (code, path string) {
+	text := strings.TrimSpace(strings.ReplaceAll(block, "\r\n", "\n"))
+	if m := fencePattern.FindStringSubmatch(strings.SplitN(text, "\n", 2)[0]); m != nil {
+		path, _ = fencePath(m[2])
+	}
+	code = stripCodeFence(text)
+	if code != "" && !strings.HasSuffix(code, "\n") {
+		code += "\n"
+	}
+	return code, path
+}
+
+// backupPath returns a free name for a backup of target next to it
+func backupPath(target string) string {
+	base := target + "." + time.Now().Format("20060102-150405")
+	p := base + ".bak"
+	for i := 2; ; i++ {
+		if _, err := os.Lstat(p); os.IsNotExist(err) {
+			return p
+		}
+		p = fmt.Sprintf("%s-%d.bak", base, i)
+	}
+}
+
+// ApplyCodeBlock writes a code block from a reply to a file in the active
+// conversation's directory, replacing the file if it exists. The block may
+// keep its fence; path may be empty when the fence names the file, as in
+// "```go title=main.go". A replaced file is first copied next to itself as
+// <name>.<time>.bak.
+func (a *App) ApplyCodeBlock(block string, path string) (AppliedBlock, error) {
+	a.telemetry.recordFeature("apply_code_block")
+	code, named := codeBlockFile(block)
+	if strings.TrimSpace(code) == "" {
+		return AppliedBlock{}, fmt.Errorf("code block is empty")
+	}
+	if path == "" {
+		path = named
+	}
+	if path == "" {
+		return AppliedBlock{}, fmt.Errorf("no file to apply the code block to")
+	}
+	target, err := a.sandboxedPath("", path)
+	if err != nil {
+		return AppliedBlock{}, err
+	}
+	name := filepath.ToSlash(filepath.Clean(filepath.FromSlash(path)))
+	old, exists, err := a.currentText(target, name)
+	if err != nil {
+		return AppliedBlock{}, err
+	}
+
+	applied := AppliedBlock{Path: name, Created: !exists}
+	if exists && old == code {
+		applied.Unchanged = true
+		return applied, nil
+	}
+	perm := os.FileMode(0o644)
+	if exists {
+		info, err := os.Stat(target)
+		if err != nil {
+			return AppliedBlock{}, err
+		}
+		perm = info.Mode().Perm()
+		backup := backupPath(target)
+		if err := writeFileAtomic(backup, []byte(old), perm); err != nil {
+			return AppliedBlock{}, fmt.Errorf("back up %s: %v", name, err)
+		}
+		applied.BackupPath = filepath.ToSlash(filepath.Join(filepath.Dir(name), filepath.Base(backup)))
+		applied.Diff = unifiedDiff(name, old, code)
+	} else {
+		applied.Diff = labeledDiff("/dev/null", "b/"+name, "", code)
+	}
+	if _, err := mkdirAllTracked(filepath.Dir(target)); err != nil {
+		return AppliedBlock{}, err
+	}
+	if err := writeFileAtomic(target, []byte(code), perm); err != nil {
+		return AppliedBlock{}, fmt.Errorf("write %s: %v", name, err)
+	}
+	a.activity.record(a.sessionRoot(""), projectActivity{Applied: 1})
+	return applied, nil
+}
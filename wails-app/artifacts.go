@@ -0,0 +1,366 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ArtifactFile is one file of a multi-file artifact
+type ArtifactFile struct {
+	// Path is slash-separated and relative to the workspace
+	Path     string `json:"path"`
+	Language string `json:"language,omitempty"`
+	Content  string `json:"content"`
+	// Exists reports that writing the file would replace one in the workspace
+	Exists bool `json:"exists,omitempty"`
+}
+
+// Artifact is the set of files a response asks to create, with a tree
+// preview of where they will go
+type Artifact struct {
+	Files []ArtifactFile `json:"files"`
+	Tree  string         `json:"tree"`
+}
+
+var (
+	fencePattern = regexp.MustCompile("^(`{3,}|~{3,})(.*)$")
+	// title="path", filename=path, file: path
+	fenceAttrPattern = regexp.MustCompile(`(?:title|file(?:name)?|path)\s*[=:]\s*"?([^"\s]+)"?`)
+	// A file named in the line before a block: "### src/main.go", "**`main.go`**", "File: main.go"
+	headingPathPattern = regexp.MustCompile("^(?:#+\\s*|[-*]\\s+)?(?:(?:file(?:name)?|path)\\s*:\\s*)?[*_`]*([\\w.@+-]+(?:/[\\w.@+-]+)*)[*_`]*:?\\s*$")
+	// A first-line comment naming the file: "// file: main.go", "# filename: app.py"
+	commentPathPattern = regexp.MustCompile(`^\s*(?://|#|--|/\*|<!--)\s*(?:file(?:name)?|path)\s*:\s*(\S+?)\s*(?:\*/|-->)?\s*$`)
+)
+
+// extensionless names that are still clearly files
+var knownFileNames = map[string]bool{
+	"Dockerfile": true, "Makefile": true, "Procfile": true, "Gemfile": true,
+	"Rakefile": true, "LICENSE": true, "Jenkinsfile": true, "Vagrantfile": true,
+}
+
+// fileNamePattern matches names with an extension, and dotfiles
+var fileNamePattern = regexp.MustCompile(`^(?:[\w@+-][\w.@+-]*\.[A-Za-z0-9]+|\.[\w.-]+)$`)
+
+// looksLikePath reports whether s names a file rather than, say, a language
+func looksLikePath(s string) bool {
+	base := path.Base(s)
+	return knownFileNames[base] || fileNamePattern.MatchString(base)
+}
+
+// cleanArtifactPath normalizes a path named by the model, returning "" for
+// paths that are absolute or escape the workspace
+func cleanArtifactPath(p string) string {
+	p = strings.TrimPrefix(strings.Trim(p, "`'\""), "./")
+	if p == "" || !filepath.IsLocal(filepath.FromSlash(p)) {
+		return ""
+	}
+	return path.Clean(p)
+}
+
+// fencePath finds a file path in a code fence's info string, such as
+// "go:cmd/main.go", "go title=main.go", or just "main.go"
+func fencePath(info string) (path, language string) {
+	fields := strings.Fields(info)
+	if len(fields) == 0 {
+		return "", ""
+	}
+	language = fields[0]
+	if lang, p, ok := strings.Cut(language, ":"); ok && looksLikePath(p) {
+		return p, lang
+	}
+	if m := fenceAttrPattern.FindStringSubmatch(info); m != nil {
+		return m[1], language
+	}
+	for i, f := range fields {
+		if looksLikePath(f) {
+			if i == 0 {
+				language = ""
+			}
+			return f, language
+		}
+	}
+	return "", language
+}
+
+// parseArtifactFiles extracts the code blocks in text that are annotated with
+// a file name. A later block for the same path replaces an earlier one.
+func parseArtifactFiles(text string) []ArtifactFile {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	var files []ArtifactFile
+	seen := make(map[string]int)
+
+	for i := 0; i < len(lines); i++ {
+		m := fencePattern.FindStringSubmatch(lines[i])
+		if m == nil {
+			continue
+		}
+		fence := m[1]
+		p, language := fencePath(strings.TrimSpace(m[2]))
+
+		end := len(lines)
+		for j := i + 1; j < len(lines); j++ {
+			if line := strings.TrimSpace(lines[j]); strings.HasPrefix(line, fence) && strings.Trim(line, fence[:1]) == "" {
+				end = j
+				break
+			}
+		}
+		body := lines[i+1 : end]
+
+		if p == "" && i > 0 {
+			if hm := headingPathPattern.FindStringSubmatch(strings.TrimSpace(messageBefore(lines, i))); hm != nil && looksLikePath(hm[1]) {
+				p = hm[1]
+			}
+		}
+		if p == "" && len(body) > 0 {
+			if cm := commentPathPattern.FindStringSubmatch(body[0]); cm != nil {
+				p = cm[1]
+			}
+		}
+		i = end
+
+		if p = cleanArtifactPath(p); p == "" {
+			continue
+		}
+		f := ArtifactFile{Path: p, Language: language, Content: strings.Join(body, "\n") + "\n"}
+		if j, ok := seen[p]; ok {
+			files[j] = f
+			continue
+		}
+		seen[p] = len(files)
+		files = append(files, f)
+	}
+	return files
+}
+
+// renderFileTree draws slash-separated paths as an indented tree
+func renderFileTree(paths []string) string {
+	type node struct {
+		children map[string]*node
+	}
+	root := &node{children: map[string]*node{}}
+	for _, p := range paths {
+		n := root
+		for _, part := range strings.Split(p, "/") {
+			child, ok := n.children[part]
+			if !ok {
+				child = &node{children: map[string]*node{}}
+				n.children[part] = child
+			}
+			n = child
+		}
+	}
+
+	var b strings.Builder
+	var walk func(n *node, prefix string)
+	walk = func(n *node, prefix string) {
+		names := make([]string, 0, len(n.children))
+		for name := range n.children {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for i, name := range names {
+			child := n.children[name]
+			branch, indent := "├── ", "│   "
+			if i == len(names)-1 {
+				branch, indent = "└── ", "    "
+			}
+			if len(child.children) > 0 {
+				name += "/"
+			}
+			b.WriteString(prefix + branch + name + "\n")
+			walk(child, prefix+indent)
+		}
+	}
+	walk(root, "")
+	return b.String()
+}
+
+// ParseArtifact collects the filename-annotated code blocks in a response
+// into a file set, marking files that already exist in the workspace
+func (a *App) ParseArtifact(text string) Artifact {
+	files := parseArtifactFiles(text)
+	paths := make([]string, len(files))
+	for i := range files {
+		paths[i] = files[i].Path
+		if target, err := a.sandboxedPath("", files[i].Path); err == nil {
+			if _, err := os.Stat(target); err == nil {
+				files[i].Exists = true
+			}
+		}
+	}
+	return Artifact{Files: files, Tree: renderFileTree(paths)}
+}
+
+// fileChange is a file to write, or to remove when remove is set
+type fileChange struct {
+	// name is the path shown in errors
+	name    string
+	target  string
+	content string
+	perm    os.FileMode
+	remove  bool
+}
+
+// stagedFile tracks one file through commitFiles
+type stagedFile struct {
+	target string
+	temp   string
+	backup string
+	perm   os.FileMode
+}
+
+// commitFiles makes all of changes or none of them: every file is staged
+// next to its target first, then moved into place, and a failure restores
+// whatever had been replaced or removed
+func commitFiles(changes []fileChange) error {
+	staged := make([]*stagedFile, 0, len(changes))
+	var createdDirs []string
+	committed := 0
+	ok := false
+	defer func() {
+		if ok {
+			return
+		}
+		for i := committed - 1; i >= 0; i-- {
+			s := staged[i]
+			if s.backup != "" {
+				os.Rename(s.backup, s.target)
+			} else {
+				os.Remove(s.target)
+			}
+		}
+		for _, s := range staged[committed:] {
+			if s.temp != "" {
+				os.Remove(s.temp)
+			}
+		}
+		for i := len(createdDirs) - 1; i >= 0; i-- {
+			os.Remove(createdDirs[i])
+		}
+	}()
+
+	for _, c := range changes {
+		s := &stagedFile{target: c.target, perm: c.perm}
+		if _, err := os.Lstat(c.target); err == nil {
+			s.backup = filepath.Join(filepath.Dir(c.target), "."+filepath.Base(c.target)+".bak-"+newID())
+		} else if c.remove {
+			continue
+		}
+		staged = append(staged, s)
+		if c.remove {
+			continue
+		}
+
+		dirs, err := mkdirAllTracked(filepath.Dir(c.target))
+		createdDirs = append(createdDirs, dirs...)
+		if err != nil {
+			return err
+		}
+		tmp, err := os.CreateTemp(filepath.Dir(c.target), "."+filepath.Base(c.target)+".tmp-*")
+		if err != nil {
+			return err
+		}
+		s.temp = tmp.Name()
+		_, err = tmp.WriteString(c.content)
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Chmod(s.temp, s.perm)
+		}
+		if err != nil {
+			return fmt.Errorf("write %s: %v", c.name, err)
+		}
+	}
+
+	for _, s := range staged {
+		if s.backup != "" {
+			if err := os.Rename(s.target, s.backup); err != nil {
+				return err
+			}
+		}
+		if s.temp != "" {
+			if err := os.Rename(s.temp, s.target); err != nil {
+				if s.backup != "" {
+					os.Rename(s.backup, s.target)
+				}
+				return err
+			}
+		}
+		committed++
+	}
+
+	ok = true
+	for _, s := range staged {
+		if s.backup != "" {
+			os.Remove(s.backup)
+		}
+	}
+	return nil
+}
+
+// WriteArtifactToWorkspace creates all files of an artifact in the workspace,
+// or the active conversation's directory when it is pinned to one, or none
+// of them: every file is staged next to its target first, then moved
+// into place, and a failure restores whatever had been replaced. Existing
+// files are only replaced when overwrite is set.
+func (a *App) WriteArtifactToWorkspace(files []ArtifactFile, overwrite bool) error {
+	if len(files) == 0 {
+		return fmt.Errorf("artifact has no files")
+	}
+
+	changes := make([]fileChange, 0, len(files))
+	seen := make(map[string]bool)
+	for _, f := range files {
+		target, err := a.sandboxedPath("", f.Path)
+		if err != nil {
+			return err
+		}
+		if seen[target] {
+			return fmt.Errorf("%s appears more than once", f.Path)
+		}
+		seen[target] = true
+
+		c := fileChange{name: f.Path, target: target, content: f.Content, perm: 0o644}
+		if info, err := os.Stat(target); err == nil {
+			if info.IsDir() {
+				return fmt.Errorf("%s is a directory", f.Path)
+			}
+			if !overwrite {
+				return fmt.Errorf("%s already exists", f.Path)
+			}
+			c.perm = info.Mode().Perm()
+		}
+		changes = append(changes, c)
+	}
+	return commitFiles(changes)
+}
+
+// mkdirAllTracked is os.MkdirAll that returns the directories it created,
+// outermost first, so they can be removed again
+func mkdirAllTracked(dir string) ([]string, error) {
+	var missing []string
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil {
+			break
+		}
+		missing = append(missing, d)
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+
+	var created []string
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err := os.Mkdir(missing[i], 0o755); err != nil && !os.IsExist(err) {
+			return created, err
+		}
+		created = append(created, missing[i])
+	}
+	return created, nil
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// AttachmentRef links a conversation to a stored attachment by content hash
+type AttachmentRef struct {
+	Hash     string    `json:"hash"`
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	MimeType string    `json:"mimeType,omitempty"`
+	AddedAt  time.Time `json:"addedAt"`
+	// Mode is "log" for files read through the log tool instead of the prompt
+	Mode string `json:"mode,omitempty"`
+}
+
+type attachmentIndex struct {
+	Refs  map[string]int   `json:"refs"`
+	Sizes map[string]int64 `json:"sizes"`
+}
+
+// attachmentStore keeps attachment contents content-addressed by SHA-256 under
+// dir/<first two hex chars>/<hash>, so identical files are stored once. Each
+// blob is reference counted and removed when its last reference is released.
+type attachmentStore struct {
+	mu    sync.Mutex
+	dir   string
+	index attachmentIndex
+}
+
+var hashPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+func newAttachmentStore() *attachmentStore {
+	return &attachmentStore{index: attachmentIndex{
+		Refs:  make(map[string]int),
+		Sizes: make(map[string]int64),
+	}}
+}
+
+func (as *attachmentStore) open(dir string) error {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	as.dir = dir
+
+	var idx attachmentIndex
+	if err := readJSONFile(filepath.Join(dir, "index.json"), &idx); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if idx.Refs != nil {
+		as.index.Refs = idx.Refs
+	}
+	if idx.Sizes != nil {
+		as.index.Sizes = idx.Sizes
+	}
+	return nil
+}
+
+func (as *attachmentStore) saveIndexLocked() error {
+	return writeJSONFile(filepath.Join(as.dir, "index.json"), as.index)
+}
+
+func (as *attachmentStore) blobPathLocked(hash string) string {
+	return filepath.Join(as.dir, hash[:2], hash)
+}
+
+// path returns where the blob for hash is stored on disk
+func (as *attachmentStore) path(hash string) (string, error) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	if !hashPattern.MatchString(hash) {
+		return "", fmt.Errorf("invalid attachment hash")
+	}
+	if as.index.Refs[hash] == 0 {
+		return "", fmt.Errorf("attachment %s not found", hash)
+	}
+	return as.blobPathLocked(hash), nil
+}
+
+// put stores the contents of r, adds a reference to it, and returns its hash and size
+func (as *attachmentStore) put(r io.Reader) (string, int64, error) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	if as.dir == "" {
+		return "", 0, fmt.Errorf("attachment store is not available")
+	}
+
+	tmp, err := os.CreateTemp(as.dir, ".upload-*")
+	if err != nil {
+		return "", 0, err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName)
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+
+	if as.index.Refs[hash] == 0 {
+		dest := as.blobPathLocked(hash)
+		if err := os.MkdirAll(filepath.Dir(dest), 0o700); err != nil {
+			return "", 0, err
+		}
+		if err := os.Rename(tmpName, dest); err != nil {
+			return "", 0, err
+		}
+	}
+
+	as.index.Refs[hash]++
+	as.index.Sizes[hash] = size
+	if err := as.saveIndexLocked(); err != nil {
+		return "", 0, err
+	}
+	return hash, size, nil
+}
+
+// retain adds a reference to an already stored blob
+func (as *attachmentStore) retain(hash string) error {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	if as.index.Refs[hash] == 0 {
+		return fmt.Errorf("attachment %s not found", hash)
+	}
+	as.index.Refs[hash]++
+	return as.saveIndexLocked()
+}
+
+// release drops a reference and deletes the blob once nothing refers to it
+func (as *attachmentStore) release(hash string) error {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	n, ok := as.index.Refs[hash]
+	if !ok {
+		return nil
+	}
+	if n > 1 {
+		as.index.Refs[hash] = n - 1
+		return as.saveIndexLocked()
+	}
+
+	delete(as.index.Refs, hash)
+	delete(as.index.Sizes, hash)
+	if err := os.Remove(as.blobPathLocked(hash)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return as.saveIndexLocked()
+}
+
+// AttachFile stores a file in the attachment store and attaches it to a session
+func (a *App) AttachFile(sessionID string, path string) (AttachmentRef, error) {
+	return a.attachPath(sessionID, path, "", "")
+}
+
+// attachPath stores the file at path and attaches it to a session under
+// name, which defaults to the file's base name
+func (a *App) attachPath(sessionID, path, name, mode string) (AttachmentRef, error) {
+	if _, ok := a.sessions.get(sessionID); !ok {
+		return AttachmentRef{}, fmt.Errorf("session %q not found", sessionID)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return AttachmentRef{}, err
+	}
+	defer f.Close()
+
+	hash, size, err := a.attachments.put(f)
+	if err != nil {
+		return AttachmentRef{}, err
+	}
+
+	if name == "" {
+		name = filepath.Base(path)
+	}
+	ref := AttachmentRef{
+		Hash:     hash,
+		Name:     name,
+		Size:     size,
+		MimeType: mime.TypeByExtension(filepath.Ext(name)),
+		AddedAt:  time.Now(),
+		Mode:     mode,
+	}
+	if _, err := a.sessions.update(sessionID, func(s *Session) error {
+		s.Attachments = append(s.Attachments, ref)
+		return nil
+	}); err != nil {
+		a.attachments.release(hash)
+		return AttachmentRef{}, err
+	}
+	return ref, nil
+}
+
+// DetachFile removes an attachment from a session, deleting its contents if
+// no other session still refers to them
+func (a *App) DetachFile(sessionID string, hash string) error {
+	found := false
+	if _, err := a.sessions.update(sessionID, func(s *Session) error {
+		for i, ref := range s.Attachments {
+			if ref.Hash == hash {
+				s.Attachments = append(s.Attachments[:i], s.Attachments[i+1:]...)
+				found = true
+				return nil
+			}
+		}
+		return fmt.Errorf("attachment %s not found in session", hash)
+	}); err != nil {
+		return err
+	}
+	if found {
+		return a.attachments.release(hash)
+	}
+	return nil
+}
+
+// ReadAttachment returns the contents of a stored attachment as text
+func (a *App) ReadAttachment(hash string) (string, error) {
+	path, err := a.attachments.path(hash)
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
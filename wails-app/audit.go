@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Sources of audited requests
+const (
+	auditSourceProvider  = "provider"
+	auditSourceTelemetry = "telemetry"
+	auditSourceSlack     = "slack"
+	auditSourceOAuth     = "oauth"
+	// auditSourceCleanRoom entries record clean-room changes and the
+	// requests clean-room mode refused
+	auditSourceCleanRoom = "clean-room"
+	// auditSourceServer entries are requests received by the local server
+	auditSourceServer = "local-server"
+)
+
+// AuditEntry records one request the app sent over the network, or one
+// its local server received. Payloads are kept only as a hash, so the trail
+// shows what was sent where without holding prompts or keys.
+type AuditEntry struct {
+	// Seq numbers entries from 1 in the order they were written
+	Seq       int64     `json:"seq"`
+	Timestamp time.Time `json:"timestamp"`
+	Source    string    `json:"source"`
+	Provider  string    `json:"provider,omitempty"`
+	Method    string    `json:"method"`
+	// Endpoint is the URL contacted, with credentials and query values redacted
+	Endpoint      string `json:"endpoint"`
+	PayloadBytes  int64  `json:"payloadBytes"`
+	PayloadSHA256 string `json:"payloadSha256"`
+	Status        int    `json:"status,omitempty"`
+	Error         string `json:"error,omitempty"`
+	// Token is the ID of the local server token the request was made for
+	Token string `json:"token,omitempty"`
+	// Hash chains the entry to the one before it: the SHA-256 of the
+	// previous entry's hash and this entry without its hash
+	Hash string `json:"hash"`
+}
+
+// chainHash returns the hash e should carry after prev
+func (e AuditEntry) chainHash(prev string) string {
+	e.Hash = ""
+	data, _ := json.Marshal(e)
+	sum := sha256.Sum256(append([]byte(prev), data...))
+	return hex.EncodeToString(sum[:])
+}
+
+// auditLog appends entries to a JSON-lines file that the app never
+// rewrites, rotates or clears. Until a path is configured nothing is kept.
+type auditLog struct {
+	mu   sync.Mutex
+	path string
+	seq  int64
+	last string
+}
+
+func newAuditLog() *auditLog {
+	return &auditLog{}
+}
+
+// open appends future entries to path, continuing the chain of any
+// entries already there
+func (l *auditLog) open(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	entries, err := readAuditFile(path)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.path = path
+	if n := len(entries); n > 0 {
+		l.seq, l.last = entries[n-1].Seq, entries[n-1].Hash
+	}
+	return nil
+}
+
+func (l *auditLog) add(e AuditEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.path == "" {
+		return
+	}
+
+	e.Seq = l.seq + 1
+	e.Hash = e.chainHash(l.last)
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		println("Error writing audit log:", err.Error())
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		println("Error writing audit log:", err.Error())
+		return
+	}
+	l.seq, l.last = e.Seq, e.Hash
+}
+
+func (l *auditLog) entries() ([]AuditEntry, error) {
+	l.mu.Lock()
+	path := l.path
+	l.mu.Unlock()
+	if path == "" {
+		return nil, nil
+	}
+	return readAuditFile(path)
+}
+
+func readAuditFile(path string) ([]AuditEntry, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var out []AuditEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var e AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return out, fmt.Errorf("audit log line %d: %v", len(out)+1, err)
+		}
+		out = append(out, e)
+	}
+	return out, scanner.Err()
+}
+
+// wrap returns a transport that audits every request sent through base
+func (l *auditLog) wrap(source, name string, base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = defaultTransport()
+	}
+	return &auditTransport{base: base, log: l, source: source, name: name}
+}
+
+type auditTransport struct {
+	base   http.RoundTripper
+	log    *auditLog
+	source string
+	name   string
+}
+
+func (t *auditTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	hash := sha256.New()
+	var size int64
+	if req.Body != nil {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		size = int64(len(body))
+		hash.Write(body)
+	}
+	e := AuditEntry{
+		Timestamp:     time.Now().UTC(),
+		Source:        t.source,
+		Provider:      t.name,
+		Method:        req.Method,
+		Endpoint:      redactEndpoint(req.URL.String()),
+		PayloadBytes:  size,
+		PayloadSHA256: hex.EncodeToString(hash.Sum(nil)),
+		Token:         serverTokenID(req.Context()),
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		e.Error = err.Error()
+	} else {
+		e.Status = resp.StatusCode
+	}
+	t.log.add(e)
+	return resp, err
+}
+
+// AuditVerification is the result of checking the audit trail's hash chain
+type AuditVerification struct {
+	Entries int  `json:"entries"`
+	Valid   bool `json:"valid"`
+	// BrokenAt is the sequence number of the first entry that was altered,
+	// removed or inserted, when the chain is broken
+	BrokenAt int64 `json:"brokenAt,omitempty"`
+}
+
+func verifyAuditEntries(entries []AuditEntry) AuditVerification {
+	v := AuditVerification{Entries: len(entries), Valid: true}
+	prev := ""
+	for i, e := range entries {
+		if e.Seq != int64(i+1) || e.Hash != e.chainHash(prev) {
+			v.Valid = false
+			v.BrokenAt = int64(i + 1)
+			break
+		}
+		prev = e.Hash
+	}
+	return v
+}
+
+// GetAuditLog returns up to limit entries of the outbound request audit
+// trail, newest first (limit <= 0 returns all)
+func (a *App) GetAuditLog(limit int) ([]AuditEntry, error) {
+	entries, err := a.audit.entries()
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 || limit > len(entries) {
+		limit = len(entries)
+	}
+	out := make([]AuditEntry, 0, limit)
+	for i := len(entries) - 1; i >= 0 && len(out) < limit; i-- {
+		out = append(out, entries[i])
+	}
+	return out, nil
+}
+
+// VerifyAuditLog checks that no audit entry has been altered, removed or
+// inserted since it was written
+func (a *App) VerifyAuditLog() (AuditVerification, error) {
+	entries, err := a.audit.entries()
+	if err != nil {
+		return AuditVerification{}, err
+	}
+	return verifyAuditEntries(entries), nil
+}
+
+// ExportAuditLog writes the whole audit trail to path as JSON lines, oldest
+// first, and returns the result of verifying it
+func (a *App) ExportAuditLog(path string) (AuditVerification, error) {
+	a.telemetry.recordFeature("export_audit_log")
+	entries, err := a.audit.entries()
+	if err != nil {
+		return AuditVerification{}, err
+	}
+	var buf bytes.Buffer
+	for _, e := range entries {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return AuditVerification{}, err
+		}
+		buf.Write(append(data, '\n'))
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o600); err != nil {
+		return AuditVerification{}, fmt.Errorf("export audit log: %v", err)
+	}
+	return verifyAuditEntries(entries), nil
+}
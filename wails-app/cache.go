@@ -0,0 +1,233 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxResponseCacheEntries = 500
+	// responseCacheTTL is how long a cached reply is served before it is asked for again
+	responseCacheTTL = 24 * time.Hour
+	// cachePromptPreview is how much of a prompt ListCachedResponses shows
+	cachePromptPreview = 200
+)
+
+// CachedResponse describes one reply in the response cache
+type CachedResponse struct {
+	Key        string    `json:"key"`
+	ProviderID string    `json:"providerId"`
+	Provider   string    `json:"provider"`
+	Model      string    `json:"model"`
+	Prompt     string    `json:"prompt"`
+	CreatedAt  time.Time `json:"createdAt"`
+	Hits       int       `json:"hits"`
+	Bytes      int       `json:"bytes"`
+}
+
+// ResponseCacheStats summarizes the response cache
+type ResponseCacheStats struct {
+	Enabled bool  `json:"enabled"`
+	Entries int   `json:"entries"`
+	Bytes   int64 `json:"bytes"`
+	Hits    int64 `json:"hits"`
+	Misses  int64 `json:"misses"`
+}
+
+type cachedReply struct {
+	info     CachedResponse
+	response string
+}
+
+// responseCache memoizes replies to identical requests in memory, evicting
+// the oldest entry when full
+type responseCache struct {
+	mu      sync.Mutex
+	entries map[string]*cachedReply
+	order   []string
+	hits    int64
+	misses  int64
+}
+
+func newResponseCache() *responseCache {
+	return &responseCache{entries: make(map[string]*cachedReply)}
+}
+
+// normalizePrompt irons out differences that do not change a prompt's
+// meaning: line endings and trailing whitespace. Indentation is kept since
+// it matters in code.
+func normalizePrompt(prompt string) string {
+	lines := strings.Split(strings.ReplaceAll(prompt, "\r\n", "\n"), "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimRight(l, " \t")
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// responseCacheKey identifies a request by provider, model, normalized
+// prompt and every parameter that affects the reply, the JSON schema of a
+// structured request included
+func responseCacheKey(config ProviderConfig, prompt string, temperature float64, maxTokens int, schema json.RawMessage) string {
+	params, _ := json.Marshal(struct {
+		Temperature float64
+		MaxTokens   int
+		TopP        *float64
+		TopK        int
+		Stop        []string
+		Schema      string
+	}{temperature, maxTokens, config.Defaults.TopP, config.Defaults.TopK, config.Defaults.Stop, string(schema)})
+	sum := sha256.Sum256([]byte(config.ID + "\x00" + config.Model + "\x00" + normalizePrompt(prompt) + "\x00" + string(params)))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *responseCache) get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok || time.Since(e.info.CreatedAt) > responseCacheTTL {
+		c.misses++
+		return "", false
+	}
+	c.hits++
+	e.info.Hits++
+	return e.response, true
+}
+
+func (c *responseCache) put(info CachedResponse, response string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[info.Key]; ok {
+		c.removeLocked(info.Key)
+	}
+	if len(c.order) >= maxResponseCacheEntries {
+		c.removeLocked(c.order[0])
+	}
+	info.Bytes = len(info.Prompt) + len(response)
+	if len(info.Prompt) > cachePromptPreview {
+		info.Prompt = truncateRunes(info.Prompt, cachePromptPreview)
+	}
+	c.entries[info.Key] = &cachedReply{info: info, response: response}
+	c.order = append(c.order, info.Key)
+}
+
+func (c *responseCache) removeLocked(key string) int {
+	e, ok := c.entries[key]
+	if !ok {
+		return 0
+	}
+	delete(c.entries, key)
+	for i, k := range c.order {
+		if k == key {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			break
+		}
+	}
+	return e.info.Bytes
+}
+
+// purge removes the entries match selects and returns how many entries
+// and bytes were removed
+func (c *responseCache) purge(match func(CachedResponse) bool) (int, int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	removed := 0
+	var bytes int64
+	for _, key := range append([]string(nil), c.order...) {
+		if match(c.entries[key].info) {
+			bytes += int64(c.removeLocked(key))
+			removed++
+		}
+	}
+	return removed, bytes
+}
+
+func (c *responseCache) list() []CachedResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	out := make([]CachedResponse, 0, len(c.entries))
+	for _, e := range c.entries {
+		out = append(out, e.info)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	return out
+}
+
+func (c *responseCache) stats() ResponseCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := ResponseCacheStats{Entries: len(c.entries), Hits: c.hits, Misses: c.misses}
+	for _, e := range c.entries {
+		s.Bytes += int64(e.info.Bytes)
+	}
+	return s
+}
+
+// truncateRunes shortens s to at most n runes without splitting a character
+func truncateRunes(s string, n int) string {
+	for i := range s {
+		if n == 0 {
+			return s[:i]
+		}
+		n--
+	}
+	return s
+}
+
+// responseCacheEnabled reports whether replies are served from the cache
+func (a *App) responseCacheEnabled() bool {
+	a.providersMutex.RLock()
+	defer a.providersMutex.RUnlock()
+	return a.cacheResponses
+}
+
+// SetResponseCacheEnabled turns the response cache on or off. While it is on,
+// a request identical to an earlier one (same provider, model, prompt and
+// parameters) is answered from the cache without calling the provider.
+func (a *App) SetResponseCacheEnabled(enabled bool) error {
+	a.providersMutex.Lock()
+	defer a.providersMutex.Unlock()
+
+	a.cacheResponses = enabled
+	return a.saveConfigLocked()
+}
+
+// GetResponseCacheStats reports the size and hit rate of the response cache
+func (a *App) GetResponseCacheStats() ResponseCacheStats {
+	s := a.responses.stats()
+	s.Enabled = a.responseCacheEnabled()
+	return s
+}
+
+// ListCachedResponses returns the cached replies, newest first, with prompts shortened
+func (a *App) ListCachedResponses() []CachedResponse {
+	return a.responses.list()
+}
+
+// PurgeResponseCache removes the cached replies of one provider, or all of
+// them when providerID is empty, and returns how many were removed
+func (a *App) PurgeResponseCache(providerID string) int {
+	a.telemetry.recordFeature("purge_response_cache")
+	removed, _ := a.responses.purge(func(e CachedResponse) bool {
+		return providerID == "" || e.ProviderID == providerID
+	})
+	return removed
+}
+
+// purgeExpiredResponses is the maintenance task for the response cache
+func (a *App) purgeExpiredResponses() (int64, string, error) {
+	removed, bytes := a.responses.purge(func(e CachedResponse) bool {
+		return time.Since(e.CreatedAt) > responseCacheTTL
+	})
+	return bytes, fmt.Sprintf("removed %d expired replies", removed), nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// errCancelled is returned by requests stopped with CancelPrompt
+var errCancelled = errors.New("request cancelled")
+
+// inflightPrompt is a prompt that can be cancelled
+type inflightPrompt struct {
+	cancel context.CancelFunc
+}
+
+// trackPrompt registers a cancellable prompt for a session. done must be
+// called when the prompt finishes.
+func (a *App) trackPrompt(sessionID string) (ctx context.Context, done func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &inflightPrompt{cancel: cancel}
+
+	a.inflightMutex.Lock()
+	if a.inflight == nil {
+		a.inflight = make(map[string]*inflightPrompt)
+	}
+	a.inflight[sessionID] = p
+	a.inflightMutex.Unlock()
+
+	return ctx, func() {
+		cancel()
+		a.inflightMutex.Lock()
+		if a.inflight[sessionID] == p {
+			delete(a.inflight, sessionID)
+		}
+		a.inflightMutex.Unlock()
+	}
+}
+
+// CancelPrompt stops the prompt in progress in a session, or in the active
+// session when sessionID is empty. The prompt returns "request cancelled"
+// and nothing is added to the conversation.
+func (a *App) CancelPrompt(sessionID string) error {
+	a.telemetry.recordFeature("cancel_prompt")
+	if sessionID == "" {
+		sessionID = a.GetActiveSession()
+	}
+
+	a.inflightMutex.Lock()
+	p, ok := a.inflight[sessionID]
+	a.inflightMutex.Unlock()
+	if !ok {
+		return fmt.Errorf("no prompt in progress")
+	}
+	p.cancel()
+	return nil
+}
+
+// sendCancellable is send that returns as soon as ctx is cancelled. Providers
+// do not take a context, so the abandoned call runs on until it finishes or
+// hits its read timeout, and anything it streams after that is dropped.
+// finished, when set, is called once the provider call has really ended.
+func (a *App) sendCancellable(ctx context.Context, provider Provider, req generateRequest, temperature float64, streamed *bool, finished func()) (string, error) {
+	if finished == nil {
+		finished = func() {}
+	}
+	if ctx == nil {
+		defer finished()
+		return a.send(provider, req, temperature, streamed)
+	}
+	if ctx.Err() != nil {
+		finished()
+		return "", errCancelled
+	}
+
+	// mu keeps a chunk from reaching the caller after the request returns
+	var mu sync.Mutex
+	if onChunk := req.OnChunk; onChunk != nil {
+		req.OnChunk = func(chunk string) {
+			mu.Lock()
+			defer mu.Unlock()
+			if ctx.Err() == nil {
+				onChunk(chunk)
+			}
+		}
+	}
+
+	type outcome struct {
+		response string
+		err      error
+		streamed bool
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		var o outcome
+		o.response, o.err = a.send(provider, req, temperature, &o.streamed)
+		finished()
+		done <- o
+	}()
+
+	select {
+	case o := <-done:
+		*streamed = o.streamed
+		return o.response, o.err
+	case <-ctx.Done():
+		mu.Lock()
+		defer mu.Unlock()
+		return "", errCancelled
+	}
+}
+
+// sleepContext waits for d, returning false early if ctx is cancelled
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	if ctx == nil {
+		time.Sleep(d)
+		return true
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Faults chaos mode and failover drills can inject into provider requests
+const (
+	// FaultTimeout fails a request as if the backend stopped responding
+	FaultTimeout = "timeout"
+	// FaultRateLimit answers with HTTP 429
+	FaultRateLimit = "rate-limit"
+	// FaultMalformed answers with a body that is not valid JSON
+	FaultMalformed = "malformed-json"
+)
+
+var chaosFaults = []string{FaultTimeout, FaultRateLimit, FaultMalformed}
+
+const (
+	// defaultChaosSeconds and maxChaosSeconds bound a chaos mode window
+	defaultChaosSeconds = 60
+	maxChaosSeconds     = 600
+	// drillProbeTimeout bounds each request of a failover drill, retries and
+	// fallbacks included
+	drillProbeTimeout = 2 * time.Minute
+)
+
+// ChaosOptions configure chaos mode
+type ChaosOptions struct {
+	// ProviderID limits the faults to one provider; empty means every provider
+	ProviderID string `json:"providerId,omitempty"`
+	// Faults are injected in turn; empty means all of them
+	Faults []string `json:"faults,omitempty"`
+	// Seconds is how long chaos mode lasts, 60 by default and at most 600
+	Seconds int `json:"seconds,omitempty"`
+}
+
+// ChaosStatus reports chaos mode
+type ChaosStatus struct {
+	Active     bool           `json:"active"`
+	ProviderID string         `json:"providerId,omitempty"`
+	Faults     []string       `json:"faults,omitempty"`
+	Until      time.Time      `json:"until,omitempty"`
+	Injected   map[string]int `json:"injected,omitempty"`
+}
+
+// chaosWindow is a period during which provider requests fail on purpose
+type chaosWindow struct {
+	providerID string
+	faults     []string
+	until      time.Time
+	next       int
+	injected   map[string]int
+}
+
+// chaosFault returns the fault to inject into a request to the provider,
+// or "" to send it as usual
+func (a *App) chaosFault(providerID string) string {
+	a.chaosMutex.Lock()
+	defer a.chaosMutex.Unlock()
+	w := a.chaos
+	if w == nil {
+		return ""
+	}
+	if time.Now().After(w.until) {
+		a.chaos = nil
+		return ""
+	}
+	if w.providerID != "" && w.providerID != providerID {
+		return ""
+	}
+	fault := w.faults[w.next%len(w.faults)]
+	w.next++
+	w.injected[fault]++
+	return fault
+}
+
+// chaosTransport fails requests to a provider while chaos mode covers it
+type chaosTransport struct {
+	app        *App
+	providerID string
+	timeout    time.Duration
+	base       http.RoundTripper
+}
+
+func (t *chaosTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	fault := t.app.chaosFault(t.providerID)
+	if fault == "" {
+		return t.base.RoundTrip(req)
+	}
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	switch fault {
+	case FaultTimeout:
+		// The same failure the read timeout reports, without the wait
+		return nil, fmt.Errorf("no response from server for %v (injected by chaos mode)", t.timeout)
+	case FaultRateLimit:
+		return chaosResponse(req, http.StatusTooManyRequests, `{"error":"rate limit exceeded (injected by chaos mode)"}`), nil
+	default:
+		return chaosResponse(req, http.StatusOK, `{"response": injected by chaos mode}`), nil
+	}
+}
+
+func chaosResponse(req *http.Request, status int, body string) *http.Response {
+	header := http.Header{"Content-Type": {"application/json"}}
+	if status == http.StatusTooManyRequests {
+		header.Set("Retry-After", "1")
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// wrapChaos lets chaos mode fail a provider's requests before they are sent
+func (a *App) wrapChaos(config ProviderConfig, base http.RoundTripper) http.RoundTripper {
+	_, read := providerTimeouts(config)
+	return &chaosTransport{app: a, providerID: config.ID, timeout: read, base: base}
+}
+
+// startChaos opens a chaos window, replacing any open one
+func (a *App) startChaos(providerID string, faults []string, d time.Duration) {
+	a.chaosMutex.Lock()
+	defer a.chaosMutex.Unlock()
+	a.chaos = &chaosWindow{providerID: providerID, faults: faults, until: time.Now().Add(d), injected: make(map[string]int)}
+}
+
+// validFaults checks faults, defaulting to all of them
+func validFaults(faults []string) ([]string, error) {
+	if len(faults) == 0 {
+		return append([]string(nil), chaosFaults...), nil
+	}
+	for _, f := range faults {
+		if !slices.Contains(chaosFaults, f) {
+			return nil, fmt.Errorf("unknown fault %q; use %s", f, strings.Join(chaosFaults, ", "))
+		}
+	}
+	return faults, nil
+}
+
+// StartChaosMode makes provider requests fail on purpose for a short window,
+// with timeouts, HTTP 429s and malformed JSON in turn, so retries, fallback
+// providers and error handling in the UI can be tried out by hand. It ends
+// by itself after opts.Seconds.
+func (a *App) StartChaosMode(opts ChaosOptions) (ChaosStatus, error) {
+	a.telemetry.recordFeature("chaos_mode")
+	faults, err := validFaults(opts.Faults)
+	if err != nil {
+		return ChaosStatus{}, err
+	}
+	if opts.ProviderID != "" {
+		if _, err := a.providerByID(opts.ProviderID); err != nil {
+			return ChaosStatus{}, err
+		}
+	}
+	seconds := opts.Seconds
+	if seconds <= 0 {
+		seconds = defaultChaosSeconds
+	}
+	seconds = min(seconds, maxChaosSeconds)
+	a.startChaos(opts.ProviderID, faults, time.Duration(seconds)*time.Second)
+	return a.GetChaosStatus(), nil
+}
+
+// StopChaosMode ends chaos mode early
+func (a *App) StopChaosMode() {
+	a.chaosMutex.Lock()
+	defer a.chaosMutex.Unlock()
+	a.chaos = nil
+}
+
+// GetChaosStatus reports whether chaos mode is on and what it has injected
+func (a *App) GetChaosStatus() ChaosStatus {
+	a.chaosMutex.Lock()
+	defer a.chaosMutex.Unlock()
+	w := a.chaos
+	if w == nil || time.Now().After(w.until) {
+		return ChaosStatus{}
+	}
+	injected := make(map[string]int, len(w.injected))
+	for f, n := range w.injected {
+		injected[f] = n
+	}
+	return ChaosStatus{Active: true, ProviderID: w.providerID, Faults: append([]string(nil), w.faults...), Until: w.until, Injected: injected}
+}
+
+// DrillCheck is one expectation of a failover drill
+type DrillCheck struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail"`
+}
+
+// DrillResult is how requests behaved under one fault
+type DrillResult struct {
+	Fault  string `json:"fault"`
+	Passed bool   `json:"passed"`
+	// Attempts is how often the request was sent to the failing provider
+	Attempts int `json:"attempts"`
+	// AnsweredBy is the provider that replied in the end, if any
+	AnsweredBy string       `json:"answeredBy,omitempty"`
+	Error      string       `json:"error,omitempty"`
+	DurationMs int64        `json:"durationMs"`
+	Checks     []DrillCheck `json:"checks"`
+}
+
+// DrillReport is the outcome of a failover drill
+type DrillReport struct {
+	Provider  string        `json:"provider"`
+	Fallbacks []string      `json:"fallbacks"`
+	StartedAt time.Time     `json:"startedAt"`
+	Passed    bool          `json:"passed"`
+	Results   []DrillResult `json:"results"`
+}
+
+// RunFailoverDrill checks that the configured failure handling works: for
+// each fault it makes the active provider fail that way, sends a short
+// request as a prompt would be sent, and checks that transient faults were
+// retried up to the provider's attempt limit and others not at all, that
+// the request then moved to the fallback providers if any are set, and
+// otherwise that a readable error came back, which is what the UI shows.
+// Only the active provider fails; fallback providers are called for real.
+// A "drill:done" event carries the report.
+func (a *App) RunFailoverDrill(faults []string) (DrillReport, error) {
+	a.telemetry.recordFeature("failover_drill")
+	faults, err := validFaults(faults)
+	if err != nil {
+		return DrillReport{}, err
+	}
+	provider, err := a.selectProvider("")
+	if err != nil {
+		return DrillReport{}, err
+	}
+	report := DrillReport{Provider: provider.GetName(), Fallbacks: []string{}, StartedAt: time.Now().UTC(), Passed: true}
+	for _, p := range a.fallbackChain(provider) {
+		report.Fallbacks = append(report.Fallbacks, p.GetName())
+	}
+
+	for _, fault := range faults {
+		r := a.drillFault(provider, fault, len(report.Fallbacks) > 0)
+		report.Passed = report.Passed && r.Passed
+		report.Results = append(report.Results, r)
+	}
+	a.emit(EventDrillDone, report)
+	return report, nil
+}
+
+// drillFault sends one request with the provider failing with fault
+func (a *App) drillFault(provider Provider, fault string, hasFallbacks bool) DrillResult {
+	config := provider.GetConfig()
+	r := DrillResult{Fault: fault}
+	ctx, cancel := context.WithTimeout(context.Background(), drillProbeTimeout)
+	defer cancel()
+
+	a.startChaos(config.ID, []string{fault}, drillProbeTimeout)
+	start := time.Now()
+	result, err := a.generate(generateRequest{
+		// A fresh prompt each time so the response cache can't answer it
+		Prompt:    fmt.Sprintf("Reply with the word OK. (Failover drill %s)", newID()),
+		MaxTokens: 5,
+		Context:   ctx,
+	})
+	r.DurationMs = time.Since(start).Milliseconds()
+	a.chaosMutex.Lock()
+	if a.chaos != nil {
+		r.Attempts = a.chaos.injected[fault]
+	}
+	a.chaos = nil
+	a.chaosMutex.Unlock()
+
+	// Timeouts and 429s are transient failures, worth retrying; malformed
+	// replies are not
+	want := 1
+	transient := fault != FaultMalformed
+	if transient {
+		want = maxAttempts(config)
+	}
+	retried := DrillCheck{Name: "retries", Passed: r.Attempts == want}
+	if transient {
+		retried.Detail = fmt.Sprintf("sent %d of %d attempts to %s", r.Attempts, want, provider.GetName())
+	} else {
+		retried.Detail = fmt.Sprintf("sent %d times to %s; a fault that is not transient should not be retried", r.Attempts, provider.GetName())
+	}
+	r.Checks = append(r.Checks, retried)
+
+	if err == nil {
+		r.AnsweredBy = result.Provider
+	} else {
+		r.Error = err.Error()
+	}
+	if hasFallbacks {
+		ok := err == nil && result.Provider != provider.GetName()
+		detail := "answered by " + result.Provider
+		if err != nil {
+			detail = "no fallback provider answered: " + err.Error()
+		}
+		r.Checks = append(r.Checks, DrillCheck{Name: "fallback", Passed: ok, Detail: detail})
+	} else {
+		ok := err != nil && strings.TrimSpace(err.Error()) != "" && !errors.Is(err, errCancelled)
+		detail := "the request failed with: " + r.Error
+		if err == nil {
+			detail = "the request succeeded although the provider failed and no fallback is set"
+		}
+		r.Checks = append(r.Checks, DrillCheck{Name: "error reported", Passed: ok, Detail: detail})
+	}
+
+	r.Passed = true
+	for _, c := range r.Checks {
+		r.Passed = r.Passed && c.Passed
+	}
+	return r
+}
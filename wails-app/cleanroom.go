@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Clean-room audit entry methods
+const (
+	cleanRoomEnable  = "ENABLE"
+	cleanRoomDisable = "DISABLE"
+	cleanRoomBlock   = "BLOCK"
+)
+
+// errCleanRoom is returned for requests of a clean-room conversation to a
+// provider it may not use
+func errCleanRoom(provider string) error {
+	return fmt.Errorf("clean-room conversation: %s is not an approved provider; approve it with SetCleanRoomProviders", provider)
+}
+
+// cleanRoomID returns the ID of s for generateRequest.CleanRoom when it is a
+// clean-room conversation
+func cleanRoomID(s Session) string {
+	if s.CleanRoom {
+		return s.ID
+	}
+	return ""
+}
+
+// cleanRoomAllowedLocked reports whether clean-room conversations may use a
+// provider: one that does not train on data and is local or approved. The
+// caller must hold providersMutex.
+func (a *App) cleanRoomAllowedLocked(config ProviderConfig) bool {
+	if config.TrainsOnData {
+		return false
+	}
+	if isLocalProvider(config) {
+		return true
+	}
+	for _, id := range a.cleanRoomProviders {
+		if id == config.ID {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *App) cleanRoomAllowed(config ProviderConfig) bool {
+	a.providersMutex.RLock()
+	defer a.providersMutex.RUnlock()
+	return a.cleanRoomAllowedLocked(config)
+}
+
+// preferCleanRoomProvider returns selected if clean-room conversations may
+// use it, otherwise the first provider they may use, falling back to
+// selected when there is none
+func (a *App) preferCleanRoomProvider(selected Provider) Provider {
+	a.providersMutex.RLock()
+	defer a.providersMutex.RUnlock()
+
+	if a.cleanRoomAllowedLocked(selected.GetConfig()) {
+		return selected
+	}
+	for _, p := range a.providers {
+		if a.cleanRoomAllowedLocked(p.GetConfig()) {
+			return p
+		}
+	}
+	return selected
+}
+
+// auditCleanRoom records a clean-room change or a blocked request
+func (a *App) auditCleanRoom(method, sessionID, provider, reason string) {
+	a.audit.add(AuditEntry{
+		Timestamp: time.Now().UTC(),
+		Source:    auditSourceCleanRoom,
+		Provider:  provider,
+		Method:    method,
+		Endpoint:  "session:" + sessionID,
+		Error:     reason,
+	})
+}
+
+// SetConversationCleanRoom switches clean-room mode for a conversation, for
+// license-sensitive work. Its requests, including summaries and
+// suggestions, only go to local or approved providers that do not train on
+// data; others are skipped and each refusal is written to the audit log,
+// as is the switch itself. Forks and splits stay clean-room.
+func (a *App) SetConversationCleanRoom(sessionID string, enabled bool) error {
+	a.telemetry.recordFeature("clean_room")
+	var was bool
+	if _, err := a.sessions.update(sessionID, func(s *Session) error {
+		was, s.CleanRoom = s.CleanRoom, enabled
+		return nil
+	}); err != nil {
+		return err
+	}
+	if was != enabled {
+		method := cleanRoomDisable
+		if enabled {
+			method = cleanRoomEnable
+		}
+		a.auditCleanRoom(method, sessionID, "", "")
+	}
+	return nil
+}
+
+// SetCleanRoomProviders sets the IDs of the hosted providers clean-room
+// conversations may use. Local providers are always allowed, and providers
+// marked as training on data never are.
+func (a *App) SetCleanRoomProviders(ids []string) error {
+	a.providersMutex.Lock()
+	defer a.providersMutex.Unlock()
+
+	seen := make(map[string]bool)
+	approved := make([]string, 0, len(ids))
+	for _, id := range ids {
+		i := a.providerIndexLocked(id)
+		if i == -1 {
+			return fmt.Errorf("provider %q not found", id)
+		}
+		if a.providers[i].GetConfig().TrainsOnData {
+			return fmt.Errorf("provider %q trains on data and can't be approved for clean-room conversations", id)
+		}
+		if !seen[id] {
+			seen[id] = true
+			approved = append(approved, id)
+		}
+	}
+	a.cleanRoomProviders = approved
+	return a.saveConfigLocked()
+}
+
+// GetCleanRoomProviders returns the IDs of the approved clean-room providers
+func (a *App) GetCleanRoomProviders() []string {
+	a.providersMutex.RLock()
+	defer a.providersMutex.RUnlock()
+	return append([]string(nil), a.cleanRoomProviders...)
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// chunkLines and chunkOverlap are how many lines a chunk of code has and
+	// shares with the one before it
+	chunkLines   = 60
+	chunkOverlap = 10
+	// chunkMaxChars caps a chunk, so minified files still make small chunks
+	chunkMaxChars = 2000
+	// embedBatchSize is the number of chunks embedded in one request
+	embedBatchSize = 32
+	// codeSearchDefault and codeSearchMax bound how many matches a search returns
+	codeSearchDefault = 10
+	codeSearchMax     = 50
+)
+
+// indexChunk is a run of lines of a file
+type indexChunk struct {
+	// StartLine and EndLine are 1-based and inclusive
+	StartLine int
+	EndLine   int
+	Text      string
+}
+
+// indexedFile is what a file looked like when it was indexed, as recorded
+// with its chunks
+type indexedFile struct {
+	size    string
+	modTime string
+	hash    string
+	ids     []string
+}
+
+// CodeIndexStatus describes a workspace's code index
+type CodeIndexStatus struct {
+	Workspace string `json:"workspace"`
+	Provider  string `json:"provider,omitempty"`
+	Model     string `json:"model,omitempty"`
+	Files     int    `json:"files"`
+	Chunks    int    `json:"chunks"`
+	// Embedded is how many chunks the last IndexWorkspace call embedded
+	Embedded int `json:"embedded,omitempty"`
+	// Truncated is set when the workspace has more files than are indexed
+	Truncated bool      `json:"truncated,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt,omitempty"`
+}
+
+// CodeMatch is a chunk of code found by SearchCode
+type CodeMatch struct {
+	Path      string  `json:"path"`
+	StartLine int     `json:"startLine"`
+	EndLine   int     `json:"endLine"`
+	Text      string  `json:"text"`
+	Language  string  `json:"language,omitempty"`
+	Score     float64 `json:"score"`
+}
+
+// IndexProgressEvent reports how far indexing a workspace has got
+type IndexProgressEvent struct {
+	Workspace string `json:"workspace"`
+	Embedded  int    `json:"embedded"`
+	Total     int    `json:"total"`
+}
+
+// codeNamespace is the vector store namespace of a workspace's code index
+func codeNamespace(workspace string) string {
+	return "code:" + workspace
+}
+
+// codeIndexFiles reads what each file of a code index looked like when it
+// was indexed
+func (a *App) codeIndexFiles(ns string) (map[string]*indexedFile, error) {
+	files := make(map[string]*indexedFile)
+	err := a.vectors.scan(ns, false, func(r vectorRecord) error {
+		p := r.Meta["path"]
+		f := files[p]
+		if f == nil {
+			f = &indexedFile{size: r.Meta["size"], modTime: r.Meta["modTime"], hash: r.Meta["hash"]}
+			files[p] = f
+		}
+		f.ids = append(f.ids, r.ID)
+		return nil
+	})
+	return files, err
+}
+
+// codeIndexStatus describes the code index of a workspace
+func (a *App) codeIndexStatus(workspace string) (CodeIndexStatus, error) {
+	ns := codeNamespace(workspace)
+	info, ok, err := a.vectors.namespace(ns)
+	if err != nil || !ok {
+		return CodeIndexStatus{Workspace: workspace}, err
+	}
+	files, err := a.codeIndexFiles(ns)
+	if err != nil {
+		return CodeIndexStatus{}, err
+	}
+	st := CodeIndexStatus{Workspace: workspace, Provider: info.Provider, Model: info.Model, Files: len(files), Truncated: info.Meta["truncated"] == "true", UpdatedAt: info.UpdatedAt}
+	for _, f := range files {
+		st.Chunks += len(f.ids)
+	}
+	return st, nil
+}
+
+// chunkCode splits content into runs of up to chunkLines lines that overlap
+// by chunkOverlap lines, cutting a run short at chunkMaxChars
+func chunkCode(content string) []indexChunk {
+	lines := splitLines(content)
+	var chunks []indexChunk
+	for start := 0; start < len(lines); {
+		end, chars := start, 0
+		for end < len(lines) && end-start < chunkLines && (end == start || chars+len(lines[end]) <= chunkMaxChars) {
+			chars += len(lines[end])
+			end++
+		}
+		text := strings.Join(lines[start:end], "")
+		text = truncateRunes(text, chunkMaxChars)
+		if strings.TrimSpace(text) != "" {
+			chunks = append(chunks, indexChunk{StartLine: start + 1, EndLine: end, Text: text})
+		}
+		if end == len(lines) {
+			break
+		}
+		start = max(end-chunkOverlap, start+1)
+	}
+	return chunks
+}
+
+// embeddingText is what is embedded for a chunk: its text under the path of
+// its file, which often says as much as the code does
+func embeddingText(path string, c indexChunk) string {
+	return "File: " + path + "\n" + c.Text
+}
+
+// IndexWorkspace brings the code index of the open workspace up to date:
+// files that git doesn't ignore are split into overlapping chunks of lines
+// and embedded with the embedding provider. Only files that changed since
+// the last run are embedded again; binary files and files over 256 KiB are
+// left out. Progress is sent as index:progress events. When embedding fails
+// part way, the files embedded so far are kept for the next run.
+func (a *App) IndexWorkspace() (CodeIndexStatus, error) {
+	a.telemetry.recordFeature("code_index")
+	root := a.GetWorkspace()
+	if root == "" {
+		return CodeIndexStatus{}, fmt.Errorf("no workspace is open")
+	}
+	provider, err := a.embeddingProvider("")
+	if err != nil {
+		return CodeIndexStatus{}, err
+	}
+	a.codeIndexing.Lock()
+	defer a.codeIndexing.Unlock()
+
+	ns, name, model := codeNamespace(root), provider.GetName(), embeddingModel(provider)
+	info, ok, err := a.vectors.namespace(ns)
+	if err != nil {
+		return CodeIndexStatus{}, err
+	}
+	if ok && (info.Provider != name || info.Model != model) {
+		if err := a.vectors.drop(ns); err != nil {
+			return CodeIndexStatus{}, err
+		}
+	}
+	if err := a.vectors.updateNamespace(ns, func(info *vectorNamespace) {
+		info.Provider, info.Model = name, model
+	}); err != nil {
+		return CodeIndexStatus{}, err
+	}
+	indexed, err := a.codeIndexFiles(ns)
+	if err != nil {
+		return CodeIndexStatus{}, err
+	}
+	paths, truncated, err := directoryFiles(root, ".")
+	if err != nil {
+		return CodeIndexStatus{}, err
+	}
+
+	type pendingFile struct {
+		path    string
+		records []vectorRecord
+		end     int
+	}
+	var pending []pendingFile
+	var texts []string
+	kept := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		full := filepath.Join(root, filepath.FromSlash(p))
+		stat, err := os.Stat(full)
+		if err != nil || stat.Size() > dirFileMaxBytes {
+			continue
+		}
+		size, modTime := strconv.FormatInt(stat.Size(), 10), stat.ModTime().UTC().Format(time.RFC3339Nano)
+		prev := indexed[p]
+		if prev != nil && prev.size == size && prev.modTime == modTime {
+			kept[p] = true
+			continue
+		}
+		content, ok := readText(full)
+		if !ok {
+			continue
+		}
+		sum := sha256.Sum256([]byte(content))
+		hash := hex.EncodeToString(sum[:])
+		kept[p] = true
+		if prev != nil && prev.hash == hash {
+			continue
+		}
+		var records []vectorRecord
+		for _, c := range chunkCode(content) {
+			texts = append(texts, embeddingText(p, c))
+			records = append(records, vectorRecord{
+				ID:   p + "#" + strconv.Itoa(c.StartLine),
+				Text: c.Text,
+				Meta: map[string]string{"path": p, "startLine": strconv.Itoa(c.StartLine), "endLine": strconv.Itoa(c.EndLine), "size": size, "modTime": modTime, "hash": hash},
+			})
+		}
+		pending = append(pending, pendingFile{path: p, records: records, end: len(texts)})
+	}
+	for p, f := range indexed {
+		if !kept[p] {
+			if err := a.vectors.replace(ns, f.ids, nil); err != nil {
+				return CodeIndexStatus{}, err
+			}
+		}
+	}
+
+	// A file's chunks replace those indexed before once all are embedded,
+	// so a failure part way leaves the rest as they were
+	embedded, cur, done := 0, 0, 0
+	commit := func() error {
+		for ; done < len(pending) && pending[done].end <= embedded; done++ {
+			var removed []string
+			if prev := indexed[pending[done].path]; prev != nil {
+				removed = prev.ids
+			}
+			if err := a.vectors.replace(ns, removed, pending[done].records); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for {
+		if err := commit(); err != nil {
+			return CodeIndexStatus{}, err
+		}
+		if embedded == len(texts) {
+			break
+		}
+		batch := texts[embedded:min(embedded+embedBatchSize, len(texts))]
+		vectors, err := a.embedWith(provider, batch)
+		if err != nil {
+			return CodeIndexStatus{}, fmt.Errorf("embedding %s: %v", pending[done].path, err)
+		}
+		for _, vec := range vectors {
+			for embedded >= pending[cur].end {
+				cur++
+			}
+			records := pending[cur].records
+			records[len(records)-(pending[cur].end-embedded)].Vector = vec
+			embedded++
+		}
+		a.emit(EventIndexProgress, IndexProgressEvent{Workspace: root, Embedded: embedded, Total: len(texts)})
+	}
+	if err := a.vectors.updateNamespace(ns, func(info *vectorNamespace) {
+		info.UpdatedAt = time.Now().UTC()
+		info.Meta = nil
+		if truncated {
+			info.Meta = map[string]string{"truncated": "true"}
+		}
+	}); err != nil {
+		return CodeIndexStatus{}, err
+	}
+	st, err := a.codeIndexStatus(root)
+	st.Embedded = embedded
+	return st, err
+}
+
+// GetCodeIndexStatus describes the open workspace's code index; Files is 0
+// when the workspace has not been indexed
+func (a *App) GetCodeIndexStatus() (CodeIndexStatus, error) {
+	root := a.GetWorkspace()
+	if root == "" {
+		return CodeIndexStatus{}, fmt.Errorf("no workspace is open")
+	}
+	return a.codeIndexStatus(root)
+}
+
+// SearchCode returns the chunks of the open workspace's code index most
+// similar in meaning to query, best first, ten when limit is 0.
+// IndexWorkspace must have run first; files changed since then are matched
+// as they were when indexed.
+func (a *App) SearchCode(query string, limit int) ([]CodeMatch, error) {
+	a.telemetry.recordFeature("code_search")
+	root := a.GetWorkspace()
+	if root == "" {
+		return nil, fmt.Errorf("no workspace is open")
+	}
+	if strings.TrimSpace(query) == "" {
+		return nil, fmt.Errorf("the search query is empty")
+	}
+	if limit <= 0 {
+		limit = codeSearchDefault
+	}
+	limit = min(limit, codeSearchMax)
+	ns := codeNamespace(root)
+	info, ok, err := a.vectors.namespace(ns)
+	if err != nil {
+		return nil, err
+	}
+	if !ok || info.Dims == 0 {
+		return nil, fmt.Errorf("the workspace has not been indexed")
+	}
+	provider, err := a.embeddingProvider(info.Provider)
+	if err != nil {
+		return nil, err
+	}
+	if provider.GetName() != info.Provider || embeddingModel(provider) != info.Model {
+		return nil, fmt.Errorf("the index was built with %s; index the workspace again to search with %s", info.Provider, provider.GetName())
+	}
+	vectors, _, err := a.embed([]string{query}, info.Provider)
+	if err != nil {
+		return nil, err
+	}
+
+	found, err := a.vectors.search(ns, vectors[0], limit, nil)
+	if err != nil {
+		return nil, err
+	}
+	matches := make([]CodeMatch, len(found))
+	for i, m := range found {
+		start, _ := strconv.Atoi(m.Meta["startLine"])
+		end, _ := strconv.Atoi(m.Meta["endLine"])
+		p := m.Meta["path"]
+		matches[i] = CodeMatch{Path: p, StartLine: start, EndLine: end, Text: m.Text, Language: fileLanguage(p), Score: m.Score}
+	}
+	return matches, nil
+}
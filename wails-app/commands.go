@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// commandTimeout is how long a command may run
+	commandTimeout = 2 * time.Minute
+	// commandConfirmTimeout is how long a command off the allowlist waits
+	// for the user before it is declined
+	commandConfirmTimeout = 5 * time.Minute
+	// commandOutputChars is how much of the end of stdout and of stderr is kept
+	commandOutputChars = 6000
+)
+
+// defaultCommandAllowlist are the commands run without asking until the
+// user sets an allowlist of their own
+var defaultCommandAllowlist = []string{"go build", "go test", "go vet", "git status", "git diff", "git log"}
+
+// unsafeCommandFlags let an allowlisted command run other programs, write
+// files or change where it works and what config it reads, as in
+// go test -exec sh or git diff --output=/path, so commands carrying one
+// still need confirmation
+var unsafeCommandFlags = []string{
+	"exec", "toolexec", "vettool", "o", "output", "outputdir", "C", "c", "config",
+	"coverprofile", "cpuprofile", "memprofile", "blockprofile", "mutexprofile", "trace",
+	"modfile", "overlay", "pkgdir", "work-tree", "git-dir", "ext-diff", "textconv",
+}
+
+var runCommandDefinition = ToolDefinition{
+	Name: "run_command",
+	Description: "Runs a command in the workspace and returns its exit status, stdout and stderr, e.g. \"go test ./...\". " +
+		"It runs without a shell, so pipes, redirection and variables don't work. Commands off the user's allowlist need their confirmation.",
+	Parameters: json.RawMessage(`{"type":"object","required":["command"],"properties":{` +
+		`"command":{"type":"string","minLength":1}}}`),
+}
+
+// CommandConfirmEvent asks the user to confirm a command off the allowlist
+type CommandConfirmEvent struct {
+	ID        string `json:"id"`
+	SessionID string `json:"sessionId"`
+	Command   string `json:"command"`
+	Dir       string `json:"dir"`
+}
+
+// commandConfirmations are the commands waiting for the user
+type commandConfirmations struct {
+	mu      sync.Mutex
+	waiting map[string]chan bool
+}
+
+func newCommandConfirmations() *commandConfirmations {
+	return &commandConfirmations{waiting: make(map[string]chan bool)}
+}
+
+// confirmCommand emits a confirmation request and waits for the answer, declining
+// when none comes in time
+func (a *App) confirmCommand(ctx context.Context, e CommandConfirmEvent) bool {
+	cc := a.commands
+	answer := make(chan bool, 1)
+	cc.mu.Lock()
+	cc.waiting[e.ID] = answer
+	cc.mu.Unlock()
+	defer func() {
+		cc.mu.Lock()
+		delete(cc.waiting, e.ID)
+		cc.mu.Unlock()
+	}()
+
+	a.emit(EventCommandConfirm, e)
+	timer := time.NewTimer(commandConfirmTimeout)
+	defer timer.Stop()
+	select {
+	case ok := <-answer:
+		return ok
+	case <-timer.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// splitCommand splits a command line into words, honouring single and
+// double quotes and backslash escapes
+func splitCommand(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, r := range line {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inWord = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote or escape in %q", line)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	if len(words) == 0 {
+		return nil, fmt.Errorf("command is empty")
+	}
+	return words, nil
+}
+
+// commandAllowed reports whether argv starts with the words of an allowlist
+// entry and carries none of the unsafe flags
+func commandAllowed(argv []string, allowlist []string) bool {
+	for _, arg := range argv[1:] {
+		if unsafeCommandFlag(arg) {
+			return false
+		}
+	}
+	for _, entry := range allowlist {
+		words := strings.Fields(entry)
+		if len(words) == 0 || len(words) > len(argv) {
+			continue
+		}
+		match := true
+		for i, w := range words {
+			match = match && argv[i] == w
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// unsafeCommandFlag reports whether arg is one of unsafeCommandFlags, with
+// one dash or two and with or without a value. Git takes unambiguous
+// abbreviations of long options, so --out counts as --output.
+func unsafeCommandFlag(arg string) bool {
+	if !strings.HasPrefix(arg, "-") {
+		return false
+	}
+	name, _, _ := strings.Cut(strings.TrimPrefix(arg, "-"), "=")
+	long := strings.HasPrefix(name, "-")
+	name = strings.TrimPrefix(name, "-")
+	if name == "" {
+		return false
+	}
+	for _, flag := range unsafeCommandFlags {
+		if name == flag || long && len(name) > 1 && strings.HasPrefix(flag, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// runCommand runs the run_command tool in the conversation's directory
+func (a *App) runCommand(ctx context.Context, sessionID string, args json.RawMessage) (string, error) {
+	var in struct {
+		Command string `json:"command"`
+	}
+	if err := json.Unmarshal(args, &in); err != nil {
+		return "", err
+	}
+	argv, err := splitCommand(in.Command)
+	if err != nil {
+		return "", err
+	}
+	dir := a.sessionRoot(sessionID)
+	if dir == "" {
+		return "", fmt.Errorf("no workspace is open")
+	}
+	if !commandAllowed(argv, a.GetCommandAllowlist()) {
+		e := CommandConfirmEvent{ID: newID(), SessionID: sessionID, Command: in.Command, Dir: dir}
+		if !a.confirmCommand(ctx, e) {
+			return "", fmt.Errorf("the user did not allow running %q", in.Command)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Dir = dir
+	// Children that keep the output open are not waited for long
+	cmd.WaitDelay = 5 * time.Second
+	stdout, stderr := &tailBuffer{max: commandOutputChars}, &tailBuffer{max: commandOutputChars}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	start := time.Now()
+	err = cmd.Run()
+	took := time.Since(start).Round(time.Millisecond)
+
+	status := "exit status 0"
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		status = fmt.Sprintf("timed out after %s", commandTimeout)
+	case errors.As(err, &exitErr):
+		status = exitErr.Error()
+	case err != nil:
+		return "", err
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "$ %s\n%s (%s)\n", in.Command, status, took)
+	fmt.Fprintf(&b, "stdout:\n%s\n", stdout)
+	fmt.Fprintf(&b, "stderr:\n%s\n", stderr)
+	return b.String(), nil
+}
+
+// SetCommandTool switches the run_command tool, which lets the model run
+// commands in the workspace. It is off until turned on.
+func (a *App) SetCommandTool(enabled bool) error {
+	a.providersMutex.Lock()
+	defer a.providersMutex.Unlock()
+	a.commandTool = enabled
+	return a.saveConfigLocked()
+}
+
+// GetCommandTool reports whether the run_command tool is on
+func (a *App) GetCommandTool() bool {
+	a.providersMutex.RLock()
+	defer a.providersMutex.RUnlock()
+	return a.commandTool
+}
+
+// SetCommandAllowlist sets the commands run_command runs without asking,
+// each matching commands that start with its words, e.g. "go test". An
+// empty list asks for every command; nil restores the defaults.
+func (a *App) SetCommandAllowlist(commands []string) error {
+	a.providersMutex.Lock()
+	defer a.providersMutex.Unlock()
+	var list []string
+	if commands != nil {
+		list = []string{}
+		for _, c := range commands {
+			if c = strings.Join(strings.Fields(c), " "); c != "" {
+				list = append(list, c)
+			}
+		}
+	}
+	a.commandAllowlist = list
+	return a.saveConfigLocked()
+}
+
+// GetCommandAllowlist returns the commands run_command runs without asking
+func (a *App) GetCommandAllowlist() []string {
+	a.providersMutex.RLock()
+	defer a.providersMutex.RUnlock()
+	if a.commandAllowlist == nil {
+		return append([]string(nil), defaultCommandAllowlist...)
+	}
+	return append([]string{}, a.commandAllowlist...)
+}
+
+// ConfirmCommand answers a "command:confirm" event, running the command
+// when allowed is true
+func (a *App) ConfirmCommand(id string, allowed bool) error {
+	cc := a.commands
+	cc.mu.Lock()
+	answer, ok := cc.waiting[id]
+	cc.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("command %q is not waiting for confirmation", id)
+	}
+	select {
+	case answer <- allowed:
+	default:
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// commitDiffChars is how much of the staged diff is sent to the model;
+	// the stat summary is always sent in full
+	commitDiffChars = 24000
+	// commitStyleCommits is how many recent subjects show the repository's style
+	commitStyleCommits = 10
+)
+
+const commitMessageInstructions = `Write a git commit message for the staged changes below.
+The first line is a summary in the imperative mood ("Add", "Fix", not "Added"), at most 72 characters, with no trailing period.
+If the change needs explaining, add a blank line and a short body wrapped at 72 characters that says what changed and why, not how.
+Match the style of the recent commit subjects when there are any. Don't mention file names unless they matter.
+Reply with the commit message only, without quotes or a code fence.`
+
+// stagedDiff returns the summary and diff of the changes staged in dir,
+// with the diff cut to commitDiffChars
+func stagedDiff(dir string) (stat, diff string, err error) {
+	if stat, err = git(dir, "diff", "--cached", "--stat"); err != nil {
+		return "", "", err
+	}
+	if strings.TrimSpace(stat) == "" {
+		return "", "", fmt.Errorf("no changes are staged")
+	}
+	if diff, err = git(dir, "diff", "--cached", "--no-color", "--no-ext-diff"); err != nil {
+		return "", "", err
+	}
+	if len(diff) > commitDiffChars {
+		diff = strings.ToValidUTF8(diff[:commitDiffChars], "") + "\n[diff cut short]\n"
+	}
+	return stat, diff, nil
+}
+
+// GenerateCommitMessage writes a commit message for the changes staged in
+// the active conversation's directory using the active model. The message
+// is only a proposal; CommitStaged commits with it once the user approves.
+func (a *App) GenerateCommitMessage() (string, error) {
+	a.telemetry.recordFeature("commit_message")
+	dir := a.sessionRoot("")
+	if dir == "" {
+		return "", fmt.Errorf("no workspace is open")
+	}
+	stat, diff, err := stagedDiff(dir)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	b.WriteString(commitMessageInstructions + "\n\n")
+	if log, err := git(dir, "log", "-n", fmt.Sprint(commitStyleCommits), "--no-merges", "--format=%s"); err == nil && strings.TrimSpace(log) != "" {
+		b.WriteString("Recent commit subjects:\n" + log + "\n")
+	}
+	b.WriteString("Files changed:\n" + stat + "\nDiff:\n" + diff)
+
+	var cleanRoom string
+	if s, ok := a.sessions.get(a.GetActiveSession()); ok {
+		cleanRoom = cleanRoomID(s)
+	}
+	temperature := 0.2
+	result, err := a.generate(generateRequest{
+		Prompt:      b.String(),
+		Temperature: &temperature,
+		MaxTokens:   summaryMaxTokens,
+		CleanRoom:   cleanRoom,
+	})
+	if err != nil {
+		return "", fmt.Errorf("commit message: %v", err)
+	}
+	message := strings.Trim(strings.TrimSpace(stripCodeFence(result.Response)), "\"'`")
+	if message == "" {
+		return "", fmt.Errorf("commit message: the model returned nothing")
+	}
+	return message, nil
+}
+
+// CommitStaged commits the changes staged in the active conversation's
+// directory with message, as approved by the user, and returns the new
+// commit's short hash
+func (a *App) CommitStaged(message string) (string, error) {
+	a.telemetry.recordFeature("commit_staged")
+	dir := a.sessionRoot("")
+	if dir == "" {
+		return "", fmt.Errorf("no workspace is open")
+	}
+	if strings.TrimSpace(message) == "" {
+		return "", fmt.Errorf("commit message is empty")
+	}
+	if _, err := git(dir, "commit", "-q", "-m", message); err != nil {
+		return "", err
+	}
+	hash, err := git(dir, "rev-parse", "--short", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(hash), nil
+}
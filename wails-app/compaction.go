@@ -0,0 +1,349 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Compaction tiers, from newest to oldest
+const (
+	tierVerbatim   = "verbatim"
+	tierSummarized = "summarized"
+	tierCompressed = "compressed"
+)
+
+const (
+	defaultRecentMessages     = 12
+	defaultSegmentMessages    = 16
+	defaultSummarizedSegments = 3
+	// segmentMaxTokens and digestMaxTokens cap the summaries of each tier
+	segmentMaxTokens = 400
+	digestMaxTokens  = 250
+)
+
+const segmentInstructions = `Summarize this part of a longer conversation so it can replace the original messages as context for future turns.
+Keep the user's goals, decisions, code identifiers, file names and open questions. Be concise, write in the third person, and reply with the summary only.`
+
+const digestInstructions = `Compress the earlier history of a long conversation into a short digest of at most 150 words.
+Keep only what still matters: lasting goals, final decisions and key identifiers. Drop anything superseded. Reply with the digest only.`
+
+// CompactionPolicy controls how long conversations are compacted when a
+// prompt is assembled: the newest messages are sent verbatim, the ones
+// before them as summaries of fixed-size segments, and everything older as
+// one digest that each aged-out segment is folded into. Zero values use the
+// defaults.
+type CompactionPolicy struct {
+	// Disabled stops compacting; conversations already compacted keep their summaries
+	Disabled bool `json:"disabled,omitempty"`
+	// RecentMessages is how many of the latest messages stay verbatim
+	RecentMessages int `json:"recentMessages,omitempty"`
+	// SegmentMessages is how many messages one mid-range summary covers
+	SegmentMessages int `json:"segmentMessages,omitempty"`
+	// SummarizedSegments is how many summaries are kept before the oldest
+	// is folded into the digest
+	SummarizedSegments int `json:"summarizedSegments,omitempty"`
+}
+
+func (p CompactionPolicy) withDefaults() CompactionPolicy {
+	if p.RecentMessages <= 0 {
+		p.RecentMessages = defaultRecentMessages
+	}
+	if p.SegmentMessages <= 0 {
+		p.SegmentMessages = defaultSegmentMessages
+	}
+	if p.SummarizedSegments <= 0 {
+		p.SummarizedSegments = defaultSummarizedSegments
+	}
+	return p
+}
+
+// ContextSegment is a compacted range of a session's messages, From
+// inclusive to Through exclusive
+type ContextSegment struct {
+	Tier      string    `json:"tier"`
+	From      int       `json:"from"`
+	Through   int       `json:"through"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// validSegments returns the leading segments that still fit the session's
+// messages, e.g. after a fork kept only part of them
+func validSegments(s Session) []ContextSegment {
+	next := 0
+	for i, seg := range s.Compaction {
+		if seg.From != next || seg.Through <= seg.From || seg.Through > len(s.Messages) {
+			return s.Compaction[:i]
+		}
+		next = seg.Through
+	}
+	return s.Compaction
+}
+
+// compactedThrough is how many leading messages the segments cover
+func compactedThrough(segments []ContextSegment) int {
+	if len(segments) == 0 {
+		return 0
+	}
+	return segments[len(segments)-1].Through
+}
+
+// segmentMessage renders a segment as the system message that stands in for it
+func segmentMessage(seg ContextSegment) Message {
+	label := "Summary of"
+	if seg.Tier == tierCompressed {
+		label = "Digest of the oldest part of the conversation,"
+	}
+	return Message{
+		Role:    "system",
+		Content: fmt.Sprintf("%s messages %d–%d:\n%s", label, seg.From+1, seg.Through, seg.Content),
+	}
+}
+
+// compactedContext returns the compacted history of a session and whether it
+// has any; sessionContext prefers it over a rolling summary that covers less
+func compactedContext(s Session) ([]Message, bool) {
+	segments := validSegments(s)
+	through := compactedThrough(segments)
+	if through == 0 || (s.Summary != nil && s.Summary.Through > through) {
+		return nil, false
+	}
+	out := make([]Message, 0, len(segments)+len(s.Messages)-through)
+	for _, seg := range segments {
+		out = append(out, segmentMessage(seg))
+	}
+	return append(out, s.Messages[through:]...), true
+}
+
+// summarizeRange asks the model for one segment summary or digest
+func (a *App) summarizeRange(s Session, instructions string, maxTokens int, prior string, messages []Message) (string, error) {
+	var b strings.Builder
+	b.WriteString(instructions)
+	b.WriteString("\n\n")
+	if prior != "" {
+		fmt.Fprintf(&b, "Earlier history:\n%s\n\n", prior)
+	}
+	for _, m := range messages {
+		fmt.Fprintf(&b, "%s: %s\n\n", m.Role, m.Content)
+	}
+	temperature := 0.2
+	result, err := a.generate(generateRequest{
+		Prompt:      b.String(),
+		Temperature: &temperature,
+		MaxTokens:   maxTokens,
+		CleanRoom:   cleanRoomID(s),
+		Private:     s.Locked,
+	})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(result.Response), nil
+}
+
+// compact brings a session's segments up to date with the policy:
+// messages older than the verbatim window are summarized a segment at a
+// time, and summaries beyond the kept number are folded into the digest
+func (a *App) compact(sessionID string) error {
+	policy := a.GetCompactionPolicy().withDefaults()
+	s, ok := a.sessions.get(sessionID)
+	if !ok {
+		return fmt.Errorf("session %q not found", sessionID)
+	}
+	if s.Archived {
+		return errArchived(sessionID)
+	}
+	segments := append([]ContextSegment(nil), validSegments(s)...)
+	start := compactedThrough(segments)
+	changed := len(segments) != len(s.Compaction)
+
+	for through := compactedThrough(segments); len(s.Messages)-policy.RecentMessages-through >= policy.SegmentMessages; through += policy.SegmentMessages {
+		content, err := a.summarizeRange(s, segmentInstructions, segmentMaxTokens, "", s.Messages[through:through+policy.SegmentMessages])
+		if err != nil {
+			return fmt.Errorf("compact: %v", err)
+		}
+		segments = append(segments, ContextSegment{
+			Tier:      tierSummarized,
+			From:      through,
+			Through:   through + policy.SegmentMessages,
+			Content:   content,
+			CreatedAt: time.Now(),
+		})
+		changed = true
+	}
+
+	for {
+		summarized := len(segments)
+		if summarized > 0 && segments[0].Tier == tierCompressed {
+			summarized--
+		}
+		if summarized <= policy.SummarizedSegments {
+			break
+		}
+		// Fold the oldest summary into the digest
+		i, prior := 0, ""
+		if segments[0].Tier == tierCompressed {
+			i, prior = 1, segments[0].Content
+		}
+		oldest := segments[i]
+		content, err := a.summarizeRange(s, digestInstructions, digestMaxTokens, prior, []Message{{Role: "summary", Content: oldest.Content}})
+		if err != nil {
+			return fmt.Errorf("compact: %v", err)
+		}
+		digest := ContextSegment{Tier: tierCompressed, From: 0, Through: oldest.Through, Content: content, CreatedAt: time.Now()}
+		segments = append([]ContextSegment{digest}, segments[i+1:]...)
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+
+	_, err := a.sessions.update(sessionID, func(s *Session) error {
+		// Keep the newer segments if the session changed underneath
+		if compactedThrough(validSegments(*s)) != start || compactedThrough(segments) > len(s.Messages) {
+			return nil
+		}
+		s.Compaction = segments
+		return nil
+	})
+	return err
+}
+
+// maybeCompact compacts a session in the background once its history has
+// grown past the verbatim window by a segment. Sessions with a rolling
+// summary keep using that instead.
+func (a *App) maybeCompact(sessionID string) {
+	policy := a.GetCompactionPolicy().withDefaults()
+	if policy.Disabled {
+		return
+	}
+	s, ok := a.sessions.get(sessionID)
+	if !ok || s.AutoSummarize || s.Archived {
+		return
+	}
+	if len(s.Messages)-policy.RecentMessages-compactedThrough(validSegments(s)) < policy.SegmentMessages {
+		return
+	}
+	if _, running := a.compacting.LoadOrStore(sessionID, true); running {
+		return
+	}
+	go func() {
+		defer a.compacting.Delete(sessionID)
+		if err := a.compact(sessionID); err != nil {
+			println("Error compacting session:", err.Error())
+		}
+	}()
+}
+
+// SetCompactionPolicy sets how long conversations are compacted
+func (a *App) SetCompactionPolicy(policy CompactionPolicy) error {
+	if policy.RecentMessages < 0 || policy.SegmentMessages < 0 || policy.SummarizedSegments < 0 {
+		return fmt.Errorf("compaction sizes must not be negative")
+	}
+	a.providersMutex.Lock()
+	defer a.providersMutex.Unlock()
+	a.compaction = policy
+	return a.saveConfigLocked()
+}
+
+// GetCompactionPolicy returns the compaction policy as configured, with zero
+// values for defaults
+func (a *App) GetCompactionPolicy() CompactionPolicy {
+	a.providersMutex.RLock()
+	defer a.providersMutex.RUnlock()
+	return a.compaction
+}
+
+// CompactSession brings a conversation's compacted history up to date now
+// instead of after the next reply
+func (a *App) CompactSession(sessionID string) (ContextDescription, error) {
+	a.telemetry.recordFeature("compact_session")
+	if err := a.compact(sessionID); err != nil {
+		return ContextDescription{}, err
+	}
+	return a.DescribeContext(sessionID)
+}
+
+// ContextDescription explains what the next prompt of a session would send
+type ContextDescription struct {
+	SessionID string `json:"sessionId"`
+	// Policy is the compaction policy with defaults filled in
+	Policy   CompactionPolicy `json:"policy"`
+	Provider string           `json:"provider"`
+	Model    string           `json:"model"`
+	// ContextSize is the model's window; Budget is what is left after the reply reserve
+	ContextSize int `json:"contextSize"`
+	Budget      int `json:"budget"`
+	// Ranges are the parts of the history, oldest first
+	Ranges []ContextRange `json:"ranges"`
+	Tokens int            `json:"tokens"`
+	// Dropped counts old messages left out because even the compacted
+	// history did not fit the budget
+	Dropped int `json:"dropped,omitempty"`
+}
+
+// ContextRange is one part of the history and how it is sent
+type ContextRange struct {
+	// Tier is "compressed", "summarized", "verbatim", or "summary" for a rolling summary
+	Tier    string `json:"tier"`
+	From    int    `json:"from"`
+	Through int    `json:"through"`
+	Tokens  int    `json:"tokens"`
+}
+
+// DescribeContext shows how a session's history is assembled into the next
+// prompt: which messages go verbatim, which as summaries, and which are
+// folded into the digest, with their token counts against the budget
+func (a *App) DescribeContext(sessionID string) (ContextDescription, error) {
+	s, ok := a.sessions.get(sessionID)
+	if !ok {
+		return ContextDescription{}, fmt.Errorf("session %q not found", sessionID)
+	}
+	provider, err := a.selectProvider("")
+	if err != nil {
+		return ContextDescription{}, err
+	}
+	config := provider.GetConfig()
+	maxTokens := config.Defaults.MaxTokens
+	if maxTokens == 0 {
+		maxTokens = defaultMaxTokens
+	}
+	size := contextSize(config)
+	d := ContextDescription{
+		SessionID:   sessionID,
+		Policy:      a.GetCompactionPolicy().withDefaults(),
+		Provider:    provider.GetName(),
+		Model:       config.Model,
+		ContextSize: size,
+		Budget:      size - responseReserve(size, maxTokens),
+		Ranges:      []ContextRange{},
+	}
+	cost := func(m Message) int {
+		return countTokens(m.Content, config.Model).Tokens + perMessageOverhead
+	}
+
+	verbatimFrom := 0
+	if _, ok := compactedContext(s); ok {
+		for _, seg := range validSegments(s) {
+			d.Ranges = append(d.Ranges, ContextRange{Tier: seg.Tier, From: seg.From, Through: seg.Through, Tokens: cost(segmentMessage(seg))})
+		}
+		verbatimFrom = compactedThrough(validSegments(s))
+	} else if s.Summary != nil && s.Summary.Through > 0 && s.Summary.Through <= len(s.Messages) {
+		d.Ranges = append(d.Ranges, ContextRange{Tier: "summary", From: 0, Through: s.Summary.Through, Tokens: cost(Message{Content: s.Summary.Content})})
+		verbatimFrom = s.Summary.Through
+	}
+	if verbatimFrom < len(s.Messages) {
+		r := ContextRange{Tier: tierVerbatim, From: verbatimFrom, Through: len(s.Messages)}
+		for _, m := range s.Messages[verbatimFrom:] {
+			r.Tokens += cost(m)
+		}
+		d.Ranges = append(d.Ranges, r)
+	}
+	for _, r := range d.Ranges {
+		d.Tokens += r.Tokens
+	}
+	if messages := sessionContext(s); len(messages) > 0 {
+		_, d.Dropped = trimHistory(messages, d.Budget, config.Model)
+	}
+	return d, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ComparisonResult is one provider's reply to a compared prompt
+type ComparisonResult struct {
+	ProviderID string `json:"providerId"`
+	Provider   string `json:"provider"`
+	Model      string `json:"model"`
+	Response   string `json:"response"`
+	RequestID  string `json:"requestId,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+	Error      string `json:"error,omitempty"`
+}
+
+// ComparePrompt sends the same prompt to several providers at once for side
+// by side evaluation. A "compare:start" event carries the comparison ID,
+// which CancelPrompt accepts to stop every provider. Each reply streams as
+// "compare:chunk" events tagged with its provider ID and ends with a
+// "compare:done" event. Providers that fail do not stop the others; their
+// result has Error set. Nothing is added to the conversation.
+func (a *App) ComparePrompt(prompt string, providerIDs []string) ([]ComparisonResult, error) {
+	a.telemetry.recordFeature("compare_prompt")
+
+	var providers []Provider
+	var ids []string
+	a.providersMutex.RLock()
+	for _, id := range providerIDs {
+		i := a.providerIndexLocked(id)
+		if i == -1 {
+			a.providersMutex.RUnlock()
+			return nil, fmt.Errorf("provider %q not found", id)
+		}
+		if id = a.providers[i].GetConfig().ID; !slices.Contains(ids, id) {
+			ids = append(ids, id)
+			providers = append(providers, a.providers[i])
+		}
+	}
+	a.providersMutex.RUnlock()
+	if len(providers) == 0 {
+		return nil, fmt.Errorf("no providers selected")
+	}
+
+	comparisonID := newID()
+	ctx, done := a.trackPrompt(comparisonID)
+	defer done()
+	a.emit(EventCompareStart, CompareStartEvent{ComparisonID: comparisonID, ProviderIDs: ids})
+
+	results := make([]ComparisonResult, len(providers))
+	var wg sync.WaitGroup
+	for i, p := range providers {
+		config := p.GetConfig()
+		results[i] = ComparisonResult{ProviderID: config.ID, Provider: p.GetName(), Model: config.Model}
+		wg.Add(1)
+		go func(r *ComparisonResult) {
+			defer wg.Done()
+			start := time.Now()
+			result, err := a.generate(generateRequest{
+				Prompt:   prompt,
+				Provider: r.ProviderID,
+				Context:  ctx,
+				OnChunk: func(chunk string) {
+					a.emit(EventCompareChunk, CompareChunkEvent{ComparisonID: comparisonID, ProviderID: r.ProviderID, Text: chunk})
+				},
+			})
+			r.Response = result.Response
+			r.RequestID = result.RequestID
+			r.DurationMs = time.Since(start).Milliseconds()
+
+			event := CompareDoneEvent{ComparisonID: comparisonID, ProviderID: r.ProviderID, Text: r.Response, DurationMs: r.DurationMs}
+			if err != nil {
+				r.Error = err.Error()
+				event.Error = r.Error
+				event.Cancelled = errors.Is(err, errCancelled)
+			}
+			a.emit(EventCompareDone, event)
+		}(&results[i])
+	}
+	wg.Wait()
+	return results, nil
+}
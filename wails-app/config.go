@@ -0,0 +1,171 @@
+package main
+
+import (
+	"os"
+)
+
+// appConfig is the persisted application configuration
+type appConfig struct {
+	Providers          []ProviderConfig      `json:"providers"`
+	ActiveProviderID   string                `json:"activeProviderId"`
+	Workspace          string                `json:"workspace,omitempty"`
+	CurrentFile        string                `json:"currentFile,omitempty"`
+	ResponseLanguage   string                `json:"responseLanguage,omitempty"`
+	TargetLength       string                `json:"targetLength,omitempty"`
+	Fallbacks          []string              `json:"fallbackProviders,omitempty"`
+	LowDataMode        string                `json:"lowDataMode,omitempty"`
+	FastProviderID     string                `json:"fastProviderId,omitempty"`
+	ResponseCache      bool                  `json:"responseCache,omitempty"`
+	FollowUps          bool                  `json:"followUpSuggestions,omitempty"`
+	Compaction         CompactionPolicy      `json:"compaction"`
+	ReportFormat       ReportFormat          `json:"reportFormat"`
+	ModelPrices        map[string]ModelPrice `json:"modelPrices,omitempty"`
+	Budget             Budget                `json:"budget"`
+	Tracing            TracingConfig         `json:"tracing"`
+	Redaction          RedactionPolicy       `json:"redaction"`
+	WorkspaceDigest    bool                  `json:"workspaceDigest,omitempty"`
+	LocalOnly          bool                  `json:"localOnly,omitempty"`
+	MergeEngine        string                `json:"mergeEngine,omitempty"`
+	Personas           []Persona             `json:"personas,omitempty"`
+	DeletedPersonas    []Persona             `json:"deletedPersonas,omitempty"`
+	PreSendReview      bool                  `json:"preSendReview,omitempty"`
+	CleanRoomProviders []string              `json:"cleanRoomProviders,omitempty"`
+	CommandTool        bool                  `json:"commandTool,omitempty"`
+	// CommandAllowlist is null for the default allowlist
+	CommandAllowlist []string `json:"commandAllowlist"`
+
+	// LegacyActiveProvider is the slice index used before providers had IDs
+	LegacyActiveProvider *int `json:"activeProvider,omitempty"`
+}
+
+// loadConfig restores providers saved by a previous run
+func (a *App) loadConfig(path string) error {
+	var cfg appConfig
+	if err := readJSONFile(path, &cfg); err != nil {
+		if os.IsNotExist(err) {
+			a.configPath = path
+			return nil
+		}
+		return err
+	}
+
+	a.providersMutex.Lock()
+	defer a.providersMutex.Unlock()
+
+	// Providers added before startup are kept after the restored ones
+	pending := a.providers
+	a.providers = make([]Provider, 0, len(cfg.Providers)+len(pending))
+	for i, pc := range cfg.Providers {
+		if pc.ID == "" {
+			pc.ID = newID()
+		}
+		if cfg.ActiveProviderID == "" && cfg.LegacyActiveProvider != nil && *cfg.LegacyActiveProvider == i {
+			cfg.ActiveProviderID = pc.ID
+		}
+		// Keys from older plaintext configs are migrated into the secret store
+		if pc.APIKey != "" {
+			if err := a.storeAPIKey(&pc); err != nil {
+				println("Error migrating API key:", err.Error())
+			}
+		} else if err := a.resolveAPIKey(&pc); err != nil {
+			println("Error loading API key:", err.Error())
+		}
+		if len(pc.Headers) > 0 {
+			if err := a.storeHeaders(&pc); err != nil {
+				println("Error migrating headers:", err.Error())
+			}
+		} else if err := a.resolveHeaders(&pc); err != nil {
+			println("Error loading headers:", err.Error())
+		}
+		p, err := a.newProvider(pc)
+		if err != nil {
+			// Keep the entry so it is not lost from the saved config
+			println("Error loading provider:", err.Error())
+			p = NewMockProvider(pc)
+		}
+		a.providers = append(a.providers, p)
+	}
+	a.providers = append(a.providers, pending...)
+
+	a.activeProvider = cfg.ActiveProviderID
+	if a.activeProviderLocked() == nil {
+		a.activeProvider = ""
+		if len(a.providers) > 0 {
+			a.activeProvider = a.providers[0].GetConfig().ID
+		}
+	}
+
+	a.workspace = cfg.Workspace
+	a.currentFile = cfg.CurrentFile
+	a.responseLanguage = cfg.ResponseLanguage
+	a.targetLength = cfg.TargetLength
+	a.fallbackProviders = cfg.Fallbacks
+	a.lowDataMode = cfg.LowDataMode
+	a.fastProvider = cfg.FastProviderID
+	a.cacheResponses = cfg.ResponseCache
+	a.followUps = cfg.FollowUps
+	a.compaction = cfg.Compaction
+	a.reportFormat = cfg.ReportFormat
+	a.modelPrices = cfg.ModelPrices
+	a.budget = cfg.Budget
+	a.tracingConfig = cfg.Tracing
+	a.redaction = cfg.Redaction
+	a.workspaceDigest = cfg.WorkspaceDigest
+	a.localOnly = cfg.LocalOnly
+	localOnly.Store(cfg.LocalOnly)
+	a.mergeEngine = cfg.MergeEngine
+	a.personas = cfg.Personas
+	a.deletedPersonas = cfg.DeletedPersonas
+	a.preSendReview = cfg.PreSendReview
+	a.cleanRoomProviders = cfg.CleanRoomProviders
+	a.commandTool = cfg.CommandTool
+	a.commandAllowlist = cfg.CommandAllowlist
+	a.applyTracingLocked()
+	a.configPath = path
+	return a.saveConfigLocked()
+}
+
+// saveConfigLocked writes the provider configuration with secrets removed;
+// API keys live in the secret store and only their references are saved.
+// The caller must hold providersMutex.
+func (a *App) saveConfigLocked() error {
+	if a.configPath == "" {
+		return nil
+	}
+
+	cfg := appConfig{
+		Providers:          make([]ProviderConfig, len(a.providers)),
+		ActiveProviderID:   a.activeProvider,
+		Workspace:          a.workspace,
+		CurrentFile:        a.currentFile,
+		ResponseLanguage:   a.responseLanguage,
+		TargetLength:       a.targetLength,
+		Fallbacks:          a.fallbackProviders,
+		LowDataMode:        a.lowDataMode,
+		FastProviderID:     a.fastProvider,
+		ResponseCache:      a.cacheResponses,
+		FollowUps:          a.followUps,
+		Compaction:         a.compaction,
+		ReportFormat:       a.reportFormat,
+		ModelPrices:        a.modelPrices,
+		Budget:             a.budget,
+		Tracing:            a.tracingConfig,
+		Redaction:          a.redaction,
+		WorkspaceDigest:    a.workspaceDigest,
+		LocalOnly:          a.localOnly,
+		MergeEngine:        a.mergeEngine,
+		Personas:           a.personas,
+		DeletedPersonas:    a.deletedPersonas,
+		PreSendReview:      a.preSendReview,
+		CleanRoomProviders: a.cleanRoomProviders,
+		CommandTool:        a.commandTool,
+		CommandAllowlist:   a.commandAllowlist,
+	}
+	for i, p := range a.providers {
+		pc := p.GetConfig()
+		pc.APIKey = ""
+		pc.Headers = nil
+		cfg.Providers[i] = pc
+	}
+	return writeJSONFile(a.configPath, cfg)
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// configExportVersion is bumped when the export format changes incompatibly
+const configExportVersion = 1
+
+// configExport is the file written by ExportConfig. Providers never carry
+// API keys in the clear; with a passphrase they travel in Secrets instead.
+type configExport struct {
+	Version    int            `json:"version"`
+	ExportedAt time.Time      `json:"exportedAt"`
+	Config     appConfig      `json:"config"`
+	Secrets    *sealedSecrets `json:"secrets,omitempty"`
+}
+
+// sealedSecrets is a JSON map of secrets encrypted with AES-256-GCM under a
+// key derived from the export passphrase with scrypt
+type sealedSecrets struct {
+	Salt  []byte `json:"salt"`
+	Nonce []byte `json:"nonce"`
+	Data  []byte `json:"data"`
+}
+
+// exportedSecretKey prefixes provider IDs in the sealed secrets map;
+// other entries are secret store references such as slackWebhookRef and
+// tracingHeadersRef
+const exportedSecretKey = "provider:"
+
+// exportedHeadersKey prefixes provider IDs for their JSON-encoded headers
+const exportedHeadersKey = "headers:"
+
+// passphraseKey derives an AES-256 key from a passphrase with scrypt
+func passphraseKey(passphrase string, salt []byte) ([]byte, error) {
+	return scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+}
+
+func passphraseCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := passphraseKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	return keyCipher(key)
+}
+
+// keyCipher returns AES-256-GCM under key
+func keyCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func sealSecrets(secrets map[string]string, passphrase string) (*sealedSecrets, error) {
+	plain, err := json.Marshal(secrets)
+	if err != nil {
+		return nil, err
+	}
+	s := &sealedSecrets{Salt: make([]byte, 16)}
+	if _, err := io.ReadFull(rand.Reader, s.Salt); err != nil {
+		return nil, err
+	}
+	aead, err := passphraseCipher(passphrase, s.Salt)
+	if err != nil {
+		return nil, err
+	}
+	s.Nonce = make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, s.Nonce); err != nil {
+		return nil, err
+	}
+	s.Data = aead.Seal(nil, s.Nonce, plain, nil)
+	return s, nil
+}
+
+func (s *sealedSecrets) open(passphrase string) (map[string]string, error) {
+	aead, err := passphraseCipher(passphrase, s.Salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("invalid secrets: bad nonce")
+	}
+	plain, err := aead.Open(nil, s.Nonce, s.Data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("wrong passphrase or corrupted secrets")
+	}
+	var secrets map[string]string
+	if err := json.Unmarshal(plain, &secrets); err != nil {
+		return nil, fmt.Errorf("invalid secrets: %v", err)
+	}
+	return secrets, nil
+}
+
+// ExportConfig writes providers and settings to a single JSON file for
+// moving to another machine. API keys and the Slack webhook are left out
+// unless a passphrase is given, in which case they are encrypted with it.
+// The workspace path is machine-specific and is not exported.
+func (a *App) ExportConfig(path string, passphrase string) error {
+	a.providersMutex.RLock()
+	cfg, secrets := a.portableConfigLocked()
+	a.providersMutex.RUnlock()
+
+	export := configExport{
+		Version:    configExportVersion,
+		ExportedAt: time.Now(),
+		Config:     cfg,
+	}
+	if passphrase != "" {
+		if webhook, err := a.secrets.Get(slackWebhookRef); err == nil && webhook != "" {
+			secrets[slackWebhookRef] = webhook
+		}
+		if headers, err := a.secrets.Get(tracingHeadersRef); err == nil && headers != "" {
+			secrets[tracingHeadersRef] = headers
+		}
+		sealed, err := sealSecrets(secrets, passphrase)
+		if err != nil {
+			return fmt.Errorf("encrypt secrets: %v", err)
+		}
+		export.Secrets = sealed
+	}
+	return writeJSONFile(path, export)
+}
+
+// portableConfigLocked returns the settings without API keys or the
+// workspace, along with the provider keys it left out
+func (a *App) portableConfigLocked() (appConfig, map[string]string) {
+	cfg := appConfig{
+		Providers:          make([]ProviderConfig, len(a.providers)),
+		ActiveProviderID:   a.activeProvider,
+		ResponseLanguage:   a.responseLanguage,
+		TargetLength:       a.targetLength,
+		Fallbacks:          a.fallbackProviders,
+		LowDataMode:        a.lowDataMode,
+		FastProviderID:     a.fastProvider,
+		ResponseCache:      a.cacheResponses,
+		FollowUps:          a.followUps,
+		Compaction:         a.compaction,
+		ReportFormat:       a.reportFormat,
+		ModelPrices:        a.modelPrices,
+		Budget:             a.budget,
+		Tracing:            a.tracingConfig,
+		Redaction:          a.redaction,
+		WorkspaceDigest:    a.workspaceDigest,
+		LocalOnly:          a.localOnly,
+		MergeEngine:        a.mergeEngine,
+		Personas:           a.personas,
+		PreSendReview:      a.preSendReview,
+		CleanRoomProviders: a.cleanRoomProviders,
+		CommandTool:        a.commandTool,
+		CommandAllowlist:   a.commandAllowlist,
+	}
+	secrets := make(map[string]string)
+	for i, p := range a.providers {
+		pc := p.GetConfig()
+		if pc.APIKey != "" {
+			secrets[exportedSecretKey+pc.ID] = pc.APIKey
+		}
+		if len(pc.Headers) > 0 {
+			if data, err := json.Marshal(pc.Headers); err == nil {
+				secrets[exportedHeadersKey+pc.ID] = string(data)
+			}
+		}
+		pc.APIKey = ""
+		pc.APIKeyRef = ""
+		pc.Headers = nil
+		pc.HeadersRef = ""
+		cfg.Providers[i] = pc
+	}
+	return cfg, secrets
+}
+
+// ImportConfig replaces the configured providers and settings with those in
+// a file written by ExportConfig. The passphrase decrypts exported secrets;
+// without one, imported providers need their API keys entered again. The
+// file is checked and every provider built before any secret is stored, and
+// secrets stored by a failed import are removed again. The command tool and
+// its allowlist are left as they are, since running commands must be turned
+// on here with SetCommandTool.
+func (a *App) ImportConfig(path string, passphrase string) error {
+	var export configExport
+	if err := readJSONFile(path, &export); err != nil {
+		return fmt.Errorf("read config export: %v", err)
+	}
+	if export.Version < 1 || export.Version > configExportVersion {
+		return fmt.Errorf("unsupported config export version %d", export.Version)
+	}
+
+	var secrets map[string]string
+	if export.Secrets != nil && passphrase != "" {
+		s, err := export.Secrets.open(passphrase)
+		if err != nil {
+			return err
+		}
+		secrets = s
+	}
+
+	providers := make([]Provider, 0, len(export.Config.Providers))
+	var stored []string
+	imported := false
+	defer func() {
+		if imported {
+			return
+		}
+		for _, p := range providers {
+			if c, isCloser := p.(io.Closer); isCloser {
+				c.Close()
+			}
+		}
+		for _, ref := range stored {
+			if err := a.secrets.Delete(ref); err != nil {
+				println("Error deleting imported secret:", err.Error())
+			}
+		}
+	}()
+
+	// Secrets get their references up front, so the providers built here
+	// carry them; nothing is stored until every provider has been built
+	configs := make([]ProviderConfig, 0, len(export.Config.Providers))
+	for _, pc := range export.Config.Providers {
+		if pc.ID == "" {
+			pc.ID = newID()
+		}
+		t, err := canonicalProviderType(pc.Type)
+		if err != nil {
+			return fmt.Errorf("provider %s: %v", pc.Name, err)
+		}
+		pc.Type = t
+		pc.APIKey, pc.APIKeyRef = secrets[exportedSecretKey+pc.ID], ""
+		if pc.APIKey != "" {
+			pc.APIKeyRef = newID()
+		}
+		pc.Headers, pc.HeadersRef = nil, ""
+		if raw := secrets[exportedHeadersKey+pc.ID]; raw != "" {
+			if err := json.Unmarshal([]byte(raw), &pc.Headers); err != nil {
+				return fmt.Errorf("provider %s headers: %v", pc.Name, err)
+			}
+			pc.HeadersRef = newID()
+		}
+		p, err := a.newProvider(pc)
+		if err != nil {
+			return fmt.Errorf("provider %s: %v", pc.Name, err)
+		}
+		providers = append(providers, p)
+		configs = append(configs, pc)
+	}
+
+	a.providersMutex.Lock()
+	defer a.providersMutex.Unlock()
+	for i := range configs {
+		pc := &configs[i]
+		if err := a.storeAPIKey(pc); err != nil {
+			return err
+		}
+		if pc.APIKeyRef != "" {
+			stored = append(stored, pc.APIKeyRef)
+		}
+		if err := a.storeHeaders(pc); err != nil {
+			return err
+		}
+		if pc.HeadersRef != "" {
+			stored = append(stored, pc.HeadersRef)
+		}
+	}
+	imported = true
+
+	if webhook := secrets[slackWebhookRef]; webhook != "" {
+		if err := a.secrets.Set(slackWebhookRef, webhook); err != nil {
+			println("Error importing Slack webhook:", err.Error())
+		}
+	}
+	if headers := secrets[tracingHeadersRef]; headers != "" {
+		if err := a.secrets.Set(tracingHeadersRef, headers); err != nil {
+			println("Error importing OTLP headers:", err.Error())
+		}
+	}
+
+	for _, p := range a.providers {
+		if c, ok := p.(io.Closer); ok {
+			c.Close()
+		}
+		if ref := p.GetConfig().APIKeyRef; ref != "" {
+			if err := a.secrets.Delete(ref); err != nil {
+				println("Error deleting API key:", err.Error())
+			}
+		}
+		if ref := p.GetConfig().HeadersRef; ref != "" {
+			if err := a.secrets.Delete(ref); err != nil {
+				println("Error deleting headers:", err.Error())
+			}
+		}
+		if err := a.SignOut(p.GetConfig().ID); err != nil {
+			println("Error deleting sign-in:", err.Error())
+		}
+	}
+	a.providers = providers
+
+	a.activeProvider = export.Config.ActiveProviderID
+	if a.activeProviderLocked() == nil {
+		a.activeProvider = ""
+		if len(a.providers) > 0 {
+			a.activeProvider = a.providers[0].GetConfig().ID
+		}
+	}
+	a.fallbackProviders = nil
+	for _, id := range export.Config.Fallbacks {
+		if a.providerIndexLocked(id) != -1 {
+			a.fallbackProviders = append(a.fallbackProviders, id)
+		}
+	}
+	a.responseLanguage = export.Config.ResponseLanguage
+	a.targetLength = export.Config.TargetLength
+	a.lowDataMode = export.Config.LowDataMode
+	a.cacheResponses = export.Config.ResponseCache
+	a.followUps = export.Config.FollowUps
+	a.compaction = export.Config.Compaction
+	a.reportFormat = export.Config.ReportFormat
+	a.modelPrices = export.Config.ModelPrices
+	a.budget = export.Config.Budget
+	a.tracingConfig = export.Config.Tracing
+	a.tracingConfig.Headers = nil
+	a.redaction = export.Config.Redaction
+	a.workspaceDigest = export.Config.WorkspaceDigest
+	a.localOnly = export.Config.LocalOnly
+	localOnly.Store(a.localOnly)
+	a.mergeEngine = export.Config.MergeEngine
+	a.personas = export.Config.Personas
+	a.preSendReview = export.Config.PreSendReview
+	a.cleanRoomProviders = nil
+	for _, id := range export.Config.CleanRoomProviders {
+		if a.providerIndexLocked(id) != -1 {
+			a.cleanRoomProviders = append(a.cleanRoomProviders, id)
+		}
+	}
+	a.applyTracingLocked()
+	a.fastProvider = ""
+	if a.providerIndexLocked(export.Config.FastProviderID) != -1 {
+		a.fastProvider = export.Config.FastProviderID
+	}
+	return a.saveConfigLocked()
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const defaultContextSize = 4096
+
+// knownContextSizes maps model name prefixes to their context window in tokens
+var knownContextSizes = []struct {
+	prefix string
+	tokens int
+}{
+	{"gpt-4o", 128000},
+	{"gpt-4.1", 1000000},
+	{"gpt-4-turbo", 128000},
+	{"gpt-4", 8192},
+	{"gpt-3.5-turbo", 16385},
+	{"gpt-5", 400000},
+	{"claude", 200000},
+	{"gemini-1.5", 1000000},
+	{"gemini", 32768},
+	{"llama3.1", 131072},
+	{"llama3.2", 131072},
+	{"llama3", 8192},
+	{"llama2", 4096},
+	{"mistral", 32768},
+	{"mixtral", 32768},
+	{"qwen2.5", 32768},
+	{"codellama", 16384},
+	{"deepseek-coder", 16384},
+	{"phi3", 4096},
+	{"mock", 8192},
+}
+
+// contextSize returns the context window for a provider: the configured
+// ContextSize if set, otherwise a known size for its model
+func contextSize(config ProviderConfig) int {
+	if config.ContextSize > 0 {
+		return config.ContextSize
+	}
+	model := strings.ToLower(config.Model)
+	for _, k := range knownContextSizes {
+		if strings.HasPrefix(model, k.prefix) {
+			return k.tokens
+		}
+	}
+	return defaultContextSize
+}
+
+// perMessageOverhead approximates the tokens spent on role labels and separators
+const perMessageOverhead = 4
+
+// trimHistory drops the oldest non-system messages until the conversation fits
+// in budget tokens. Leading system messages and the final message are always
+// kept; a marker message notes how many turns were removed.
+func trimHistory(messages []Message, budget int, model string) ([]Message, int) {
+	if len(messages) == 0 {
+		return messages, 0
+	}
+
+	var system, rest []Message
+	for i, m := range messages {
+		if m.Role == "system" && len(rest) == 0 {
+			system = append(system, m)
+			continue
+		}
+		rest = messages[i:]
+		break
+	}
+
+	cost := func(m Message) int {
+		return countTokens(m.Content, model).Tokens + perMessageOverhead
+	}
+
+	used := 0
+	for _, m := range system {
+		used += cost(m)
+	}
+
+	// Walk back from the newest message, keeping as many turns as fit
+	keepFrom := len(rest)
+	for i := len(rest) - 1; i >= 0; i-- {
+		c := cost(rest[i])
+		if i < len(rest)-1 && used+c > budget {
+			break
+		}
+		used += c
+		keepFrom = i
+	}
+
+	if keepFrom == 0 {
+		return messages, 0
+	}
+
+	out := append([]Message(nil), system...)
+	out = append(out, Message{
+		Role:    "system",
+		Content: fmt.Sprintf("[%d earlier messages omitted to fit the context window]", keepFrom),
+	})
+	out = append(out, rest[keepFrom:]...)
+	return out, keepFrom
+}
+
+// responseReserve is the part of the context window left free for the reply
+func responseReserve(size, maxTokens int) int {
+	if maxTokens <= 0 || maxTokens > size/2 {
+		return size / 4
+	}
+	return maxTokens
+}
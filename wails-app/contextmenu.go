@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/wailsapp/wails/v2/pkg/options"
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// singleInstanceID identifies the running app to later launches, which
+// hand over their arguments and exit
+const singleInstanceID = "io.github.aavishay.vibe-coder"
+
+// contextMenuLabel is the menu item the OS integrations add
+const contextMenuLabel = "Ask Vibe Coder"
+
+// PromptPrefill is a prompt started from outside the app, waiting to be
+// finished and sent. Files were attached to SessionID when they arrived.
+type PromptPrefill struct {
+	SessionID   string          `json:"sessionId,omitempty"`
+	Prompt      string          `json:"prompt"`
+	Attachments []AttachmentRef `json:"attachments,omitempty"`
+}
+
+// askRequest is what a context menu entry sent: selected text and/or file paths
+type askRequest struct {
+	Text  []string
+	Files []string
+}
+
+func (r askRequest) empty() bool {
+	return len(r.Text) == 0 && len(r.Files) == 0
+}
+
+// parseAskArgs reads "--ask-text <text>" and "--ask-files <path>..." from a
+// command line. Relative paths are resolved against dir.
+func parseAskArgs(args []string, dir string) askRequest {
+	var r askRequest
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--ask-text":
+			if i+1 < len(args) {
+				i++
+				if text := strings.TrimSpace(args[i]); text != "" {
+					r.Text = append(r.Text, text)
+				}
+			}
+		case "--ask-files":
+			for _, path := range args[i+1:] {
+				if path == "" {
+					continue
+				}
+				if !filepath.IsAbs(path) && dir != "" {
+					path = filepath.Join(dir, path)
+				}
+				r.Files = append(r.Files, path)
+			}
+			return r
+		}
+	}
+	return r
+}
+
+// askPrefill holds the prefill until the frontend takes it
+type askPrefill struct {
+	mu      sync.Mutex
+	pending *PromptPrefill
+}
+
+// receiveAsk turns text and files sent from the OS into a prefilled prompt
+// in the active conversation and brings the window forward. Requests that
+// arrive before the frontend takes the prefill are merged into it, since
+// Explorer launches the app once per selected file.
+func (a *App) receiveAsk(r askRequest) {
+	if r.empty() {
+		return
+	}
+	a.telemetry.recordFeature("context_menu_ask")
+
+	title := contextMenuLabel
+	if len(r.Text) > 0 {
+		title = r.Text[0]
+	} else if len(r.Files) > 0 {
+		title = filepath.Base(r.Files[0])
+	}
+	sessionID := a.ensureActiveSession(title)
+
+	var b strings.Builder
+	for _, text := range r.Text {
+		fmt.Fprintf(&b, "\n\n```\n%s\n```", text)
+	}
+	var attached []AttachmentRef
+	for _, path := range r.Files {
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			fmt.Fprintf(&b, "\n\nDirectory: %s", path)
+			continue
+		}
+		ref, err := a.AttachFile(sessionID, path)
+		if err != nil {
+			println("Error attaching file:", err.Error())
+			fmt.Fprintf(&b, "\n\nFile: %s", path)
+			continue
+		}
+		attached = append(attached, ref)
+	}
+
+	a.prefill.mu.Lock()
+	p := a.prefill.pending
+	if p == nil || p.SessionID != sessionID {
+		p = &PromptPrefill{SessionID: sessionID}
+		a.prefill.pending = p
+	}
+	p.Prompt = strings.TrimPrefix(p.Prompt+b.String(), "\n\n")
+	p.Attachments = append(p.Attachments, attached...)
+	prefill := *p
+	a.prefill.mu.Unlock()
+
+	a.emit(EventPromptPrefill, prefill)
+	a.showWindow()
+}
+
+// showWindow brings the main window to the front
+func (a *App) showWindow() {
+	if a.ctx == nil || a.eventSink != nil {
+		return
+	}
+	runtime.WindowUnminimise(a.ctx)
+	runtime.WindowShow(a.ctx)
+}
+
+// onSecondInstance receives the arguments of a later launch, such as one
+// started from a context menu entry
+func (a *App) onSecondInstance(data options.SecondInstanceData) {
+	a.receiveAsk(parseAskArgs(data.Args, data.WorkingDirectory))
+}
+
+// TakePrefilledPrompt returns the prompt prefilled from a context menu, if
+// any, and clears it so it is only shown once
+func (a *App) TakePrefilledPrompt() PromptPrefill {
+	a.prefill.mu.Lock()
+	defer a.prefill.mu.Unlock()
+
+	p := a.prefill.pending
+	a.prefill.pending = nil
+	if p == nil {
+		return PromptPrefill{}
+	}
+	return *p
+}
+
+// InstallContextMenu adds an "Ask Vibe Coder" entry to the OS: a Services
+// menu Quick Action on macOS, an Explorer context menu entry on Windows and
+// a Nautilus script on Linux. It returns where the entry was installed.
+func (a *App) InstallContextMenu() (string, error) {
+	a.telemetry.recordFeature("install_context_menu")
+	exe, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("locate executable: %v", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = resolved
+	}
+	return installContextMenu(exe)
+}
+
+// UninstallContextMenu removes the entry added by InstallContextMenu
+func (a *App) UninstallContextMenu() error {
+	return uninstallContextMenu()
+}
+
+// shellQuote quotes s for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// quickAction is one Services menu workflow: Automator runs command with the
+// selection on stdin (text) or as arguments (files)
+type quickAction struct {
+	name string
+	// input is the Automator service input type
+	input string
+	// sendTypes is the NSServices key and value of what the service accepts
+	sendKey, sendType string
+	// inputMethod is 0 for stdin and 1 for arguments
+	inputMethod int
+	command     string
+}
+
+func quickActions(exe string) []quickAction {
+	return []quickAction{
+		{contextMenuLabel, "com.apple.Automator.text", "NSSendTypes", "public.utf8-plain-text", 0,
+			fmt.Sprintf(`exec %s --ask-text "$(cat)"`, shellQuote(exe))},
+		{contextMenuLabel + " about Files", "com.apple.Automator.fileSystemObject", "NSSendFileTypes", "public.item", 1,
+			fmt.Sprintf(`exec %s --ask-files "$@"`, shellQuote(exe))},
+	}
+}
+
+func servicesDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, "Library", "Services"), nil
+}
+
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+const quickActionInfo = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>NSServices</key>
+	<array>
+		<dict>
+			<key>NSMenuItem</key>
+			<dict>
+				<key>default</key>
+				<string>%s</string>
+			</dict>
+			<key>NSMessage</key>
+			<string>runWorkflowAsService</string>
+			<key>%s</key>
+			<array>
+				<string>%s</string>
+			</array>
+		</dict>
+	</array>
+</dict>
+</plist>
+`
+
+const quickActionWorkflow = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>AMApplicationBuild</key>
+	<string>521</string>
+	<key>AMApplicationVersion</key>
+	<string>2.10</string>
+	<key>AMDocumentVersion</key>
+	<string>2</string>
+	<key>actions</key>
+	<array>
+		<dict>
+			<key>action</key>
+			<dict>
+				<key>AMAccepts</key>
+				<dict>
+					<key>Container</key>
+					<string>List</string>
+					<key>Optional</key>
+					<true/>
+					<key>Types</key>
+					<array>
+						<string>com.apple.cocoa.string</string>
+					</array>
+				</dict>
+				<key>AMActionVersion</key>
+				<string>2.0.3</string>
+				<key>AMProvides</key>
+				<dict>
+					<key>Container</key>
+					<string>List</string>
+					<key>Types</key>
+					<array>
+						<string>com.apple.cocoa.string</string>
+					</array>
+				</dict>
+				<key>ActionBundlePath</key>
+				<string>/System/Library/Automator/Run Shell Script.action</string>
+				<key>ActionName</key>
+				<string>Run Shell Script</string>
+				<key>ActionParameters</key>
+				<dict>
+					<key>COMMAND_STRING</key>
+					<string>%s</string>
+					<key>CheckedForUserDefaultShell</key>
+					<true/>
+					<key>inputMethod</key>
+					<integer>%d</integer>
+					<key>shell</key>
+					<string>/bin/sh</string>
+					<key>source</key>
+					<string></string>
+				</dict>
+				<key>BundleIdentifier</key>
+				<string>com.apple.RunShellScript</string>
+				<key>CFBundleVersion</key>
+				<string>2.0.3</string>
+				<key>Class Name</key>
+				<string>RunShellScriptAction</string>
+			</dict>
+		</dict>
+	</array>
+	<key>connectors</key>
+	<dict/>
+	<key>workflowMetaData</key>
+	<dict>
+		<key>serviceInputTypeIdentifier</key>
+		<string>%s</string>
+		<key>serviceOutputTypeIdentifier</key>
+		<string>com.apple.Automator.nothing</string>
+		<key>serviceProcessesInput</key>
+		<integer>0</integer>
+		<key>workflowTypeIdentifier</key>
+		<string>com.apple.Automator.servicesMenu</string>
+	</dict>
+</dict>
+</plist>
+`
+
+// installContextMenu writes Quick Actions to ~/Library/Services for selected
+// text and for files selected in Finder
+func installContextMenu(exe string) (string, error) {
+	dir, err := servicesDir()
+	if err != nil {
+		return "", err
+	}
+	for _, qa := range quickActions(exe) {
+		contents := filepath.Join(dir, qa.name+".workflow", "Contents")
+		info := fmt.Sprintf(quickActionInfo, xmlEscape(qa.name), qa.sendKey, qa.sendType)
+		workflow := fmt.Sprintf(quickActionWorkflow, xmlEscape(qa.command), qa.inputMethod, qa.input)
+		if err := writeFileAtomic(filepath.Join(contents, "Info.plist"), []byte(info), 0o644); err != nil {
+			return "", fmt.Errorf("install Quick Action: %v", err)
+		}
+		if err := writeFileAtomic(filepath.Join(contents, "document.wflow"), []byte(workflow), 0o644); err != nil {
+			return "", fmt.Errorf("install Quick Action: %v", err)
+		}
+	}
+	// Ask the pasteboard server to pick up the new services now rather than at next login
+	exec.Command("/System/Library/CoreServices/pbs", "-update").Run()
+	return dir, nil
+}
+
+func uninstallContextMenu() error {
+	dir, err := servicesDir()
+	if err != nil {
+		return err
+	}
+	for _, qa := range quickActions("") {
+		if err := os.RemoveAll(filepath.Join(dir, qa.name+".workflow")); err != nil {
+			return err
+		}
+	}
+	exec.Command("/System/Library/CoreServices/pbs", "-update").Run()
+	return nil
+}
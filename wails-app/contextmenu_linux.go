@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// nautilusScriptPath is where Nautilus looks for scripts in its context menu
+func nautilusScriptPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "share", "nautilus", "scripts", contextMenuLabel), nil
+}
+
+// installContextMenu writes a Nautilus script that passes the selected files
+// to the app. Nautilus lists the selection one path per line.
+func installContextMenu(exe string) (string, error) {
+	path, err := nautilusScriptPath()
+	if err != nil {
+		return "", err
+	}
+	script := fmt.Sprintf(`#!/bin/sh
+# Installed by Vibe Coder: sends the selected files to the running app
+IFS='
+'
+exec %s --ask-files $NAUTILUS_SCRIPT_SELECTED_FILE_PATHS
+`, shellQuote(exe))
+	if err := writeFileAtomic(path, []byte(script), 0o755); err != nil {
+		return "", fmt.Errorf("install Nautilus script: %v", err)
+	}
+	return path, nil
+}
+
+func uninstallContextMenu() error {
+	path, err := nautilusScriptPath()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
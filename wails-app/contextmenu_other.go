@@ -0,0 +1,14 @@
+//go:build !darwin && !linux && !windows
+
+package main
+
+import "fmt"
+
+// installContextMenu is not implemented on this platform
+func installContextMenu(exe string) (string, error) {
+	return "", fmt.Errorf("context menu integration is not supported on this platform")
+}
+
+func uninstallContextMenu() error {
+	return nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// explorerMenuKeys are the classes the Explorer entry is registered for:
+// every file type and folders
+var explorerMenuKeys = []string{
+	`HKCU\Software\Classes\*\shell\VibeCoder`,
+	`HKCU\Software\Classes\Directory\shell\VibeCoder`,
+}
+
+// installContextMenu registers an Explorer context menu entry for the
+// current user. Explorer runs the command once per selected item.
+func installContextMenu(exe string) (string, error) {
+	command := fmt.Sprintf(`"%s" --ask-files "%%1"`, exe)
+	for _, key := range explorerMenuKeys {
+		for _, args := range [][]string{
+			{"add", key, "/ve", "/d", contextMenuLabel, "/f"},
+			{"add", key, "/v", "Icon", "/d", exe, "/f"},
+			{"add", key + `\command`, "/ve", "/d", command, "/f"},
+		} {
+			if out, err := exec.Command("reg", args...).CombinedOutput(); err != nil {
+				return "", fmt.Errorf("register Explorer menu: %v: %s", err, out)
+			}
+		}
+	}
+	return explorerMenuKeys[0], nil
+}
+
+func uninstallContextMenu() error {
+	for _, key := range explorerMenuKeys {
+		// reg fails when the key is already gone, which is what we want anyway
+		exec.Command("reg", "delete", key, "/f").Run()
+	}
+	return nil
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// sealedContent is the content of a locked conversation encrypted with
+// AES-256-GCM under a key derived from its passphrase with scrypt
+type sealedContent struct {
+	Salt  []byte `json:"salt"`
+	Nonce []byte `json:"nonce"`
+	Data  []byte `json:"data"`
+}
+
+// lockedTitle stands in for the title of a locked conversation
+const lockedTitle = "Locked conversation"
+
+// lockedContent is what a lock encrypts; links and attachment references
+// stay readable so the conversation can still be listed and cleaned up
+type lockedContent struct {
+	Title      string               `json:"title"`
+	Messages   []Message            `json:"messages"`
+	Summary    *ConversationSummary `json:"summary,omitempty"`
+	Compaction []ContextSegment     `json:"compaction,omitempty"`
+	Notes      []SessionNote        `json:"notes,omitempty"`
+}
+
+// conversationKey is the key of a locked conversation unlocked in this run
+type conversationKey struct {
+	salt []byte
+	key  []byte
+}
+
+// errConversationLocked is returned when using a locked conversation that
+// is not unlocked
+func errConversationLocked(id string) error {
+	return fmt.Errorf("conversation %q is locked; unlock it with its passphrase first", id)
+}
+
+func sealConversation(s *Session, k conversationKey) (*sealedContent, error) {
+	plain, err := json.Marshal(lockedContent{Title: s.Title, Messages: s.Messages, Summary: s.Summary, Compaction: s.Compaction, Notes: s.Notes})
+	if err != nil {
+		return nil, err
+	}
+	aead, err := keyCipher(k.key)
+	if err != nil {
+		return nil, err
+	}
+	sealed := &sealedContent{Salt: k.salt, Nonce: make([]byte, aead.NonceSize())}
+	if _, err := io.ReadFull(rand.Reader, sealed.Nonce); err != nil {
+		return nil, err
+	}
+	sealed.Data = aead.Seal(nil, sealed.Nonce, plain, []byte(s.ID))
+	return sealed, nil
+}
+
+func (sc *sealedContent) open(id string, key []byte) (lockedContent, error) {
+	var content lockedContent
+	aead, err := keyCipher(key)
+	if err != nil {
+		return content, err
+	}
+	if len(sc.Nonce) != aead.NonceSize() {
+		return content, fmt.Errorf("invalid locked conversation: bad nonce")
+	}
+	plain, err := aead.Open(nil, sc.Nonce, sc.Data, []byte(id))
+	if err != nil {
+		return content, fmt.Errorf("wrong passphrase")
+	}
+	if err := json.Unmarshal(plain, &content); err != nil {
+		return content, fmt.Errorf("invalid locked conversation: %v", err)
+	}
+	return content, nil
+}
+
+// diskFormLocked returns s as it is written to disk: an unlocked locked
+// conversation is sealed again under its key
+func (st *SessionStore) diskFormLocked(s *Session) (*Session, error) {
+	if !s.Locked || s.Sealed != nil {
+		return s, nil
+	}
+	k, ok := st.keys[s.ID]
+	if !ok {
+		return nil, errConversationLocked(s.ID)
+	}
+	sealed, err := sealConversation(s, k)
+	if err != nil {
+		return nil, err
+	}
+	c := *s
+	c.Title, c.Messages, c.Summary, c.Compaction, c.Notes = lockedTitle, []Message{}, nil, nil, nil
+	c.Sealed = sealed
+	return &c, nil
+}
+
+// closeLocked seals an unlocked locked conversation and drops its content
+// from memory, the search index and the key cache
+func (st *SessionStore) closeLocked(s *Session) error {
+	c, err := st.diskFormLocked(s)
+	if err != nil {
+		return err
+	}
+	for i := range s.Messages {
+		st.index.remove(s.ID, i)
+	}
+	*s = *c
+	delete(st.keys, s.ID)
+	return nil
+}
+
+// locked reports whether a conversation is locked, unlocked or not
+func (st *SessionStore) locked(id string) bool {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	s, ok := st.sessions[id]
+	return ok && s.Locked
+}
+
+// inheritLockLocked locks child, made from an unlocked locked parent, under
+// the parent's passphrase
+func (st *SessionStore) inheritLockLocked(parent, child *Session) {
+	if parent.Locked {
+		child.Locked = true
+		st.keys[child.ID] = st.keys[parent.ID]
+	}
+}
+
+// LockConversation encrypts a conversation with its own passphrase. Its
+// title, messages, summary and notes are only written to disk encrypted,
+// and it can't be read, searched or continued until UnlockConversation is
+// called with the passphrase. It is locked right away.
+func (a *App) LockConversation(sessionID string, passphrase string) error {
+	a.telemetry.recordFeature("lock_conversation")
+	if strings.TrimSpace(passphrase) == "" {
+		return fmt.Errorf("passphrase is empty")
+	}
+	k := conversationKey{salt: make([]byte, 16)}
+	if _, err := io.ReadFull(rand.Reader, k.salt); err != nil {
+		return err
+	}
+	key, err := passphraseKey(passphrase, k.salt)
+	if err != nil {
+		return err
+	}
+	k.key = key
+
+	st := a.sessions
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	s, ok := st.sessions[sessionID]
+	if !ok {
+		return fmt.Errorf("session %q not found", sessionID)
+	}
+	if s.Locked {
+		return fmt.Errorf("conversation %q is already locked", sessionID)
+	}
+	s.Locked = true
+	st.keys[s.ID] = k
+	if err := st.closeLocked(s); err != nil {
+		s.Locked = false
+		delete(st.keys, s.ID)
+		return err
+	}
+	if err := st.saveLocked(s); err != nil {
+		return err
+	}
+	a.history.forgetSession(sessionID)
+	a.forgetMemories([]Session{{ID: sessionID}})
+	return nil
+}
+
+// lockSalt returns the salt of a locked conversation's key and whether it
+// is sealed. The key is slow to derive, so callers derive it from the salt
+// without holding the store and check the salt again once they do.
+func (st *SessionStore) lockSalt(id string) (salt []byte, sealed bool, err error) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	s, ok := st.sessions[id]
+	if !ok {
+		return nil, false, fmt.Errorf("session %q not found", id)
+	}
+	if !s.Locked {
+		return nil, false, fmt.Errorf("conversation %q is not locked", id)
+	}
+	if s.Sealed != nil {
+		return s.Sealed.Salt, true, nil
+	}
+	return st.keys[id].salt, false, nil
+}
+
+// openLocked decrypts s with key, which was derived from salt, or checks key
+// against the key s was unlocked with when it is not sealed
+func (st *SessionStore) openLocked(s *Session, salt, key []byte) error {
+	if s.Sealed == nil {
+		k := st.keys[s.ID]
+		if !bytes.Equal(k.salt, salt) || subtle.ConstantTimeCompare(key, k.key) != 1 {
+			return fmt.Errorf("wrong passphrase")
+		}
+		return nil
+	}
+	if !bytes.Equal(s.Sealed.Salt, salt) {
+		return fmt.Errorf("conversation %q was locked again while unlocking", s.ID)
+	}
+	content, err := s.Sealed.open(s.ID, key)
+	if err != nil {
+		return err
+	}
+	st.keys[s.ID] = conversationKey{salt: salt, key: key}
+	s.Title, s.Messages, s.Summary, s.Compaction, s.Notes = content.Title, content.Messages, content.Summary, content.Compaction, content.Notes
+	if s.Messages == nil {
+		s.Messages = []Message{}
+	}
+	s.Sealed = nil
+	for i, m := range s.Messages {
+		st.index.add(s.ID, i, m.Content)
+	}
+	return nil
+}
+
+// UnlockConversation decrypts a locked conversation for the rest of this
+// run, or until RelockConversation. It stays encrypted on disk.
+func (a *App) UnlockConversation(sessionID string, passphrase string) error {
+	st := a.sessions
+	salt, sealed, err := st.lockSalt(sessionID)
+	if err != nil || !sealed {
+		return err
+	}
+	key, err := passphraseKey(passphrase, salt)
+	if err != nil {
+		return err
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	s, ok := st.sessions[sessionID]
+	if !ok {
+		return fmt.Errorf("session %q not found", sessionID)
+	}
+	if !s.Locked {
+		return fmt.Errorf("conversation %q is not locked", sessionID)
+	}
+	return st.openLocked(s, salt, key)
+}
+
+// RelockConversation locks an unlocked conversation again without waiting
+// for the app to quit
+func (a *App) RelockConversation(sessionID string) error {
+	st := a.sessions
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	s, ok := st.sessions[sessionID]
+	if !ok {
+		return fmt.Errorf("session %q not found", sessionID)
+	}
+	if !s.Locked {
+		return fmt.Errorf("conversation %q is not locked", sessionID)
+	}
+	return st.closeLocked(s)
+}
+
+// RemoveConversationLock decrypts a locked conversation for good
+func (a *App) RemoveConversationLock(sessionID string, passphrase string) error {
+	st := a.sessions
+	salt, _, err := st.lockSalt(sessionID)
+	if err != nil {
+		return err
+	}
+	// It may have been unlocked before, so the passphrase is checked either way
+	key, err := passphraseKey(passphrase, salt)
+	if err != nil {
+		return err
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	s, ok := st.sessions[sessionID]
+	if !ok {
+		return fmt.Errorf("session %q not found", sessionID)
+	}
+	if !s.Locked {
+		return nil
+	}
+	if err := st.openLocked(s, salt, key); err != nil {
+		return err
+	}
+	s.Locked = false
+	delete(st.keys, s.ID)
+	return st.saveLocked(s)
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ModelPrice is what a hosted model charges, in US dollars per million tokens
+type ModelPrice struct {
+	InputPerMillion  float64 `json:"inputPerMillion"`
+	OutputPerMillion float64 `json:"outputPerMillion"`
+}
+
+// cost estimates the charge for a request in US dollars
+func (p ModelPrice) cost(tokensIn, tokensOut int) float64 {
+	return (float64(tokensIn)*p.InputPerMillion + float64(tokensOut)*p.OutputPerMillion) / 1e6
+}
+
+// modelPrices are published list prices of common hosted models. Dated
+// variants such as gpt-4o-2024-08-06 match by prefix, the longest first.
+var modelPrices = map[string]ModelPrice{
+	"gpt-4o":            {2.50, 10.00},
+	"gpt-4o-mini":       {0.15, 0.60},
+	"gpt-4.1":           {2.00, 8.00},
+	"gpt-4.1-mini":      {0.40, 1.60},
+	"gpt-4.1-nano":      {0.10, 0.40},
+	"gpt-4-turbo":       {10.00, 30.00},
+	"gpt-4":             {30.00, 60.00},
+	"gpt-3.5-turbo":     {0.50, 1.50},
+	"o1":                {15.00, 60.00},
+	"o1-mini":           {1.10, 4.40},
+	"o3":                {2.00, 8.00},
+	"o3-mini":           {1.10, 4.40},
+	"o4-mini":           {1.10, 4.40},
+	"claude-opus-4":     {15.00, 75.00},
+	"claude-sonnet-4":   {3.00, 15.00},
+	"claude-3-7-sonnet": {3.00, 15.00},
+	"claude-3-5-sonnet": {3.00, 15.00},
+	"claude-3-5-haiku":  {0.80, 4.00},
+	"claude-3-opus":     {15.00, 75.00},
+	"claude-3-haiku":    {0.25, 1.25},
+	"gemini-2.5-pro":    {1.25, 10.00},
+	"gemini-2.5-flash":  {0.30, 2.50},
+	"gemini-2.0-flash":  {0.10, 0.40},
+	"gemini-1.5-pro":    {1.25, 5.00},
+	"gemini-1.5-flash":  {0.075, 0.30},
+	"mistral-large":     {2.00, 6.00},
+	"mistral-small":     {0.20, 0.60},
+	"deepseek-chat":     {0.27, 1.10},
+	"deepseek-reasoner": {0.55, 2.19},
+}
+
+// normalizeModelName lowercases a model name and drops a router prefix
+// such as "openai/"
+func normalizeModelName(model string) string {
+	model = strings.ToLower(strings.TrimSpace(model))
+	if i := strings.LastIndex(model, "/"); i >= 0 {
+		model = model[i+1:]
+	}
+	return model
+}
+
+// lookupPrice finds the longest entry of table that model starts with
+func lookupPrice(table map[string]ModelPrice, model string) (ModelPrice, bool) {
+	model = normalizeModelName(model)
+	best := ""
+	for name := range table {
+		if strings.HasPrefix(model, name) && len(name) > len(best) {
+			best = name
+		}
+	}
+	if best == "" {
+		return ModelPrice{}, false
+	}
+	return table[best], true
+}
+
+// priceFor returns what config's model charges. Prices set with
+// SetModelPrice apply to any provider; the built-in table only to hosted
+// ones, since a local model with a familiar name costs nothing to run.
+func (a *App) priceFor(config ProviderConfig) (ModelPrice, bool) {
+	a.providersMutex.RLock()
+	p, ok := lookupPrice(a.modelPrices, config.Model)
+	a.providersMutex.RUnlock()
+	if ok {
+		return p, true
+	}
+	if isLocalProvider(config) {
+		return ModelPrice{}, false
+	}
+	return lookupPrice(modelPrices, config.Model)
+}
+
+// ModelPricing is one row of the pricing table
+type ModelPricing struct {
+	Model string `json:"model"`
+	ModelPrice
+	// Custom marks prices set with SetModelPrice
+	Custom bool `json:"custom,omitempty"`
+}
+
+// GetModelPrices returns the pricing table used for cost estimates, with
+// custom prices in place of the built-in ones they override
+func (a *App) GetModelPrices() []ModelPricing {
+	a.providersMutex.RLock()
+	defer a.providersMutex.RUnlock()
+
+	rows := make(map[string]ModelPricing, len(modelPrices)+len(a.modelPrices))
+	for model, p := range modelPrices {
+		rows[model] = ModelPricing{Model: model, ModelPrice: p}
+	}
+	for model, p := range a.modelPrices {
+		rows[model] = ModelPricing{Model: model, ModelPrice: p, Custom: true}
+	}
+	out := make([]ModelPricing, 0, len(rows))
+	for _, row := range rows {
+		out = append(out, row)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Model < out[j].Model })
+	return out
+}
+
+// SetModelPrice sets the price of a model, or of every model whose name
+// starts with model. It overrides the built-in table and also prices local
+// providers, e.g. to account for a rented GPU.
+func (a *App) SetModelPrice(model string, price ModelPrice) error {
+	model = normalizeModelName(model)
+	if model == "" {
+		return fmt.Errorf("model name is required")
+	}
+	if price.InputPerMillion < 0 || price.OutputPerMillion < 0 {
+		return fmt.Errorf("prices must not be negative")
+	}
+
+	a.providersMutex.Lock()
+	defer a.providersMutex.Unlock()
+	if a.modelPrices == nil {
+		a.modelPrices = make(map[string]ModelPrice)
+	}
+	a.modelPrices[model] = price
+	return a.saveConfigLocked()
+}
+
+// ResetModelPrice removes a price set with SetModelPrice
+func (a *App) ResetModelPrice(model string) error {
+	a.providersMutex.Lock()
+	defer a.providersMutex.Unlock()
+	delete(a.modelPrices, normalizeModelName(model))
+	return a.saveConfigLocked()
+}
+
+// Budget limits estimated spending on hosted models per calendar month
+type Budget struct {
+	// MonthlyUSD is the limit in US dollars; 0 means no budget
+	MonthlyUSD float64 `json:"monthlyUsd,omitempty"`
+	// Action is "warn" (the default) to emit "budget:exceeded" and carry on,
+	// or "block" to refuse paid requests until the month ends
+	Action string `json:"action,omitempty"`
+}
+
+// SetBudget sets the monthly budget
+func (a *App) SetBudget(budget Budget) error {
+	if budget.MonthlyUSD < 0 {
+		return fmt.Errorf("budget must not be negative")
+	}
+	switch budget.Action {
+	case "":
+		budget.Action = "warn"
+	case "warn", "block":
+	default:
+		return fmt.Errorf("unknown budget action %q; use warn or block", budget.Action)
+	}
+
+	a.providersMutex.Lock()
+	defer a.providersMutex.Unlock()
+	a.budget = budget
+	return a.saveConfigLocked()
+}
+
+// GetBudget returns the monthly budget
+func (a *App) GetBudget() Budget {
+	a.providersMutex.RLock()
+	defer a.providersMutex.RUnlock()
+	return a.budget
+}
+
+func monthStart(t time.Time) string {
+	return t.Format("2006-01") + "-01"
+}
+
+// checkBudget refuses a paid request once the month's spending has reached
+// a blocking budget
+func (a *App) checkBudget(config ProviderConfig) error {
+	budget := a.GetBudget()
+	if budget.MonthlyUSD <= 0 || budget.Action != "block" {
+		return nil
+	}
+	if price, ok := a.priceFor(config); !ok || price == (ModelPrice{}) {
+		return nil
+	}
+	spent := a.metrics.costSince(monthStart(time.Now()))
+	if spent < budget.MonthlyUSD {
+		return nil
+	}
+	f := a.reportFormatter()
+	return fmt.Errorf("monthly budget of %s reached (%s spent); raise it or use a local provider", f.Money(budget.MonthlyUSD), f.Money(spent))
+}
+
+// noteSpending emits "budget:exceeded" when a request takes the month's
+// spending over the budget
+func (a *App) noteSpending(rec RequestRecord) {
+	budget := a.GetBudget()
+	if budget.MonthlyUSD <= 0 || rec.CostUSD == 0 {
+		return
+	}
+	spent := a.metrics.costSince(monthStart(rec.Timestamp))
+	if spent < budget.MonthlyUSD || spent-rec.CostUSD >= budget.MonthlyUSD {
+		return
+	}
+	f := a.reportFormatter()
+	a.emit(EventBudgetExceeded, BudgetExceededEvent{
+		RequestID: rec.ID,
+		SpentUSD:  spent,
+		BudgetUSD: budget.MonthlyUSD,
+		Spent:     f.Money(spent),
+		Budget:    f.Money(budget.MonthlyUSD),
+		Action:    budget.Action,
+	})
+}
+
+// UsageCosts is the estimated spending over a period
+type UsageCosts struct {
+	Since    string  `json:"since"`
+	TotalUSD float64 `json:"totalUsd"`
+	// Total and the other unsuffixed amounts are formatted in the report currency
+	Total      string         `json:"total"`
+	ByProvider []ProviderCost `json:"byProvider"`
+	Daily      []DailyCost    `json:"daily"`
+	// MonthToDateUSD is spending since the start of the calendar month, for comparing with the budget
+	MonthToDateUSD float64 `json:"monthToDateUsd"`
+	MonthToDate    string  `json:"monthToDate"`
+	Budget         Budget  `json:"budget"`
+	// BudgetUsed is MonthToDateUSD as a fraction of the budget, when there is one
+	BudgetUsed float64 `json:"budgetUsed,omitempty"`
+}
+
+// ProviderCost is one provider's share of UsageCosts
+type ProviderCost struct {
+	ProviderID string  `json:"providerId"`
+	Provider   string  `json:"provider"`
+	Model      string  `json:"model"`
+	Requests   int64   `json:"requests"`
+	TokensIn   int64   `json:"tokensIn"`
+	TokensOut  int64   `json:"tokensOut"`
+	CostUSD    float64 `json:"costUsd"`
+	Cost       string  `json:"cost"`
+}
+
+// DailyCost is the spending of one day
+type DailyCost struct {
+	Date    string  `json:"date"`
+	CostUSD float64 `json:"costUsd"`
+	Cost    string  `json:"cost"`
+}
+
+// GetUsageCosts estimates what requests to hosted models cost over the last
+// days days, today included (days <= 0 means today only), from the tokens
+// sent and received and the pricing table. Local models are free.
+func (a *App) GetUsageCosts(days int) UsageCosts {
+	a.telemetry.recordFeature("usage_costs")
+	if days <= 0 {
+		days = 1
+	}
+	now := time.Now()
+	since := now.AddDate(0, 0, 1-days).Format("2006-01-02")
+	f := a.reportFormatter()
+
+	out := UsageCosts{Since: since, Budget: a.GetBudget(), ByProvider: []ProviderCost{}, Daily: []DailyCost{}}
+	byDate := make(map[string]float64)
+	for _, m := range a.metrics.report(since) {
+		out.TotalUSD += m.CostUSD
+		out.ByProvider = append(out.ByProvider, ProviderCost{
+			ProviderID: m.ProviderID,
+			Provider:   m.Provider,
+			Model:      m.Model,
+			Requests:   m.Requests,
+			TokensIn:   m.TokensIn,
+			TokensOut:  m.TokensOut,
+			CostUSD:    m.CostUSD,
+			Cost:       f.Money(m.CostUSD),
+		})
+		for _, d := range m.Daily {
+			byDate[d.Date] += d.CostUSD
+		}
+	}
+	sort.Slice(out.ByProvider, func(i, j int) bool { return out.ByProvider[i].CostUSD > out.ByProvider[j].CostUSD })
+	for date, cost := range byDate {
+		out.Daily = append(out.Daily, DailyCost{Date: date, CostUSD: cost, Cost: f.Money(cost)})
+	}
+	sort.Slice(out.Daily, func(i, j int) bool { return out.Daily[i].Date < out.Daily[j].Date })
+	out.Total = f.Money(out.TotalUSD)
+
+	out.MonthToDateUSD = a.metrics.costSince(monthStart(now))
+	out.MonthToDate = f.Money(out.MonthToDateUSD)
+	if out.Budget.MonthlyUSD > 0 {
+		out.BudgetUsed = out.MonthToDateUSD / out.Budget.MonthlyUSD
+	}
+	return out
+}
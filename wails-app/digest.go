@@ -0,0 +1,406 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxDigestItems caps each list in a workspace digest
+	maxDigestItems = 50
+	// maxTestReportBytes skips test reports too large to be worth parsing
+	maxTestReportBytes = 8 << 20
+	// emptyTreeHash is git's empty tree, the base for repositories that are
+	// newer than the last session
+	emptyTreeHash = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+)
+
+var diffHunkPattern = regexp.MustCompile(`^@@ -\d+(?:,\d+)? \+(\d+)(?:,\d+)? @@`)
+
+// WorkspaceDigest is what changed in a workspace since the last session in
+// it: commits, changed files, new TODOs and failing tests
+type WorkspaceDigest struct {
+	Workspace string    `json:"workspace"`
+	Since     time.Time `json:"since"`
+	// Commits are "<short hash> <author>: <subject>", newest first
+	Commits []string `json:"commits"`
+	// ChangedFiles are "<status> <path>" from git, including uncommitted changes
+	ChangedFiles []string      `json:"changedFiles"`
+	TODOs        []DigestTODO  `json:"todos"`
+	FailingTests []FailingTest `json:"failingTests"`
+	Truncated    bool          `json:"truncated,omitempty"`
+	// SessionID is the conversation started with the digest, if any
+	SessionID string `json:"sessionId,omitempty"`
+}
+
+func (d WorkspaceDigest) empty() bool {
+	return len(d.Commits) == 0 && len(d.ChangedFiles) == 0 && len(d.TODOs) == 0 && len(d.FailingTests) == 0
+}
+
+// DigestTODO is a TODO or FIXME comment added since the last session
+type DigestTODO struct {
+	Path string `json:"path"`
+	Line int    `json:"line"`
+	Text string `json:"text"`
+}
+
+// FailingTest is a failed test case from a JUnit XML report written since
+// the last session
+type FailingTest struct {
+	Name    string `json:"name"`
+	Report  string `json:"report"`
+	Message string `json:"message,omitempty"`
+}
+
+// visitStore remembers when each workspace was last left, which marks the
+// end of the last session in it
+type visitStore struct {
+	mu     sync.Mutex
+	path   string
+	visits map[string]time.Time
+}
+
+func newVisitStore() *visitStore {
+	return &visitStore{visits: make(map[string]time.Time)}
+}
+
+func (vs *visitStore) open(path string) error {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+
+	vs.path = path
+	if err := readJSONFile(path, &vs.visits); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if vs.visits == nil {
+		vs.visits = make(map[string]time.Time)
+	}
+	return nil
+}
+
+func (vs *visitStore) lastSeen(workspace string) time.Time {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	return vs.visits[workspace]
+}
+
+// leave records that the session in workspace ended now
+func (vs *visitStore) leave(workspace string) {
+	if workspace == "" {
+		return
+	}
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	vs.visits[workspace] = time.Now().UTC()
+	if vs.path == "" {
+		return
+	}
+	if err := writeJSONFile(vs.path, vs.visits); err != nil {
+		println("Error saving workspace visits:", err.Error())
+	}
+}
+
+// git runs a git command in dir and returns its output
+func git(dir string, args ...string) (string, error) {
+	out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).Output()
+	if err != nil {
+		// git explains most failures on stderr
+		if exitErr, ok := err.(*exec.ExitError); ok && len(bytes.TrimSpace(exitErr.Stderr)) > 0 {
+			return "", fmt.Errorf("git %s: %s", args[0], bytes.TrimSpace(exitErr.Stderr))
+		}
+		return "", fmt.Errorf("git %s: %v", args[0], err)
+	}
+	return string(out), nil
+}
+
+// buildWorkspaceDigest collects what changed in dir since
+func buildWorkspaceDigest(dir string, since time.Time) (WorkspaceDigest, error) {
+	d := WorkspaceDigest{Workspace: dir, Since: since}
+	add := func(list []string, item string) []string {
+		if len(list) >= maxDigestItems {
+			d.Truncated = true
+			return list
+		}
+		return append(list, item)
+	}
+
+	if _, err := git(dir, "rev-parse", "--git-dir"); err == nil {
+		stamp := since.Format(time.RFC3339)
+		out, err := git(dir, "log", "--no-merges", "--since="+stamp, "--pretty=format:%h %an: %s")
+		if err != nil {
+			return d, err
+		}
+		for _, line := range strings.Split(out, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				d.Commits = add(d.Commits, line)
+			}
+		}
+
+		base, _ := git(dir, "rev-list", "-1", "--before="+stamp, "HEAD")
+		if base = strings.TrimSpace(base); base == "" {
+			base = emptyTreeHash
+		}
+		if out, err = git(dir, "diff", "--name-status", base); err != nil {
+			return d, err
+		}
+		for _, line := range strings.Split(out, "\n") {
+			if fields := strings.Fields(line); len(fields) >= 2 {
+				d.ChangedFiles = add(d.ChangedFiles, fields[0][:1]+" "+fields[len(fields)-1])
+			}
+		}
+		if out, err = git(dir, "diff", "-U0", "--no-color", base); err != nil {
+			return d, err
+		}
+		d.TODOs = addedTODOs(out)
+
+		// New files git doesn't track yet are changes too
+		out, _ = git(dir, "ls-files", "--others", "--exclude-standard")
+		for _, rel := range strings.Split(out, "\n") {
+			if rel = strings.TrimSpace(rel); rel == "" {
+				continue
+			}
+			info, err := os.Stat(filepath.Join(dir, filepath.FromSlash(rel)))
+			if err != nil || info.ModTime().Before(since) {
+				continue
+			}
+			d.ChangedFiles = add(d.ChangedFiles, "? "+rel)
+			d.TODOs = append(d.TODOs, fileTODOs(dir, rel)...)
+		}
+		if len(d.TODOs) > maxDigestItems {
+			d.TODOs, d.Truncated = d.TODOs[:maxDigestItems], true
+		}
+	}
+
+	d.FailingTests = failingTests(dir, since)
+	if len(d.FailingTests) > maxDigestItems {
+		d.FailingTests, d.Truncated = d.FailingTests[:maxDigestItems], true
+	}
+	return d, nil
+}
+
+// addedTODOs returns the TODO and FIXME comments on lines a zero-context
+// unified diff adds
+func addedTODOs(diff string) []DigestTODO {
+	var todos []DigestTODO
+	var path string
+	line := 0
+	for _, l := range strings.Split(diff, "\n") {
+		switch {
+		case strings.HasPrefix(l, "+++ "):
+			path = strings.TrimPrefix(strings.TrimPrefix(l, "+++ "), "b/")
+		case strings.HasPrefix(l, "@@"):
+			if m := diffHunkPattern.FindStringSubmatch(l); m != nil {
+				line, _ = strconv.Atoi(m[1])
+			}
+		case strings.HasPrefix(l, "+"):
+			if m := todoMarkerPattern.FindStringSubmatch(l[1:]); m != nil && path != "/dev/null" {
+				todos = append(todos, DigestTODO{Path: path, Line: line, Text: strings.TrimSpace(m[1])})
+			}
+			line++
+		}
+	}
+	return todos
+}
+
+// fileTODOs returns the TODO and FIXME comments in a new file
+func fileTODOs(dir, rel string) []DigestTODO {
+	var todos []DigestTODO
+	scanLog(filepath.Join(dir, filepath.FromSlash(rel)), func(n int, line string) bool {
+		if m := todoMarkerPattern.FindStringSubmatch(line); m != nil {
+			todos = append(todos, DigestTODO{Path: rel, Line: n, Text: strings.TrimSpace(m[1])})
+		}
+		return len(todos) < maxDigestItems
+	})
+	return todos
+}
+
+// junitCase is a test case in a JUnit XML report
+type junitCase struct {
+	Name      string `xml:"name,attr"`
+	ClassName string `xml:"classname,attr"`
+	Failure   *struct {
+		Message string `xml:"message,attr"`
+	} `xml:"failure"`
+	Error *struct {
+		Message string `xml:"message,attr"`
+	} `xml:"error"`
+}
+
+// failingTests returns the failed cases in JUnit XML reports under dir
+// written since
+func failingTests(dir string, since time.Time) []FailingTest {
+	var failed []FailingTest
+	count := 0
+	filepath.WalkDir(dir, func(path string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if e.IsDir() {
+			// Reports are usually written under build or target
+			if path != dir && skipIndexDir(e.Name()) && e.Name() != "build" && e.Name() != "target" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if count++; count > maxIndexedFiles || len(failed) >= maxDigestItems {
+			return filepath.SkipAll
+		}
+		if !strings.HasSuffix(e.Name(), ".xml") {
+			return nil
+		}
+		info, err := e.Info()
+		if err != nil || info.ModTime().Before(since) || info.Size() > maxTestReportBytes {
+			return nil
+		}
+		rel, _ := filepath.Rel(dir, path)
+		failed = append(failed, reportFailures(path, filepath.ToSlash(rel))...)
+		return nil
+	})
+	return failed
+}
+
+// reportFailures parses a JUnit XML report, returning nothing for other XML files
+func reportFailures(path, rel string) []FailingTest {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var failed []FailingTest
+	dec := xml.NewDecoder(bufio.NewReader(f))
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return failed
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "testcase" {
+			continue
+		}
+		var c junitCase
+		if err := dec.DecodeElement(&c, &start); err != nil {
+			return failed
+		}
+		name := c.Name
+		if c.ClassName != "" {
+			name = c.ClassName + "." + c.Name
+		}
+		switch {
+		case c.Failure != nil:
+			failed = append(failed, FailingTest{Name: name, Report: rel, Message: c.Failure.Message})
+		case c.Error != nil:
+			failed = append(failed, FailingTest{Name: name, Report: rel, Message: c.Error.Message})
+		}
+	}
+}
+
+// markdown renders the digest as the opening message of a conversation
+func (d WorkspaceDigest) markdown(f reportFormatter) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "**What changed in %s since %s**\n", filepath.Base(d.Workspace), f.LongDate(d.Since))
+	if len(d.Commits) > 0 {
+		fmt.Fprintf(&b, "\nCommits (%s):\n", f.Int(int64(len(d.Commits))))
+		for _, c := range d.Commits {
+			fmt.Fprintf(&b, "- %s\n", c)
+		}
+	}
+	if len(d.ChangedFiles) > 0 {
+		b.WriteString("\nChanged files:\n")
+		for _, c := range d.ChangedFiles {
+			fmt.Fprintf(&b, "- %s\n", c)
+		}
+	}
+	if len(d.TODOs) > 0 {
+		b.WriteString("\nNew TODOs:\n")
+		for _, t := range d.TODOs {
+			fmt.Fprintf(&b, "- %s:%d %s\n", t.Path, t.Line, t.Text)
+		}
+	}
+	if len(d.FailingTests) > 0 {
+		b.WriteString("\nFailing tests:\n")
+		for _, t := range d.FailingTests {
+			if t.Message != "" {
+				fmt.Fprintf(&b, "- %s (%s): %s\n", t.Name, t.Report, t.Message)
+			} else {
+				fmt.Fprintf(&b, "- %s (%s)\n", t.Name, t.Report)
+			}
+		}
+	}
+	if d.Truncated {
+		fmt.Fprintf(&b, "\nOnly the first %d of each are listed.\n", maxDigestItems)
+	}
+	return b.String()
+}
+
+// startDigest builds the digest of a workspace just opened and starts a
+// conversation with it, emitting "workspace:digest"
+func (a *App) startDigest(workspace string, since time.Time) {
+	d, err := a.digestSince(workspace, since)
+	if err != nil {
+		println("Error building workspace digest:", err.Error())
+		return
+	}
+	if !d.empty() {
+		a.emit(EventWorkspaceDigest, d)
+	}
+}
+
+// digestSince builds a workspace's digest and, when anything changed,
+// saves it as a new conversation to start from
+func (a *App) digestSince(workspace string, since time.Time) (WorkspaceDigest, error) {
+	d, err := buildWorkspaceDigest(workspace, since)
+	if err != nil || d.empty() {
+		return d, err
+	}
+	f := a.reportFormatter()
+	s := a.sessions.create("Since " + f.Date(since))
+	if err := a.sessions.appendMessages(s.ID, Message{Role: "assistant", Content: d.markdown(f)}); err != nil {
+		return d, fmt.Errorf("save digest: %v", err)
+	}
+	d.SessionID = s.ID
+	return d, nil
+}
+
+// DigestSinceLastSession returns what changed in the open workspace since
+// the last session in it and starts a conversation with it
+func (a *App) DigestSinceLastSession() (WorkspaceDigest, error) {
+	a.telemetry.recordFeature("workspace_digest")
+	a.providersMutex.RLock()
+	workspace, since := a.workspace, a.lastSession
+	a.providersMutex.RUnlock()
+	if workspace == "" {
+		return WorkspaceDigest{}, fmt.Errorf("no workspace is open")
+	}
+	if since.IsZero() {
+		return WorkspaceDigest{}, fmt.Errorf("no earlier session in %s", workspace)
+	}
+	return a.digestSince(workspace, since)
+}
+
+// SetWorkspaceDigest turns the digest on opening a workspace on or off
+func (a *App) SetWorkspaceDigest(enabled bool) error {
+	a.providersMutex.Lock()
+	defer a.providersMutex.Unlock()
+
+	a.workspaceDigest = enabled
+	return a.saveConfigLocked()
+}
+
+// GetWorkspaceDigest reports whether a digest is built on opening a workspace
+func (a *App) GetWorkspaceDigest() bool {
+	a.providersMutex.RLock()
+	defer a.providersMutex.RUnlock()
+	return a.workspaceDigest
+}
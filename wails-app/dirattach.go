@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// dirFileMaxBytes skips files too large to be worth attaching whole
+	dirFileMaxBytes = 256 << 10
+	// dirMaxFiles caps the files looked at in one directory attachment
+	dirMaxFiles = 2000
+)
+
+// Reasons a file of an attached directory was left out
+const (
+	skipBinary   = "binary"
+	skipTooLarge = "too large"
+	skipBudget   = "over budget"
+	skipUnread   = "unreadable"
+)
+
+// SkippedFile is a file of an attached directory that was left out
+type SkippedFile struct {
+	Path string `json:"path"`
+	// Reason is "binary", "too large", "over budget" or "unreadable"
+	Reason string `json:"reason"`
+}
+
+// DirectoryContext is what attaching a directory sends: the files that fit
+// in the budget and those left out. Files that git ignores are not listed.
+type DirectoryContext struct {
+	Dir      string        `json:"dir"`
+	Included []PromptFile  `json:"included"`
+	Skipped  []SkippedFile `json:"skipped"`
+	Tokens   int           `json:"tokens"`
+	// Truncated is set when the directory has more files than are looked at
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// DirectoryReply is the reply to a prompt with an attached directory
+type DirectoryReply struct {
+	Response string           `json:"response"`
+	Context  DirectoryContext `json:"context"`
+}
+
+// directoryFiles lists the files under dir that git doesn't ignore, those in
+// shallower directories first, as slash-separated paths relative to root
+func directoryFiles(root, dir string) (files []string, truncated bool, err error) {
+	ignore := newIgnoreMatcher(root)
+	start := filepath.Join(root, filepath.FromSlash(dir))
+	err = filepath.WalkDir(start, func(p string, e fs.DirEntry, err error) error {
+		if err != nil {
+			if p == start {
+				return err
+			}
+			return nil
+		}
+		if p == start {
+			return nil
+		}
+		rel, _ := filepath.Rel(root, p)
+		rel = filepath.ToSlash(rel)
+		if ignore.ignored(rel, e.IsDir()) {
+			if e.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if e.IsDir() || !e.Type().IsRegular() {
+			return nil
+		}
+		if len(files) >= dirMaxFiles {
+			truncated = true
+			return filepath.SkipAll
+		}
+		files = append(files, rel)
+		return nil
+	})
+	sort.SliceStable(files, func(i, j int) bool {
+		return strings.Count(files[i], "/") < strings.Count(files[j], "/")
+	})
+	return files, truncated, err
+}
+
+// directoryContext reads the files of dir in the active conversation's
+// directory that fit in the file budget. A file that doesn't fit is left
+// out, and smaller files after it can still be taken.
+func (a *App) directoryContext(dir string) (DirectoryContext, []attachedFile, error) {
+	root := a.sessionRoot("")
+	if root == "" {
+		return DirectoryContext{}, nil, fmt.Errorf("no workspace is open")
+	}
+	rel := path.Clean(filepath.ToSlash(dir))
+	if rel == "/" {
+		rel = "."
+	}
+	target, err := a.sandboxedPath("", rel)
+	if err != nil {
+		return DirectoryContext{}, nil, err
+	}
+	if info, err := os.Stat(target); err != nil || !info.IsDir() {
+		return DirectoryContext{}, nil, fmt.Errorf("%s is not a directory", rel)
+	}
+	paths, truncated, err := directoryFiles(root, rel)
+	if err != nil {
+		return DirectoryContext{}, nil, err
+	}
+
+	dc := DirectoryContext{Dir: rel, Included: []PromptFile{}, Skipped: []SkippedFile{}, Truncated: truncated}
+	model := a.activeModel()
+	var kept []attachedFile
+	for _, p := range paths {
+		// The walk doesn't follow symlinks, so every file is in the workspace
+		full := filepath.Join(root, filepath.FromSlash(p))
+		info, err := os.Stat(full)
+		if err != nil {
+			dc.Skipped = append(dc.Skipped, SkippedFile{Path: p, Reason: skipUnread})
+			continue
+		}
+		if info.Size() > dirFileMaxBytes {
+			dc.Skipped = append(dc.Skipped, SkippedFile{Path: p, Reason: skipTooLarge})
+			continue
+		}
+		content, ok := readText(full)
+		if !ok {
+			dc.Skipped = append(dc.Skipped, SkippedFile{Path: p, Reason: skipBinary})
+			continue
+		}
+		f := attachedFile{PromptFile: PromptFile{Path: p, Tokens: countTokens(content, model).Tokens}, language: fileLanguage(p), content: content}
+		if dc.Tokens+f.Tokens > fileContextTokens {
+			dc.Skipped = append(dc.Skipped, SkippedFile{Path: p, Reason: skipBudget})
+			continue
+		}
+		dc.Tokens += f.Tokens
+		dc.Included = append(dc.Included, f.PromptFile)
+		kept = append(kept, f)
+	}
+	return dc, kept, nil
+}
+
+// PreviewDirectoryContext reports which files attaching a directory of the
+// workspace would send, without sending anything
+func (a *App) PreviewDirectoryContext(dir string) (DirectoryContext, error) {
+	dc, _, err := a.directoryContext(dir)
+	return dc, err
+}
+
+// SendPromptWithDirectory is SendPromptWithFiles with every file of a
+// workspace directory that git doesn't ignore, shallower files first.
+// Binary files, files over 256 KiB and files that don't fit in the 16,000
+// token budget are left out; the reply reports what was sent and skipped.
+func (a *App) SendPromptWithDirectory(prompt string, dir string) (DirectoryReply, error) {
+	a.telemetry.recordFeature("send_prompt_with_directory")
+	dc, files, err := a.directoryContext(dir)
+	if err != nil {
+		return DirectoryReply{}, err
+	}
+	if len(files) == 0 {
+		return DirectoryReply{Context: dc}, fmt.Errorf("no files in %s can be attached", dc.Dir)
+	}
+	result, err := a.sendPrompt(prompt, sendOptions{files: files}, nil)
+	return DirectoryReply{Response: result.Response, Context: dc}, err
+}
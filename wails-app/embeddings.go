@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Embedder is implemented by providers that can produce vector embeddings
+type Embedder interface {
+	Embed(texts []string) ([][]float64, error)
+}
+
+// defaultOpenAIEmbeddingModel is what OpenAI providers embed with when no
+// embedding model is set, since their chat models can't embed
+const defaultOpenAIEmbeddingModel = "text-embedding-3-small"
+
+// embeddingModel returns the model a provider embeds with
+func embeddingModel(p Provider) string {
+	config := p.GetConfig()
+	switch {
+	case config.EmbeddingModel != "":
+		return config.EmbeddingModel
+	case config.Type == "OpenAI":
+		return defaultOpenAIEmbeddingModel
+	}
+	return config.Model
+}
+
+// embeddingsUnsupported reports whether an embedding request failed because
+// the provider or its model can't embed at all, rather than for this request
+func embeddingsUnsupported(err error) bool {
+	msg := strings.ToLower(err.Error())
+	for _, s := range []string{"http 404", "http 405", "http 501", "not support", "unknown method"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Embed returns embeddings for texts from the Ollama /api/embed endpoint
+func (p *OllamaProvider) Embed(texts []string) ([][]float64, error) {
+	url := fmt.Sprintf("%s/api/embed", p.config.Endpoint)
+
+	payload := map[string]interface{}{
+		"model": embeddingModel(p),
+		"input": texts,
+	}
+
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := p.client.Post(url, "application/json", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("network error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(body))
+	}
+
+	var result struct {
+		Embeddings [][]float64 `json:"embeddings"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("invalid response: %v", err)
+	}
+	if len(result.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(result.Embeddings))
+	}
+
+	return result.Embeddings, nil
+}
+
+// Embed returns embeddings for texts from the /embeddings endpoint
+func (p *OpenAIProvider) Embed(texts []string) ([][]float64, error) {
+	jsonData, err := json.Marshal(map[string]interface{}{
+		"model": embeddingModel(p),
+		"input": texts,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := p.newRequest(http.MethodPost, "/embeddings", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("network error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(body))
+	}
+
+	var result struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float64 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("invalid response: %v", err)
+	}
+	if len(result.Data) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(result.Data))
+	}
+	// The API numbers each embedding, which need not come back in order
+	sort.Slice(result.Data, func(i, j int) bool { return result.Data[i].Index < result.Data[j].Index })
+	vectors := make([][]float64, len(result.Data))
+	for i, d := range result.Data {
+		vectors[i] = d.Embedding
+	}
+	return vectors, nil
+}
+
+// Embed asks the plugin to embed texts with its "embed" method
+func (p *PluginProvider) Embed(texts []string) ([][]float64, error) {
+	raw, err := p.call("embed", map[string]interface{}{
+		"texts": texts,
+		"model": embeddingModel(p),
+	}, pluginRequestTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Embeddings [][]float64 `json:"embeddings"`
+	}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, fmt.Errorf("invalid response: %v", err)
+	}
+	if len(result.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(result.Embeddings))
+	}
+	return result.Embeddings, nil
+}
+
+const mockEmbeddingDimensions = 64
+
+// Embed returns deterministic pseudo-embeddings derived from a hash of each text
+func (p *MockProvider) Embed(texts []string) ([][]float64, error) {
+	vectors := make([][]float64, len(texts))
+	for i, text := range texts {
+		sum := sha256.Sum256([]byte(text))
+		vec := make([]float64, mockEmbeddingDimensions)
+		var norm float64
+		for j := range vec {
+			vec[j] = float64(sum[j%len(sum)])/127.5 - 1
+			norm += vec[j] * vec[j]
+		}
+		norm = math.Sqrt(norm)
+		for j := range vec {
+			vec[j] /= norm
+		}
+		vectors[i] = vec
+	}
+	return vectors, nil
+}
+
+const maxEmbeddingCacheEntries = 4096
+
+// embeddingCache memoizes embeddings by provider and text so repeated
+// lookups (from the UI or local server clients) don't hit the backend again.
+// It also remembers which providers turned out not to support embeddings.
+type embeddingCache struct {
+	mu          sync.Mutex
+	entries     map[string][]float64
+	order       []string
+	unsupported map[string]bool
+}
+
+func newEmbeddingCache() *embeddingCache {
+	return &embeddingCache{entries: make(map[string][]float64), unsupported: make(map[string]bool)}
+}
+
+// embedderKey identifies a provider's embedding setup, so changing its
+// endpoint or embedding model gives it another chance
+func embedderKey(p Provider) string {
+	config := p.GetConfig()
+	return config.ID + "\x00" + config.Endpoint + "\x00" + embeddingModel(p)
+}
+
+func (c *embeddingCache) markUnsupported(p Provider) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.unsupported[embedderKey(p)] = true
+}
+
+// canEmbed reports whether p supports embeddings as far as is known
+func (c *embeddingCache) canEmbed(p Provider) bool {
+	if _, ok := p.(Embedder); !ok {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return !c.unsupported[embedderKey(p)]
+}
+
+func embeddingCacheKey(provider, text string) string {
+	sum := sha256.Sum256([]byte(provider + "\x00" + text))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *embeddingCache) get(provider, text string) ([]float64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	vec, ok := c.entries[embeddingCacheKey(provider, text)]
+	return vec, ok
+}
+
+func (c *embeddingCache) put(provider, text string, vec []float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := embeddingCacheKey(provider, text)
+	if _, ok := c.entries[key]; ok {
+		return
+	}
+	if len(c.order) >= maxEmbeddingCacheEntries {
+		oldest := c.order[0]
+		c.order = c.order[1:]
+		delete(c.entries, oldest)
+	}
+	c.entries[key] = vec
+	c.order = append(c.order, key)
+}
+
+// embeddingProvider picks the provider used for embeddings: the one whose
+// name matches the requested model, then the active provider, then the
+// first configured provider that supports embeddings. Providers that turned
+// out not to support them are passed over.
+func (a *App) embeddingProvider(model string) (Provider, error) {
+	a.providersMutex.RLock()
+	defer a.providersMutex.RUnlock()
+
+	if model != "" {
+		for _, p := range a.providers {
+			if a.embeddings.canEmbed(p) && p.GetName() == model {
+				return p, nil
+			}
+		}
+	}
+
+	if p := a.activeProviderLocked(); p != nil && a.embeddings.canEmbed(p) {
+		return p, nil
+	}
+
+	for _, p := range a.providers {
+		if a.embeddings.canEmbed(p) {
+			return p, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no configured provider supports embeddings")
+}
+
+// embedWith embeds texts with provider, remembering when it turns out not
+// to support embeddings
+func (a *App) embedWith(provider Provider, texts []string) ([][]float64, error) {
+	vectors, err := provider.(Embedder).Embed(texts)
+	if err == nil && len(vectors) != len(texts) {
+		err = fmt.Errorf("expected %d embeddings, got %d", len(texts), len(vectors))
+	}
+	if err != nil && embeddingsUnsupported(err) {
+		a.embeddings.markUnsupported(provider)
+	}
+	return vectors, err
+}
+
+// embed returns embeddings for texts, serving cached vectors where possible.
+// When the provider turns out not to support embeddings, the next one that
+// may is tried.
+func (a *App) embed(texts []string, model string) ([][]float64, string, error) {
+	var lastErr error
+	for {
+		provider, err := a.embeddingProvider(model)
+		if err != nil {
+			if lastErr != nil {
+				return nil, "", lastErr
+			}
+			return nil, "", err
+		}
+		vectors, err := a.embedCached(provider, texts)
+		if err != nil && embeddingsUnsupported(err) {
+			lastErr = fmt.Errorf("%s does not support embeddings: %v", provider.GetName(), err)
+			continue
+		}
+		return vectors, provider.GetName(), err
+	}
+}
+
+// embedCached embeds texts with provider, serving cached vectors where possible
+func (a *App) embedCached(provider Provider, texts []string) ([][]float64, error) {
+	name := provider.GetName()
+
+	vectors := make([][]float64, len(texts))
+	var missing []string
+	var missingIdx []int
+	for i, text := range texts {
+		if vec, ok := a.embeddings.get(name, text); ok {
+			vectors[i] = vec
+			continue
+		}
+		missing = append(missing, text)
+		missingIdx = append(missingIdx, i)
+	}
+
+	if len(missing) > 0 {
+		fresh, err := a.embedWith(provider, missing)
+		if err != nil {
+			return nil, err
+		}
+		for j, vec := range fresh {
+			vectors[missingIdx[j]] = vec
+			a.embeddings.put(name, missing[j], vec)
+		}
+	}
+
+	return vectors, nil
+}
+
+// Embed returns embeddings for texts using the configured embedding provider
+func (a *App) Embed(texts []string) ([][]float64, error) {
+	vectors, _, err := a.embed(texts, "")
+	return vectors, err
+}
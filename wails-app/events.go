@@ -0,0 +1,388 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// EventSchemaVersion is the version of the event API as a whole. It changes
+// when an event is removed or renamed. Each event also has its own version,
+// which changes when one of its fields is removed or changes meaning; new
+// fields may be added to an event without a new version, so clients should
+// ignore fields they don't know.
+const EventSchemaVersion = 1
+
+// Names of the events emitted to the frontend
+const (
+	EventPromptChunk           = "prompt:chunk"
+	EventPromptDone            = "prompt:done"
+	EventPromptRedacted        = "prompt:redacted"
+	EventPromptTool            = "prompt:tool"
+	EventPromptFollowUps       = "prompt:followups"
+	EventPromptPrefill         = "prompt:prefill"
+	EventCompareStart          = "compare:start"
+	EventCompareChunk          = "compare:chunk"
+	EventCompareDone           = "compare:done"
+	EventFileProgress          = "file:progress"
+	EventFileDone              = "file:done"
+	EventScriptStart           = "script:start"
+	EventScriptOutput          = "script:output"
+	EventScriptDone            = "script:done"
+	EventSessionSplitSuggested = "session:splitSuggested"
+	EventProviderFallback      = "provider:fallback"
+	EventRequestQueued         = "request:queued"
+	EventRequestRetry          = "request:retry"
+	EventRequestStalled        = "request:stalled"
+	EventRequestStallResolved  = "request:stall-resolved"
+	EventBudgetExceeded        = "budget:exceeded"
+	EventOAuthSignedIn         = "oauth:signed-in"
+	EventOAuthFailed           = "oauth:failed"
+	EventModelPull             = "model:pull"
+	EventMaintenanceDone       = "maintenance:done"
+	EventWorkspaceDigest       = "workspace:digest"
+	EventEditConflicts         = "edit:conflicts"
+	EventWatchReview           = "watch:review"
+	EventDrillDone             = "drill:done"
+	EventChangePending         = "change:pending"
+	EventCommandConfirm        = "command:confirm"
+	EventIndexProgress         = "index:progress"
+)
+
+// PromptChunkEvent is a part of a streamed reply
+type PromptChunkEvent struct {
+	SessionID string `json:"sessionId"`
+	Text      string `json:"text"`
+}
+
+// PromptDoneEvent carries the final reply of a streamed prompt
+type PromptDoneEvent struct {
+	SessionID string `json:"sessionId"`
+	Text      string `json:"text"`
+	// Truncated marks replies ContinueResponse can extend
+	Truncated bool   `json:"truncated"`
+	Error     string `json:"error,omitempty"`
+	Cancelled bool   `json:"cancelled,omitempty"`
+}
+
+// PromptRedactedEvent reports secrets masked before a request was sent
+type PromptRedactedEvent struct {
+	Provider string   `json:"provider"`
+	Count    int      `json:"count"`
+	Kinds    []string `json:"kinds"`
+}
+
+// PromptToolEvent reports a round of tool calls made for a prompt
+type PromptToolEvent struct {
+	Tool     string   `json:"tool"`
+	Commands []string `json:"commands"`
+	Round    int      `json:"round"`
+}
+
+// PromptFollowUpsEvent carries suggested next prompts for a reply
+type PromptFollowUpsEvent struct {
+	SessionID    string               `json:"sessionId"`
+	MessageIndex int                  `json:"messageIndex"`
+	Suggestions  []FollowUpSuggestion `json:"suggestions"`
+}
+
+// CompareStartEvent starts a side-by-side comparison
+type CompareStartEvent struct {
+	ComparisonID string   `json:"comparisonId"`
+	ProviderIDs  []string `json:"providerIds"`
+}
+
+// CompareChunkEvent is a part of one provider's reply in a comparison
+type CompareChunkEvent struct {
+	ComparisonID string `json:"comparisonId"`
+	ProviderID   string `json:"providerId"`
+	Text         string `json:"text"`
+}
+
+// CompareDoneEvent carries one provider's final reply in a comparison
+type CompareDoneEvent struct {
+	ComparisonID string `json:"comparisonId"`
+	ProviderID   string `json:"providerId"`
+	Text         string `json:"text"`
+	DurationMs   int64  `json:"durationMs"`
+	Error        string `json:"error,omitempty"`
+	Cancelled    bool   `json:"cancelled,omitempty"`
+}
+
+// FileProgressEvent reports how much of a reply has been streamed to a file
+type FileProgressEvent struct {
+	ID        string `json:"id"`
+	SessionID string `json:"sessionId"`
+	Path      string `json:"path"`
+	Bytes     int64  `json:"bytes"`
+}
+
+// FileDoneEvent ends a reply streamed to a file
+type FileDoneEvent struct {
+	ID        string `json:"id"`
+	SessionID string `json:"sessionId"`
+	Path      string `json:"path"`
+	Bytes     int64  `json:"bytes"`
+	Error     string `json:"error,omitempty"`
+	Cancelled bool   `json:"cancelled,omitempty"`
+}
+
+// ScriptStartEvent starts a script run
+type ScriptStartEvent struct {
+	RunID    string `json:"runId"`
+	ScriptID string `json:"scriptId"`
+}
+
+// ScriptOutputEvent is a line of output of a script run
+type ScriptOutputEvent struct {
+	RunID string `json:"runId"`
+	Line  string `json:"line"`
+}
+
+// SplitSuggestedEvent suggests splitting a conversation that changed topic
+type SplitSuggestedEvent struct {
+	SessionID string     `json:"sessionId"`
+	Shift     TopicShift `json:"shift"`
+}
+
+// ProviderFallbackEvent reports a request moving down the fallback chain
+type ProviderFallbackEvent struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Error string `json:"error"`
+}
+
+// RequestQueuedEvent reports a request waiting for a rate limit ("rate-limit",
+// with DelayMs) or for a free slot ("concurrency", with Position)
+type RequestQueuedEvent struct {
+	RequestID string `json:"requestId"`
+	Provider  string `json:"provider"`
+	Reason    string `json:"reason"`
+	DelayMs   int64  `json:"delayMs,omitempty"`
+	Position  int    `json:"position,omitempty"`
+}
+
+// RequestRetryEvent announces the next attempt of a failed request
+type RequestRetryEvent struct {
+	RequestID   string `json:"requestId"`
+	Provider    string `json:"provider"`
+	Attempt     int    `json:"attempt"`
+	MaxAttempts int    `json:"maxAttempts"`
+	DelayMs     int64  `json:"delayMs,omitempty"`
+	Error       string `json:"error,omitempty"`
+	// Reason is "stalled" for a stalled attempt the user chose to retry
+	Reason string `json:"reason,omitempty"`
+}
+
+// RequestStalledEvent reports a streamed reply that stopped sending text
+type RequestStalledEvent struct {
+	RequestID  string `json:"requestId"`
+	Provider   string `json:"provider"`
+	ProviderID string `json:"providerId"`
+	SilentMs   int64  `json:"silentMs"`
+	// Options are the actions ResolveStall accepts for the request
+	Options []string `json:"options"`
+}
+
+// StallResolvedEvent reports how a stalled request went on
+type StallResolvedEvent struct {
+	RequestID string `json:"requestId"`
+	Provider  string `json:"provider"`
+	Action    string `json:"action"`
+}
+
+// BudgetExceededEvent reports spending past the monthly budget
+type BudgetExceededEvent struct {
+	RequestID string  `json:"requestId"`
+	SpentUSD  float64 `json:"spentUsd"`
+	BudgetUSD float64 `json:"budgetUsd"`
+	// Spent and Budget are formatted for display
+	Spent  string `json:"spent"`
+	Budget string `json:"budget"`
+	Action string `json:"action"`
+}
+
+// OAuthEvent reports the outcome of an OAuth sign-in
+type OAuthEvent struct {
+	ProviderID string `json:"providerId"`
+	Error      string `json:"error,omitempty"`
+}
+
+// EditConflictsEvent reports a proposed edit that could not merge cleanly
+type EditConflictsEvent struct {
+	Path      string `json:"path"`
+	Conflicts int    `json:"conflicts"`
+}
+
+// eventSpec describes one event for the schema
+type eventSpec struct {
+	version     int
+	description string
+	payload     interface{}
+}
+
+// eventSpecs are the events the app emits, by name
+var eventSpecs = map[string]eventSpec{
+	EventPromptChunk:           {1, "A part of a streamed reply", PromptChunkEvent{}},
+	EventPromptDone:            {1, "The final reply of a streamed prompt, replacing the streamed text", PromptDoneEvent{}},
+	EventPromptRedacted:        {1, "Secrets were masked before a request was sent", PromptRedactedEvent{}},
+	EventPromptTool:            {1, "A round of tool calls made while answering a prompt", PromptToolEvent{}},
+	EventPromptFollowUps:       {1, "Suggested next prompts for a reply", PromptFollowUpsEvent{}},
+	EventPromptPrefill:         {1, "A prompt was sent from the OS context menu", PromptPrefill{}},
+	EventCompareStart:          {1, "A side-by-side comparison started", CompareStartEvent{}},
+	EventCompareChunk:          {1, "A part of one provider's reply in a comparison", CompareChunkEvent{}},
+	EventCompareDone:           {1, "One provider's final reply in a comparison", CompareDoneEvent{}},
+	EventFileProgress:          {1, "Progress of a reply streamed to a file", FileProgressEvent{}},
+	EventFileDone:              {1, "A reply streamed to a file ended", FileDoneEvent{}},
+	EventScriptStart:           {1, "A script run started", ScriptStartEvent{}},
+	EventScriptOutput:          {1, "A line of output of a script run", ScriptOutputEvent{}},
+	EventScriptDone:            {1, "A script run ended", ScriptRun{}},
+	EventSessionSplitSuggested: {1, "A conversation changed topic and could be split", SplitSuggestedEvent{}},
+	EventProviderFallback:      {1, "A request moved to the next provider in the fallback chain", ProviderFallbackEvent{}},
+	EventRequestQueued:         {1, "A request is waiting for a rate limit or a free slot", RequestQueuedEvent{}},
+	EventRequestRetry:          {1, "A failed request is about to be sent again", RequestRetryEvent{}},
+	EventRequestStalled:        {1, "A streamed reply stopped sending text", RequestStalledEvent{}},
+	EventRequestStallResolved:  {1, "A stalled request went on", StallResolvedEvent{}},
+	EventBudgetExceeded:        {1, "Spending passed the monthly budget", BudgetExceededEvent{}},
+	EventOAuthSignedIn:         {1, "An OAuth sign-in succeeded", OAuthEvent{}},
+	EventOAuthFailed:           {1, "An OAuth sign-in failed", OAuthEvent{}},
+	EventModelPull:             {1, "Progress of a model download", PullProgress{}},
+	EventMaintenanceDone:       {1, "Background maintenance ran", MaintenanceReport{}},
+	EventWorkspaceDigest:       {1, "What changed in a workspace since the last session", WorkspaceDigest{}},
+	EventEditConflicts:         {1, "A proposed edit has merge conflicts", EditConflictsEvent{}},
+	EventWatchReview:           {1, "A watch mode review of saved files", WatchReview{}},
+	EventDrillDone:             {1, "A failover drill finished", DrillReport{}},
+	EventChangePending:         {1, "The model proposed a file change that waits for approval", PendingChange{}},
+	EventCommandConfirm:        {1, "A command the model wants to run is off the allowlist and needs confirming", CommandConfirmEvent{}},
+	EventIndexProgress:         {1, "Progress of indexing a workspace for code search", IndexProgressEvent{}},
+}
+
+// EventField is a field of an event payload
+type EventField struct {
+	Name string `json:"name"`
+	// Type is string, number, boolean, object or array
+	Type     string `json:"type"`
+	Optional bool   `json:"optional,omitempty"`
+	// Items is the type of an array's elements
+	Items string `json:"items,omitempty"`
+	// Fields are the fields of an object, or of an array's objects
+	Fields []EventField `json:"fields,omitempty"`
+}
+
+// EventSpec describes an event and its payload
+type EventSpec struct {
+	Name        string       `json:"name"`
+	Version     int          `json:"version"`
+	Description string       `json:"description"`
+	Payload     string       `json:"payload"`
+	Fields      []EventField `json:"fields"`
+}
+
+// EventSchema describes every event the app emits
+type EventSchema struct {
+	Version int         `json:"version"`
+	Events  []EventSpec `json:"events"`
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// jsonType returns the JSON type t is encoded as
+func jsonType(t reflect.Type) string {
+	if t == timeType {
+		return "string"
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return jsonType(t.Elem())
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	}
+	return "object"
+}
+
+// structType returns the struct type t holds, directly or behind a pointer
+func structType(t reflect.Type) (reflect.Type, bool) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t, t.Kind() == reflect.Struct && t != timeType
+}
+
+// eventFields describes the JSON fields of the struct type t; types already
+// being described are not expanded again
+func eventFields(t reflect.Type, seen map[reflect.Type]bool) []EventField {
+	if seen[t] {
+		return nil
+	}
+	seen[t] = true
+	defer delete(seen, t)
+	var fields []EventField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		field := EventField{Name: name, Type: jsonType(f.Type), Optional: strings.Contains(opts, "omitempty")}
+		elem := f.Type
+		if field.Type == "array" {
+			elem = f.Type.Elem()
+			field.Items = jsonType(elem)
+		}
+		if st, ok := structType(elem); ok {
+			field.Fields = eventFields(st, seen)
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// GetEventSchema describes every event the app emits to the frontend, with
+// its version and the fields of its payload, so clients can check what
+// they rely on
+func (a *App) GetEventSchema() EventSchema {
+	schema := EventSchema{Version: EventSchemaVersion}
+	for name, spec := range eventSpecs {
+		t := reflect.TypeOf(spec.payload)
+		schema.Events = append(schema.Events, EventSpec{
+			Name:        name,
+			Version:     spec.version,
+			Description: spec.description,
+			Payload:     t.Name(),
+			Fields:      eventFields(t, make(map[reflect.Type]bool)),
+		})
+	}
+	sort.Slice(schema.Events, func(i, j int) bool { return schema.Events[i].Name < schema.Events[j].Name })
+	return schema
+}
+
+// emit sends an event to the frontend, or to eventSink when one is set. It is
+// a no-op before startup, such as when the app is driven without a window.
+// name is one of the Event constants and data its payload type.
+func (a *App) emit(name string, data ...interface{}) {
+	if a.eventSink != nil {
+		a.eventSink(name, data...)
+		return
+	}
+	if a.ctx == nil {
+		return
+	}
+	runtime.EventsEmit(a.ctx, name, data...)
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeOllama is an in-process Ollama server with canned fixtures for
+// generate, chat, tags, pull, show, delete, embed and version
+type fakeOllama struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	models map[string]bool
+	// reply returns the completion for a prompt; it defaults to defaultFakeReply
+	reply func(prompt string) string
+	// failures are HTTP statuses returned, in order, before requests succeed
+	failures []int
+	// hold, when set, pauses streamed generations after the first chunk until
+	// it is closed or the client goes away
+	hold chan struct{}
+	// requests records the path and JSON body of each request
+	requests []fakeRequest
+	// toolCalls, when set, returns the tool calls a chat with tools answers
+	// with, given its messages; no calls fall back to reply
+	toolCalls func(messages []interface{}) []map[string]interface{}
+}
+
+type fakeRequest struct {
+	Path   string
+	Header http.Header
+	Body   map[string]interface{}
+}
+
+const defaultFakeReply = "Hello from the fake model. It answers every prompt the same way."
+
+// newFakeOllama starts a fake Ollama serving the given installed models
+func newFakeOllama(t *testing.T, models ...string) *fakeOllama {
+	t.Helper()
+	f := &fakeOllama{models: make(map[string]bool)}
+	for _, m := range models {
+		f.models[m] = true
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/generate", f.handleGenerate)
+	mux.HandleFunc("POST /api/chat", f.handleChat)
+	mux.HandleFunc("GET /api/tags", f.handleTags)
+	mux.HandleFunc("POST /api/pull", f.handlePull)
+	mux.HandleFunc("POST /api/show", f.handleShow)
+	mux.HandleFunc("DELETE /api/delete", f.handleDelete)
+	mux.HandleFunc("POST /api/embed", f.handleEmbed)
+	mux.HandleFunc("GET /api/version", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"version": "0.0.0-fake"})
+	})
+	f.Server = httptest.NewServer(f.record(mux))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// failNext makes the next requests fail with the given HTTP statuses
+func (f *fakeOllama) failNext(statuses ...int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures = append(f.failures, statuses...)
+}
+
+// received returns the requests made to path
+func (f *fakeOllama) received(path string) []fakeRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []fakeRequest
+	for _, r := range f.requests {
+		if r.Path == path {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// record logs each request and serves queued failures before the handler
+func (f *fakeOllama) record(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if r.Body != nil {
+			json.NewDecoder(r.Body).Decode(&body)
+		}
+
+		f.mu.Lock()
+		f.requests = append(f.requests, fakeRequest{Path: r.URL.Path, Header: r.Header.Clone(), Body: body})
+		status := 0
+		if len(f.failures) > 0 {
+			status, f.failures = f.failures[0], f.failures[1:]
+		}
+		f.mu.Unlock()
+
+		if status != 0 {
+			http.Error(w, fmt.Sprintf(`{"error":"injected failure %d"}`, status), status)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), fakeBodyKey{}, body)))
+	})
+}
+
+type fakeBodyKey struct{}
+
+// fakeBody returns the JSON body decoded by record
+func fakeBody(r *http.Request) map[string]interface{} {
+	body, _ := r.Context().Value(fakeBodyKey{}).(map[string]interface{})
+	return body
+}
+
+func (f *fakeOllama) completion(prompt string) string {
+	f.mu.Lock()
+	reply := f.reply
+	f.mu.Unlock()
+	if reply == nil {
+		return defaultFakeReply
+	}
+	return reply(prompt)
+}
+
+// checkModel writes Ollama's not-found error when the requested model is not installed
+func (f *fakeOllama) checkModel(w http.ResponseWriter, model string) bool {
+	f.mu.Lock()
+	ok := f.models[model]
+	f.mu.Unlock()
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("model %q not found, try pulling it first", model)})
+	}
+	return ok
+}
+
+// fakeChunks splits a reply into word-sized pieces, as Ollama streams tokens
+func fakeChunks(text string) []string {
+	var chunks []string
+	for len(text) > 0 {
+		i := strings.IndexByte(text[1:], ' ')
+		if i == -1 {
+			chunks = append(chunks, text)
+			break
+		}
+		chunks = append(chunks, text[:i+1])
+		text = text[i+1:]
+	}
+	return chunks
+}
+
+func (f *fakeOllama) handleGenerate(w http.ResponseWriter, r *http.Request) {
+	body := fakeBody(r)
+	model, _ := body["model"].(string)
+	prompt, _ := body["prompt"].(string)
+	if !f.checkModel(w, model) {
+		return
+	}
+	reply := f.completion(prompt)
+
+	if stream, ok := body["stream"].(bool); ok && !stream {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"model": model, "response": reply, "done": true, "done_reason": "stop",
+			"prompt_eval_count": len(strings.Fields(prompt)), "eval_count": len(fakeChunks(reply)),
+		})
+		return
+	}
+	f.stream(w, r, fakeChunks(reply), func(chunk string, done bool) interface{} {
+		return map[string]interface{}{"model": model, "response": chunk, "done": done}
+	})
+}
+
+func (f *fakeOllama) handleChat(w http.ResponseWriter, r *http.Request) {
+	body := fakeBody(r)
+	model, _ := body["model"].(string)
+	if !f.checkModel(w, model) {
+		return
+	}
+	var prompt string
+	if messages, ok := body["messages"].([]interface{}); ok && len(messages) > 0 {
+		if m, ok := messages[len(messages)-1].(map[string]interface{}); ok {
+			prompt, _ = m["content"].(string)
+		}
+	}
+	f.mu.Lock()
+	toolCalls := f.toolCalls
+	f.mu.Unlock()
+	if _, ok := body["tools"]; ok && toolCalls != nil {
+		messages, _ := body["messages"].([]interface{})
+		if calls := toolCalls(messages); len(calls) > 0 {
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"model":   model,
+				"message": map[string]interface{}{"role": "assistant", "content": "", "tool_calls": calls},
+				"done":    true,
+			})
+			return
+		}
+	}
+	reply := f.completion(prompt)
+
+	message := func(content string) map[string]string {
+		return map[string]string{"role": "assistant", "content": content}
+	}
+	if stream, ok := body["stream"].(bool); ok && !stream {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"model": model, "message": message(reply), "done": true})
+		return
+	}
+	f.stream(w, r, fakeChunks(reply), func(chunk string, done bool) interface{} {
+		return map[string]interface{}{"model": model, "message": message(chunk), "done": done}
+	})
+}
+
+// stream writes chunks as NDJSON followed by a final done object, honouring hold
+func (f *fakeOllama) stream(w http.ResponseWriter, r *http.Request, chunks []string, line func(chunk string, done bool) interface{}) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+
+	f.mu.Lock()
+	hold := f.hold
+	f.mu.Unlock()
+
+	for i, chunk := range chunks {
+		enc.Encode(line(chunk, false))
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if i == 0 && hold != nil {
+			select {
+			case <-hold:
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+	enc.Encode(line("", true))
+}
+
+func (f *fakeOllama) handleTags(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	models := make([]map[string]interface{}, 0, len(f.models))
+	for name := range f.models {
+		models = append(models, map[string]interface{}{
+			"name":        name,
+			"model":       name,
+			"size":        int64(4_000_000_000),
+			"modified_at": time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+			"details": map[string]string{
+				"family":             "llama",
+				"parameter_size":     "7B",
+				"quantization_level": "Q4_0",
+			},
+		})
+	}
+	f.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]interface{}{"models": models})
+}
+
+func (f *fakeOllama) handlePull(w http.ResponseWriter, r *http.Request) {
+	model, _ := fakeBody(r)["model"].(string)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	if strings.HasPrefix(model, "missing") {
+		enc.Encode(map[string]string{"error": "pull model manifest: file does not exist"})
+		return
+	}
+
+	const total = 1000
+	enc.Encode(map[string]string{"status": "pulling manifest"})
+	for completed := 0; completed <= total; completed += 250 {
+		enc.Encode(map[string]interface{}{
+			"status":    "pulling 0123456789ab",
+			"digest":    "sha256:0123456789ab",
+			"total":     total,
+			"completed": completed,
+		})
+	}
+	enc.Encode(map[string]string{"status": "verifying sha256 digest"})
+	enc.Encode(map[string]string{"status": "writing manifest"})
+
+	f.mu.Lock()
+	f.models[model] = true
+	f.mu.Unlock()
+	enc.Encode(map[string]string{"status": "success"})
+}
+
+func (f *fakeOllama) handleShow(w http.ResponseWriter, r *http.Request) {
+	model, _ := fakeBody(r)["model"].(string)
+	if !f.checkModel(w, model) {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"license":    "MIT",
+		"modelfile":  "FROM " + model,
+		"parameters": "temperature 0.7",
+		"template":   "{{ .Prompt }}",
+		"model_info": map[string]interface{}{"general.architecture": "llama"},
+		"details": map[string]string{
+			"format":             "gguf",
+			"family":             "llama",
+			"parameter_size":     "7B",
+			"quantization_level": "Q4_0",
+		},
+	})
+}
+
+func (f *fakeOllama) handleDelete(w http.ResponseWriter, r *http.Request) {
+	model, _ := fakeBody(r)["model"].(string)
+	if !f.checkModel(w, model) {
+		return
+	}
+	f.mu.Lock()
+	delete(f.models, model)
+	f.mu.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
+
+func (f *fakeOllama) handleEmbed(w http.ResponseWriter, r *http.Request) {
+	input, _ := fakeBody(r)["input"].([]interface{})
+	embeddings := make([][]float64, len(input))
+	for i, in := range input {
+		text, _ := in.(string)
+		// A fixed-size vector derived from the text keeps results deterministic
+		v := make([]float64, 8)
+		for j, c := range text {
+			v[j%len(v)] += float64(c) / 1000
+		}
+		embeddings[i] = v
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"embeddings": embeddings})
+}
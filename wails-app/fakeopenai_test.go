@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// fakeOpenAI is an in-process OpenAI-compatible server answering
+// /chat/completions, streamed or not, /embeddings and /models
+type fakeOpenAI struct {
+	*httptest.Server
+
+	mu sync.Mutex
+	// reply returns the completion for a prompt; it defaults to defaultFakeReply
+	reply    func(prompt string) string
+	failures []int
+	// hold, when set, pauses streamed completions after the first chunk
+	hold chan struct{}
+	// header is the header of the last completion request
+	header http.Header
+	// embedModel is the model of the last embeddings request
+	embedModel string
+}
+
+func newFakeOpenAI(t *testing.T) *fakeOpenAI {
+	t.Helper()
+	f := &fakeOpenAI{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /chat/completions", f.handleChat)
+	mux.HandleFunc("POST /embeddings", f.handleEmbeddings)
+	mux.HandleFunc("GET /models", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"data": []map[string]interface{}{{"id": "gpt-4o-mini", "created": 1700000000}},
+		})
+	})
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeOpenAI) failNext(statuses ...int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures = append(f.failures, statuses...)
+}
+
+// handleEmbeddings answers with one vector per input, listed last first
+// since the API numbers them rather than keeping their order
+func (f *fakeOpenAI) handleEmbeddings(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Model string   `json:"model"`
+		Input []string `json:"input"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request_error", err)
+		return
+	}
+	f.mu.Lock()
+	f.embedModel = body.Model
+	f.mu.Unlock()
+
+	data := []map[string]interface{}{}
+	for i := len(body.Input) - 1; i >= 0; i-- {
+		data = append(data, map[string]interface{}{"object": "embedding", "index": i, "embedding": []float64{float64(len(body.Input[i])), 1}})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"object": "list", "data": data})
+}
+
+func (f *fakeOpenAI) handleChat(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Messages []struct {
+			Content string `json:"content"`
+		} `json:"messages"`
+		Stream bool `json:"stream"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request_error", err)
+		return
+	}
+
+	f.mu.Lock()
+	f.header = r.Header.Clone()
+	status := 0
+	if len(f.failures) > 0 {
+		status, f.failures = f.failures[0], f.failures[1:]
+	}
+	reply, hold := f.reply, f.hold
+	f.mu.Unlock()
+	if status != 0 {
+		writeAPIError(w, status, "server_error", fmt.Errorf("injected failure %d", status))
+		return
+	}
+
+	var prompt string
+	if n := len(body.Messages); n > 0 {
+		prompt = body.Messages[n-1].Content
+	}
+	text := defaultFakeReply
+	if reply != nil {
+		text = reply(prompt)
+	}
+
+	if !body.Stream {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"choices": []map[string]interface{}{{
+				"message":       map[string]string{"role": "assistant", "content": text},
+				"finish_reason": "stop",
+			}},
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	flusher, _ := w.(http.Flusher)
+	for i, chunk := range fakeChunks(text) {
+		data, _ := json.Marshal(map[string]interface{}{
+			"choices": []map[string]interface{}{{"delta": map[string]string{"content": chunk}}},
+		})
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if i == 0 && hold != nil {
+			select {
+			case <-hold:
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// fileProgressInterval spaces out "file:progress" events
+const fileProgressInterval = 250 * time.Millisecond
+
+// FileGeneration is a reply streamed into a workspace file
+type FileGeneration struct {
+	ID        string `json:"id"`
+	SessionID string `json:"sessionId"`
+	// Path is the file's path relative to the workspace
+	Path  string `json:"path"`
+	Bytes int64  `json:"bytes"`
+}
+
+// fileSinkInstruction asks for the file's contents alone
+func fileSinkInstruction(path string) string {
+	return fmt.Sprintf("Your reply is written verbatim to the file %s. Reply with only the complete contents of that file: no code fences around it and no commentary before or after.", path)
+}
+
+// fileSink writes a streamed reply into a temporary file next to its target
+type fileSink struct {
+	target string
+	tmp    *os.File
+	bytes  int64
+	err    error
+}
+
+func newFileSink(target string) (*fileSink, error) {
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	return &fileSink{target: target, tmp: tmp}, nil
+}
+
+func (s *fileSink) write(text string) {
+	if s.err != nil {
+		return
+	}
+	n, err := s.tmp.WriteString(text)
+	s.bytes += int64(n)
+	s.err = err
+}
+
+// finalize syncs the file and renames it over the target
+func (s *fileSink) finalize() error {
+	if s.err != nil {
+		s.abort()
+		return s.err
+	}
+	if err := s.tmp.Sync(); err != nil {
+		s.abort()
+		return err
+	}
+	if err := s.tmp.Close(); err != nil {
+		os.Remove(s.tmp.Name())
+		return err
+	}
+	if err := os.Chmod(s.tmp.Name(), 0o644); err != nil {
+		os.Remove(s.tmp.Name())
+		return err
+	}
+	if err := os.Rename(s.tmp.Name(), s.target); err != nil {
+		os.Remove(s.tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// abort removes the partial file, leaving the target as it was
+func (s *fileSink) abort() {
+	s.tmp.Close()
+	os.Remove(s.tmp.Name())
+}
+
+// StreamPromptToFile runs a prompt in the active conversation and streams
+// the reply into path in the workspace instead of the chat, for long
+// generations such as a design doc or a large test file. The reply goes to
+// a temporary file that replaces path only once it is complete; a failed
+// or cancelled generation (CancelPrompt) leaves path untouched. An existing
+// file is only replaced with overwrite. Progress is reported with
+// "file:progress" events and the outcome with "file:done".
+func (a *App) StreamPromptToFile(prompt string, path string, overwrite bool) (FileGeneration, error) {
+	a.telemetry.recordFeature("stream_to_file")
+	target, err := a.sandboxedPath("", path)
+	if err != nil {
+		return FileGeneration{}, err
+	}
+	if info, err := os.Stat(target); err == nil {
+		if info.IsDir() {
+			return FileGeneration{}, fmt.Errorf("%s is a directory", path)
+		}
+		if !overwrite {
+			return FileGeneration{}, fmt.Errorf("%s already exists", path)
+		}
+	}
+	sink, err := newFileSink(target)
+	if err != nil {
+		return FileGeneration{}, fmt.Errorf("create %s: %v", path, err)
+	}
+
+	sessionID := a.ensureActiveSession(prompt)
+	session, _ := a.sessions.get(sessionID)
+	gen := FileGeneration{ID: newID(), SessionID: sessionID, Path: filepath.ToSlash(path)}
+	a.activity.record(a.sessionRoot(sessionID), projectActivity{Prompts: 1})
+	userMsg := Message{Role: "user", Content: prompt}
+	messages := append(append(a.personaContext(session), sessionContext(session)...),
+		Message{Role: "system", Content: fileSinkInstruction(gen.Path)},
+		userMsg,
+	)
+
+	ctx, done := a.trackPrompt(sessionID)
+	defer done()
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	var lastProgress time.Time
+	progress := func() {
+		a.emit(EventFileProgress, FileProgressEvent{ID: gen.ID, SessionID: sessionID, Path: gen.Path, Bytes: sink.bytes})
+	}
+	defaults := a.personaDefaults(session)
+	result, err := a.generate(generateRequest{
+		Messages:    messages,
+		Temperature: defaults.Temperature,
+		MaxTokens:   defaults.MaxTokens,
+		Context:     ctx,
+		Private:     session.Locked,
+		OnChunk: func(chunk string) {
+			sink.write(chunk)
+			if sink.err != nil {
+				// A full disk or similar stops the generation
+				stop()
+				return
+			}
+			if time.Since(lastProgress) >= fileProgressInterval {
+				lastProgress = time.Now()
+				progress()
+			}
+		},
+	})
+	if err == nil && sink.bytes == 0 {
+		// Providers that don't stream deliver the reply in one piece
+		sink.write(result.Response)
+	}
+	if err == nil {
+		err = sink.finalize()
+	} else {
+		if sink.err != nil {
+			err = fmt.Errorf("write %s: %v", gen.Path, sink.err)
+		}
+		sink.abort()
+	}
+	gen.Bytes = sink.bytes
+
+	event := FileDoneEvent{ID: gen.ID, SessionID: sessionID, Path: gen.Path, Bytes: gen.Bytes}
+	if err != nil {
+		event.Error = err.Error()
+		event.Cancelled = errors.Is(err, errCancelled)
+		a.emit(EventFileDone, event)
+		return gen, err
+	}
+	progress()
+	a.emit(EventFileDone, event)
+
+	if err := a.sessions.appendMessages(sessionID,
+		userMsg,
+		Message{Role: "assistant", Content: fmt.Sprintf("Wrote %s (%s bytes).", gen.Path, a.reportFormatter().Int(gen.Bytes)), Provider: result.Provider},
+	); err != nil {
+		println("Error saving session:", err.Error())
+	}
+	return gen, nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// treeMaxEntries caps the entries in one file tree
+const treeMaxEntries = 5000
+
+// FileNode is a file or directory in the workspace tree
+type FileNode struct {
+	Name string `json:"name"`
+	// Path is slash-separated and relative to the workspace, "" for its root
+	Path string `json:"path"`
+	Dir  bool   `json:"dir,omitempty"`
+	Size int64  `json:"size,omitempty"`
+	// Children are nil for a directory that was not listed because of the
+	// depth or size limit, which Truncated then reports
+	Children  []FileNode `json:"children,omitempty"`
+	Truncated bool       `json:"truncated,omitempty"`
+}
+
+// WorkspaceFile is a text file read from the workspace
+type WorkspaceFile struct {
+	Path     string    `json:"path"`
+	Language string    `json:"language,omitempty"`
+	Content  string    `json:"content"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"modTime"`
+}
+
+// fileLanguages are the code fence languages of file extensions
+var fileLanguages = map[string]string{
+	".go": "go", ".py": "python", ".js": "javascript", ".mjs": "javascript", ".cjs": "javascript",
+	".jsx": "jsx", ".ts": "typescript", ".tsx": "tsx", ".rs": "rust", ".java": "java",
+	".kt": "kotlin", ".c": "c", ".h": "c", ".cc": "cpp", ".cpp": "cpp", ".hpp": "cpp",
+	".cs": "csharp", ".rb": "ruby", ".php": "php", ".swift": "swift", ".scala": "scala",
+	".sh": "bash", ".bash": "bash", ".zsh": "bash", ".ps1": "powershell", ".sql": "sql",
+	".html": "html", ".css": "css", ".scss": "scss", ".vue": "vue", ".svelte": "svelte",
+	".json": "json", ".yaml": "yaml", ".yml": "yaml", ".toml": "toml", ".xml": "xml",
+	".md": "markdown", ".proto": "protobuf", ".lua": "lua", ".dart": "dart", ".ex": "elixir",
+}
+
+// fileLanguage returns the code fence language for a file, or ""
+func fileLanguage(name string) string {
+	switch path.Base(filepath.ToSlash(name)) {
+	case "Dockerfile":
+		return "dockerfile"
+	case "Makefile":
+		return "makefile"
+	}
+	return fileLanguages[strings.ToLower(path.Ext(name))]
+}
+
+// fileTree lists a directory as a tree, skipping what git ignores
+type fileTree struct {
+	root    string
+	ignore  *ignoreMatcher
+	entries int
+}
+
+// list fills in the children of n and, depth levels further down, theirs;
+// every level is listed when depth is below 0
+func (t *fileTree) list(n *FileNode, depth int) {
+	entries, err := os.ReadDir(filepath.Join(t.root, filepath.FromSlash(n.Path)))
+	if err != nil {
+		return
+	}
+	n.Children = []FileNode{}
+	for _, e := range entries {
+		rel := path.Join(n.Path, e.Name())
+		if t.ignore.ignored(rel, e.IsDir()) {
+			continue
+		}
+		if t.entries >= treeMaxEntries {
+			n.Truncated = true
+			break
+		}
+		t.entries++
+		child := FileNode{Name: e.Name(), Path: rel, Dir: e.IsDir()}
+		if info, err := e.Info(); err == nil && !e.IsDir() {
+			child.Size = info.Size()
+		}
+		n.Children = append(n.Children, child)
+	}
+	sort.Slice(n.Children, func(i, j int) bool {
+		a, b := n.Children[i], n.Children[j]
+		if a.Dir != b.Dir {
+			return a.Dir
+		}
+		return a.Name < b.Name
+	})
+	for i := range n.Children {
+		if c := &n.Children[i]; c.Dir {
+			if depth == 0 || t.entries >= treeMaxEntries {
+				c.Truncated = true
+				continue
+			}
+			t.list(c, depth-1)
+		}
+	}
+}
+
+// GetFileTree lists a directory of the active conversation's workspace as a
+// tree, skipping .git and what .gitignore files exclude. dir is relative to
+// the workspace, "" for its root. depth is how many levels of directories
+// below dir are listed, every level when it is 0; unlisted directories are
+// marked truncated so the UI can load them when they are opened.
+func (a *App) GetFileTree(dir string, depth int) (FileNode, error) {
+	a.telemetry.recordFeature("file_tree")
+	root := a.sessionRoot("")
+	if root == "" {
+		return FileNode{}, fmt.Errorf("no workspace is open")
+	}
+	rel := path.Clean(filepath.ToSlash(dir))
+	if rel == "." || rel == "/" {
+		rel = ""
+	}
+	if rel != "" {
+		target, err := a.sandboxedPath("", rel)
+		if err != nil {
+			return FileNode{}, err
+		}
+		if info, err := os.Stat(target); err != nil || !info.IsDir() {
+			return FileNode{}, fmt.Errorf("%s is not a directory", rel)
+		}
+	}
+	n := FileNode{Name: path.Base(rel), Path: rel, Dir: true}
+	if rel == "" {
+		n.Name = filepath.Base(root)
+	}
+	t := &fileTree{root: root, ignore: newIgnoreMatcher(root)}
+	t.list(&n, depth-1)
+	return n, nil
+}
+
+// ReadWorkspaceFile reads a text file in the active conversation's workspace
+func (a *App) ReadWorkspaceFile(path string) (WorkspaceFile, error) {
+	target, err := a.sandboxedPath("", path)
+	if err != nil {
+		return WorkspaceFile{}, err
+	}
+	name := filepath.ToSlash(filepath.Clean(filepath.FromSlash(path)))
+	content, exists, err := a.currentText(target, name)
+	if err != nil {
+		return WorkspaceFile{}, err
+	}
+	if !exists {
+		return WorkspaceFile{}, fmt.Errorf("%s does not exist", name)
+	}
+	info, err := os.Stat(target)
+	if err != nil {
+		return WorkspaceFile{}, err
+	}
+	return WorkspaceFile{Path: name, Language: fileLanguage(name), Content: content, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// SetCurrentFile records the file open in the editor, relative to the
+// workspace; an empty path clears it. Opening another workspace clears it too.
+func (a *App) SetCurrentFile(path string) error {
+	name := ""
+	if path != "" {
+		target, err := a.sandboxedPath("", path)
+		if err != nil {
+			return err
+		}
+		if info, err := os.Stat(target); err != nil || info.IsDir() {
+			return fmt.Errorf("%s is not a file", path)
+		}
+		name = filepath.ToSlash(filepath.Clean(filepath.FromSlash(path)))
+	}
+	a.providersMutex.Lock()
+	defer a.providersMutex.Unlock()
+	a.currentFile = name
+	return a.saveConfigLocked()
+}
+
+// GetCurrentFile returns the file open in the editor, or ""
+func (a *App) GetCurrentFile() string {
+	a.providersMutex.RLock()
+	defer a.providersMutex.RUnlock()
+	return a.currentFile
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const defaultFocusMinutes = 25
+
+const focusSummaryInstructions = `Write a short wrap-up of the focus session described below for the user's notes.
+Cover what was asked, what code was changed, and what remains open. Use brief markdown bullets under those three headings.`
+
+// FocusSession is a time-boxed working period that is summarized when it ends
+type FocusSession struct {
+	ID        string    `json:"id"`
+	SessionID string    `json:"sessionId,omitempty"`
+	StartedAt time.Time `json:"startedAt"`
+	EndsAt    time.Time `json:"endsAt"`
+	EndedAt   time.Time `json:"endedAt,omitempty"`
+
+	Prompts      []string `json:"prompts,omitempty"`
+	ChangedFiles []string `json:"changedFiles,omitempty"`
+	OpenTasks    []string `json:"openTasks,omitempty"`
+	Summary      string   `json:"summary,omitempty"`
+}
+
+// StartFocusSession starts a focus timer of the given length (25 minutes by
+// default). When it runs out, or EndFocusSession is called, the activity in
+// that window is summarized and saved as a note on the active conversation.
+func (a *App) StartFocusSession(minutes int) (FocusSession, error) {
+	a.telemetry.recordFeature("focus_session")
+	if minutes <= 0 {
+		minutes = defaultFocusMinutes
+	}
+
+	a.sessionMutex.Lock()
+	sessionID := a.activeSession
+	a.sessionMutex.Unlock()
+
+	a.focusMutex.Lock()
+	defer a.focusMutex.Unlock()
+
+	if a.focus != nil {
+		return FocusSession{}, fmt.Errorf("a focus session is already in progress")
+	}
+
+	now := time.Now()
+	f := &FocusSession{
+		ID:        newID(),
+		SessionID: sessionID,
+		StartedAt: now,
+		EndsAt:    now.Add(time.Duration(minutes) * time.Minute),
+	}
+	a.focus = f
+	a.focusTimer = time.AfterFunc(f.EndsAt.Sub(now), func() {
+		if _, err := a.endFocus(f.ID); err != nil {
+			println("Error ending focus session:", err.Error())
+		}
+	})
+	return *f, nil
+}
+
+// GetFocusSession returns the focus session in progress, or nil if none
+func (a *App) GetFocusSession() *FocusSession {
+	a.focusMutex.Lock()
+	defer a.focusMutex.Unlock()
+
+	if a.focus == nil {
+		return nil
+	}
+	f := *a.focus
+	return &f
+}
+
+// EndFocusSession stops the focus session in progress early and returns its summary
+func (a *App) EndFocusSession() (FocusSession, error) {
+	return a.endFocus("")
+}
+
+// endFocus finishes the focus session in progress; a non-empty id only ends
+// that session, so a timer firing late cannot end a newer one
+func (a *App) endFocus(id string) (FocusSession, error) {
+	a.focusMutex.Lock()
+	if a.focus == nil || (id != "" && a.focus.ID != id) {
+		a.focusMutex.Unlock()
+		return FocusSession{}, fmt.Errorf("no focus session in progress")
+	}
+	f := *a.focus
+	a.focus = nil
+	a.focusTimer.Stop()
+	a.focusMutex.Unlock()
+
+	f.EndedAt = time.Now()
+
+	var texts, replies []string
+	// Any clean-room conversation in the session restricts its providers,
+	// and any locked one keeps the summary request out of the logs
+	var cleanRoom string
+	private := false
+	for _, summary := range a.sessions.list() {
+		s, ok := a.sessions.get(summary.ID)
+		if !ok {
+			continue
+		}
+		for _, m := range s.Messages {
+			if m.Timestamp.Before(f.StartedAt) || m.Timestamp.After(f.EndedAt) {
+				continue
+			}
+			if cleanRoom == "" {
+				cleanRoom = cleanRoomID(s)
+			}
+			private = private || s.Locked
+			texts = append(texts, m.Content)
+			switch m.Role {
+			case "user":
+				f.Prompts = append(f.Prompts, m.Content)
+			case "assistant":
+				replies = append(replies, m.Content)
+			}
+		}
+	}
+	f.ChangedFiles = changedFiles(replies...)
+	f.OpenTasks = extractTasks(texts...)
+	f.Summary = a.summarizeFocus(f, cleanRoom, private)
+
+	sessionID := f.SessionID
+	if sessionID == "" {
+		sessionID = a.GetActiveSession()
+	}
+	if sessionID != "" {
+		if _, err := a.sessions.update(sessionID, func(s *Session) error {
+			s.Notes = append(s.Notes, SessionNote{
+				Kind:      "focus",
+				Content:   f.Summary,
+				CreatedAt: f.EndedAt,
+			})
+			return nil
+		}); err != nil {
+			println("Error saving focus note:", err.Error())
+		}
+	}
+	return f, nil
+}
+
+// summarizeFocus asks the active model to write up a focus session, falling
+// back to a plain listing of the collected activity
+func (a *App) summarizeFocus(f FocusSession, cleanRoom string, private bool) string {
+	var b strings.Builder
+	format := a.reportFormatter()
+	fmt.Fprintf(&b, "Focus session %s – %s\n\n", format.Clock(f.StartedAt), format.Clock(f.EndedAt))
+	b.WriteString("### Asked\n")
+	if len(f.Prompts) == 0 {
+		b.WriteString("- Nothing\n")
+	}
+	for _, p := range f.Prompts {
+		fmt.Fprintf(&b, "- %s\n", sessionTitle(p))
+	}
+	b.WriteString("\n### Code changed\n")
+	if len(f.ChangedFiles) == 0 {
+		b.WriteString("- No changes\n")
+	}
+	for _, file := range f.ChangedFiles {
+		fmt.Fprintf(&b, "- `%s`\n", file)
+	}
+	b.WriteString("\n### Still open\n")
+	if len(f.OpenTasks) == 0 {
+		b.WriteString("- Nothing outstanding\n")
+	}
+	for _, task := range f.OpenTasks {
+		fmt.Fprintf(&b, "- %s\n", task)
+	}
+	listing := b.String()
+
+	if len(f.Prompts) == 0 {
+		return listing
+	}
+	temperature := 0.2
+	result, err := a.generate(generateRequest{
+		Prompt:      focusSummaryInstructions + "\n\n" + listing,
+		Temperature: &temperature,
+		MaxTokens:   summaryMaxTokens,
+		CleanRoom:   cleanRoom,
+		Private:     private,
+	})
+	if err != nil {
+		println("Error summarizing focus session:", err.Error())
+		return listing
+	}
+	return strings.TrimSpace(result.Response)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// followUpMaxTokens caps the suggestion request so it stays cheap
+	followUpMaxTokens = 120
+	maxFollowUps      = 3
+	// followUpLabelLength is how long a chip label may be before it is shortened
+	followUpLabelLength = 48
+	// followUpContextChars is how much of the exchange the suggester sees
+	followUpContextChars = 2000
+)
+
+const followUpInstructions = `Suggest up to three short follow-up prompts the user might send next in the conversation below.
+Each must be a complete question or instruction in the user's voice, under 15 words, and different from the others.
+Reply with one prompt per line and nothing else.`
+
+// FollowUpSuggestion is a suggested next prompt, shown as a chip under an
+// assistant message
+type FollowUpSuggestion struct {
+	// Label is the chip text, shortened from Prompt when it is long
+	Label  string `json:"label"`
+	Prompt string `json:"prompt"`
+}
+
+// followUpPrefix matches list markers and labels models put before each line
+var followUpPrefix = regexp.MustCompile(`^\s*(?:[-*•]|\d+[.)]|follow[- ]?up\s*\d*:)\s*`)
+
+// parseFollowUps reads suggestions from a reply with one prompt per line
+func parseFollowUps(reply string) []FollowUpSuggestion {
+	out := make([]FollowUpSuggestion, 0, maxFollowUps)
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(reply, "\n") {
+		prompt := followUpPrefix.ReplaceAllString(strings.TrimSpace(line), "")
+		prompt = strings.Trim(strings.TrimSpace(prompt), "\"'`*")
+		key := strings.ToLower(prompt)
+		if len(prompt) < 4 || seen[key] || strings.HasSuffix(prompt, ":") {
+			continue
+		}
+		seen[key] = true
+		out = append(out, FollowUpSuggestion{Label: followUpLabel(prompt), Prompt: prompt})
+		if len(out) == maxFollowUps {
+			break
+		}
+	}
+	return out
+}
+
+// followUpLabel shortens a prompt to chip length at a word boundary
+func followUpLabel(prompt string) string {
+	runes := []rune(prompt)
+	if len(runes) <= followUpLabelLength {
+		return prompt
+	}
+	cut := string(runes[:followUpLabelLength])
+	if i := strings.LastIndex(cut, " "); i > followUpLabelLength/2 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, " ,;:.") + "…"
+}
+
+// followUpProvider picks a cheap model for suggestions: the fast tier
+// provider, else a local one, else the active provider
+func (a *App) followUpProvider() string {
+	if p := a.tierProvider(tierFast); p != nil {
+		return p.GetConfig().ID
+	}
+	a.providersMutex.RLock()
+	defer a.providersMutex.RUnlock()
+	var active ProviderConfig
+	if p := a.activeProviderLocked(); p != nil {
+		active = p.GetConfig()
+	}
+	if active.ID != "" && isLocalProvider(active) {
+		return active.ID
+	}
+	for _, p := range a.providers {
+		if config := p.GetConfig(); config.Type != "Group" && isLocalProvider(config) {
+			return config.ID
+		}
+	}
+	return active.ID
+}
+
+// lastChars returns the last n characters of s
+func lastChars(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return "…" + string(runes[len(runes)-n:])
+}
+
+// suggestFollowUps generates suggestions for the assistant message at index
+// and saves them on it
+func (a *App) suggestFollowUps(sessionID string, index int) ([]FollowUpSuggestion, error) {
+	s, ok := a.sessions.get(sessionID)
+	if !ok {
+		return nil, fmt.Errorf("session %q not found", sessionID)
+	}
+	if index < 0 || index >= len(s.Messages) || s.Messages[index].Role != "assistant" {
+		return nil, fmt.Errorf("message %d is not an assistant reply", index)
+	}
+
+	var b strings.Builder
+	b.WriteString(followUpInstructions)
+	b.WriteString("\n\n")
+	if index > 0 && s.Messages[index-1].Role == "user" {
+		fmt.Fprintf(&b, "User: %s\n\n", lastChars(s.Messages[index-1].Content, followUpContextChars/2))
+	}
+	fmt.Fprintf(&b, "Assistant: %s\n", lastChars(s.Messages[index].Content, followUpContextChars))
+
+	temperature := 0.4
+	result, err := a.generate(generateRequest{
+		Prompt:      b.String(),
+		Temperature: &temperature,
+		MaxTokens:   followUpMaxTokens,
+		Provider:    a.followUpProvider(),
+		Language:    a.sessionLanguage(s),
+		CleanRoom:   cleanRoomID(s),
+		Private:     s.Locked,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("suggest follow-ups: %v", err)
+	}
+	suggestions := parseFollowUps(result.Response)
+
+	if _, err := a.sessions.update(sessionID, func(s *Session) error {
+		// The conversation may have moved on while the suggestions were generated
+		if index < len(s.Messages) && s.Messages[index].Role == "assistant" {
+			s.Messages[index].FollowUps = suggestions
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return suggestions, nil
+}
+
+// maybeSuggestFollowUps generates suggestions for the latest reply of a
+// session in the background when they are turned on, and emits
+// "prompt:followups" with them
+func (a *App) maybeSuggestFollowUps(sessionID string) {
+	if !a.GetFollowUpSuggestions() {
+		return
+	}
+	s, ok := a.sessions.get(sessionID)
+	if !ok || len(s.Messages) == 0 {
+		return
+	}
+	index := len(s.Messages) - 1
+	if _, running := a.suggesting.LoadOrStore(sessionID, true); running {
+		return
+	}
+	go func() {
+		defer a.suggesting.Delete(sessionID)
+		suggestions, err := a.suggestFollowUps(sessionID, index)
+		if err != nil {
+			println("Error suggesting follow-ups:", err.Error())
+			return
+		}
+		a.emit(EventPromptFollowUps, PromptFollowUpsEvent{SessionID: sessionID, MessageIndex: index, Suggestions: suggestions})
+	}()
+}
+
+// SuggestFollowUps generates two or three follow-up prompts for an assistant
+// message on a cheap model and saves them on the message
+func (a *App) SuggestFollowUps(sessionID string, messageIndex int) ([]FollowUpSuggestion, error) {
+	a.telemetry.recordFeature("suggest_follow_ups")
+	return a.suggestFollowUps(sessionID, messageIndex)
+}
+
+// SetFollowUpSuggestions turns automatic follow-up suggestions after each
+// reply on or off
+func (a *App) SetFollowUpSuggestions(enabled bool) error {
+	a.providersMutex.Lock()
+	defer a.providersMutex.Unlock()
+
+	a.followUps = enabled
+	return a.saveConfigLocked()
+}
+
+// GetFollowUpSuggestions reports whether follow-ups are suggested after each reply
+func (a *App) GetFollowUpSuggestions() bool {
+	a.providersMutex.RLock()
+	defer a.providersMutex.RUnlock()
+	return a.followUps
+}
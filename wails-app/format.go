@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/text/currency"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// ReportFormat controls how generated reports show numbers, money and dates
+type ReportFormat struct {
+	// Locale is a BCP 47 tag such as "en-US" or "de-DE"; empty follows the system
+	Locale string `json:"locale,omitempty"`
+	// Currency is the ISO 4217 code cost estimates are shown in; empty uses the locale's
+	Currency string `json:"currency,omitempty"`
+	// USDRate is how many units of Currency a US dollar buys. Prices are
+	// known in dollars, so without a rate costs stay in USD.
+	USDRate float64 `json:"usdRate,omitempty"`
+}
+
+// dateStyle holds a locale's date and time layouts. Long dates use
+// {weekday}, {day} and {month} placeholders so the names can be translated.
+type dateStyle struct {
+	short    string
+	long     string
+	clock    string
+	weekdays [7]string
+	months   [12]string
+}
+
+var englishDays = [7]string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"}
+var englishMonths = [12]string{"January", "February", "March", "April", "May", "June", "July", "August", "September", "October", "November", "December"}
+
+// dateStyles are keyed by full tag first, then base language
+var dateStyles = map[string]dateStyle{
+	"en-US": {"1/2/2006", "{weekday}, {month} {day}", "3:04 PM", englishDays, englishMonths},
+	"en":    {"02/01/2006", "{weekday} {day} {month}", "15:04", englishDays, englishMonths},
+	"de": {"02.01.2006", "{weekday}, {day}. {month}", "15:04",
+		[7]string{"Sonntag", "Montag", "Dienstag", "Mittwoch", "Donnerstag", "Freitag", "Samstag"},
+		[12]string{"Januar", "Februar", "März", "April", "Mai", "Juni", "Juli", "August", "September", "Oktober", "November", "Dezember"}},
+	"fr": {"02/01/2006", "{weekday} {day} {month}", "15:04",
+		[7]string{"dimanche", "lundi", "mardi", "mercredi", "jeudi", "vendredi", "samedi"},
+		[12]string{"janvier", "février", "mars", "avril", "mai", "juin", "juillet", "août", "septembre", "octobre", "novembre", "décembre"}},
+	"es": {"02/01/2006", "{weekday}, {day} de {month}", "15:04",
+		[7]string{"domingo", "lunes", "martes", "miércoles", "jueves", "viernes", "sábado"},
+		[12]string{"enero", "febrero", "marzo", "abril", "mayo", "junio", "julio", "agosto", "septiembre", "octubre", "noviembre", "diciembre"}},
+	"it": {"02/01/2006", "{weekday} {day} {month}", "15:04",
+		[7]string{"domenica", "lunedì", "martedì", "mercoledì", "giovedì", "venerdì", "sabato"},
+		[12]string{"gennaio", "febbraio", "marzo", "aprile", "maggio", "giugno", "luglio", "agosto", "settembre", "ottobre", "novembre", "dicembre"}},
+	"pt": {"02/01/2006", "{weekday}, {day} de {month}", "15:04",
+		[7]string{"domingo", "segunda-feira", "terça-feira", "quarta-feira", "quinta-feira", "sexta-feira", "sábado"},
+		[12]string{"janeiro", "fevereiro", "março", "abril", "maio", "junho", "julho", "agosto", "setembro", "outubro", "novembro", "dezembro"}},
+	"nl": {"02-01-2006", "{weekday} {day} {month}", "15:04",
+		[7]string{"zondag", "maandag", "dinsdag", "woensdag", "donderdag", "vrijdag", "zaterdag"},
+		[12]string{"januari", "februari", "maart", "april", "mei", "juni", "juli", "augustus", "september", "oktober", "november", "december"}},
+	"ja": {"2006/01/02", "{month}{day}日({weekday})", "15:04",
+		[7]string{"日", "月", "火", "水", "木", "金", "土"},
+		[12]string{"1月", "2月", "3月", "4月", "5月", "6月", "7月", "8月", "9月", "10月", "11月", "12月"}},
+	"zh": {"2006/01/02", "{month}{day}日 {weekday}", "15:04",
+		[7]string{"星期日", "星期一", "星期二", "星期三", "星期四", "星期五", "星期六"},
+		[12]string{"1月", "2月", "3月", "4月", "5月", "6月", "7月", "8月", "9月", "10月", "11月", "12月"}},
+}
+
+// isoDateStyle is used for locales without a style of their own
+var isoDateStyle = dateStyle{"2006-01-02", "{weekday}, {month} {day}", "15:04", englishDays, englishMonths}
+
+// symbolAfter lists languages that put the currency symbol after the amount
+var symbolAfter = map[string]bool{
+	"de": true, "fr": true, "es": true, "it": true, "pt": true, "sv": true, "da": true,
+	"fi": true, "pl": true, "cs": true, "ru": true, "uk": true, "nb": true,
+}
+
+// reportFormatter formats values for generated reports in one locale
+type reportFormatter struct {
+	tag      language.Tag
+	base     string
+	printer  *message.Printer
+	dates    dateStyle
+	currency currency.Unit
+	rate     float64
+}
+
+// systemLocale reads the locale from the environment, e.g. LANG=de_DE.UTF-8
+func systemLocale() string {
+	for _, v := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		l := os.Getenv(v)
+		if i := strings.IndexAny(l, ".@"); i != -1 {
+			l = l[:i]
+		}
+		if l != "" && l != "C" && l != "POSIX" {
+			return strings.ReplaceAll(l, "_", "-")
+		}
+	}
+	return "en-US"
+}
+
+func newReportFormatter(f ReportFormat) reportFormatter {
+	locale := f.Locale
+	if locale == "" {
+		locale = systemLocale()
+	}
+	tag, err := language.Parse(locale)
+	if err != nil {
+		tag = language.AmericanEnglish
+	}
+	base, _ := tag.Base()
+
+	r := reportFormatter{tag: tag, base: base.String(), printer: message.NewPrinter(tag), currency: currency.USD, rate: 1}
+	var ok bool
+	if r.dates, ok = dateStyles[tag.String()]; !ok {
+		if r.dates, ok = dateStyles[r.base]; !ok {
+			r.dates = isoDateStyle
+		}
+	}
+
+	unit, err := currency.ParseISO(f.Currency)
+	if f.Currency == "" {
+		unit, _ = currency.FromTag(tag)
+		err = nil
+	}
+	// Without a rate there is no honest way to show a dollar price in another currency
+	if err == nil && (unit == currency.USD || f.USDRate > 0) {
+		r.currency = unit
+		if unit != currency.USD {
+			r.rate = f.USDRate
+		}
+	}
+	return r
+}
+
+// Int formats a whole number with the locale's digit grouping
+func (r reportFormatter) Int(n int64) string {
+	return r.printer.Sprintf("%d", n)
+}
+
+// Decimal formats x with the given number of fraction digits
+func (r reportFormatter) Decimal(x float64, digits int) string {
+	return r.printer.Sprintf("%.*f", digits, x)
+}
+
+// Money converts a US dollar amount into the report currency and formats it
+// with the currency's symbol and usual number of decimals
+func (r reportFormatter) Money(usd float64) string {
+	scale, _ := currency.Standard.Rounding(r.currency)
+	amount := r.Decimal(usd*r.rate, scale)
+	symbol := r.printer.Sprint(currency.Symbol(r.currency))
+	if symbolAfter[r.base] {
+		return amount + " " + symbol
+	}
+	if strings.Trim(symbol, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") == "" {
+		// Codes such as CHF need a space before the amount
+		return symbol + " " + amount
+	}
+	return symbol + amount
+}
+
+// Date formats a date in the locale's short numeric form
+func (r reportFormatter) Date(t time.Time) string {
+	return t.Format(r.dates.short)
+}
+
+// LongDate formats a date with the weekday and month spelled out, without the year
+func (r reportFormatter) LongDate(t time.Time) string {
+	return strings.NewReplacer(
+		"{weekday}", r.dates.weekdays[t.Weekday()],
+		"{month}", r.dates.months[t.Month()-1],
+		"{day}", fmt.Sprint(t.Day()),
+	).Replace(r.dates.long)
+}
+
+// Clock formats a time of day
+func (r reportFormatter) Clock(t time.Time) string {
+	return t.Format(r.dates.clock)
+}
+
+// reportFormatter returns the formatter for the configured report format
+func (a *App) reportFormatter() reportFormatter {
+	a.providersMutex.RLock()
+	defer a.providersMutex.RUnlock()
+	return newReportFormatter(a.reportFormat)
+}
+
+// SetReportFormat sets the locale, currency and exchange rate that usage
+// reports, cost estimates and standups are formatted with
+func (a *App) SetReportFormat(format ReportFormat) error {
+	if format.Locale != "" {
+		if _, err := language.Parse(format.Locale); err != nil {
+			return fmt.Errorf("invalid locale %q", format.Locale)
+		}
+	}
+	if format.Currency != "" {
+		unit, err := currency.ParseISO(format.Currency)
+		if err != nil {
+			return fmt.Errorf("unknown currency %q", format.Currency)
+		}
+		format.Currency = unit.String()
+	}
+	if format.USDRate < 0 {
+		return fmt.Errorf("exchange rate must not be negative")
+	}
+
+	a.providersMutex.Lock()
+	defer a.providersMutex.Unlock()
+	a.reportFormat = format
+	return a.saveConfigLocked()
+}
+
+// GetReportFormat returns the report format settings; empty fields follow the system locale
+func (a *App) GetReportFormat() ReportFormat {
+	a.providersMutex.RLock()
+	defer a.providersMutex.RUnlock()
+	return a.reportFormat
+}
@@ -1,6 +1,6 @@
-import React, { useState } from 'react';
+import React, { useEffect, useState } from 'react';
 import { Editor } from '@monaco-editor/react';
-import { FolderOpen, Brain, Cog, PlugZap, Send, X } from 'lucide-react';
+import { FolderOpen, Brain, Cog, PlugZap, Send, Square, X } from 'lucide-react';
 
 // Placeholder for Wails-bound API (after wails generate)
 declare global { 
@@ -8,14 +8,139 @@ declare global {
     backend?: { 
       App?: { 
         SendPrompt(prompt: string): Promise<string>;
-        AddProvider(config: ProviderConfig): Promise<void>;
-        ListProviders(): Promise<string[]>;
-        SetActiveProvider(index: number): Promise<void>;
+        StreamPrompt(prompt: string): Promise<string>;
+        SendPromptUnredacted(prompt: string): Promise<string>;
+        GetPreSendReview(): Promise<boolean>;
+        ReviewContext(prompt: string): Promise<ContextReview>;
+        SendReviewedPrompt(prompt: string, excluded: string[]): Promise<string>;
+        ScanSecrets(text: string): Promise<{ kind: string; line: number }[]>;
+        GetPromptHistory(query: string, limit: number): Promise<{ prompt: string; sessionId?: string; sentAt: string }[]>;
+        ListPersonas(): Promise<Persona[]>;
+        SetSessionPersona(sessionId: string, personaId: string): Promise<void>;
+        GetActiveSession(): Promise<string>;
+        NewSession(title: string): Promise<{ id: string }>;
+        CancelPrompt(sessionId: string): Promise<void>;
+        AddProvider(config: ProviderConfig): Promise<ProviderInfo>;
+        AddValidatedProvider(config: ProviderConfig): Promise<{ provider?: ProviderInfo; test: ProviderTest }>;
+        ListProviders(): Promise<ProviderInfo[]>;
+        SetActiveProvider(id: string): Promise<void>;
+        RemoveProvider(id: string): Promise<void>;
+        TestProvider(config: ProviderConfig): Promise<ProviderTest>;
+        ListModels(providerId: string): Promise<ModelInfo[]>;
+        GetSupportedProviderTypes(): Promise<string[]>;
+        TakePrefilledPrompt(): Promise<PromptPrefill>;
+        GetUsageDashboard(days: number): Promise<UsageDashboard>;
+        ListScripts(): Promise<Script[]>;
+        SaveScript(script: Script): Promise<Script>;
+        DeleteScript(id: string): Promise<void>;
+        RunScript(id: string, input: string): Promise<ScriptRun>;
       } 
     } 
   } 
 }
 
+interface Persona {
+  id: string;
+  name: string;
+  prompt: string;
+  defaults?: { temperature?: number; maxTokens?: number };
+  builtIn?: boolean;
+}
+
+interface ContextReview {
+  provider: string;
+  local: boolean;
+  items: { id: string; kind: string; label: string; preview: string; tokens: number }[];
+  tokens: number;
+}
+
+interface PromptPrefill {
+  sessionId?: string;
+  prompt: string;
+  attachments?: { hash: string; name: string; size: number }[];
+}
+
+interface UsageDashboard {
+  since?: string;
+  totals: {
+    prompts: number;
+    replies: number;
+    conversations: number;
+    activeDays: number;
+    tokensIn: number;
+    tokensOut: number;
+    averageResponseChars: number;
+    averageResponseTokens: number;
+  };
+  // count is the prompts sent on the day
+  days: { day: string; count: number; replies: number; tokensIn: number; tokensOut: number }[];
+  models: { providerId: string; provider: string; model: string; requests: number; share: number; averageResponseChars: number }[];
+}
+
+interface ScriptPermissions {
+  prompt: boolean;
+  readWorkspace: boolean;
+  writeWorkspace: boolean;
+  tools: boolean;
+}
+
+interface Script {
+  id: string;
+  name: string;
+  description?: string;
+  source: string;
+  permissions: ScriptPermissions;
+  timeoutSeconds?: number;
+}
+
+interface ScriptRun {
+  runId: string;
+  scriptId: string;
+  output: string[];
+  result?: string;
+  error?: string;
+  durationMs: number;
+}
+
+const NEW_SCRIPT: Script = {
+  id: '',
+  name: 'New script',
+  source: '-- vibe.grep, vibe.files, vibe.read, vibe.write, vibe.prompt, vibe.log\nfor _, m in ipairs(vibe.grep("TODO")) do\n  print(m.path, m.line, m.text)\nend\n',
+  permissions: { prompt: false, readWorkspace: true, writeWorkspace: false, tools: false },
+};
+
+// How many days the dashboard tab covers
+const DASHBOARD_DAYS = 30;
+
+// How many earlier prompts the up arrow can recall
+const HISTORY_LIMIT = 200;
+
+interface ProviderInfo {
+  id: string;
+  name: string;
+  type: string;
+  model: string;
+  active: boolean;
+  warnings?: string[];
+}
+
+interface ModelInfo {
+  name: string;
+  size?: number;
+  family?: string;
+  parameterSize?: string;
+  quantization?: string;
+  modifiedAt?: string;
+}
+
+interface ProviderTest {
+  ok: boolean;
+  latencyMs: number;
+  detail?: string;
+  error?: string;
+  reason?: string;
+}
+
 interface ProviderConfig {
   type: string;
   name: string;
@@ -24,22 +149,18 @@ interface ProviderConfig {
   model: string;
 }
 
-const PROVIDER_TYPES = ['Ollama', 'Copilot', 'Gemini', 'Claude', 'Mock'] as const;
-type ProviderType = typeof PROVIDER_TYPES[number];
+// Types the provider dialog can configure; the backend's supported list narrows these
+const DIALOG_PROVIDER_TYPES = ['Ollama', 'OpenAI', 'Mock'];
 
-const DEFAULT_ENDPOINTS: Record<ProviderType, string> = {
+const DEFAULT_ENDPOINTS: Record<string, string> = {
   Ollama: 'http://localhost:11434',
-  Copilot: 'https://api.githubcopilot.com',
-  Gemini: 'https://generativelanguage.googleapis.com',
-  Claude: 'https://api.anthropic.com',
+  OpenAI: 'https://api.openai.com/v1',
   Mock: '',
 };
 
-const DEFAULT_MODELS: Record<ProviderType, string> = {
+const DEFAULT_MODELS: Record<string, string> = {
   Ollama: 'llama3',
-  Copilot: 'gpt-4o-mini',
-  Gemini: 'gemini-pro',
-  Claude: 'claude-3-opus',
+  OpenAI: 'gpt-4o-mini',
   Mock: 'mock-model-v1',
 };
 
@@ -47,11 +168,23 @@ const App: React.FC = () => {
   const [prompt, setPrompt] = useState('');
   const [response, setResponse] = useState<string>('');
   const [loading, setLoading] = useState(false);
+  const [unredacted, setUnredacted] = useState(false);
+  const [review, setReview] = useState<ContextReview | null>(null);
+  const [excluded, setExcluded] = useState<string[]>([]);
+  const [personas, setPersonas] = useState<Persona[]>([]);
+  const [persona, setPersona] = useState('');
+  // history holds earlier prompts, newest first; recall is the one shown
+  // (-1 while editing a new prompt) and draft the prompt being written
+  const [history, setHistory] = useState<string[]>([]);
+  const [recall, setRecall] = useState(-1);
+  const [draft, setDraft] = useState('');
   const [style, setStyle] = useState<'vscode' | 'zed'>('vscode');
   const [theme, setTheme] = useState<'dark' | 'light'>('dark');
   const [fontFamily, setFontFamily] = useState<string>('JetBrains Mono');
   const [fontSize, setFontSize] = useState<number>(14);
   const [showProviderDialog, setShowProviderDialog] = useState(false);
+  const [tab, setTab] = useState<'chat' | 'dashboard' | 'scripts'>('chat');
+  const [dashboard, setDashboard] = useState<UsageDashboard | null>(null);
   
   // Provider dialog state
   const [providerTypeIndex, setProviderTypeIndex] = useState(0);
@@ -59,9 +192,81 @@ const App: React.FC = () => {
   const [providerApiKey, setProviderApiKey] = useState('');
   const [providerEndpoint, setProviderEndpoint] = useState('');
   const [providerModel, setProviderModel] = useState('');
+  // providerCheck is the failed validation shown in the dialog
+  const [providerCheck, setProviderCheck] = useState<ProviderTest | null>(null);
+  const [providerTypes, setProviderTypes] = useState<string[]>(DIALOG_PROVIDER_TYPES);
+
+  useEffect(() => {
+    window.backend?.App?.GetSupportedProviderTypes?.()
+      .then(types => setProviderTypes(DIALOG_PROVIDER_TYPES.filter(t => types.includes(t))))
+      .catch(e => console.error('Error loading provider types:', e));
+  }, []);
+
+  useEffect(() => {
+    window.backend?.App?.ListPersonas?.()
+      .then(setPersonas)
+      .catch(e => console.error('Error loading personas:', e));
+  }, []);
+
+  const loadHistory = () => {
+    window.backend?.App?.GetPromptHistory?.('', HISTORY_LIMIT)
+      .then(entries => setHistory(entries.map(e => e.prompt)))
+      .catch(e => console.error('Error loading prompt history:', e));
+  };
+  useEffect(loadHistory, []);
+
+  // Up and down arrows step through earlier prompts, as in a shell, when the
+  // cursor is on the first or last line
+  function recallPrompt(e: React.KeyboardEvent<HTMLTextAreaElement>) {
+    const el = e.currentTarget;
+    if (e.key === 'ArrowUp' && !el.value.slice(0, el.selectionStart).includes('\n') && recall + 1 < history.length) {
+      e.preventDefault();
+      if (recall === -1) setDraft(prompt);
+      setRecall(recall + 1);
+      setPrompt(history[recall + 1]);
+    } else if (e.key === 'ArrowDown' && !el.value.slice(el.selectionEnd).includes('\n') && recall >= 0) {
+      e.preventDefault();
+      setRecall(recall - 1);
+      setPrompt(recall === 0 ? draft : history[recall - 1]);
+    }
+  }
+
+  // Personas apply to the active conversation, which is started if needed
+  async function choosePersona(id: string) {
+    const api = window.backend?.App;
+    setPersona(id);
+    if (!api) return;
+    try {
+      const sessionId = (await api.GetActiveSession()) || (await api.NewSession('')).id;
+      await api.SetSessionPersona(sessionId, id);
+    } catch (e) {
+      console.error('Error setting persona:', e);
+    }
+  }
+
+  // Pick up prompts sent from the OS "Ask Vibe Coder" menu, at launch and
+  // whenever the window is brought forward for one
+  useEffect(() => {
+    const takePrefill = () => {
+      window.backend?.App?.TakePrefilledPrompt?.()
+        .then(p => { if (p.prompt || p.attachments?.length) setPrompt(p.prompt); })
+        .catch(e => console.error('Error loading prefilled prompt:', e));
+    };
+    takePrefill();
+    window.addEventListener('focus', takePrefill);
+    return () => window.removeEventListener('focus', takePrefill);
+  }, []);
+
+  // Refresh the dashboard each time its tab is opened
+  useEffect(() => {
+    if (tab !== 'dashboard') return;
+    window.backend?.App?.GetUsageDashboard?.(DASHBOARD_DAYS)
+      .then(setDashboard)
+      .catch(e => console.error('Error loading usage dashboard:', e));
+  }, [tab]);
 
   const fonts = ['JetBrains Mono', 'Fira Code', 'SF Mono', 'Cascadia Code', 'Menlo'];
-  const currentProviderType = PROVIDER_TYPES[providerTypeIndex];
+  const currentProviderType = providerTypes[providerTypeIndex % providerTypes.length];
 
   const cycleFontFamily = () => {
     const idx = fonts.indexOf(fontFamily);
@@ -73,14 +278,16 @@ const App: React.FC = () => {
   };
 
   const cycleProviderType = () => {
-    const nextIndex = (providerTypeIndex + 1) % PROVIDER_TYPES.length;
+    const nextIndex = (providerTypeIndex + 1) % providerTypes.length;
     setProviderTypeIndex(nextIndex);
-    const nextType = PROVIDER_TYPES[nextIndex];
+    const nextType = providerTypes[nextIndex];
     setProviderEndpoint(DEFAULT_ENDPOINTS[nextType]);
     setProviderModel(DEFAULT_MODELS[nextType]);
   };
 
-  const addProvider = async () => {
+  // addProvider validates the provider before adding it, unless the user
+  // chose to add it anyway after a failed check
+  const addProvider = async (skipCheck = false) => {
     const config: ProviderConfig = {
       type: currentProviderType,
       name: providerName || currentProviderType,
@@ -91,7 +298,13 @@ const App: React.FC = () => {
 
     try {
       const api = window.backend?.App;
-      if (api?.AddProvider) {
+      if (!skipCheck && api?.AddValidatedProvider) {
+        const result = await api.AddValidatedProvider(config);
+        if (!result.test.ok) {
+          setProviderCheck(result.test);
+          return;
+        }
+      } else if (api?.AddProvider) {
         await api.AddProvider(config);
         console.log('Provider added successfully');
       } else {
@@ -102,6 +315,7 @@ const App: React.FC = () => {
     }
 
     setShowProviderDialog(false);
+    setProviderCheck(null);
     setProviderName('');
     setProviderApiKey('');
     setProviderEndpoint('');
@@ -113,8 +327,23 @@ const App: React.FC = () => {
     setLoading(true);
     try {
       const api = window.backend?.App;
-      const resp = api ? await api.SendPrompt(prompt) : `Local echo:\n${prompt}`;
+      // Hosted providers get nothing before the context is reviewed
+      if (api && !review && await api.GetPreSendReview?.()) {
+        const r = await api.ReviewContext(prompt);
+        if (!r.local) {
+          setReview(r);
+          setExcluded([]);
+          return;
+        }
+      }
+      const resp = !api ? `Local echo:\n${prompt}`
+        : review ? await api.SendReviewedPrompt(prompt, excluded)
+        : unredacted ? await api.SendPromptUnredacted(prompt) : await api.SendPrompt(prompt);
       setResponse(resp);
+      setReview(null);
+      setUnredacted(false);
+      setRecall(-1);
+      loadHistory();
     } catch (e: any) {
       setResponse(`Error: ${e.message || String(e)}`);
     } finally {
@@ -122,6 +351,11 @@ const App: React.FC = () => {
     }
   }
 
+  function cancel() {
+    window.backend?.App?.CancelPrompt('')
+      .catch(e => console.error('Error cancelling prompt:', e));
+  }
+
   return (
     <div className={`w-screen h-screen flex flex-col ${theme === 'dark' ? 'bg-[#1e1e1e]' : 'bg-white'} text-sm`}>      
       <div className="flex flex-1 overflow-hidden">
@@ -143,11 +377,44 @@ const App: React.FC = () => {
         <div className="flex-1 flex flex-col">
           {/* Tab Bar */}
           <div className="flex items-center h-9 bg-[#2d2d2d] text-gray-200 text-xs">
-            <div className="px-3 h-full flex items-center bg-[#1e1e1e] border-r border-[#3c3c3c]">Chat ⨉</div>
+            {(['chat', 'dashboard', 'scripts'] as const).map(t => (
+              <button
+                key={t}
+                onClick={() => setTab(t)}
+                className={`px-3 h-full flex items-center border-r border-[#3c3c3c] ${tab === t ? 'bg-[#1e1e1e]' : 'opacity-70 hover:opacity-100'}`}
+              >
+                {t === 'chat' ? 'Chat' : t === 'dashboard' ? 'Dashboard' : 'Scripts'}
+              </button>
+            ))}
           </div>
           {/* Response Area */}
           <div className="flex-1 overflow-auto bg-[#1e1e1e] p-4 space-y-4">
-            {response ? (
+            {tab === 'dashboard' ? (
+              <Dashboard data={dashboard} />
+            ) : tab === 'scripts' ? (
+              <ScriptsPanel />
+            ) : review ? (
+              <div className="text-gray-200 text-xs space-y-2">
+                <div className="opacity-70">
+                  Choose what is sent to {review.provider}, then press Send. The prompt itself is always sent.
+                </div>
+                {review.items.length === 0 && <div className="opacity-70">No context besides the prompt.</div>}
+                {review.items.map(item => (
+                  <label key={item.id} className="flex gap-2 bg-[#252526] border border-[#3c3c3c] rounded-md p-2">
+                    <input
+                      type="checkbox"
+                      checked={!excluded.includes(item.id)}
+                      onChange={e => setExcluded(e.target.checked ? excluded.filter(id => id !== item.id) : [...excluded, item.id])}
+                    />
+                    <div className="flex-1 min-w-0">
+                      <div className="font-semibold">{item.label} <span className="opacity-60 font-normal">· {item.tokens.toLocaleString()} tokens</span></div>
+                      <div className="opacity-70 whitespace-pre-wrap break-words">{item.preview}</div>
+                    </div>
+                  </label>
+                ))}
+                <button onClick={() => setReview(null)} className="px-3 py-1 rounded-md bg-[#3c3c3c] hover:bg-[#4c4c4c]">Cancel</button>
+              </div>
+            ) : response ? (
               <Editor
                 theme={theme === 'dark' ? 'vs-dark' : 'light'}
                 height="100%"
@@ -166,7 +433,8 @@ const App: React.FC = () => {
               style={{ fontFamily, fontSize: `${fontSize}px` }}
               placeholder="Ask something..."
               value={prompt}
-              onChange={(e) => setPrompt(e.target.value)}
+              onChange={(e) => { setPrompt(e.target.value); setRecall(-1); setReview(null); }}
+              onKeyDown={recallPrompt}
             />
             <button
               onClick={send}
@@ -175,6 +443,27 @@ const App: React.FC = () => {
             >
               <Send size={16} /> {loading ? 'Sending...' : 'Send'}
             </button>
+            <select
+              value={persona}
+              onChange={(e) => choosePersona(e.target.value)}
+              className="bg-[#1e1e1e] border border-[#3c3c3c] rounded-md text-xs text-gray-300 px-2 py-2"
+              title="Persona for this conversation"
+            >
+              <option value="">No persona</option>
+              {personas.map(p => <option key={p.id} value={p.id}>{p.name}</option>)}
+            </select>
+            <label className="flex items-center gap-1 text-xs text-gray-400" title="Send this prompt without masking secrets">
+              <input type="checkbox" checked={unredacted} onChange={(e) => setUnredacted(e.target.checked)} />
+              Unmasked
+            </label>
+            {loading && (
+              <button
+                onClick={cancel}
+                className="flex items-center gap-2 bg-[#3c3c3c] hover:bg-[#4c4c4c] text-white text-xs font-medium px-4 py-2 rounded-md"
+              >
+                <Square size={16} /> Stop
+              </button>
+            )}
           </div>
         </div>
       </div>
@@ -270,16 +559,30 @@ const App: React.FC = () => {
               </div>
             </div>
 
+            {providerCheck && (
+              <div className="mx-4 mb-2 p-3 rounded-md bg-red-900/40 border border-red-700 text-red-200 text-sm">
+                {providerCheck.error}
+              </div>
+            )}
+
             {/* Footer */}
             <div className="flex items-center justify-end gap-3 p-4 border-t border-[#3c3c3c]">
+              {providerCheck && (
+                <button
+                  onClick={() => addProvider(true)}
+                  className="px-4 py-2 bg-[#3c3c3c] hover:bg-[#4c4c4c] text-gray-200 text-sm rounded-md"
+                >
+                  Add Anyway
+                </button>
+              )}
               <button
-                onClick={() => setShowProviderDialog(false)}
+                onClick={() => { setShowProviderDialog(false); setProviderCheck(null); }}
                 className="px-4 py-2 bg-[#3c3c3c] hover:bg-[#4c4c4c] text-gray-200 text-sm rounded-md"
               >
                 Cancel
               </button>
               <button
-                onClick={addProvider}
+                onClick={() => addProvider()}
                 className="px-4 py-2 bg-blue-600 hover:bg-blue-500 text-white text-sm rounded-md"
               >
                 Add Provider
@@ -292,4 +595,180 @@ const App: React.FC = () => {
   );
 };
 
+// Dashboard shows the usage figures from GetUsageDashboard: totals, prompts
+// per day as bars, and the most used models
+const Dashboard: React.FC<{ data: UsageDashboard | null }> = ({ data }) => {
+  if (!data) {
+    return <div className="text-gray-500 text-sm">Loading usage...</div>;
+  }
+  const { totals, days, models } = data;
+  const maxPrompts = Math.max(1, ...days.map(d => d.count));
+  const stats: [string, string][] = [
+    ['Prompts', totals.prompts.toLocaleString()],
+    ['Conversations', totals.conversations.toLocaleString()],
+    ['Active days', totals.activeDays.toLocaleString()],
+    ['Tokens in', totals.tokensIn.toLocaleString()],
+    ['Tokens out', totals.tokensOut.toLocaleString()],
+    ['Avg reply', `${Math.round(totals.averageResponseTokens).toLocaleString()} tokens`],
+  ];
+
+  return (
+    <div className="text-gray-200 space-y-6">
+      <div className="grid grid-cols-3 gap-3">
+        {stats.map(([label, value]) => (
+          <div key={label} className="bg-[#252526] border border-[#3c3c3c] rounded-md p-3">
+            <div className="text-xs opacity-70">{label}</div>
+            <div className="text-lg font-semibold">{value}</div>
+          </div>
+        ))}
+      </div>
+      <div>
+        <div className="text-xs uppercase tracking-wide font-semibold mb-2">Prompts per day</div>
+        <div className="flex items-end gap-px h-32 bg-[#252526] border border-[#3c3c3c] rounded-md p-2">
+          {days.map(d => (
+            <div
+              key={d.day}
+              title={`${d.day}: ${d.count} prompts, ${(d.tokensIn + d.tokensOut).toLocaleString()} tokens`}
+              className="flex-1 bg-blue-600 rounded-sm"
+              style={{ height: `${(d.count / maxPrompts) * 100}%` }}
+            />
+          ))}
+        </div>
+      </div>
+      <div>
+        <div className="text-xs uppercase tracking-wide font-semibold mb-2">Most used models</div>
+        <table className="w-full text-xs">
+          <thead className="opacity-70 text-left">
+            <tr><th>Provider</th><th>Model</th><th>Requests</th><th>Share</th><th>Avg reply</th></tr>
+          </thead>
+          <tbody>
+            {models.map(m => (
+              <tr key={m.providerId} className="border-t border-[#3c3c3c]">
+                <td className="py-1">{m.provider}</td>
+                <td>{m.model}</td>
+                <td>{m.requests.toLocaleString()}</td>
+                <td>{Math.round(m.share * 100)}%</td>
+                <td>{Math.round(m.averageResponseChars).toLocaleString()} chars</td>
+              </tr>
+            ))}
+          </tbody>
+        </table>
+      </div>
+    </div>
+  );
+};
+
+// ScriptsPanel lists the saved Lua scripts and edits, grants permissions
+// to and runs the selected one
+const ScriptsPanel: React.FC = () => {
+  const [scripts, setScripts] = useState<Script[]>([]);
+  const [draft, setDraft] = useState<Script>(NEW_SCRIPT);
+  const [input, setInput] = useState('');
+  const [run, setRun] = useState<ScriptRun | null>(null);
+  const [running, setRunning] = useState(false);
+
+  const load = () => {
+    window.backend?.App?.ListScripts?.()
+      .then(setScripts)
+      .catch(e => console.error('Error loading scripts:', e));
+  };
+  useEffect(load, []);
+
+  const save = async () => {
+    try {
+      const saved = await window.backend!.App!.SaveScript(draft);
+      setDraft(saved);
+      load();
+      return saved;
+    } catch (e) {
+      setRun({ runId: '', scriptId: draft.id, output: [], error: String(e), durationMs: 0 });
+      return null;
+    }
+  };
+
+  const runScript = async () => {
+    const saved = await save();
+    if (!saved) return;
+    setRunning(true);
+    try {
+      setRun(await window.backend!.App!.RunScript(saved.id, input));
+    } catch (e) {
+      console.error('Error running script:', e);
+    } finally {
+      setRunning(false);
+    }
+  };
+
+  const remove = async () => {
+    if (!draft.id) return;
+    await window.backend?.App?.DeleteScript?.(draft.id);
+    setDraft(NEW_SCRIPT);
+    load();
+  };
+
+  const permissions: [keyof ScriptPermissions, string][] = [
+    ['prompt', 'Send prompts'],
+    ['readWorkspace', 'Read workspace'],
+    ['writeWorkspace', 'Write workspace'],
+    ['tools', 'Run tools'],
+  ];
+
+  return (
+    <div className="flex gap-4 h-full text-gray-200 text-xs">
+      <div className="w-48 flex flex-col gap-1">
+        <button onClick={() => { setDraft(NEW_SCRIPT); setRun(null); }} className="text-left px-2 py-1 rounded-md bg-[#3c3c3c] hover:bg-[#4c4c4c]">+ New script</button>
+        {scripts.map(s => (
+          <button
+            key={s.id}
+            onClick={() => { setDraft(s); setRun(null); }}
+            className={`text-left px-2 py-1 rounded-md ${s.id === draft.id ? 'bg-[#37373d]' : 'hover:bg-[#2a2d2e]'}`}
+          >
+            {s.name}
+          </button>
+        ))}
+      </div>
+      <div className="flex-1 flex flex-col gap-2">
+        <input
+          className="bg-[#252526] border border-[#3c3c3c] rounded-md p-2"
+          value={draft.name}
+          onChange={e => setDraft({ ...draft, name: e.target.value })}
+        />
+        <textarea
+          className="flex-1 min-h-[12rem] bg-[#252526] border border-[#3c3c3c] rounded-md p-2 font-mono"
+          value={draft.source}
+          onChange={e => setDraft({ ...draft, source: e.target.value })}
+        />
+        <div className="flex items-center gap-4">
+          {permissions.map(([key, label]) => (
+            <label key={key} className="flex items-center gap-1">
+              <input
+                type="checkbox"
+                checked={draft.permissions[key]}
+                onChange={e => setDraft({ ...draft, permissions: { ...draft.permissions, [key]: e.target.checked } })}
+              />
+              {label}
+            </label>
+          ))}
+        </div>
+        <div className="flex items-center gap-2">
+          <input
+            className="flex-1 bg-[#252526] border border-[#3c3c3c] rounded-md p-2"
+            placeholder="Input (vibe.input)"
+            value={input}
+            onChange={e => setInput(e.target.value)}
+          />
+          <button onClick={save} className="px-3 py-2 rounded-md bg-[#3c3c3c] hover:bg-[#4c4c4c]">Save</button>
+          <button onClick={runScript} disabled={running} className="px-3 py-2 rounded-md bg-blue-600 hover:bg-blue-500 disabled:opacity-50">{running ? 'Running...' : 'Run'}</button>
+          {draft.id && <button onClick={remove} className="px-3 py-2 rounded-md bg-[#3c3c3c] hover:bg-[#4c4c4c]">Delete</button>}
+        </div>
+        {run && (
+          <pre className="bg-[#252526] border border-[#3c3c3c] rounded-md p-2 max-h-48 overflow-auto whitespace-pre-wrap">
+            {[...run.output, run.error ? `Error: ${run.error}` : run.result ? `=> ${run.result}` : ''].filter(Boolean).join('\n')}
+          </pre>
+        )}
+      </div>
+    </div>
+  );
+};
+
 export default App;
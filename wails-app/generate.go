@@ -0,0 +1,482 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	defaultTemperature = 0.7
+	defaultMaxTokens   = 2000
+)
+
+// generateRequest is a single completion passed through the app's pipeline.
+// Every entry point (bindings, local server) goes through generate so that
+// provider selection and policies are applied consistently.
+type generateRequest struct {
+	Prompt string
+	// Messages, when set, is a conversation that is trimmed to the provider's
+	// context window and rendered into Prompt
+	Messages []Message
+	// Temperature and MaxTokens fall back to the provider's defaults, then the
+	// app's, when nil or zero
+	Temperature *float64
+	MaxTokens   int
+	// TopP, TopK and Stop replace the provider's sampling defaults when set
+	TopP *float64
+	TopK int
+	Stop []string
+	// Provider optionally selects a provider by ID or name instead of the active one
+	Provider string
+	// ReplayOf marks the request as a replay of an earlier recorded request
+	ReplayOf string
+	// Language, when set, instructs the model to reply in that language and
+	// re-asks when the reply drifts into another one
+	Language string
+	// TargetLength, when set, limits the reply to a target length (see
+	// parseTargetLength) and holds back text that runs past it
+	TargetLength string
+	// Tier is the routing tier from the intent classifier; "fast" requests
+	// go to the fast tier provider when one is set
+	Tier string
+	// Unredacted sends secrets in the prompt as typed instead of masking them
+	// (see RedactionPolicy)
+	Unredacted bool
+	// Reviewed marks requests whose context the user chose in a pre-send
+	// review; while review is on, only these go to hosted providers
+	Reviewed bool
+	// CleanRoom is the ID of the clean-room conversation the request is
+	// made for; such requests only go to providers it may use
+	CleanRoom string
+	// Private marks requests made for a locked conversation; their prompt
+	// and reply are kept out of the request and HTTP logs and the cache
+	Private bool
+	// Schema, when set, asks for a JSON reply matching it through the
+	// backend's JSON mode; see generateStructured for validation
+	Schema json.RawMessage
+	// Tools are the tools the model may call; replies then carry the calls
+	// as tool blocks (see generateWithTools)
+	Tools []ToolDefinition
+	// OnChunk, when set, receives the reply incrementally as it streams
+	OnChunk func(string)
+	// Context, when set, cancels the request; it then fails with errCancelled
+	Context context.Context
+	// trace carries the span of the dispatched request to the provider
+	trace context.Context
+	// unstreamed sends the request in one piece even when OnChunk is set;
+	// OnChunk then receives the whole reply at once
+	unstreamed bool
+}
+
+type generateResult struct {
+	Response  string
+	Provider  string
+	RequestID string
+	// Remainder is reply text held back by the target length
+	Remainder string
+}
+
+// selectProvider returns the provider with the given ID or name, or the
+// active one when ref is empty or unknown. With no providers configured it
+// falls back to a mock provider.
+func (a *App) selectProvider(ref string) (Provider, error) {
+	a.providersMutex.RLock()
+	defer a.providersMutex.RUnlock()
+
+	if ref != "" {
+		if i := a.providerIndexLocked(ref); i != -1 {
+			return a.providers[i], nil
+		}
+		for _, p := range a.providers {
+			if p.GetName() == ref {
+				return p, nil
+			}
+		}
+	}
+
+	if p := a.activeProviderLocked(); p != nil {
+		return p, nil
+	}
+	// No provider configured, return mock response
+	return NewMockProvider(ProviderConfig{Name: "Mock"}), nil
+}
+
+func (a *App) generate(req generateRequest) (generateResult, error) {
+	provider, err := a.selectProvider(req.Provider)
+	if err != nil {
+		return generateResult{}, err
+	}
+	if req.Provider == "" {
+		if p := a.tierProvider(req.Tier); p != nil {
+			provider = p
+		}
+		if a.lowDataActive() {
+			provider = a.preferLocalProvider(provider)
+		}
+		if req.CleanRoom != "" {
+			provider = a.preferCleanRoomProvider(provider)
+		}
+	}
+
+	result, err := a.generateOn(provider, req)
+	if err == nil || req.Provider != "" || errors.Is(err, errCancelled) {
+		return result, err
+	}
+
+	// Requests that did not ask for a specific provider move down the fallback chain
+	for _, next := range a.fallbackChain(provider) {
+		a.emit(EventProviderFallback, ProviderFallbackEvent{From: provider.GetName(), To: next.GetName(), Error: err.Error()})
+		provider = next
+		if result, err = a.generateOn(provider, req); err == nil || errors.Is(err, errCancelled) {
+			break
+		}
+	}
+	return result, err
+}
+
+// fallbackChain returns the configured fallback providers, skipping failed
+func (a *App) fallbackChain(failed Provider) []Provider {
+	a.providersMutex.RLock()
+	defer a.providersMutex.RUnlock()
+
+	var chain []Provider
+	for _, id := range a.fallbackProviders {
+		if id == failed.GetConfig().ID {
+			continue
+		}
+		if i := a.providerIndexLocked(id); i != -1 {
+			chain = append(chain, a.providers[i])
+		}
+	}
+	return chain
+}
+
+// generateOn runs a request against one provider
+func (a *App) generateOn(provider Provider, req generateRequest) (generateResult, error) {
+	config := provider.GetConfig()
+	d := GenerationDefaults{Temperature: req.Temperature, MaxTokens: req.MaxTokens, TopP: req.TopP, TopK: req.TopK, Stop: req.Stop}.over(config.Defaults)
+	if gp, ok := provider.(generationProvider); ok && (req.TopP != nil || req.TopK > 0 || len(req.Stop) > 0) {
+		provider = gp.withGeneration(d)
+		config = provider.GetConfig()
+	}
+	temperature := defaultTemperature
+	if d.Temperature != nil {
+		temperature = *d.Temperature
+	}
+	req.MaxTokens = d.MaxTokens
+	if req.MaxTokens == 0 {
+		req.MaxTokens = defaultMaxTokens
+	}
+	var target lengthTarget
+	if req.TargetLength != "" {
+		t, err := parseTargetLength(req.TargetLength)
+		if err != nil {
+			return generateResult{}, err
+		}
+		target = t
+		req.MaxTokens = int(float64(t.Tokens) * lengthHeadroom)
+	}
+	lowData := a.lowDataActive()
+	if lowData {
+		req.MaxTokens = min(req.MaxTokens, lowDataMaxTokens)
+		req.unstreamed = true
+	}
+
+	if !req.Reviewed && a.GetPreSendReview() && !isLocalProvider(config) {
+		return generateResult{}, errReviewRequired(provider.GetName())
+	}
+	if req.CleanRoom != "" && !a.cleanRoomAllowed(config) {
+		err := errCleanRoom(provider.GetName())
+		a.auditCleanRoom(cleanRoomBlock, req.CleanRoom, provider.GetName(), err.Error())
+		return generateResult{}, err
+	}
+	if !req.Unredacted {
+		req.Prompt, req.Messages = a.redactRequest(config, req.Prompt, req.Messages)
+	}
+	messages := req.Messages
+	var instructions []string
+	if req.Language != "" {
+		instructions = append(instructions, languageInstruction(req.Language))
+	}
+	if target.Instruction != "" {
+		instructions = append(instructions, target.Instruction)
+	}
+	// Backends without tool calling are told about the tools in the prompt
+	if _, native := provider.(ToolProvider); len(req.Tools) > 0 && !native {
+		instructions = append(instructions, toolInstructions(req.Tools))
+	}
+	if len(instructions) > 0 {
+		if len(messages) == 0 {
+			messages = []Message{{Role: "user", Content: req.Prompt}}
+		}
+		instruction := Message{Role: "system", Content: strings.Join(instructions, " ")}
+		messages = append([]Message{instruction}, messages...)
+	}
+	if len(messages) > 0 {
+		size := contextSize(config)
+		budget := size - responseReserve(size, req.MaxTokens)
+		if lowData {
+			budget = min(budget, lowDataContextBudget)
+		}
+		messages, _ = trimHistory(messages, budget, config.Model)
+		req.Prompt, req.Messages = renderTranscript(messages), messages
+	}
+
+	result, err := a.dispatch(provider, req, temperature)
+	for retry := 0; err == nil && req.Language != "" && retry < maxLanguageRetries; retry++ {
+		if languageMatches(result.Response, req.Language) {
+			break
+		}
+		reask := append(messages[:len(messages):len(messages)],
+			Message{Role: "assistant", Content: result.Response},
+			Message{Role: "user", Content: "Your previous reply was not in the requested language. " + languageInstruction(req.Language) + " Answer again."},
+		)
+		req.Prompt, req.Messages = renderTranscript(reask), reask
+		// Re-asks are not streamed; the caller gets the final reply as the result
+		req.OnChunk = nil
+		result, err = a.dispatch(provider, req, temperature)
+	}
+	if err == nil && target.Tokens > 0 {
+		result.Response, result.Remainder = truncateAtSentence(result.Response, target.Tokens, config.Model)
+	}
+	return result, err
+}
+
+// dispatch sends a fully rendered request to provider and records it
+func (a *App) dispatch(provider Provider, req generateRequest, temperature float64) (generateResult, error) {
+	config := provider.GetConfig()
+	rec := RequestRecord{
+		ID:          newID(),
+		Timestamp:   time.Now(),
+		Provider:    provider.GetName(),
+		ProviderID:  config.ID,
+		Model:       config.Model,
+		Prompt:      req.Prompt,
+		Temperature: temperature,
+		MaxTokens:   req.MaxTokens,
+		ReplayOf:    req.ReplayOf,
+		Private:     req.Private,
+	}
+	if req.Private {
+		rec.Prompt = ""
+	}
+	var span trace.Span
+	req.trace, span = a.tracing.start(req.Context, "chat "+config.Model, trace.SpanKindClient,
+		attribute.String("gen_ai.operation.name", "chat"),
+		attribute.String("gen_ai.system", genAISystem(config)),
+		attribute.String("gen_ai.request.model", config.Model),
+		attribute.Float64("gen_ai.request.temperature", temperature),
+		attribute.Int("gen_ai.request.max_tokens", req.MaxTokens),
+		attribute.String("vibecoder.provider", rec.Provider),
+		attribute.String("vibecoder.request_id", rec.ID),
+	)
+
+	if req.Private {
+		req.trace = withPrivateRequest(req.trace)
+	}
+	var group *dispatchInfo
+	req.trace, group = withDispatch(req.trace, rec.ID)
+
+	// Replays exist to run a request again, so they bypass the cache, and
+	// replies of locked conversations are not kept in it
+	var cacheKey string
+	if req.ReplayOf == "" && !req.Private && len(req.Tools) == 0 && a.responseCacheEnabled() {
+		cacheKey = responseCacheKey(config, req.Prompt, temperature, req.MaxTokens, req.Schema)
+		if response, ok := a.responses.get(cacheKey); ok {
+			rec.Cached = true
+			rec.Response = response
+			a.requests.add(rec)
+			endRequestSpan(span, rec, 0, 0)
+			if req.OnChunk != nil {
+				req.OnChunk(response)
+			}
+			return generateResult{Response: response, Provider: rec.Provider, RequestID: rec.ID}, nil
+		}
+	}
+
+	if err := a.checkBudget(config); err != nil {
+		endSpan(span, err)
+		return generateResult{}, err
+	}
+
+	var response string
+	var err error
+	limiter := a.rateLimiter(config)
+	queue := a.requestQueue(provider)
+	promptTokens := 0
+	if limiter != nil {
+		promptTokens = countTokens(req.Prompt, config.Model).Tokens
+	}
+	// The reply can be as long as MaxTokens; what it does not use is refunded
+	cost := promptTokens + req.MaxTokens
+	limit := maxAttempts(config)
+	watch := a.watchStalls(provider, req, &rec)
+	defer watch.stop()
+	for rec.Attempts = 1; ; rec.Attempts++ {
+		if wait := limiter.reserve(cost); wait > 0 {
+			a.emit(EventRequestQueued, RequestQueuedEvent{RequestID: rec.ID, Provider: rec.Provider, Reason: "rate-limit", DelayMs: wait.Milliseconds()})
+			rec.QueuedMs += wait.Milliseconds()
+			if !sleepContext(req.Context, wait) {
+				limiter.release(cost)
+				err = errCancelled
+				break
+			}
+		}
+		if queue != nil {
+			queuedAt := time.Now()
+			err = queue.acquire(req.Context, func(position int) {
+				a.emit(EventRequestQueued, RequestQueuedEvent{RequestID: rec.ID, Provider: rec.Provider, Reason: "concurrency", Position: position})
+			})
+			rec.QueuedMs += time.Since(queuedAt).Milliseconds()
+			if err != nil {
+				limiter.release(cost)
+				break
+			}
+		}
+		var streamed bool
+		attempt := req
+		ctx, onChunk := watch.attempt(req.Context, req)
+		attempt.OnChunk = onChunk
+		// The slot stays taken until the provider call ends, even if the request is cancelled first
+		response, err = a.sendCancellable(ctx, provider, attempt, temperature, &streamed, queue.done())
+		stallRetry := watch.settle()
+		used := promptTokens
+		if err == nil && limiter != nil {
+			used += countTokens(response, config.Model).Tokens
+		}
+		limiter.settle(cost, used)
+		// A stalled attempt the user chose to retry is sent again right away
+		if stallRetry && (req.Context == nil || req.Context.Err() == nil) {
+			a.emit(EventRequestRetry, RequestRetryEvent{
+				RequestID:   rec.ID,
+				Provider:    rec.Provider,
+				Attempt:     rec.Attempts + 1,
+				MaxAttempts: limit,
+				Reason:      "stalled",
+			})
+			span.AddEvent("retry", trace.WithAttributes(attribute.String("vibecoder.retry_reason", "stalled")))
+			continue
+		}
+		// A reply that already reached the caller cannot be taken back, so it is not retried
+		if err == nil || streamed || rec.Attempts >= limit || !isTransientError(err) {
+			break
+		}
+		delay := retryDelay(rec.Attempts)
+		a.emit(EventRequestRetry, RequestRetryEvent{
+			RequestID:   rec.ID,
+			Provider:    rec.Provider,
+			Attempt:     rec.Attempts + 1,
+			MaxAttempts: limit,
+			DelayMs:     delay.Milliseconds(),
+			Error:       err.Error(),
+		})
+		span.AddEvent("retry", trace.WithAttributes(attribute.String("vibecoder.retry_reason", err.Error())))
+		if !sleepContext(req.Context, delay) {
+			err = errCancelled
+			break
+		}
+	}
+	// Waits for the rate limits of a group's members
+	rec.QueuedMs += group.queuedMs.Load()
+	rec.DurationMs = time.Since(rec.Timestamp).Milliseconds()
+	a.lastActivity.Store(time.Now().UnixNano())
+	tokensIn := promptTokens
+	if limiter == nil {
+		tokensIn = countTokens(req.Prompt, config.Model).Tokens
+	}
+	if err != nil {
+		rec.Error = err.Error()
+		a.requests.add(rec)
+		a.metrics.record(rec, tokensIn, 0, errors.Is(err, errCancelled))
+		endRequestSpan(span, rec, tokensIn, 0)
+		a.telemetry.recordError(err)
+		return generateResult{RequestID: rec.ID}, err
+	}
+	if !req.Private {
+		rec.Response = response
+	}
+	tokensOut := countTokens(response, config.Model).Tokens
+	if price, ok := a.priceFor(config); ok {
+		rec.CostUSD = price.cost(tokensIn, tokensOut)
+	}
+	a.requests.add(rec)
+	a.metrics.record(rec, tokensIn, tokensOut, false)
+	a.noteSpending(rec)
+	endRequestSpan(span, rec, tokensIn, tokensOut)
+	if cacheKey != "" {
+		a.responses.put(CachedResponse{
+			Key:        cacheKey,
+			ProviderID: config.ID,
+			Provider:   rec.Provider,
+			Model:      config.Model,
+			Prompt:     req.Prompt,
+			CreatedAt:  rec.Timestamp,
+		}, response)
+	}
+
+	return generateResult{Response: response, Provider: rec.Provider, RequestID: rec.ID}, nil
+}
+
+// send makes one attempt at a request, streaming when the provider and
+// caller support it. streamed is set once any text has reached the caller.
+func (a *App) send(provider Provider, req generateRequest, temperature float64, streamed *bool) (string, error) {
+	if tp, ok := provider.(tracedProvider); ok && req.trace != nil {
+		provider = tp.withTrace(req.trace)
+	}
+	if sp, ok := provider.(schemaProvider); ok && len(req.Schema) > 0 {
+		provider = sp.withSchema(req.Schema)
+	}
+	if tp, ok := provider.(ToolProvider); ok && len(req.Tools) > 0 {
+		messages := req.Messages
+		if len(messages) == 0 {
+			messages = []Message{{Role: "user", Content: req.Prompt}}
+		}
+		content, calls, err := tp.SendWithTools(messages, req.Tools, temperature, req.MaxTokens)
+		return content + formatToolCalls(calls), err
+	}
+	if sp, ok := provider.(StreamingProvider); ok && req.OnChunk != nil && !req.unstreamed {
+		asm := newChunkAssembler(func(chunk string) {
+			*streamed = true
+			req.OnChunk(chunk)
+		})
+		response, err := sp.StreamRequest(req.Prompt, temperature, req.MaxTokens, asm.write)
+		asm.close()
+		return response, err
+	}
+
+	response, err := provider.SendRequest(req.Prompt, temperature, req.MaxTokens)
+	if err == nil && req.OnChunk != nil {
+		*streamed = true
+		req.OnChunk(response)
+	}
+	return response, err
+}
+
+// renderTranscript flattens a multi-turn conversation into a single prompt
+// for providers that only accept plain text
+func renderTranscript(messages []Message) string {
+	if len(messages) == 1 && messages[0].Role == "user" {
+		return messages[0].Content
+	}
+
+	var b strings.Builder
+	for _, m := range messages {
+		role := m.Role
+		if role != "" {
+			role = strings.ToUpper(role[:1]) + role[1:]
+		}
+		if m.ToolCallID != "" {
+			role += " result for " + m.ToolCallID
+		}
+		fmt.Fprintf(&b, "%s: %s\n\n", role, m.Content)
+	}
+	b.WriteString("Assistant:")
+	return b.String()
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ignoreRule is one pattern of a .gitignore file
+type ignoreRule struct {
+	// base is the slash-separated directory of the .gitignore, "" for the root
+	base    string
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// ignoreMatcher tells which paths under root git ignores, reading the
+// .gitignore of each directory the first time a path in it is matched
+type ignoreMatcher struct {
+	root   string
+	rules  []ignoreRule
+	loaded map[string]bool
+}
+
+// newIgnoreMatcher reads root's .git/info/exclude; .gitignore files are
+// read as they are needed
+func newIgnoreMatcher(root string) *ignoreMatcher {
+	m := &ignoreMatcher{root: root, loaded: make(map[string]bool)}
+	m.rules = append(m.rules, parseIgnoreFile(filepath.Join(root, ".git", "info", "exclude"), "")...)
+	return m
+}
+
+// globPattern translates a gitignore glob to a regular expression
+func globPattern(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; {
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case glob[i:] == "/**":
+			b.WriteString("/.*")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+// parseIgnoreFile reads the rules of a .gitignore in the directory base
+func parseIgnoreFile(file, base string) []ignoreRule {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+	var rules []ignoreRule
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		r := ignoreRule{base: base}
+		if strings.HasPrefix(line, "!") {
+			r.negate, line = true, line[1:]
+		} else if strings.HasPrefix(line, `\`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			r.dirOnly, line = true, strings.TrimRight(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		// A slash anywhere but the end ties the pattern to base
+		prefix := "^(?:.*/)?"
+		if strings.Contains(line, "/") {
+			prefix, line = "^", strings.TrimPrefix(line, "/")
+		}
+		re, err := regexp.Compile(prefix + globPattern(line) + "$")
+		if err != nil {
+			continue
+		}
+		r.re = re
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+// load reads the .gitignore of dir and of the directories above it
+func (m *ignoreMatcher) load(dir string) {
+	if m.loaded[dir] {
+		return
+	}
+	if dir != "" {
+		if parent := path.Dir(dir); parent != "." {
+			m.load(parent)
+		} else {
+			m.load("")
+		}
+	}
+	m.loaded[dir] = true
+	m.rules = append(m.rules, parseIgnoreFile(filepath.Join(m.root, filepath.FromSlash(dir), ".gitignore"), dir)...)
+}
+
+// ignored reports whether git ignores the slash-separated path rel. It
+// doesn't look at the directories above rel, which a walk skips first.
+func (m *ignoreMatcher) ignored(rel string, isDir bool) bool {
+	if rel == ".git" || strings.HasSuffix(rel, "/.git") {
+		return true
+	}
+	dir := path.Dir(rel)
+	if dir == "." {
+		dir = ""
+	}
+	m.load(dir)
+	ignored := false
+	for _, r := range m.rules {
+		if r.dirOnly && !isDir {
+			continue
+		}
+		name := rel
+		if r.base != "" {
+			if !strings.HasPrefix(rel, r.base+"/") {
+				continue
+			}
+			name = rel[len(r.base)+1:]
+		}
+		if r.re.MatchString(name) {
+			ignored = !r.negate
+		}
+	}
+	return ignored
+}
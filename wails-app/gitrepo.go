@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// gitLogDefault and gitLogMax bound how many commits a log returns
+	gitLogDefault = 20
+	gitLogMax     = 200
+)
+
+// GitFileStatus is a changed file in a repository's working tree or index
+type GitFileStatus struct {
+	Path string `json:"path"`
+	// OrigPath is the old path of a renamed or copied file
+	OrigPath string `json:"origPath,omitempty"`
+	// Index and Worktree are git's status letters for the staged and
+	// unstaged change, "." for none, "?" for untracked files
+	Index    string `json:"index"`
+	Worktree string `json:"worktree"`
+	// Conflicted is set for files with merge conflicts
+	Conflicted bool `json:"conflicted,omitempty"`
+}
+
+// GitStatus is the branch and changed files of a repository
+type GitStatus struct {
+	Branch string `json:"branch"`
+	// Detached is set when HEAD is not on a branch; Branch is then the commit
+	Detached bool            `json:"detached,omitempty"`
+	Upstream string          `json:"upstream,omitempty"`
+	Ahead    int             `json:"ahead,omitempty"`
+	Behind   int             `json:"behind,omitempty"`
+	Files    []GitFileStatus `json:"files"`
+}
+
+// GitCommit is one entry of a repository's log
+type GitCommit struct {
+	Hash      string    `json:"hash"`
+	ShortHash string    `json:"shortHash"`
+	Author    string    `json:"author"`
+	Date      time.Time `json:"date"`
+	Subject   string    `json:"subject"`
+}
+
+// gitDir returns the conversation's directory for a git command
+func (a *App) gitDir(sessionID string) (string, error) {
+	dir := a.sessionRoot(sessionID)
+	if dir == "" {
+		return "", fmt.Errorf("no workspace is open")
+	}
+	return dir, nil
+}
+
+// parseGitStatus reads `git status --porcelain=v2 --branch -z`
+func parseGitStatus(out string) GitStatus {
+	st := GitStatus{Files: []GitFileStatus{}}
+	fields := strings.Split(out, "\x00")
+	for i := 0; i < len(fields); i++ {
+		f := fields[i]
+		switch {
+		case strings.HasPrefix(f, "# branch.oid "):
+			if oid := strings.TrimPrefix(f, "# branch.oid "); oid != "(initial)" && len(oid) >= 7 {
+				st.Branch = oid[:7]
+			}
+		case strings.HasPrefix(f, "# branch.head "):
+			if head := strings.TrimPrefix(f, "# branch.head "); head == "(detached)" {
+				st.Detached = true
+			} else {
+				st.Branch = head
+			}
+		case strings.HasPrefix(f, "# branch.upstream "):
+			st.Upstream = strings.TrimPrefix(f, "# branch.upstream ")
+		case strings.HasPrefix(f, "# branch.ab "):
+			var ahead, behind string
+			fmt.Sscan(strings.TrimPrefix(f, "# branch.ab "), &ahead, &behind)
+			st.Ahead, _ = strconv.Atoi(strings.TrimPrefix(ahead, "+"))
+			st.Behind, _ = strconv.Atoi(strings.TrimPrefix(behind, "-"))
+		case strings.HasPrefix(f, "1 "), strings.HasPrefix(f, "u "):
+			parts := strings.SplitN(f, " ", 9)
+			if f[0] == 'u' {
+				parts = strings.SplitN(f, " ", 11)
+			}
+			xy := parts[1]
+			st.Files = append(st.Files, GitFileStatus{Path: parts[len(parts)-1], Index: xy[:1], Worktree: xy[1:], Conflicted: f[0] == 'u'})
+		case strings.HasPrefix(f, "2 "):
+			parts := strings.SplitN(f, " ", 10)
+			xy := parts[1]
+			file := GitFileStatus{Path: parts[9], Index: xy[:1], Worktree: xy[1:]}
+			// The old path follows as a field of its own
+			if i+1 < len(fields) {
+				i++
+				file.OrigPath = fields[i]
+			}
+			st.Files = append(st.Files, file)
+		case strings.HasPrefix(f, "? "):
+			st.Files = append(st.Files, GitFileStatus{Path: f[2:], Index: "?", Worktree: "?"})
+		}
+	}
+	return st
+}
+
+// gitStatus returns the status of the repository dir is in
+func gitStatus(dir string) (GitStatus, error) {
+	out, err := git(dir, "status", "--porcelain=v2", "--branch", "-z")
+	if err != nil {
+		return GitStatus{}, err
+	}
+	return parseGitStatus(out), nil
+}
+
+// gitLog returns the newest limit commits of the repository dir is in
+func gitLog(dir string, limit int) ([]GitCommit, error) {
+	if limit <= 0 {
+		limit = gitLogDefault
+	}
+	limit = min(limit, gitLogMax)
+	out, err := git(dir, "log", "-n", strconv.Itoa(limit), "--format=%H%x1f%h%x1f%an%x1f%aI%x1f%s%x1e")
+	if err != nil {
+		// A repository without commits has no log
+		if strings.Contains(err.Error(), "does not have any commits") {
+			return []GitCommit{}, nil
+		}
+		return nil, err
+	}
+	commits := []GitCommit{}
+	for _, rec := range strings.Split(out, "\x1e") {
+		parts := strings.Split(strings.TrimSpace(rec), "\x1f")
+		if len(parts) != 5 {
+			continue
+		}
+		date, _ := time.Parse(time.RFC3339, parts[3])
+		commits = append(commits, GitCommit{Hash: parts[0], ShortHash: parts[1], Author: parts[2], Date: date, Subject: parts[4]})
+	}
+	return commits, nil
+}
+
+// gitDiffArgs returns the arguments that diff path, or every file when it
+// is empty, against the index or, when staged, the index against HEAD
+func (a *App) gitDiffArgs(sessionID, path string, staged bool) ([]string, error) {
+	args := []string{"diff", "--no-color", "--no-ext-diff"}
+	if staged {
+		args = append(args, "--cached")
+	}
+	if path == "" {
+		return args, nil
+	}
+	// The file must be in the workspace, though it may have been deleted
+	target, err := a.sandboxedPath(sessionID, path)
+	if err != nil {
+		return nil, err
+	}
+	root, err := filepath.EvalSymlinks(a.sessionRoot(sessionID))
+	if err != nil {
+		return nil, err
+	}
+	rel, err := filepath.Rel(root, target)
+	if err != nil {
+		return nil, err
+	}
+	return append(args, "--", filepath.ToSlash(rel)), nil
+}
+
+// GetGitStatus returns the branch and changed files of the repository the
+// active conversation's directory is in
+func (a *App) GetGitStatus() (GitStatus, error) {
+	a.telemetry.recordFeature("git")
+	dir, err := a.gitDir("")
+	if err != nil {
+		return GitStatus{}, err
+	}
+	return gitStatus(dir)
+}
+
+// GetGitBranch returns the current branch, or the short commit hash when
+// HEAD is detached
+func (a *App) GetGitBranch() (string, error) {
+	st, err := a.GetGitStatus()
+	if err != nil {
+		return "", err
+	}
+	return st.Branch, nil
+}
+
+// GetGitLog returns the newest commits, twenty when limit is 0
+func (a *App) GetGitLog(limit int) ([]GitCommit, error) {
+	a.telemetry.recordFeature("git")
+	dir, err := a.gitDir("")
+	if err != nil {
+		return nil, err
+	}
+	return gitLog(dir, limit)
+}
+
+// GetGitDiff returns the unstaged changes to a file as a unified diff, or
+// the staged ones when staged is true. An empty path diffs every file.
+func (a *App) GetGitDiff(path string, staged bool) (string, error) {
+	a.telemetry.recordFeature("git")
+	dir, err := a.gitDir("")
+	if err != nil {
+		return "", err
+	}
+	args, err := a.gitDiffArgs("", path, staged)
+	if err != nil {
+		return "", err
+	}
+	return git(dir, args...)
+}
+
+var gitStatusDefinition = ToolDefinition{
+	Name:        "git_status",
+	Description: "Shows the workspace repository's current branch, how far it is ahead of or behind its upstream, and its staged, unstaged and untracked files.",
+	Parameters:  json.RawMessage(`{"type":"object","properties":{}}`),
+}
+
+var gitLogDefinition = ToolDefinition{
+	Name:        "git_log",
+	Description: "Lists the workspace repository's recent commits, newest first, with hash, date, author and subject.",
+	Parameters:  json.RawMessage(`{"type":"object","properties":{"limit":{"type":"integer","minimum":1,"maximum":200}}}`),
+}
+
+var gitDiffDefinition = ToolDefinition{
+	Name: "git_diff",
+	Description: "Shows uncommitted changes in the workspace repository as a unified diff: of one file when path is given, else of every file. " +
+		"Set staged to see the changes staged for commit instead of the unstaged ones.",
+	Parameters: json.RawMessage(`{"type":"object","properties":{` +
+		`"path":{"type":"string"},` +
+		`"staged":{"type":"boolean"}}}`),
+}
+
+// gitStatusTool runs the git_status tool in the conversation's directory
+func (a *App) gitStatusTool(ctx context.Context, sessionID string, args json.RawMessage) (string, error) {
+	dir, err := a.gitDir(sessionID)
+	if err != nil {
+		return "", err
+	}
+	return git(dir, "status", "--short", "--branch")
+}
+
+// gitLogTool runs the git_log tool in the conversation's directory
+func (a *App) gitLogTool(ctx context.Context, sessionID string, args json.RawMessage) (string, error) {
+	var in struct {
+		Limit int `json:"limit"`
+	}
+	if err := json.Unmarshal(args, &in); err != nil {
+		return "", err
+	}
+	dir, err := a.gitDir(sessionID)
+	if err != nil {
+		return "", err
+	}
+	commits, err := gitLog(dir, in.Limit)
+	if err != nil {
+		return "", err
+	}
+	if len(commits) == 0 {
+		return "The repository has no commits.", nil
+	}
+	var b strings.Builder
+	for _, c := range commits {
+		fmt.Fprintf(&b, "%s %s %s: %s\n", c.ShortHash, c.Date.Format("2006-01-02"), c.Author, c.Subject)
+	}
+	return b.String(), nil
+}
+
+// gitDiffTool runs the git_diff tool in the conversation's directory
+func (a *App) gitDiffTool(ctx context.Context, sessionID string, args json.RawMessage) (string, error) {
+	var in struct {
+		Path   string `json:"path"`
+		Staged bool   `json:"staged"`
+	}
+	if err := json.Unmarshal(args, &in); err != nil {
+		return "", err
+	}
+	dir, err := a.gitDir(sessionID)
+	if err != nil {
+		return "", err
+	}
+	gitArgs, err := a.gitDiffArgs(sessionID, filepath.ToSlash(in.Path), in.Staged)
+	if err != nil {
+		return "", err
+	}
+	out, err := git(dir, gitArgs...)
+	if err == nil && out == "" {
+		return "No changes.", nil
+	}
+	return out, err
+}
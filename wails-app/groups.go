@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Provider group strategies
+const (
+	groupRoundRobin   = "round-robin"
+	groupLeastLatency = "least-latency"
+)
+
+// latencySmoothing weights the newest sample in a member's moving average latency
+const latencySmoothing = 0.3
+
+// GroupProvider spreads requests across equivalent member providers, either
+// in turn or by lowest measured latency. A member that fails is skipped for
+// that request and the next one in order is tried.
+type GroupProvider struct {
+	config ProviderConfig
+	lookup func(id string) (Provider, error)
+	// traceCtx, when set, is the context members send their requests under
+	traceCtx context.Context
+	// limit, when set, waits until a member's rate limits allow a request
+	// and returns the function that settles the reservation with the reply
+	limit func(ctx context.Context, member Provider, prompt string, maxTokens int) (func(response string), error)
+	// balance is shared by the copies withTrace makes
+	balance *groupBalance
+}
+
+// groupBalance is what a group has learned about its members
+type groupBalance struct {
+	mu      sync.Mutex
+	next    int
+	latency map[string]float64
+}
+
+func NewGroupProvider(config ProviderConfig, lookup func(id string) (Provider, error)) *GroupProvider {
+	if config.Strategy == "" {
+		config.Strategy = groupRoundRobin
+	}
+	return &GroupProvider{
+		config:  config,
+		lookup:  lookup,
+		balance: &groupBalance{latency: make(map[string]float64)},
+	}
+}
+
+func (g *GroupProvider) withTrace(ctx context.Context) Provider {
+	traced := *g
+	traced.traceCtx = ctx
+	return &traced
+}
+
+// validateGroupLocked checks that a group's members exist and are not groups.
+// The caller must hold providersMutex.
+func (a *App) validateGroupLocked(config ProviderConfig) error {
+	if len(config.Members) == 0 {
+		return fmt.Errorf("a provider group needs at least one member")
+	}
+	switch config.Strategy {
+	case "", groupRoundRobin, groupLeastLatency:
+	default:
+		return fmt.Errorf("unknown group strategy %q", config.Strategy)
+	}
+	for _, id := range config.Members {
+		i := a.providerIndexLocked(id)
+		if i == -1 {
+			return fmt.Errorf("provider %q not found", id)
+		}
+		if _, nested := a.providers[i].(*GroupProvider); nested {
+			return fmt.Errorf("provider groups cannot contain other groups")
+		}
+	}
+	return nil
+}
+
+func (g *GroupProvider) GetName() string {
+	if g.config.Name != "" {
+		return g.config.Name
+	}
+	return "Group"
+}
+
+func (g *GroupProvider) GetConfig() ProviderConfig {
+	return g.config
+}
+
+// order returns the members to try for one request, best first. Groups are
+// not allowed as members, which rules out cycles.
+func (g *GroupProvider) order() []Provider {
+	var members []Provider
+	for _, id := range g.config.Members {
+		p, err := g.lookup(id)
+		if err != nil {
+			continue
+		}
+		if _, nested := p.(*GroupProvider); nested {
+			continue
+		}
+		members = append(members, p)
+	}
+	if len(members) == 0 {
+		return nil
+	}
+
+	b := g.balance
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch g.config.Strategy {
+	case groupLeastLatency:
+		// Unmeasured members sort first so every member gets sampled
+		sort.SliceStable(members, func(i, j int) bool {
+			return b.latency[members[i].GetConfig().ID] < b.latency[members[j].GetConfig().ID]
+		})
+	default:
+		start := b.next % len(members)
+		b.next++
+		members = append(members[start:], members[:start]...)
+	}
+	return members
+}
+
+func (g *GroupProvider) observe(id string, d time.Duration) {
+	b := g.balance
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ms := float64(d.Milliseconds())
+	if prev, ok := b.latency[id]; ok {
+		ms = prev + latencySmoothing*(ms-prev)
+	}
+	b.latency[id] = ms
+}
+
+// try runs fn against each member in order until one succeeds, within the
+// member's rate limits
+func (g *GroupProvider) try(prompt string, maxTokens int, fn func(p Provider) (string, error)) (string, error) {
+	members := g.order()
+	if len(members) == 0 {
+		return "", fmt.Errorf("provider group %s has no available members", g.GetName())
+	}
+
+	var errs []string
+	for _, p := range members {
+		if tp, ok := p.(tracedProvider); ok && g.traceCtx != nil {
+			p = tp.withTrace(g.traceCtx)
+		}
+		settle := func(string) {}
+		if g.limit != nil {
+			var err error
+			if settle, err = g.limit(g.traceCtx, p, prompt, maxTokens); err != nil {
+				return "", err
+			}
+		}
+		start := time.Now()
+		response, err := fn(p)
+		settle(response)
+		if err == nil {
+			g.observe(p.GetConfig().ID, time.Since(start))
+			return response, nil
+		}
+		// A failure counts as very slow so least-latency moves away from it
+		g.observe(p.GetConfig().ID, time.Minute)
+		errs = append(errs, fmt.Sprintf("%s: %v", p.GetName(), err))
+	}
+	return "", fmt.Errorf("all members of %s failed: %s", g.GetName(), strings.Join(errs, "; "))
+}
+
+func (g *GroupProvider) SendRequest(prompt string, temperature float64, maxTokens int) (string, error) {
+	return g.try(prompt, maxTokens, func(p Provider) (string, error) {
+		return p.SendRequest(prompt, temperature, maxTokens)
+	})
+}
+
+// StreamRequest streams from the chosen member when it supports streaming
+func (g *GroupProvider) StreamRequest(prompt string, temperature float64, maxTokens int, onChunk func(string)) (string, error) {
+	return g.try(prompt, maxTokens, func(p Provider) (string, error) {
+		if sp, ok := p.(StreamingProvider); ok {
+			return sp.StreamRequest(prompt, temperature, maxTokens, onChunk)
+		}
+		response, err := p.SendRequest(prompt, temperature, maxTokens)
+		if err == nil {
+			onChunk(response)
+		}
+		return response, err
+	})
+}
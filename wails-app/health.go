@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// healthCheckTimeout bounds how long TestProvider waits for a provider
+const healthCheckTimeout = 10 * time.Second
+
+// Reasons a provider check fails, for the frontend to point at the right field
+const (
+	testReasonConfig          = "config"
+	testReasonBadKey          = "bad_key"
+	testReasonUnreachable     = "unreachable"
+	testReasonTimeout         = "timeout"
+	testReasonBadEndpoint     = "bad_endpoint"
+	testReasonUnknownModel    = "unknown_model"
+	testReasonServerError     = "server_error"
+	testReasonInvalidResponse = "invalid_response"
+)
+
+// ProviderTest is the outcome of a provider connectivity check
+type ProviderTest struct {
+	OK        bool   `json:"ok"`
+	LatencyMs int64  `json:"latencyMs"`
+	Detail    string `json:"detail,omitempty"`
+	Error     string `json:"error,omitempty"`
+	// Reason classifies a failure: config, bad_key, unreachable, timeout,
+	// bad_endpoint, unknown_model, server_error or invalid_response
+	Reason string `json:"reason,omitempty"`
+}
+
+// HealthChecker is implemented by providers with a cheaper check than a completion
+type HealthChecker interface {
+	// HealthCheck verifies the endpoint (and key) and returns a short description of the server
+	HealthCheck() (string, error)
+}
+
+// HealthCheck queries the Ollama /api/version endpoint
+func (p *OllamaProvider) HealthCheck() (string, error) {
+	client := &http.Client{Transport: p.client.Transport, Timeout: healthCheckTimeout}
+	resp, err := client.Get(fmt.Sprintf("%s/api/version", p.config.Endpoint))
+	if err != nil {
+		return "", fmt.Errorf("network error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(body))
+	}
+
+	var result struct {
+		Version string `json:"version"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("invalid response: %v", err)
+	}
+	return "Ollama " + result.Version, nil
+}
+
+// HealthCheck lists models, which validates both the endpoint and the API key
+func (p *OpenAIProvider) HealthCheck() (string, error) {
+	checked := *p
+	checked.client = &http.Client{Transport: p.client.Transport, Timeout: healthCheckTimeout}
+	models, err := checked.ListModels()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d models available", len(models)), nil
+}
+
+// TestProvider checks that a provider configuration can reach its backend
+// before it is saved. Providers without a dedicated health check are sent a
+// one-token completion. Providers that list their models must also offer
+// the configured model.
+func (a *App) TestProvider(config ProviderConfig) ProviderTest {
+	a.telemetry.recordFeature("test_provider")
+	return a.testProvider(config)
+}
+
+func (a *App) testProvider(config ProviderConfig) ProviderTest {
+	p, err := a.newProvider(config)
+	if err != nil {
+		return ProviderTest{Error: err.Error(), Reason: testReasonConfig}
+	}
+	if c, ok := p.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	start := time.Now()
+	var detail string
+	if hc, ok := p.(HealthChecker); ok {
+		detail, err = hc.HealthCheck()
+	} else {
+		_, err = p.SendRequest("ping", 0, 1)
+		detail = "Completion succeeded"
+	}
+
+	result := ProviderTest{LatencyMs: time.Since(start).Milliseconds()}
+	if err != nil {
+		result.Error = describeProviderError(err, config)
+		result.Reason = classifyProviderError(err)
+		return result
+	}
+	if lister, ok := p.(ModelLister); ok && config.Model != "" {
+		if models, err := lister.ListModels(); err == nil && !hasModel(models, config.Model) {
+			result.Error = fmt.Sprintf("%s does not offer the model %q. Check the model name or pull it first.", config.Endpoint, config.Model)
+			result.Reason = testReasonUnknownModel
+			return result
+		}
+	}
+	result.OK = true
+	result.Detail = detail
+	return result
+}
+
+// ProviderValidation is the result of AddValidatedProvider
+type ProviderValidation struct {
+	// Provider is the added provider, or nil when the check failed and
+	// nothing was added
+	Provider *ProviderInfo `json:"provider,omitempty"`
+	Test     ProviderTest  `json:"test"`
+}
+
+// AddValidatedProvider checks a provider like TestProvider and adds it only
+// when the check passes, so a mistyped key, host or model is caught before
+// the first prompt. The test's reason says which one was wrong.
+func (a *App) AddValidatedProvider(config ProviderConfig) (ProviderValidation, error) {
+	a.telemetry.recordFeature("add_validated_provider")
+	result := ProviderValidation{Test: a.testProvider(config)}
+	if !result.Test.OK {
+		return result, nil
+	}
+	info, err := a.AddProvider(config)
+	if err != nil {
+		return ProviderValidation{}, err
+	}
+	result.Provider = &info
+	return result, nil
+}
+
+// hasModel reports whether model is among models; an Ollama name without a
+// tag matches its :latest
+func hasModel(models []ModelInfo, model string) bool {
+	for _, m := range models {
+		if m.Name == model || m.Name == model+":latest" || strings.TrimSuffix(m.Name, ":latest") == model {
+			return true
+		}
+	}
+	return false
+}
+
+// classifyProviderError returns the ProviderTest reason for a failed check
+func classifyProviderError(err error) string {
+	var netErr net.Error
+	msg := err.Error()
+	switch {
+	case errors.As(err, &netErr) && netErr.Timeout(), strings.Contains(msg, "Client.Timeout"), strings.Contains(msg, "no response from server"):
+		return testReasonTimeout
+	case strings.Contains(msg, "unsupported protocol scheme"), strings.HasPrefix(msg, "HTTP 404"):
+		return testReasonBadEndpoint
+	case strings.Contains(msg, "connection refused"), strings.Contains(msg, "no such host"), strings.Contains(msg, "network error"):
+		return testReasonUnreachable
+	case strings.HasPrefix(msg, "HTTP 401"), strings.HasPrefix(msg, "HTTP 403"):
+		return testReasonBadKey
+	case strings.HasPrefix(msg, "HTTP 5"):
+		return testReasonServerError
+	case strings.HasPrefix(msg, "invalid response"):
+		return testReasonInvalidResponse
+	default:
+		return ""
+	}
+}
+
+// describeProviderError turns a provider error into advice a user can act on
+func describeProviderError(err error, config ProviderConfig) string {
+	var netErr net.Error
+	msg := err.Error()
+	switch {
+	case errors.As(err, &netErr) && netErr.Timeout(), strings.Contains(msg, "Client.Timeout"):
+		return fmt.Sprintf("Timed out waiting for %s. The server may be overloaded or unreachable.", config.Endpoint)
+	case strings.Contains(msg, "connection refused"):
+		return fmt.Sprintf("Could not connect to %s. Is the server running?", config.Endpoint)
+	case strings.Contains(msg, "no such host"):
+		return fmt.Sprintf("Could not resolve the host in %s. Check the endpoint URL.", config.Endpoint)
+	case strings.Contains(msg, "unsupported protocol scheme"):
+		return "The endpoint must start with http:// or https://."
+	case strings.HasPrefix(msg, "HTTP 401"), strings.HasPrefix(msg, "HTTP 403"):
+		return "The server rejected the API key."
+	case strings.HasPrefix(msg, "HTTP 404"):
+		return fmt.Sprintf("%s does not look like a %s endpoint (HTTP 404). Check the URL.", config.Endpoint, config.Type)
+	case strings.HasPrefix(msg, "HTTP 5"):
+		return "The server reported an internal error: " + msg
+	case strings.HasPrefix(msg, "invalid response"):
+		return "The server answered, but not in the expected format: " + msg
+	default:
+		return msg
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultConnectTimeout = 10 * time.Second
+	// defaultReadTimeout is generous because local models can take minutes to load
+	defaultReadTimeout = 5 * time.Minute
+)
+
+// providerTimeouts returns the connect and read timeouts configured for a provider
+func providerTimeouts(config ProviderConfig) (connect, read time.Duration) {
+	connect, read = defaultConnectTimeout, defaultReadTimeout
+	if config.ConnectTimeoutSeconds > 0 {
+		connect = time.Duration(config.ConnectTimeoutSeconds) * time.Second
+	}
+	if config.ReadTimeoutSeconds > 0 {
+		read = time.Duration(config.ReadTimeoutSeconds) * time.Second
+	}
+	return connect, read
+}
+
+// parseProxyURL checks a provider's proxy setting and returns the proxy to
+// use, or nil for the environment's (which is also an empty setting) and
+// for "direct". socks5h is accepted as an alias of socks5, which already
+// resolves names on the proxy.
+func parseProxyURL(raw string) (*url.URL, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" || raw == "direct" {
+		return nil, nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy URL %q; use e.g. http://proxy:8080 or socks5://127.0.0.1:9050", redactEndpoint(raw))
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	case "socks5h":
+		u.Scheme = "socks5"
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q; use http, https or socks5", u.Scheme)
+	}
+	return u, nil
+}
+
+// newHTTPClient builds the client a provider talks to its backend with. The
+// connect timeout covers dialing and the TLS handshake; the read timeout is
+// how long the server may go without sending anything, so long streamed
+// replies are not cut off as long as they keep arriving.
+func newHTTPClient(config ProviderConfig) *http.Client {
+	connect, read := providerTimeouts(config)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if strings.TrimSpace(config.ProxyURL) == "direct" {
+		transport.Proxy = nil
+	} else if proxy, err := parseProxyURL(config.ProxyURL); err == nil && proxy != nil {
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	tlsConfig, err := tlsClientConfig(config.TLS)
+	if err != nil {
+		return &http.Client{Transport: failingTransport{err: err}}
+	}
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
+	transport.Proxy = localOnlyProxy(transport.Proxy)
+	transport.DialContext = localOnlyDialer(&net.Dialer{Timeout: connect, KeepAlive: 30 * time.Second})
+	transport.TLSHandshakeTimeout = connect
+	var rt http.RoundTripper = &readTimeoutTransport{base: transport, timeout: read}
+	if len(config.Headers) > 0 {
+		rt = &headerTransport{base: rt, headers: config.Headers}
+	}
+	return &http.Client{Transport: rt}
+}
+
+// reservedHeaders are set by the client and the provider and can't be overridden
+var reservedHeaders = map[string]bool{
+	"Host":              true,
+	"Content-Type":      true,
+	"Content-Length":    true,
+	"Transfer-Encoding": true,
+	"Connection":        true,
+}
+
+// validateHeaders checks a provider's extra request headers
+func validateHeaders(headers map[string]string) error {
+	for name, value := range headers {
+		if name == "" || strings.ContainsAny(name, " \t\r\n:") {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+		if reservedHeaders[http.CanonicalHeaderKey(name)] {
+			return fmt.Errorf("header %s is set by the app and can't be overridden", http.CanonicalHeaderKey(name))
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("header %s has a line break in its value", name)
+		}
+	}
+	return nil
+}
+
+// headerTransport adds a provider's extra headers to every request, replacing
+// any the provider set itself (so Authorization can point at a gateway)
+type headerTransport struct {
+	base    http.RoundTripper
+	headers map[string]string
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for name, value := range t.headers {
+		req.Header.Set(name, value)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// readTimeoutTransport cancels a request when the server stops sending data
+// for longer than timeout, whether before the headers or mid-body
+type readTimeoutTransport struct {
+	base    http.RoundTripper
+	timeout time.Duration
+}
+
+func (t *readTimeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	var expired atomic.Bool
+	timer := time.AfterFunc(t.timeout, func() {
+		expired.Store(true)
+		cancel()
+	})
+
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		timer.Stop()
+		cancel()
+		if expired.Load() {
+			return nil, t.timeoutError()
+		}
+		return nil, err
+	}
+	timer.Reset(t.timeout)
+	resp.Body = &idleTimeoutBody{ReadCloser: resp.Body, transport: t, timer: timer, cancel: cancel, expired: &expired}
+	return resp, nil
+}
+
+func (t *readTimeoutTransport) timeoutError() error {
+	return fmt.Errorf("no response from server for %v", t.timeout)
+}
+
+// idleTimeoutBody restarts the read timeout each time data arrives
+type idleTimeoutBody struct {
+	io.ReadCloser
+	transport *readTimeoutTransport
+	timer     *time.Timer
+	cancel    context.CancelFunc
+	expired   *atomic.Bool
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF && b.expired.Load() {
+		return n, b.transport.timeoutError()
+	}
+	if n > 0 {
+		b.timer.Reset(b.transport.timeout)
+	}
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	b.timer.Stop()
+	b.cancel()
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxHTTPLogEntries = 500
+	// maxLoggedBody is how much of each request and response body is kept
+	maxLoggedBody = 4096
+	// maxHTTPLogFile is the size at which the log file is rotated to a .1 backup
+	maxHTTPLogFile = 10 << 20
+)
+
+// HTTPLogEntry is one request a provider sent to its backend
+type HTTPLogEntry struct {
+	ID         string    `json:"id"`
+	Timestamp  time.Time `json:"timestamp"`
+	ProviderID string    `json:"providerId,omitempty"`
+	Provider   string    `json:"provider"`
+	Model      string    `json:"model,omitempty"`
+	Method     string    `json:"method"`
+	URL        string    `json:"url"`
+	Status     int       `json:"status,omitempty"`
+	DurationMs int64     `json:"durationMs"`
+	// PromptTokens and CompletionTokens are the counts the backend reported, if any
+	PromptTokens     int    `json:"promptTokens,omitempty"`
+	CompletionTokens int    `json:"completionTokens,omitempty"`
+	RequestBytes     int64  `json:"requestBytes"`
+	ResponseBytes    int64  `json:"responseBytes"`
+	RequestBody      string `json:"requestBody,omitempty"`
+	ResponseBody     string `json:"responseBody,omitempty"`
+	// Truncated marks entries whose bodies were cut to maxLoggedBody
+	Truncated bool `json:"truncated,omitempty"`
+	// Private marks requests of locked conversations, logged without their bodies
+	Private bool   `json:"private,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// privateRequestKey marks the context of requests whose bodies are not logged
+type privateRequestKey struct{}
+
+// withPrivateRequest marks HTTP requests made with ctx as private
+func withPrivateRequest(ctx context.Context) context.Context {
+	return context.WithValue(requestContext(ctx), privateRequestKey{}, true)
+}
+
+func privateRequest(ctx context.Context) bool {
+	private, _ := ctx.Value(privateRequestKey{}).(bool)
+	return private
+}
+
+// httpLog keeps recent provider HTTP traffic in memory and appends every
+// entry to a JSON-lines file when a path is configured
+type httpLog struct {
+	mu      sync.RWMutex
+	path    string
+	entries []HTTPLogEntry
+}
+
+func newHTTPLog() *httpLog {
+	return &httpLog{}
+}
+
+// open appends future entries to path; earlier traffic is left in the file
+// but not loaded, since the log is for debugging the current run
+func (l *httpLog) open(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.path = path
+	return nil
+}
+
+func (l *httpLog) add(e HTTPLogEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries = append(l.entries, e)
+	if len(l.entries) > maxHTTPLogEntries {
+		l.entries = append([]HTTPLogEntry(nil), l.entries[len(l.entries)-maxHTTPLogEntries:]...)
+	}
+	if err := l.appendFileLocked(e); err != nil {
+		println("Error writing HTTP log:", err.Error())
+	}
+}
+
+func (l *httpLog) appendFileLocked(e HTTPLogEntry) error {
+	if l.path == "" {
+		return nil
+	}
+	if info, err := os.Stat(l.path); err == nil && info.Size() > maxHTTPLogFile {
+		if err := os.Rename(l.path, l.path+".1"); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// recent returns up to limit entries, newest first
+func (l *httpLog) recent(limit int) []HTTPLogEntry {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if limit <= 0 || limit > len(l.entries) {
+		limit = len(l.entries)
+	}
+	out := make([]HTTPLogEntry, 0, limit)
+	for i := len(l.entries) - 1; i >= 0 && len(out) < limit; i-- {
+		out = append(out, l.entries[i])
+	}
+	return out
+}
+
+func (l *httpLog) get(id string) (HTTPLogEntry, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	for i := len(l.entries) - 1; i >= 0; i-- {
+		if l.entries[i].ID == id {
+			return l.entries[i], true
+		}
+	}
+	return HTTPLogEntry{}, false
+}
+
+func (l *httpLog) clear() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries = nil
+	if l.path == "" {
+		return nil
+	}
+	for _, p := range []string{l.path, l.path + ".1"} {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// wrap returns a transport that logs every request sent through base
+func (l *httpLog) wrap(config ProviderConfig, name string, base http.RoundTripper) http.RoundTripper {
+	return &loggingTransport{base: base, log: l, config: config, name: name}
+}
+
+// loggingTransport records each request and its response in an httpLog.
+// The entry is added once the response body has been read or closed, so
+// streamed replies are logged with their full duration and size.
+type loggingTransport struct {
+	base   http.RoundTripper
+	log    *httpLog
+	config ProviderConfig
+	name   string
+}
+
+func (t *loggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	e := HTTPLogEntry{
+		ID:         newID(),
+		Timestamp:  time.Now(),
+		ProviderID: t.config.ID,
+		Provider:   t.name,
+		Model:      t.config.Model,
+		Method:     req.Method,
+		URL:        redactEndpoint(req.URL.String()),
+		Private:    privateRequest(req.Context()),
+	}
+	if req.Body != nil {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		e.RequestBytes = int64(len(body))
+		if !e.Private {
+			e.RequestBody, e.Truncated = truncateBody(body)
+		}
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		e.DurationMs = time.Since(e.Timestamp).Milliseconds()
+		e.Error = err.Error()
+		t.log.add(e)
+		return nil, err
+	}
+	e.Status = resp.StatusCode
+	resp.Body = &loggedBody{ReadCloser: resp.Body, log: t.log, entry: e}
+	return resp, nil
+}
+
+func truncateBody(b []byte) (string, bool) {
+	if len(b) <= maxLoggedBody {
+		return string(b), false
+	}
+	return strings.ToValidUTF8(string(b[:maxLoggedBody]), "") + "…", true
+}
+
+// loggedBody keeps the start of a response body for the log, and its end,
+// where streamed replies report their token counts
+type loggedBody struct {
+	io.ReadCloser
+	log   *httpLog
+	entry HTTPLogEntry
+	head  []byte
+	tail  []byte
+	size  int64
+	once  sync.Once
+}
+
+func (b *loggedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.size += int64(n)
+		if room := maxLoggedBody - len(b.head); room > 0 {
+			b.head = append(b.head, p[:min(n, room)]...)
+		}
+		b.tail = append(b.tail, p[:n]...)
+		if len(b.tail) > maxLoggedBody {
+			b.tail = b.tail[len(b.tail)-maxLoggedBody:]
+		}
+	}
+	if err == io.EOF {
+		b.finish(nil)
+	} else if err != nil {
+		b.finish(err)
+	}
+	return n, err
+}
+
+func (b *loggedBody) Close() error {
+	b.finish(nil)
+	return b.ReadCloser.Close()
+}
+
+var (
+	promptTokensPattern     = regexp.MustCompile(`"(?:prompt_tokens|prompt_eval_count)"\s*:\s*(\d+)`)
+	completionTokensPattern = regexp.MustCompile(`"(?:completion_tokens|eval_count)"\s*:\s*(\d+)`)
+)
+
+// lastCount returns the last number pattern captures in b, or 0
+func lastCount(pattern *regexp.Regexp, b []byte) int {
+	matches := pattern.FindAllSubmatch(b, -1)
+	if len(matches) == 0 {
+		return 0
+	}
+	n, _ := strconv.Atoi(string(matches[len(matches)-1][1]))
+	return n
+}
+
+func (b *loggedBody) finish(err error) {
+	b.once.Do(func() {
+		e := b.entry
+		e.DurationMs = time.Since(e.Timestamp).Milliseconds()
+		e.ResponseBytes = b.size
+		if !e.Private {
+			e.ResponseBody = string(b.head)
+		}
+		if !e.Private && b.size > int64(len(b.head)) {
+			e.ResponseBody = strings.ToValidUTF8(e.ResponseBody, "") + "…"
+			e.Truncated = true
+		}
+		e.PromptTokens = lastCount(promptTokensPattern, b.tail)
+		e.CompletionTokens = lastCount(completionTokensPattern, b.tail)
+		if err != nil {
+			e.Error = err.Error()
+		}
+		b.log.add(e)
+	})
+}
+
+// GetRequestLog returns up to limit recent HTTP requests sent to providers,
+// newest first (limit <= 0 returns all), with bodies cut to 4 KB
+func (a *App) GetRequestLog(limit int) []HTTPLogEntry {
+	return a.httpLog.recent(limit)
+}
+
+// GetRequestLogEntry returns one logged HTTP request by ID
+func (a *App) GetRequestLogEntry(id string) (HTTPLogEntry, error) {
+	e, ok := a.httpLog.get(id)
+	if !ok {
+		return HTTPLogEntry{}, fmt.Errorf("log entry %q not found", id)
+	}
+	return e, nil
+}
+
+// ClearRequestLog deletes the logged HTTP traffic from memory and disk
+func (a *App) ClearRequestLog() error {
+	return a.httpLog.clear()
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DiffStats counts replies in a workspace that suggested a diff and the
+// diffs applied there with ApplyDiff
+type DiffStats struct {
+	Proposed       int     `json:"proposed"`
+	Applied        int     `json:"applied"`
+	AcceptanceRate float64 `json:"acceptanceRate"`
+}
+
+// Insights is a personal usage report computed over conversation history
+type Insights struct {
+	Since         time.Time    `json:"since"`
+	TotalPrompts  int          `json:"totalPrompts"`
+	PromptsPerDay []DayCount   `json:"promptsPerDay"`
+	TopModels     []ModelUsage `json:"topModels"`
+	// TopProjects are the workspaces prompts were sent in, most prompts first
+	TopProjects      []ProjectUsage `json:"topProjects"`
+	AverageLatencyMs float64        `json:"averageLatencyMs"`
+	Diffs            DiffStats      `json:"diffs"`
+	// Report is a markdown rendering of the figures above
+	Report string `json:"report"`
+}
+
+// diffPattern matches fenced diff blocks and unified diff hunk headers
+var diffPattern = regexp.MustCompile("(?m)^```(diff|patch)\\b|^@@ -\\d+(,\\d+)? \\+\\d+(,\\d+)? @@")
+
+// GetInsights computes usage statistics over the last days days of history
+// (all history when days <= 0), from the same figures as GetUsageDashboard
+func (a *App) GetInsights(days int) Insights {
+	a.telemetry.recordFeature("insights")
+
+	u := a.aggregateUsage(days)
+	ins := Insights{
+		Since:            u.since,
+		TotalPrompts:     u.prompts,
+		PromptsPerDay:    u.activeDays(),
+		TopModels:        u.models,
+		AverageLatencyMs: u.averageLatencyMs(),
+		TopProjects:      a.activity.projects(u.sinceDate),
+		Diffs:            a.activity.diffs(u.sinceDate),
+	}
+
+	ins.Report = renderInsights(ins, a.reportFormatter())
+	return ins
+}
+
+func renderInsights(ins Insights, f reportFormatter) string {
+	var b strings.Builder
+	b.WriteString("# AI Usage Report\n\n")
+	if ins.Since.IsZero() {
+		b.WriteString("All history\n\n")
+	} else {
+		fmt.Fprintf(&b, "Since %s\n\n", f.Date(ins.Since))
+	}
+
+	fmt.Fprintf(&b, "- Prompts: %s\n", f.Int(int64(ins.TotalPrompts)))
+	if n := len(ins.PromptsPerDay); n > 0 {
+		fmt.Fprintf(&b, "- Active days: %s (%s prompts/day)\n", f.Int(int64(n)), f.Decimal(float64(ins.TotalPrompts)/float64(n), 1))
+	}
+	fmt.Fprintf(&b, "- Average response latency: %s ms\n", f.Decimal(ins.AverageLatencyMs, 0))
+	fmt.Fprintf(&b, "- Diffs proposed: %s, applied: %s\n", f.Int(int64(ins.Diffs.Proposed)), f.Int(int64(ins.Diffs.Applied)))
+
+	if len(ins.TopModels) > 0 {
+		b.WriteString("\n## Most used models\n\n| Provider | Model | Requests | Errors | Avg latency |\n|---|---|---|---|---|\n")
+		for _, u := range ins.TopModels {
+			fmt.Fprintf(&b, "| %s | %s | %s | %s | %s ms |\n", u.Provider, u.Model, f.Int(u.Requests), f.Int(u.Errors), f.Decimal(u.AverageLatencyMs, 0))
+		}
+	}
+	if len(ins.TopProjects) > 0 {
+		b.WriteString("\n## Top projects\n\n| Workspace | Prompts | Changes applied |\n|---|---|---|\n")
+		for _, p := range ins.TopProjects {
+			fmt.Fprintf(&b, "| %s | %s | %s |\n", p.Workspace, f.Int(int64(p.Prompts)), f.Int(int64(p.Applied)))
+		}
+	}
+	return b.String()
+}
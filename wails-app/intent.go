@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+)
+
+// Prompt intents
+const (
+	intentQuestion = "question"
+	intentCodeGen  = "code-gen"
+	intentRefactor = "refactor"
+	intentChitChat = "chit-chat"
+)
+
+// Routing tiers
+const (
+	tierFast = "fast"
+	tierFull = "full"
+)
+
+// PromptIntent is the classifier's label for a prompt
+type PromptIntent struct {
+	Label      string             `json:"label"`
+	Confidence float64            `json:"confidence"`
+	Scores     map[string]float64 `json:"scores"`
+	// Tier is "fast" for prompts a small model answers well, otherwise "full"
+	Tier string `json:"tier"`
+	// Hints name UI affordances worth offering for the reply
+	Hints []string `json:"hints,omitempty"`
+}
+
+// intentCue is a phrase that votes for an intent with some weight
+type intentCue struct {
+	pattern *regexp.Regexp
+	intent  string
+	weight  float64
+}
+
+func cue(intent string, weight float64, pattern string) intentCue {
+	return intentCue{regexp.MustCompile(`(?i)` + pattern), intent, weight}
+}
+
+var intentCues = []intentCue{
+	cue(intentRefactor, 3, `\b(refactor|restructure|clean(?:\s|-)?up|simplify|tidy|deduplicate|extract (?:a |this )?(?:method|function|class|component))\b`),
+	cue(intentRefactor, 2, `\b(fix|rename|rewrite|convert|migrate|port|modernize|optimi[sz]e|speed up|make (?:this|it) (?:faster|cleaner|more readable|idiomatic))\b`),
+	cue(intentRefactor, 1, `\b(improve|split (?:this|it) into|reduce duplication|without changing behavio)`),
+	cue(intentCodeGen, 3, `\b(write|implement|generate|scaffold|create|build)\b.{0,40}\b(function|method|class|script|program|component|endpoint|test|tests|cli|api|module|app|query|regex|struct|handler)\b`),
+	cue(intentCodeGen, 2, `^\s*(write|implement|generate|scaffold|create|build|add|make)\b`),
+	cue(intentCodeGen, 1, `\b(code for|snippet|boilerplate|example code|in (?:go|golang|python|rust|typescript|javascript|java|c\+\+|sql|bash))\b`),
+	cue(intentQuestion, 3, `^\s*(what|why|how|when|where|which|who|is|are|does|do|can|could|should|would|explain|describe)\b`),
+	cue(intentQuestion, 2, `\?\s*$`),
+	cue(intentQuestion, 1, `\b(difference between|what does|meaning of|explain|pros and cons|when should i|vs\.?)\b`),
+	cue(intentChitChat, 3, `^\s*(hi|hey|hello|yo|thanks|thank you|thx|cheers|good (?:morning|afternoon|evening|night)|bye|ok(?:ay)?|cool|nice|great|lol)\b[\s!.,:)]*$`),
+	cue(intentChitChat, 2, `\b(how are you|who are you|what's up|tell me a joke|you're (?:great|awesome))\b`),
+}
+
+// codeLinePattern matches lines that look like source code
+var codeLinePattern = regexp.MustCompile(`(?m)^\s*(func |def |class |import |package |const |let |var |public |private |#include|return\b|if \(|for \(|\}|\{$)`)
+
+// classifyPrompt labels a prompt from keyword cues and the shape of the text.
+// It is a handful of regular expressions, so it runs in well under a
+// millisecond and can be called on every keystroke.
+func classifyPrompt(prompt string) PromptIntent {
+	scores := map[string]float64{
+		intentQuestion: 0.5,
+		intentCodeGen:  0,
+		intentRefactor: 0,
+		intentChitChat: 0,
+	}
+	text := strings.TrimSpace(prompt)
+	for _, c := range intentCues {
+		if c.pattern.MatchString(text) {
+			scores[c.intent] += c.weight
+		}
+	}
+
+	// Pasted code with an instruction is usually about changing that code
+	hasCode := strings.Contains(text, "```") || len(codeLinePattern.FindAllString(text, 3)) >= 2
+	if hasCode {
+		scores[intentRefactor] += 2
+		scores[intentChitChat] = 0
+	}
+	if words := len(strings.Fields(text)); words <= 4 && !hasCode && scores[intentCodeGen] == 0 && scores[intentRefactor] == 0 {
+		scores[intentChitChat] += 1
+	}
+
+	// Softmax turns the votes into a distribution that sums to one
+	total := 0.0
+	for intent, s := range scores {
+		scores[intent] = math.Exp(s)
+		total += scores[intent]
+	}
+	label := intentQuestion
+	for intent := range scores {
+		scores[intent] = math.Round(scores[intent]/total*1000) / 1000
+	}
+	for intent, s := range scores {
+		if s > scores[label] || s == scores[label] && intent < label {
+			label = intent
+		}
+	}
+
+	intent := PromptIntent{Label: label, Confidence: scores[label], Scores: scores, Tier: tierFull}
+	switch label {
+	case intentChitChat:
+		intent.Tier = tierFast
+	case intentQuestion:
+		if !hasCode && len(text) < 200 {
+			intent.Tier = tierFast
+		}
+	case intentCodeGen:
+		intent.Hints = []string{"copy-code", "write-artifact"}
+	case intentRefactor:
+		intent.Hints = []string{"copy-code"}
+	}
+	return intent
+}
+
+// ClassifyPrompt labels a prompt as a question, code generation, refactor,
+// or chit-chat locally, without calling a model, so the UI can adapt as the
+// user types
+func (a *App) ClassifyPrompt(prompt string) PromptIntent {
+	return classifyPrompt(prompt)
+}
+
+// SetFastTierProvider picks the provider that prompts classified as "fast"
+// tier are routed to when no provider is requested explicitly; an empty id
+// turns routing off
+func (a *App) SetFastTierProvider(id string) error {
+	a.providersMutex.Lock()
+	defer a.providersMutex.Unlock()
+
+	if id != "" && a.providerIndexLocked(id) == -1 {
+		return fmt.Errorf("provider %q not found", id)
+	}
+	a.fastProvider = id
+	return a.saveConfigLocked()
+}
+
+// GetFastTierProvider returns the fast tier provider ID, or "" if routing is off
+func (a *App) GetFastTierProvider() string {
+	a.providersMutex.RLock()
+	defer a.providersMutex.RUnlock()
+	return a.fastProvider
+}
+
+// tierProvider returns the provider for a routing tier, or nil to keep the default
+func (a *App) tierProvider(tier string) Provider {
+	if tier != tierFast {
+		return nil
+	}
+	a.providersMutex.RLock()
+	defer a.providersMutex.RUnlock()
+
+	if i := a.providerIndexLocked(a.fastProvider); a.fastProvider != "" && i != -1 {
+		return a.providers[i]
+	}
+	return nil
+}
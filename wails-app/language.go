@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// maxLanguageRetries is how many times a reply in the wrong language is re-asked
+const maxLanguageRetries = 2
+
+// minLanguageLetters is the least amount of prose needed to judge a reply's language
+const minLanguageLetters = 20
+
+// responseLanguage describes a language replies can be enforced in. Script is
+// the Unicode script its prose is written in; Latin-script languages are
+// told apart by their most common words.
+type responseLanguage struct {
+	Name      string
+	Script    *unicode.RangeTable
+	Stopwords []string
+}
+
+var responseLanguages = map[string]responseLanguage{
+	"en": {Name: "English", Script: unicode.Latin, Stopwords: []string{"the", "and", "is", "are", "of", "to", "in", "that", "this", "with", "you", "for", "it"}},
+	"es": {Name: "Spanish", Script: unicode.Latin, Stopwords: []string{"el", "la", "los", "las", "de", "que", "y", "es", "en", "un", "una", "para", "con", "por"}},
+	"fr": {Name: "French", Script: unicode.Latin, Stopwords: []string{"le", "la", "les", "de", "des", "et", "est", "un", "une", "que", "pour", "dans", "vous", "avec"}},
+	"de": {Name: "German", Script: unicode.Latin, Stopwords: []string{"der", "die", "das", "und", "ist", "nicht", "ein", "eine", "mit", "zu", "den", "sie", "auf", "für"}},
+	"pt": {Name: "Portuguese", Script: unicode.Latin, Stopwords: []string{"o", "a", "os", "as", "de", "que", "e", "é", "um", "uma", "para", "com", "não", "do"}},
+	"it": {Name: "Italian", Script: unicode.Latin, Stopwords: []string{"il", "la", "di", "che", "e", "è", "un", "una", "per", "con", "non", "sono", "del", "gli"}},
+	"nl": {Name: "Dutch", Script: unicode.Latin, Stopwords: []string{"de", "het", "een", "en", "is", "van", "dat", "niet", "op", "te", "met", "voor", "zijn"}},
+	"ru": {Name: "Russian", Script: unicode.Cyrillic},
+	"uk": {Name: "Ukrainian", Script: unicode.Cyrillic},
+	"ar": {Name: "Arabic", Script: unicode.Arabic},
+	"fa": {Name: "Persian", Script: unicode.Arabic},
+	"he": {Name: "Hebrew", Script: unicode.Hebrew},
+	"hi": {Name: "Hindi", Script: unicode.Devanagari},
+	"el": {Name: "Greek", Script: unicode.Greek},
+	"th": {Name: "Thai", Script: unicode.Thai},
+	"ko": {Name: "Korean", Script: unicode.Hangul},
+	"zh": {Name: "Chinese", Script: unicode.Han},
+	"ja": {Name: "Japanese", Script: unicode.Han},
+}
+
+// codePattern matches fenced code blocks and inline code, which are left out
+// of language detection since identifiers are usually English
+var codePattern = regexp.MustCompile("(?s)```.*?```|`[^`\n]*`")
+
+// lookupLanguage accepts a language code such as "ja" or "pt-BR", or an English name
+func lookupLanguage(lang string) (string, responseLanguage, bool) {
+	code := strings.ToLower(strings.TrimSpace(lang))
+	if i := strings.IndexAny(code, "-_"); i > 0 {
+		code = code[:i]
+	}
+	if l, ok := responseLanguages[code]; ok {
+		return code, l, true
+	}
+	for c, l := range responseLanguages {
+		if strings.EqualFold(l.Name, lang) {
+			return c, l, true
+		}
+	}
+	return "", responseLanguage{}, false
+}
+
+// languageInstruction is appended to prompts that must be answered in lang
+func languageInstruction(lang string) string {
+	name := lang
+	if _, l, ok := lookupLanguage(lang); ok {
+		name = l.Name
+	}
+	return fmt.Sprintf("Always respond in %s, even if the question or context is in another language. Code and identifiers may stay as they are.", name)
+}
+
+// languageMatches reports whether the prose in text is written in lang. Text
+// that is too short to judge, or a language without detection rules, counts
+// as a match.
+func languageMatches(text, lang string) bool {
+	code, l, ok := lookupLanguage(lang)
+	if !ok {
+		return true
+	}
+	text = codePattern.ReplaceAllString(text, " ")
+
+	var letters, inScript, kana int
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		if unicode.Is(l.Script, r) {
+			inScript++
+		}
+		if unicode.In(r, unicode.Hiragana, unicode.Katakana) {
+			kana++
+		}
+	}
+	if letters < minLanguageLetters {
+		return true
+	}
+
+	switch code {
+	case "ja":
+		// Japanese mixes kana with Han; kana alone distinguishes it from Chinese
+		return float64(inScript+kana)/float64(letters) >= 0.5 && kana > 0
+	case "zh":
+		return float64(inScript)/float64(letters) >= 0.5 && kana == 0
+	}
+	if float64(inScript)/float64(letters) < 0.5 {
+		return false
+	}
+	if len(l.Stopwords) == 0 {
+		return true
+	}
+
+	// Among Latin-script languages, the target must have the most common-word hits
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	})
+	scores := make(map[string]int)
+	for c, other := range responseLanguages {
+		if other.Script != l.Script || len(other.Stopwords) == 0 {
+			continue
+		}
+		set := make(map[string]bool, len(other.Stopwords))
+		for _, w := range other.Stopwords {
+			set[w] = true
+		}
+		for _, w := range words {
+			if set[w] {
+				scores[c]++
+			}
+		}
+	}
+	for c, n := range scores {
+		if c != code && n > scores[code] {
+			return false
+		}
+	}
+	return true
+}
+
+// SetResponseLanguage sets the language replies are enforced in for
+// conversations without their own setting; an empty lang disables it
+func (a *App) SetResponseLanguage(lang string) error {
+	a.providersMutex.Lock()
+	defer a.providersMutex.Unlock()
+
+	a.responseLanguage = strings.TrimSpace(lang)
+	return a.saveConfigLocked()
+}
+
+// GetResponseLanguage returns the default response language, or "" if none
+func (a *App) GetResponseLanguage() string {
+	a.providersMutex.RLock()
+	defer a.providersMutex.RUnlock()
+	return a.responseLanguage
+}
+
+// SetSessionLanguage overrides the response language for one conversation;
+// an empty lang falls back to the default
+func (a *App) SetSessionLanguage(sessionID string, lang string) error {
+	_, err := a.sessions.update(sessionID, func(s *Session) error {
+		s.Language = strings.TrimSpace(lang)
+		return nil
+	})
+	return err
+}
+
+// sessionLanguage returns the language replies in a session must be written in
+func (a *App) sessionLanguage(s Session) string {
+	if s.Language != "" {
+		return s.Language
+	}
+	return a.GetResponseLanguage()
+}
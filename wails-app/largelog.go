@@ -0,0 +1,459 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// attachmentModeLog marks an attachment kept out of the prompt, which the
+// model reads through the log tool instead
+const attachmentModeLog = "log"
+
+const (
+	// maxLogToolRounds is how many times a reply may call the log tool
+	// before the model has to answer with what it has
+	maxLogToolRounds = 5
+	// logToolDefaultLines is how many lines head, tail and grep return by default
+	logToolDefaultLines = 20
+	// logToolMaxLines caps the lines one command returns
+	logToolMaxLines = 200
+	// logToolLineChars is how much of each line is shown
+	logToolLineChars = 400
+	// logToolOutputChars caps the tool output fed back per round
+	logToolOutputChars = 12000
+)
+
+const logToolInstructions = `Some attached files are too large to include. You can read them with the log tool by replying with only a fenced block such as:
+
+` + "```logtool" + `
+grep <file> <regexp> [max]
+` + "```" + `
+
+Commands, one per line (quote arguments containing spaces):
+- grep <file> <regexp> [max]: matching lines with their line numbers (Go regexp syntax; prefix (?i) to ignore case)
+- head <file> [n]: the first n lines
+- tail <file> [n]: the last n lines
+- lines <file> <from> <to>: lines from through to
+Results come back in a Tool message. When you have what you need, answer normally without a logtool block.
+
+Files:
+`
+
+// logToolBlock matches a tool call in a reply
+var logToolBlock = regexp.MustCompile("(?s)```logtool\\s*\\n(.*?)```")
+
+// logAttachments returns the attachments of a session in log mode
+func logAttachments(s Session) []AttachmentRef {
+	var out []AttachmentRef
+	for _, ref := range s.Attachments {
+		if ref.Mode == attachmentModeLog {
+			out = append(out, ref)
+		}
+	}
+	return out
+}
+
+// logToolContext is the system message that introduces the log tool and files
+func logToolContext(refs []AttachmentRef, f reportFormatter) string {
+	var b strings.Builder
+	b.WriteString(logToolInstructions)
+	for _, ref := range refs {
+		fmt.Fprintf(&b, "- %s (%s bytes)\n", ref.Name, f.Int(ref.Size))
+	}
+	return b.String()
+}
+
+// generateWithLogTools runs req, executing the log tool commands the model
+// replies with and asking again with their output until it answers
+func (a *App) generateWithLogTools(req generateRequest, refs []AttachmentRef) (generateResult, error) {
+	messages := req.Messages
+	for round := 0; ; round++ {
+		result, err := a.generate(req)
+		if err != nil {
+			return result, err
+		}
+		calls := logToolBlock.FindAllStringSubmatch(result.Response, -1)
+		if len(calls) == 0 || round == maxLogToolRounds {
+			return result, nil
+		}
+
+		var commands []string
+		for _, call := range calls {
+			for _, line := range strings.Split(call[1], "\n") {
+				if line = strings.TrimSpace(line); line != "" {
+					commands = append(commands, line)
+				}
+			}
+		}
+		output := a.runLogCommands(refs, commands)
+		a.emit(EventPromptTool, PromptToolEvent{Tool: "logtool", Commands: commands, Round: round + 1})
+
+		next := Message{Role: "tool", Content: output}
+		if round == maxLogToolRounds-1 {
+			next.Content += "\n\nThis was the last tool call. Answer now with what you have found."
+		}
+		messages = append(messages[:len(messages):len(messages)], Message{Role: "assistant", Content: result.Response}, next)
+		req.Messages = messages
+	}
+}
+
+// runLogCommands runs tool commands and returns their combined output
+func (a *App) runLogCommands(refs []AttachmentRef, commands []string) string {
+	var b strings.Builder
+	for _, command := range commands {
+		out, err := a.runLogCommand(refs, command)
+		if err != nil {
+			out = "error: " + err.Error()
+		}
+		fmt.Fprintf(&b, "$ %s\n%s\n", command, strings.TrimRight(out, "\n"))
+		if b.Len() > logToolOutputChars {
+			break
+		}
+	}
+	out := b.String()
+	if len(out) > logToolOutputChars {
+		out = strings.ToValidUTF8(out[:logToolOutputChars], "") + "\n[output truncated]"
+	}
+	return out
+}
+
+func (a *App) runLogCommand(refs []AttachmentRef, command string) (string, error) {
+	args, err := splitCommandLine(command)
+	if err != nil {
+		return "", err
+	}
+	if len(args) < 2 {
+		return "", fmt.Errorf("usage: grep|head|tail|lines <file> ...")
+	}
+	ref, ok := findLogAttachment(refs, args[1])
+	if !ok {
+		return "", fmt.Errorf("no attached log named %q", args[1])
+	}
+	path, err := a.attachments.path(ref.Hash)
+	if err != nil {
+		return "", err
+	}
+
+	switch args[0] {
+	case "grep":
+		if len(args) < 3 {
+			return "", fmt.Errorf("usage: grep <file> <regexp> [max]")
+		}
+		pattern, err := regexp.Compile(args[2])
+		if err != nil {
+			return "", fmt.Errorf("invalid regexp: %v", err)
+		}
+		return grepLog(path, pattern, lineCount(args, 3))
+	case "head":
+		return logLines(path, 1, lineCount(args, 2))
+	case "tail":
+		return tailLog(path, lineCount(args, 2))
+	case "lines":
+		if len(args) < 4 {
+			return "", fmt.Errorf("usage: lines <file> <from> <to>")
+		}
+		from, err1 := strconv.Atoi(args[2])
+		to, err2 := strconv.Atoi(args[3])
+		if err1 != nil || err2 != nil || from < 1 || to < from {
+			return "", fmt.Errorf("lines needs a range such as 100 140")
+		}
+		return logLines(path, from, min(to-from+1, logToolMaxLines))
+	}
+	return "", fmt.Errorf("unknown command %q; use grep, head, tail or lines", args[0])
+}
+
+// findLogAttachment matches a file by name or hash prefix, then by name
+// ignoring case
+func findLogAttachment(refs []AttachmentRef, name string) (AttachmentRef, bool) {
+	for _, ref := range refs {
+		if ref.Name == name || (len(name) >= 8 && strings.HasPrefix(ref.Hash, name)) {
+			return ref, true
+		}
+	}
+	for _, ref := range refs {
+		if strings.EqualFold(ref.Name, name) {
+			return ref, true
+		}
+	}
+	return AttachmentRef{}, false
+}
+
+// lineCount reads an optional line count argument
+func lineCount(args []string, i int) int {
+	n := logToolDefaultLines
+	if i < len(args) {
+		if v, err := strconv.Atoi(args[i]); err == nil && v > 0 {
+			n = v
+		}
+	}
+	return min(n, logToolMaxLines)
+}
+
+// splitCommandLine splits a command into arguments, honouring single and
+// double quotes
+func splitCommandLine(s string) ([]string, error) {
+	var args []string
+	var cur strings.Builder
+	var quote rune
+	inArg := false
+	for _, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t':
+			if inArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+		default:
+			cur.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if inArg {
+		args = append(args, cur.String())
+	}
+	return args, nil
+}
+
+// scanLog calls fn with each line of the file at path and its number,
+// starting at 1, until fn returns false. Lines are cut to logToolLineChars
+// so a file without newlines cannot exhaust memory.
+func scanLog(path string, fn func(n int, line string) bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReaderSize(f, 64*1024)
+	var line []byte
+	for n := 1; ; n++ {
+		line = line[:0]
+		var readErr error
+		for {
+			chunk, err := r.ReadSlice('\n')
+			if room := logToolLineChars + 1 - len(line); room > 0 {
+				line = append(line, chunk[:min(len(chunk), room)]...)
+			}
+			if err != bufio.ErrBufferFull {
+				readErr = err
+				break
+			}
+		}
+		if len(line) == 0 && readErr != nil {
+			if readErr == io.EOF {
+				return nil
+			}
+			return readErr
+		}
+		if !fn(n, shortenLine(line)) {
+			return nil
+		}
+		if readErr == io.EOF {
+			return nil
+		} else if readErr != nil {
+			return readErr
+		}
+	}
+}
+
+func shortenLine(line []byte) string {
+	s := strings.TrimRight(string(line), "\r\n")
+	if len(s) > logToolLineChars {
+		s = strings.ToValidUTF8(s[:logToolLineChars], "") + "…"
+	}
+	return s
+}
+
+func grepLog(path string, pattern *regexp.Regexp, max int) (string, error) {
+	var b strings.Builder
+	matches := 0
+	err := scanLog(path, func(n int, line string) bool {
+		if pattern.MatchString(line) {
+			matches++
+			if matches <= max {
+				fmt.Fprintf(&b, "%d: %s\n", n, line)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case matches == 0:
+		return "no matches", nil
+	case matches > max:
+		fmt.Fprintf(&b, "[%d of %d matches shown]\n", max, matches)
+	}
+	return b.String(), nil
+}
+
+func logLines(path string, from, count int) (string, error) {
+	var b strings.Builder
+	err := scanLog(path, func(n int, line string) bool {
+		if n >= from {
+			fmt.Fprintf(&b, "%d: %s\n", n, line)
+		}
+		return n < from+count-1
+	})
+	if err != nil {
+		return "", err
+	}
+	if b.Len() == 0 {
+		return fmt.Sprintf("the file has fewer than %d lines", from), nil
+	}
+	return b.String(), nil
+}
+
+func tailLog(path string, count int) (string, error) {
+	type numbered struct {
+		n    int
+		line string
+	}
+	ring := make([]numbered, 0, count)
+	total := 0
+	err := scanLog(path, func(n int, line string) bool {
+		if len(ring) < count {
+			ring = append(ring, numbered{n, line})
+		} else {
+			ring[(n-1)%count] = numbered{n, line}
+		}
+		total = n
+		return true
+	})
+	if err != nil {
+		return "", err
+	}
+	// Once the ring has wrapped, its oldest line is where the next would go
+	if total > count {
+		start := total % count
+		ring = append(ring[start:], ring[:start]...)
+	}
+	var b strings.Builder
+	for _, l := range ring {
+		fmt.Fprintf(&b, "%d: %s\n", l.n, l.line)
+	}
+	return b.String(), nil
+}
+
+// attachmentUpload is a file being received in chunks
+type attachmentUpload struct {
+	sessionID string
+	name      string
+	file      *os.File
+	size      int64
+}
+
+// attachmentUploads holds the uploads in progress
+type attachmentUploads struct {
+	mu      sync.Mutex
+	pending map[string]*attachmentUpload
+}
+
+// BeginAttachmentUpload starts receiving a file in chunks, for files too
+// large to pass to AttachFile from the frontend in one piece
+func (a *App) BeginAttachmentUpload(sessionID string, name string) (string, error) {
+	if _, ok := a.sessions.get(sessionID); !ok {
+		return "", fmt.Errorf("session %q not found", sessionID)
+	}
+	f, err := os.CreateTemp("", "vibe-coder-upload-*")
+	if err != nil {
+		return "", err
+	}
+	id := newID()
+	a.uploads.mu.Lock()
+	defer a.uploads.mu.Unlock()
+	if a.uploads.pending == nil {
+		a.uploads.pending = make(map[string]*attachmentUpload)
+	}
+	a.uploads.pending[id] = &attachmentUpload{sessionID: sessionID, name: name, file: f}
+	return id, nil
+}
+
+// UploadAttachmentChunk appends base64-encoded data to an upload and
+// returns how many bytes have been received
+func (a *App) UploadAttachmentChunk(uploadID string, data string) (int64, error) {
+	chunk, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return 0, fmt.Errorf("invalid chunk: %v", err)
+	}
+	a.uploads.mu.Lock()
+	defer a.uploads.mu.Unlock()
+	u, ok := a.uploads.pending[uploadID]
+	if !ok {
+		return 0, fmt.Errorf("upload %q not found", uploadID)
+	}
+	n, err := u.file.Write(chunk)
+	u.size += int64(n)
+	if err != nil {
+		return u.size, err
+	}
+	return u.size, nil
+}
+
+// takeUpload removes an upload from the pending set and closes its file
+func (a *App) takeUpload(uploadID string) (*attachmentUpload, error) {
+	a.uploads.mu.Lock()
+	u, ok := a.uploads.pending[uploadID]
+	delete(a.uploads.pending, uploadID)
+	a.uploads.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("upload %q not found", uploadID)
+	}
+	return u, u.file.Close()
+}
+
+// FinishAttachmentUpload stores a completed upload and attaches it to its
+// session. With asLog the file is kept out of prompts and read through the
+// log tool, as with AttachLogFile.
+func (a *App) FinishAttachmentUpload(uploadID string, asLog bool) (AttachmentRef, error) {
+	u, err := a.takeUpload(uploadID)
+	if u != nil {
+		defer os.Remove(u.file.Name())
+	}
+	if err != nil {
+		return AttachmentRef{}, err
+	}
+	mode := ""
+	if asLog {
+		mode = attachmentModeLog
+	}
+	return a.attachPath(u.sessionID, u.file.Name(), u.name, mode)
+}
+
+// CancelAttachmentUpload discards an upload in progress
+func (a *App) CancelAttachmentUpload(uploadID string) error {
+	u, err := a.takeUpload(uploadID)
+	if u != nil {
+		os.Remove(u.file.Name())
+	}
+	return err
+}
+
+// AttachLogFile attaches a file, such as a log of hundreds of megabytes,
+// without putting its contents in the prompt. The model searches it with
+// grep, head, tail and line range commands run over the stored copy.
+func (a *App) AttachLogFile(sessionID string, path string) (AttachmentRef, error) {
+	a.telemetry.recordFeature("attach_log_file")
+	return a.attachPath(sessionID, path, "", attachmentModeLog)
+}
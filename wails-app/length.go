@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// lengthTarget is the budget and instruction behind a target length
+type lengthTarget struct {
+	Tokens      int
+	Instruction string
+}
+
+// lengthTargets are the named target lengths
+var lengthTargets = map[string]lengthTarget{
+	"short":  {150, "Keep your answer short: a few sentences, with code only where it is essential."},
+	"medium": {500, "Keep your answer focused; a few paragraphs at most."},
+	"long":   {1500, "Give a thorough, detailed answer."},
+}
+
+// lengthHeadroom is how far past its target a reply may run before the
+// provider cuts it off, so the model can finish its sentence
+const lengthHeadroom = 1.5
+
+// parseTargetLength resolves "short", "medium", "long", or an explicit token count
+func parseTargetLength(target string) (lengthTarget, error) {
+	target = strings.ToLower(strings.TrimSpace(target))
+	if t, ok := lengthTargets[target]; ok {
+		return t, nil
+	}
+	n, err := strconv.Atoi(target)
+	if err != nil || n <= 0 {
+		return lengthTarget{}, fmt.Errorf("unknown target length %q: use short, medium, long, or a token count", target)
+	}
+	return lengthTarget{
+		Tokens:      n,
+		Instruction: fmt.Sprintf("Keep your answer under about %d words.", n*3/4),
+	}, nil
+}
+
+// truncateAtSentence cuts text that runs past limit tokens at the last
+// sentence or paragraph boundary within the limit, outside code blocks. It
+// returns the text to show and the held-back remainder, which is empty when
+// the text fits or has no usable boundary.
+func truncateAtSentence(text string, limit int, model string) (string, string) {
+	total := countTokens(text, model).Tokens
+	if total <= limit {
+		return text, ""
+	}
+	// Token counts are roughly proportional to length, which is close enough to place the cut
+	maxBytes := len(text) * limit / total
+
+	cut := -1
+	inCode := false
+	for i := 0; i < maxBytes; i++ {
+		if strings.HasPrefix(text[i:], "```") && (i == 0 || text[i-1] == '\n') {
+			inCode = !inCode
+			if !inCode {
+				// The end of a code block is a boundary once its closing fence is included
+				if end := strings.IndexByte(text[i:], '\n'); end >= 0 && i+end <= maxBytes {
+					cut = i + end
+				}
+			}
+			i += 2
+			continue
+		}
+		if inCode {
+			continue
+		}
+		switch text[i] {
+		case '.', '!', '?':
+			if i+1 < len(text) && (text[i+1] == ' ' || text[i+1] == '\n') {
+				cut = i + 1
+			}
+		case '\n':
+			if i+1 < len(text) && text[i+1] == '\n' {
+				cut = i
+			}
+		}
+	}
+	if cut <= 0 {
+		return text, ""
+	}
+	return text[:cut], text[cut:]
+}
+
+// SetTargetLength sets how long replies should be: "short", "medium",
+// "long", or a token count. An empty target removes the limit.
+func (a *App) SetTargetLength(target string) error {
+	target = strings.ToLower(strings.TrimSpace(target))
+	if target != "" {
+		if _, err := parseTargetLength(target); err != nil {
+			return err
+		}
+	}
+
+	a.providersMutex.Lock()
+	defer a.providersMutex.Unlock()
+
+	a.targetLength = target
+	return a.saveConfigLocked()
+}
+
+// GetTargetLength returns the target reply length, or "" if replies are unlimited
+func (a *App) GetTargetLength() string {
+	a.providersMutex.RLock()
+	defer a.providersMutex.RUnlock()
+	return a.targetLength
+}
+
+// ContinueResponse extends the last reply in a session. Text held back by the
+// target length is shown first; once it has all been shown, the model is
+// asked to carry on from where the reply stopped. It returns the added text.
+func (a *App) ContinueResponse(sessionID string) (string, error) {
+	a.telemetry.recordFeature("continue_response")
+	session, ok := a.sessions.get(sessionID)
+	if !ok {
+		return "", fmt.Errorf("session %q not found", sessionID)
+	}
+	last := len(session.Messages) - 1
+	if last < 0 || session.Messages[last].Role != "assistant" {
+		return "", fmt.Errorf("session %q has no reply to continue", sessionID)
+	}
+
+	if remainder := session.Messages[last].Remainder; remainder != "" {
+		err := a.sessions.updateMessage(sessionID, last, func(m *Message) {
+			m.Content += m.Remainder
+			m.Remainder = ""
+		})
+		return remainder, err
+	}
+
+	messages := append(sessionContext(session), Message{
+		Role:    "user",
+		Content: "Continue your previous reply exactly where it stopped. Do not repeat anything you already wrote.",
+	})
+	ctx, done := a.trackPrompt(sessionID)
+	defer done()
+	result, err := a.generate(generateRequest{
+		Messages:     messages,
+		Language:     a.sessionLanguage(session),
+		TargetLength: a.GetTargetLength(),
+		Context:      ctx,
+		CleanRoom:    cleanRoomID(session),
+		Private:      session.Locked,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	added := result.Response
+	if prev := session.Messages[last].Content; prev != "" && added != "" &&
+		!strings.ContainsAny(prev[len(prev)-1:], " \n") && !strings.ContainsAny(added[:1], " \n") {
+		added = " " + added
+	}
+	err = a.sessions.updateMessage(sessionID, last, func(m *Message) {
+		m.Content += added
+		m.Remainder = result.Remainder
+	})
+	return added, err
+}
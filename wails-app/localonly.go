@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// localOnly is local-only mode. It is process-wide because provider clients
+// are built outside the App, and is checked by every connection the app
+// makes, so switching it on also stops connections already open.
+var localOnly atomic.Bool
+
+// isLocalIP reports whether ip is on this machine or the local network
+func isLocalIP(ip net.IP) bool {
+	return ip != nil && (ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast())
+}
+
+// checkLocalOnly refuses address, a resolved host:port, while local-only
+// mode is on and it is not on this machine or the local network
+func checkLocalOnly(address string) error {
+	if !localOnly.Load() {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	if !isLocalIP(net.ParseIP(host)) {
+		return fmt.Errorf("local-only mode blocks connections to %s, which is outside this machine and network", address)
+	}
+	return nil
+}
+
+// localOnlyConn fails writes once local-only mode forbids its address, so
+// pooled keep-alive connections and replies still streaming can't be used
+// to send anything after the switch
+type localOnlyConn struct {
+	net.Conn
+}
+
+func (c *localOnlyConn) Write(b []byte) (int, error) {
+	if err := checkLocalOnly(c.RemoteAddr().String()); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(b)
+}
+
+// localOnlyDialer dials with d, checking each address after name resolution
+// (so a proxy outside the network is refused too)
+func localOnlyDialer(d *net.Dialer) func(ctx context.Context, network, address string) (net.Conn, error) {
+	d.Control = func(network, address string, _ syscall.RawConn) error {
+		return checkLocalOnly(address)
+	}
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := d.DialContext(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		return &localOnlyConn{Conn: conn}, nil
+	}
+}
+
+// defaultTransport is http.DefaultTransport with local-only mode enforced
+func defaultTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = localOnlyProxy(transport.Proxy)
+	transport.DialContext = localOnlyDialer(&net.Dialer{Timeout: defaultConnectTimeout, KeepAlive: 30 * time.Second})
+	return transport
+}
+
+// localOnlyProxy wraps a transport's proxy function to refuse requests to
+// hosts that resolve outside the network while local-only mode is on. The
+// connection check alone would let a local proxy, such as Tor on
+// 127.0.0.1, forward them. The proxy is checked too, for clients the app
+// does not dial for itself such as the trace exporter.
+func localOnlyProxy(proxy func(*http.Request) (*url.URL, error)) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		var target *url.URL
+		if proxy != nil {
+			var err error
+			if target, err = proxy(req); err != nil {
+				return nil, err
+			}
+		}
+		if !localOnly.Load() {
+			return target, nil
+		}
+		for _, u := range []*url.URL{req.URL, target} {
+			if u == nil {
+				continue
+			}
+			ips, err := net.DefaultResolver.LookupIPAddr(req.Context(), u.Hostname())
+			if err != nil {
+				return nil, err
+			}
+			for _, ip := range ips {
+				if err := checkLocalOnly(net.JoinHostPort(ip.String(), u.Port())); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return target, nil
+	}
+}
+
+// SetLocalOnlyMode switches local-only mode, which blocks every connection
+// to an address outside this machine and the local network (loopback,
+// private and link-local addresses): hosted providers, remote proxies,
+// telemetry, webhooks, OAuth and trace export. It is enforced when
+// connecting and on every write, so it also stops connections already open.
+func (a *App) SetLocalOnlyMode(enabled bool) error {
+	a.providersMutex.Lock()
+	defer a.providersMutex.Unlock()
+
+	localOnly.Store(enabled)
+	a.localOnly = enabled
+	return a.saveConfigLocked()
+}
+
+// GetLocalOnlyMode reports whether local-only mode is on
+func (a *App) GetLocalOnlyMode() bool {
+	a.providersMutex.RLock()
+	defer a.providersMutex.RUnlock()
+	return a.localOnly
+}
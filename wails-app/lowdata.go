@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Low-data modes
+const (
+	lowDataOff  = "off"
+	lowDataOn   = "on"
+	lowDataAuto = "auto"
+)
+
+const (
+	// lowDataMaxTokens caps response length while low-data mode is active
+	lowDataMaxTokens = 512
+	// lowDataContextBudget caps the prompt, in tokens, while low-data mode is active
+	lowDataContextBudget = 1024
+	// meteredCheckInterval is how long an OS metered-connection answer is reused
+	meteredCheckInterval = 30 * time.Second
+)
+
+// LowDataStatus reports the low-data setting and whether it currently applies
+type LowDataStatus struct {
+	Mode   string `json:"mode"`
+	Active bool   `json:"active"`
+	// Metered is the OS report; MeteredKnown is false when the OS cannot tell
+	Metered      bool `json:"metered"`
+	MeteredKnown bool `json:"meteredKnown"`
+}
+
+// meteredCache remembers the last metered-connection check
+type meteredCache struct {
+	mu        sync.Mutex
+	checkedAt time.Time
+	metered   bool
+	known     bool
+}
+
+func (c *meteredCache) get() (bool, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.checkedAt) > meteredCheckInterval {
+		c.metered, c.known = osMeteredConnection()
+		c.checkedAt = time.Now()
+	}
+	return c.metered, c.known
+}
+
+// SetLowDataMode switches low-data mode "on", "off", or to "auto", which
+// follows the OS metered-connection setting. While active, local providers
+// are preferred, replies are not streamed and are capped at lowDataMaxTokens,
+// and conversation context is trimmed to lowDataContextBudget tokens.
+func (a *App) SetLowDataMode(mode string) error {
+	switch mode {
+	case lowDataOff, lowDataOn, lowDataAuto:
+	default:
+		return fmt.Errorf("invalid low-data mode %q", mode)
+	}
+
+	a.providersMutex.Lock()
+	defer a.providersMutex.Unlock()
+
+	a.lowDataMode = mode
+	return a.saveConfigLocked()
+}
+
+// GetLowDataStatus returns the low-data mode and whether it is in effect
+func (a *App) GetLowDataStatus() LowDataStatus {
+	a.providersMutex.RLock()
+	mode := a.lowDataMode
+	a.providersMutex.RUnlock()
+	if mode == "" {
+		mode = lowDataOff
+	}
+
+	status := LowDataStatus{Mode: mode}
+	status.Metered, status.MeteredKnown = a.metered.get()
+	switch mode {
+	case lowDataOn:
+		status.Active = true
+	case lowDataAuto:
+		status.Active = status.Metered
+	}
+	return status
+}
+
+func (a *App) lowDataActive() bool {
+	a.providersMutex.RLock()
+	mode := a.lowDataMode
+	a.providersMutex.RUnlock()
+
+	switch mode {
+	case lowDataOn:
+		return true
+	case lowDataAuto:
+		metered, _ := a.metered.get()
+		return metered
+	}
+	return false
+}
+
+// isLocalProvider reports whether a provider runs on this machine or the
+// local network, so using it does not consume metered data
+func isLocalProvider(config ProviderConfig) bool {
+	switch config.Type {
+	case "Mock", "Plugin":
+		return true
+	}
+	u, err := url.Parse(config.Endpoint)
+	if err != nil || u.Hostname() == "" {
+		return false
+	}
+	if u.Hostname() == "localhost" {
+		return true
+	}
+	return isLocalIP(net.ParseIP(u.Hostname()))
+}
+
+// preferLocalProvider returns selected if it is local, otherwise the first
+// configured local provider, falling back to selected when there is none
+func (a *App) preferLocalProvider(selected Provider) Provider {
+	if isLocalProvider(selected.GetConfig()) {
+		return selected
+	}
+
+	a.providersMutex.RLock()
+	defer a.providersMutex.RUnlock()
+
+	for _, p := range a.providers {
+		if isLocalProvider(p.GetConfig()) {
+			return p
+		}
+	}
+	return selected
+}
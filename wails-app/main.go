@@ -1,46 +1,209 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/wailsapp/wails/v2"
 	"github.com/wailsapp/wails/v2/pkg/logger"
 	"github.com/wailsapp/wails/v2/pkg/options"
 	"github.com/wailsapp/wails/v2/pkg/options/assetserver"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 //go:embed frontend/dist
 var assets embed.FS
 
 type ProviderConfig struct {
+	// ID is assigned when the provider is added and never changes
+	ID       string `json:"id"`
 	Type     string `json:"type"`
 	Name     string `json:"name"`
 	APIKey   string `json:"apiKey"`
 	Endpoint string `json:"endpoint"`
 	Model    string `json:"model"`
+	// APIKeyRef names the entry in the OS credential store that holds APIKey
+	APIKeyRef string `json:"apiKeyRef,omitempty"`
+	// Command and Args start an out-of-process plugin provider
+	Command string   `json:"command,omitempty"`
+	Args    []string `json:"args,omitempty"`
+	// Disabled is the plugin kill switch
+	Disabled bool `json:"disabled,omitempty"`
+	// Members and Strategy configure a provider group
+	Members  []string `json:"members,omitempty"`
+	Strategy string   `json:"strategy,omitempty"`
+	// EmbeddingModel overrides Model for embedding requests
+	EmbeddingModel string `json:"embeddingModel,omitempty"`
+	// ContextSize overrides the model's context window in tokens
+	ContextSize int `json:"contextSize,omitempty"`
+	// ConnectTimeoutSeconds and ReadTimeoutSeconds bound how long the backend
+	// may take to accept a connection and to send each part of a reply
+	ConnectTimeoutSeconds int `json:"connectTimeoutSeconds,omitempty"`
+	ReadTimeoutSeconds    int `json:"readTimeoutSeconds,omitempty"`
+	// ProxyURL routes requests through an http, https or socks5 proxy, or
+	// "direct" to bypass one; empty uses HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+	ProxyURL string `json:"proxyUrl,omitempty"`
+	// TLS sets a custom CA, a client certificate or skipped verification
+	TLS ProviderTLS `json:"tls"`
+	// Headers are sent with every request to the provider, e.g. gateway
+	// credentials or an org ID. Values are kept in the secret store.
+	Headers map[string]string `json:"headers,omitempty"`
+	// HeadersRef names the secret store entry that holds Headers
+	HeadersRef string `json:"headersRef,omitempty"`
+	// OrganizationID and ProjectID scope OpenAI requests to an organization
+	// and project, so usage is billed to them
+	OrganizationID string `json:"organizationId,omitempty"`
+	ProjectID      string `json:"projectId,omitempty"`
+	// TrainsOnData marks providers whose data policy allows training on
+	// requests; clean-room conversations never use them
+	TrainsOnData bool `json:"trainsOnData,omitempty"`
+	// OAuth signs in to the provider instead of using an API key
+	OAuth ProviderOAuth `json:"oauth"`
+	// MaxAttempts limits how often a request is sent when it fails transiently
+	MaxAttempts int `json:"maxAttempts,omitempty"`
+	// RequestsPerMinute and TokensPerMinute rate limit requests to the
+	// provider; requests over the limit wait their turn
+	RequestsPerMinute int `json:"requestsPerMinute,omitempty"`
+	TokensPerMinute   int `json:"tokensPerMinute,omitempty"`
+	// MaxConcurrent limits the generations running at once; extra requests
+	// wait in a bounded queue. Ollama providers default to 2.
+	MaxConcurrent int `json:"maxConcurrent,omitempty"`
+	// StallTimeoutSeconds is how long a streamed reply may go without new
+	// text before a "request:stalled" event; 0 uses 60 seconds and a
+	// negative value turns the watchdog off
+	StallTimeoutSeconds int `json:"stallTimeoutSeconds,omitempty"`
+	// Defaults are generation parameters for requests that don't set their own
+	Defaults GenerationDefaults `json:"defaults"`
+}
+
+// GenerationDefaults tune generation for one provider's models. Temperature
+// and MaxTokens apply when a request leaves them unset; the sampling options
+// are sent with every request to backends that support them. Personas and
+// SendPromptWithOptions set them for a kind of task, over the provider's.
+type GenerationDefaults struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	MaxTokens   int      `json:"maxTokens,omitempty"`
+	TopP        *float64 `json:"topP,omitempty"`
+	TopK        int      `json:"topK,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
+}
+
+func (d GenerationDefaults) validate() error {
+	if d.Temperature != nil && (*d.Temperature < 0 || *d.Temperature > 2) {
+		return fmt.Errorf("temperature must be between 0 and 2")
+	}
+	if d.MaxTokens < 0 {
+		return fmt.Errorf("maxTokens can't be negative")
+	}
+	if d.TopP != nil && (*d.TopP <= 0 || *d.TopP > 1) {
+		return fmt.Errorf("topP must be above 0 and at most 1")
+	}
+	if d.TopK < 0 {
+		return fmt.Errorf("topK can't be negative")
+	}
+	return nil
+}
+
+// equal reports whether d and other set the same options
+func (d GenerationDefaults) equal(other GenerationDefaults) bool {
+	same := func(a, b *float64) bool { return a == nil && b == nil || a != nil && b != nil && *a == *b }
+	return same(d.Temperature, other.Temperature) && d.MaxTokens == other.MaxTokens &&
+		same(d.TopP, other.TopP) && d.TopK == other.TopK && slices.Equal(d.Stop, other.Stop)
+}
+
+// over returns d with the fields it leaves unset taken from base
+func (d GenerationDefaults) over(base GenerationDefaults) GenerationDefaults {
+	if d.Temperature == nil {
+		d.Temperature = base.Temperature
+	}
+	if d.MaxTokens == 0 {
+		d.MaxTokens = base.MaxTokens
+	}
+	if d.TopP == nil {
+		d.TopP = base.TopP
+	}
+	if d.TopK == 0 {
+		d.TopK = base.TopK
+	}
+	if len(d.Stop) == 0 {
+		d.Stop = base.Stop
+	}
+	return d
+}
+
+func (d GenerationDefaults) String() string {
+	var parts []string
+	if d.Temperature != nil {
+		parts = append(parts, fmt.Sprintf("temperature %g", *d.Temperature))
+	}
+	if d.MaxTokens > 0 {
+		parts = append(parts, fmt.Sprintf("maxTokens %d", d.MaxTokens))
+	}
+	if d.TopP != nil {
+		parts = append(parts, fmt.Sprintf("topP %g", *d.TopP))
+	}
+	if d.TopK > 0 {
+		parts = append(parts, fmt.Sprintf("topK %d", d.TopK))
+	}
+	if len(d.Stop) > 0 {
+		parts = append(parts, fmt.Sprintf("stop %q", d.Stop))
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// generationProvider is a provider whose sampling defaults can be replaced
+// for one request
+type generationProvider interface {
+	withGeneration(d GenerationDefaults) Provider
+}
+
+func (p *OllamaProvider) withGeneration(d GenerationDefaults) Provider {
+	tuned := *p
+	tuned.config.Defaults = d
+	return &tuned
+}
+
+func (p *OpenAIProvider) withGeneration(d GenerationDefaults) Provider {
+	tuned := *p
+	tuned.config.Defaults = d
+	return &tuned
 }
 
 type Provider interface {
 	SendRequest(prompt string, temperature float64, maxTokens int) (string, error)
 	GetName() string
+	GetConfig() ProviderConfig
 }
 
 type OllamaProvider struct {
 	config ProviderConfig
 	client *http.Client
+	// traceCtx carries the span requests are sent under
+	traceCtx context.Context
+	// schema, when set, is sent as the format replies must follow
+	schema json.RawMessage
 }
 
 func NewOllamaProvider(config ProviderConfig) *OllamaProvider {
 	return &OllamaProvider{
 		config: config,
-		client: &http.Client{},
+		client: newHTTPClient(config),
 	}
 }
 
@@ -51,25 +214,46 @@ func (p *OllamaProvider) GetName() string {
 	return "Ollama"
 }
 
-func (p *OllamaProvider) SendRequest(prompt string, temperature float64, maxTokens int) (string, error) {
-	url := fmt.Sprintf("%s/api/generate", p.config.Endpoint)
+func (p *OllamaProvider) GetConfig() ProviderConfig {
+	return p.config
+}
 
+func (p *OllamaProvider) generatePayload(prompt string, temperature float64, maxTokens int, stream bool) map[string]interface{} {
+	options := map[string]interface{}{
+		"temperature": temperature,
+		"num_predict": maxTokens,
+	}
+	d := p.config.Defaults
+	if d.TopP != nil {
+		options["top_p"] = *d.TopP
+	}
+	if d.TopK > 0 {
+		options["top_k"] = d.TopK
+	}
+	if len(d.Stop) > 0 {
+		options["stop"] = d.Stop
+	}
 	payload := map[string]interface{}{
-		"model":  p.config.Model,
-		"prompt": prompt,
-		"stream": false,
-		"options": map[string]interface{}{
-			"temperature": temperature,
-			"num_predict": maxTokens,
-		},
+		"model":   p.config.Model,
+		"prompt":  prompt,
+		"stream":  stream,
+		"options": options,
+	}
+	if len(p.schema) > 0 {
+		payload["format"] = p.schema
 	}
+	return payload
+}
 
-	jsonData, err := json.Marshal(payload)
+func (p *OllamaProvider) SendRequest(prompt string, temperature float64, maxTokens int) (string, error) {
+	url := fmt.Sprintf("%s/api/generate", p.config.Endpoint)
+
+	jsonData, err := json.Marshal(p.generatePayload(prompt, temperature, maxTokens, false))
 	if err != nil {
 		return "", err
 	}
 
-	resp, err := p.client.Post(url, "application/json", bytes.NewBuffer(jsonData))
+	resp, err := p.post(url, jsonData)
 	if err != nil {
 		return "", fmt.Errorf("network error: %v", err)
 	}
@@ -108,89 +292,668 @@ func (p *MockProvider) GetName() string {
 	return "Mock"
 }
 
+func (p *MockProvider) GetConfig() ProviderConfig {
+	return p.config
+}
+
 func (p *MockProvider) SendRequest(prompt string, temperature float64, maxTokens int) (string, error) {
 	return fmt.Sprintf("# Mock AI Response\n\nYou asked: %s\n\n## Code Example\n\n```go\nfunc hello() {\n    fmt.Println(\"Hello from Vibe Coder!\")\n}\n```\n\n## Explanation\n\nThis is a mock response demonstrating the parsing capabilities.", prompt), nil
 }
 
 type App struct {
+	// ctx is the Wails runtime context, set on startup
+	ctx context.Context
+
 	providers      []Provider
-	activeProvider int
+	activeProvider string
 	providersMutex sync.RWMutex
+	configPath     string
+	secrets        SecretStore
+	// workspace, responseLanguage and targetLength are saved with the config and guarded by providersMutex
+	workspace        string
+	responseLanguage string
+	targetLength     string
+	// currentFile is the file open in the editor, relative to the workspace
+	currentFile string
+	// fallbackProviders are tried in order when the selected provider fails
+	fallbackProviders []string
+	// fastProvider serves prompts the intent classifier puts in the fast tier
+	fastProvider string
+	lowDataMode  string
+	metered      meteredCache
+	// cacheResponses serves repeated requests from responses
+	cacheResponses bool
+	// followUps suggests next prompts after each reply
+	followUps    bool
+	compaction   CompactionPolicy
+	reportFormat ReportFormat
+	// modelPrices are custom prices by model name prefix, overriding the built-in table
+	modelPrices map[string]ModelPrice
+	budget      Budget
+	// tracingConfig is the trace export setting; its headers live in the secret store
+	tracingConfig TracingConfig
+	redaction     RedactionPolicy
+	// localOnly mirrors local-only mode for the config
+	localOnly bool
+	// mergeEngine names the engine proposed edits are merged with
+	mergeEngine string
+	// personas are the user-defined personas; built-in ones are not saved
+	personas []Persona
+	// deletedPersonas are the personas in the trash
+	deletedPersonas []Persona
+	// preSendReview holds requests to hosted providers until their context is reviewed
+	preSendReview bool
+	// cleanRoomProviders are the hosted providers clean-room conversations may use
+	cleanRoomProviders []string
+	// commandTool turns on the run_command tool
+	commandTool bool
+	// commandAllowlist are the commands run without asking; nil means the defaults
+	commandAllowlist []string
+	// workspaceDigest starts a conversation with what changed on opening a workspace
+	workspaceDigest bool
+	// lastSession is when the last session in the open workspace ended
+	lastSession time.Time
+
+	embeddings *embeddingCache
+	vectors    *vectorStore
+	// codeIndexing is held while a workspace is indexed, so runs don't overlap
+	codeIndexing sync.Mutex
+	responses    *responseCache
+
+	requests    *requestStore
+	httpLog     *httpLog
+	audit       *auditLog
+	tracing     *tracing
+	metrics     *metricsStore
+	activity    *activityStore
+	telemetry   *telemetry
+	attachments *attachmentStore
+	scripts     *scriptStore
+	visits      *visitStore
+	snapshots   *snapshotStore
+	// history keeps prompts for recall, apart from sessions
+	history *promptHistory
+	abTests *abTestStore
+	// tools are the tools prompts can let the model call
+	tools *toolRegistry
+	// changes are file changes proposed by tools, waiting for approval
+	changes *pendingChanges
+	// commands are run_command calls waiting for the user
+	commands *commandConfirmations
+	// serverTokens authorize requests to the local server
+	serverTokens *serverTokenStore
+
+	sessions      *SessionStore
+	activeSession string
+	sessionMutex  sync.Mutex
+	// summarizing holds IDs of sessions with a background summary in flight
+	summarizing sync.Map
+	// suggesting holds IDs of sessions with follow-up suggestions in flight
+	suggesting sync.Map
+	// compacting holds IDs of sessions being compacted
+	compacting sync.Map
+
+	server      *localServer
+	serverMutex sync.Mutex
+
+	focus      *FocusSession
+	focusTimer *time.Timer
+	focusMutex sync.Mutex
+
+	// chaos is the chaos mode window, nil while it is off
+	chaos      *chaosWindow
+	chaosMutex sync.Mutex
+
+	// watch is watch mode, nil while it is off
+	watch      *fileWatch
+	watchMutex sync.Mutex
+
+	// dataDir is where state is persisted, set at startup
+	dataDir string
+	// lastActivity is the UnixNano time of the last provider request
+	lastActivity     atomic.Int64
+	lastMaintenance  *MaintenanceReport
+	maintenanceMutex sync.Mutex
+
+	// inflight holds the cancellable prompt of each session with one in progress
+	inflight      map[string]*inflightPrompt
+	inflightMutex sync.Mutex
+	// limiters rate limit providers by ID
+	limiters      map[string]*providerLimiter
+	limitersMutex sync.Mutex
+	// queues limit concurrent generations by provider ID
+	queues      map[string]*providerQueue
+	queuesMutex sync.Mutex
+	// stalls holds the watchdog of each streamed request in progress
+	stalls      map[string]*stallWatch
+	stallsMutex sync.Mutex
+	// prefill holds a prompt started from a context menu until the frontend takes it
+	prefill askPrefill
+	// uploads holds attachments being received in chunks
+	uploads attachmentUploads
+	// oauth caches the tokens of providers signed in with OAuth
+	oauth oauthState
+	// eventSink, when set, receives events in place of the Wails runtime
+	eventSink func(name string, data ...interface{})
 }
 
 func NewApp() *App {
-	return &App{
-		providers:      make([]Provider, 0),
-		activeProvider: -1,
+	a := &App{
+		providers:    make([]Provider, 0),
+		secrets:      newKeyringSecretStore(),
+		embeddings:   newEmbeddingCache(),
+		vectors:      newVectorStore(),
+		responses:    newResponseCache(),
+		sessions:     newSessionStore(),
+		requests:     newRequestStore(),
+		httpLog:      newHTTPLog(),
+		audit:        newAuditLog(),
+		tracing:      newTracing(),
+		metrics:      newMetricsStore(),
+		activity:     newActivityStore(),
+		telemetry:    newTelemetry(),
+		attachments:  newAttachmentStore(),
+		scripts:      newScriptStore(),
+		visits:       newVisitStore(),
+		snapshots:    newSnapshotStore(),
+		history:      newPromptHistory(),
+		abTests:      newABTestStore(),
+		tools:        newToolRegistry(),
+		changes:      newPendingChanges(),
+		commands:     newCommandConfirmations(),
+		serverTokens: newServerTokenStore(),
+	}
+	a.telemetry.client.Transport = a.audit.wrap(auditSourceTelemetry, "", nil)
+	a.registerBuiltinTools()
+	return a
+}
+
+func (a *App) startup(ctx context.Context) {
+	a.ctx = ctx
+	dir, err := appDataDir()
+	if err != nil {
+		println("Error locating data directory:", err.Error())
+		return
+	}
+	a.dataDir = dir
+	if err := a.loadConfig(filepath.Join(dir, "config.json")); err != nil {
+		println("Error loading config:", err.Error())
+	}
+	if err := a.sessions.open(filepath.Join(dir, "sessions")); err != nil {
+		println("Error loading sessions:", err.Error())
+	}
+	if err := a.requests.open(filepath.Join(dir, "requests.jsonl")); err != nil {
+		println("Error loading request log:", err.Error())
+	}
+	if err := a.httpLog.open(filepath.Join(dir, "http.jsonl")); err != nil {
+		println("Error opening HTTP log:", err.Error())
+	}
+	if err := a.audit.open(filepath.Join(dir, "audit.jsonl")); err != nil {
+		println("Error opening audit log:", err.Error())
+	}
+	if err := a.metrics.open(filepath.Join(dir, "metrics.json")); err != nil {
+		println("Error loading metrics:", err.Error())
+	}
+	if err := a.activity.open(filepath.Join(dir, "activity.json")); err != nil {
+		println("Error loading activity:", err.Error())
+	}
+	if err := a.telemetry.open(filepath.Join(dir, "telemetry.json")); err != nil {
+		println("Error loading telemetry state:", err.Error())
+	}
+	if err := a.attachments.open(filepath.Join(dir, "attachments")); err != nil {
+		println("Error opening attachment store:", err.Error())
+	}
+	if err := a.scripts.open(filepath.Join(dir, "scripts.json")); err != nil {
+		println("Error loading scripts:", err.Error())
+	}
+	if err := a.serverTokens.open(filepath.Join(dir, "server-tokens.json")); err != nil {
+		println("Error loading server tokens:", err.Error())
+	}
+	if err := a.visits.open(filepath.Join(dir, "workspaces.json")); err != nil {
+		println("Error loading workspace visits:", err.Error())
 	}
+	if err := a.snapshots.open(filepath.Join(dir, "snapshots")); err != nil {
+		println("Error opening snapshot store:", err.Error())
+	}
+	if err := a.history.open(filepath.Join(dir, "prompt-history.json")); err != nil {
+		println("Error loading prompt history:", err.Error())
+	}
+	if err := a.abTests.open(filepath.Join(dir, "ab-tests.json")); err != nil {
+		println("Error loading A/B tests:", err.Error())
+	}
+	if err := a.vectors.open(filepath.Join(dir, "vectors.db")); err != nil {
+		println("Error opening vector store:", err.Error())
+	}
+	a.resumeWorkspace()
+	go a.telemetry.maybeSend()
+	go a.maintenanceLoop(ctx)
+
+	// A first launch from a context menu carries its selection as arguments
+	wd, _ := os.Getwd()
+	a.receiveAsk(parseAskArgs(os.Args[1:], wd))
 }
 
-func (a *App) startup(ctx context.Context) {}
+func (a *App) shutdown(ctx context.Context) {
+	a.StopLocalServer()
+	a.StopWatch()
+	a.visits.leave(a.GetWorkspace())
+	a.closePlugins()
+	a.tracing.shutdown()
+	a.telemetry.flush()
+	if err := a.vectors.close(); err != nil {
+		println("Error closing vector store:", err.Error())
+	}
+}
 
-// AddProvider adds a new AI provider
-func (a *App) AddProvider(config ProviderConfig) error {
-	a.providersMutex.Lock()
-	defer a.providersMutex.Unlock()
+// providerTypes are the provider types newProvider can build
+var providerTypes = []string{"Ollama", "OpenAI", "Plugin", "Group", "Mock"}
+
+// canonicalProviderType matches a provider type case-insensitively and
+// returns its canonical spelling
+func canonicalProviderType(t string) (string, error) {
+	for _, known := range providerTypes {
+		if strings.EqualFold(strings.TrimSpace(t), known) {
+			return known, nil
+		}
+	}
+	return "", fmt.Errorf("unknown provider type %q; supported types are %s", t, strings.Join(providerTypes, ", "))
+}
+
+func (a *App) newProvider(config ProviderConfig) (Provider, error) {
+	t, err := canonicalProviderType(config.Type)
+	if err != nil {
+		return nil, err
+	}
+	config.Type = t
+	if _, err := parseProxyURL(config.ProxyURL); err != nil {
+		return nil, err
+	}
+	if _, err := tlsClientConfig(config.TLS); err != nil {
+		return nil, err
+	}
+	if err := validateHeaders(config.Headers); err != nil {
+		return nil, err
+	}
+	if strings.ContainsAny(config.OrganizationID+config.ProjectID, "\r\n") {
+		return nil, fmt.Errorf("organization and project IDs can't contain line breaks")
+	}
+	for _, warning := range providerWarnings(config) {
+		println("Warning:", config.Name+":", warning)
+	}
 
-	var provider Provider
 	switch config.Type {
 	case "Ollama":
-		provider = NewOllamaProvider(config)
-	case "Mock":
-		provider = NewMockProvider(config)
+		p := NewOllamaProvider(config)
+		p.client.Transport = a.tracing.wrap(a.httpLog.wrap(config, p.GetName(), a.audit.wrap(auditSourceProvider, p.GetName(), a.wrapOAuth(config, a.wrapChaos(config, p.client.Transport)))))
+		return p, nil
+	case "OpenAI":
+		p := NewOpenAIProvider(config)
+		p.client.Transport = a.tracing.wrap(a.httpLog.wrap(config, p.GetName(), a.audit.wrap(auditSourceProvider, p.GetName(), a.wrapOAuth(config, a.wrapChaos(config, p.client.Transport)))))
+		return p, nil
+	case "Plugin":
+		return NewPluginProvider(config), nil
+	case "Group":
+		g := NewGroupProvider(config, a.providerByID)
+		g.limit = a.limitMember
+		return g, nil
 	default:
-		// For now, unsupported providers default to Mock
-		provider = NewMockProvider(config)
+		return NewMockProvider(config), nil
 	}
+}
 
-	a.providers = append(a.providers, provider)
+// GetSupportedProviderTypes returns the provider types AddProvider accepts
+func (a *App) GetSupportedProviderTypes() []string {
+	return append([]string(nil), providerTypes...)
+}
+
+// ProviderInfo describes a configured provider
+type ProviderInfo struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Model  string `json:"model"`
+	Active bool   `json:"active"`
+	// Warnings flag settings that weaken the provider's security
+	Warnings []string `json:"warnings,omitempty"`
+	// Embeddings is set for providers that can embed text, as far as is
+	// known; it is cleared once one turns out not to support them
+	Embeddings bool `json:"embeddings,omitempty"`
+}
+
+// AddProvider adds a new AI provider and returns it with its assigned ID
+func (a *App) AddProvider(config ProviderConfig) (ProviderInfo, error) {
+	a.providersMutex.Lock()
+	defer a.providersMutex.Unlock()
+
+	t, err := canonicalProviderType(config.Type)
+	if err != nil {
+		return ProviderInfo{}, err
+	}
+	config.Type = t
+	config.ID = newID()
+	if config.Type == "Group" {
+		if err := a.validateGroupLocked(config); err != nil {
+			return ProviderInfo{}, err
+		}
+	}
+	if err := a.storeAPIKey(&config); err != nil {
+		return ProviderInfo{}, err
+	}
+	if err := a.storeHeaders(&config); err != nil {
+		return ProviderInfo{}, err
+	}
+	p, err := a.newProvider(config)
+	if err != nil {
+		return ProviderInfo{}, err
+	}
+	a.providers = append(a.providers, p)
+	a.telemetry.recordProviderType(config.Type)
 
 	// Set as active if it's the first provider
-	if a.activeProvider == -1 {
-		a.activeProvider = 0
+	if a.activeProvider == "" {
+		a.activeProvider = config.ID
 	}
 
-	return nil
+	return a.providerInfoLocked(p), a.saveConfigLocked()
 }
 
-// ListProviders returns names of all configured providers
-func (a *App) ListProviders() []string {
+// ListProviders returns all configured providers
+func (a *App) ListProviders() []ProviderInfo {
 	a.providersMutex.RLock()
 	defer a.providersMutex.RUnlock()
 
-	names := make([]string, len(a.providers))
+	infos := make([]ProviderInfo, len(a.providers))
 	for i, p := range a.providers {
-		names[i] = p.GetName()
+		infos[i] = a.providerInfoLocked(p)
 	}
-	return names
+	return infos
 }
 
-// SetActiveProvider sets the active provider by index
-func (a *App) SetActiveProvider(index int) error {
+// SetActiveProvider sets the active provider by ID
+func (a *App) SetActiveProvider(id string) error {
 	a.providersMutex.Lock()
 	defer a.providersMutex.Unlock()
 
-	if index < 0 || index >= len(a.providers) {
-		return fmt.Errorf("invalid provider index")
+	if a.providerIndexLocked(id) == -1 {
+		return fmt.Errorf("provider %q not found", id)
 	}
 
-	a.activeProvider = index
-	return nil
+	a.activeProvider = id
+	return a.saveConfigLocked()
 }
 
-// SendPrompt sends a prompt to the active AI provider
-func (a *App) SendPrompt(prompt string) (string, error) {
+// RemoveProvider deletes a provider and its stored API key. If it was the
+// active provider, the first remaining one becomes active.
+func (a *App) RemoveProvider(id string) error {
+	a.providersMutex.Lock()
+	defer a.providersMutex.Unlock()
+
+	i := a.providerIndexLocked(id)
+	if i == -1 {
+		return fmt.Errorf("provider %q not found", id)
+	}
+	if c, ok := a.providers[i].(io.Closer); ok {
+		c.Close()
+	}
+	if ref := a.providers[i].GetConfig().APIKeyRef; ref != "" {
+		if err := a.secrets.Delete(ref); err != nil {
+			println("Error deleting API key:", err.Error())
+		}
+	}
+	if ref := a.providers[i].GetConfig().HeadersRef; ref != "" {
+		if err := a.secrets.Delete(ref); err != nil {
+			println("Error deleting headers:", err.Error())
+		}
+	}
+	if err := a.SignOut(id); err != nil {
+		println("Error deleting sign-in:", err.Error())
+	}
+	a.providers = append(a.providers[:i], a.providers[i+1:]...)
+	for j, fid := range a.fallbackProviders {
+		if fid == id {
+			a.fallbackProviders = append(a.fallbackProviders[:j], a.fallbackProviders[j+1:]...)
+			break
+		}
+	}
+	for j, cid := range a.cleanRoomProviders {
+		if cid == id {
+			a.cleanRoomProviders = append(a.cleanRoomProviders[:j], a.cleanRoomProviders[j+1:]...)
+			break
+		}
+	}
+
+	if a.fastProvider == id {
+		a.fastProvider = ""
+	}
+	a.limitersMutex.Lock()
+	delete(a.limiters, id)
+	a.limitersMutex.Unlock()
+	a.queuesMutex.Lock()
+	delete(a.queues, id)
+	a.queuesMutex.Unlock()
+	a.responses.purge(func(e CachedResponse) bool { return e.ProviderID == id })
+	if a.activeProvider == id {
+		a.activeProvider = ""
+		if len(a.providers) > 0 {
+			a.activeProvider = a.providers[0].GetConfig().ID
+		}
+	}
+	return a.saveConfigLocked()
+}
+
+// SetFallbackProviders sets the ordered list of provider IDs that SendPrompt
+// retries on when the active provider errors or times out
+func (a *App) SetFallbackProviders(ids []string) error {
+	a.providersMutex.Lock()
+	defer a.providersMutex.Unlock()
+
+	seen := make(map[string]bool)
+	chain := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if a.providerIndexLocked(id) == -1 {
+			return fmt.Errorf("provider %q not found", id)
+		}
+		if !seen[id] {
+			seen[id] = true
+			chain = append(chain, id)
+		}
+	}
+	a.fallbackProviders = chain
+	return a.saveConfigLocked()
+}
+
+// GetFallbackProviders returns the fallback chain as provider IDs
+func (a *App) GetFallbackProviders() []string {
+	a.providersMutex.RLock()
+	defer a.providersMutex.RUnlock()
+	return append([]string(nil), a.fallbackProviders...)
+}
+
+// providerIndexLocked returns the position of the provider with id, or -1.
+// The caller must hold providersMutex.
+func (a *App) providerIndexLocked(id string) int {
+	for i, p := range a.providers {
+		if p.GetConfig().ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// providerByID returns the configured provider with id
+func (a *App) providerByID(id string) (Provider, error) {
 	a.providersMutex.RLock()
 	defer a.providersMutex.RUnlock()
 
-	if a.activeProvider == -1 || len(a.providers) == 0 {
-		// No provider configured, return mock response
-		mock := NewMockProvider(ProviderConfig{Name: "Mock"})
-		return mock.SendRequest(prompt, 0.7, 2000)
+	if i := a.providerIndexLocked(id); i != -1 {
+		return a.providers[i], nil
+	}
+	return nil, fmt.Errorf("provider %q not found", id)
+}
+
+// activeProviderLocked returns the active provider, or nil if none is set.
+// The caller must hold providersMutex.
+func (a *App) activeProviderLocked() Provider {
+	if i := a.providerIndexLocked(a.activeProvider); i != -1 {
+		return a.providers[i]
+	}
+	return nil
+}
+
+func (a *App) providerInfoLocked(p Provider) ProviderInfo {
+	config := p.GetConfig()
+	return ProviderInfo{
+		ID:         config.ID,
+		Name:       p.GetName(),
+		Type:       config.Type,
+		Model:      config.Model,
+		Active:     config.ID == a.activeProvider,
+		Warnings:   providerWarnings(config),
+		Embeddings: a.embeddings.canEmbed(p),
+	}
+}
+
+// SendPrompt sends a prompt to the active AI provider and records the
+// exchange in the active session
+func (a *App) SendPrompt(prompt string) (string, error) {
+	a.telemetry.recordFeature("send_prompt")
+	result, err := a.sendPrompt(prompt, sendOptions{}, nil)
+	return result.Response, err
+}
+
+// SendPromptWithOptions is SendPrompt with generation options for this send
+// only; options it leaves unset come from the conversation's persona, then
+// the provider's defaults
+func (a *App) SendPromptWithOptions(prompt string, options GenerationDefaults) (string, error) {
+	a.telemetry.recordFeature("send_prompt_with_options")
+	if err := options.validate(); err != nil {
+		return "", err
+	}
+	result, err := a.sendPrompt(prompt, sendOptions{generation: options}, nil)
+	return result.Response, err
+}
+
+// StreamPrompt is SendPrompt with the reply delivered incrementally as
+// "prompt:chunk" events. A "prompt:done" event carries the final reply, which
+// replaces the streamed text if the reply had to be re-asked or was cut to
+// the target length; "truncated" marks replies ContinueResponse can extend.
+func (a *App) StreamPrompt(prompt string) (string, error) {
+	a.telemetry.recordFeature("stream_prompt")
+	var sessionID string
+	result, err := a.sendPrompt(prompt, sendOptions{}, func(id, chunk string) {
+		sessionID = id
+		a.emit(EventPromptChunk, PromptChunkEvent{SessionID: id, Text: chunk})
+	})
+	done := PromptDoneEvent{SessionID: sessionID, Text: result.Response, Truncated: result.Remainder != ""}
+	if err != nil {
+		done.Error = err.Error()
+		done.Cancelled = errors.Is(err, errCancelled)
 	}
+	a.emit(EventPromptDone, done)
+	return result.Response, err
+}
 
-	provider := a.providers[a.activeProvider]
-	return provider.SendRequest(prompt, 0.7, 2000)
+// sendOptions change how sendPrompt sends a prompt
+type sendOptions struct {
+	// unredacted skips secret redaction
+	unredacted bool
+	// reviewed marks a prompt sent from a pre-send review, and excluded are
+	// the IDs of the context items the user left out
+	reviewed bool
+	excluded []string
+	// schema, when set, asks for a JSON reply matching it
+	schema map[string]interface{}
+	// tools, when set, are tools the model can call while answering
+	tools []registeredTool
+	// files are workspace files sent as context with the prompt
+	files []attachedFile
+	// generation overrides the persona's and provider's generation defaults
+	generation GenerationDefaults
+}
+
+// sendPrompt runs a prompt in the active session, passing streamed chunks to
+// onChunk when it is non-nil
+func (a *App) sendPrompt(prompt string, opts sendOptions, onChunk func(sessionID, chunk string)) (generateResult, error) {
+	sessionID := a.ensureActiveSession(prompt)
+	session, _ := a.sessions.get(sessionID)
+	if session.Sealed != nil {
+		return generateResult{}, errConversationLocked(sessionID)
+	}
+	// Prompts of locked conversations would be readable in the history
+	if !session.Locked {
+		a.history.add(prompt, sessionID)
+	}
+	a.activity.record(a.sessionRoot(sessionID), projectActivity{Prompts: 1})
+
+	intent := classifyPrompt(prompt)
+	userMsg := Message{Role: "user", Content: prompt, Intent: intent.Label, StackTraces: a.ParseStackTrace(prompt)}
+	for _, f := range opts.files {
+		userMsg.Files = append(userMsg.Files, f.PromptFile)
+	}
+	parts := append(a.assembleContext(session, userMsg, ""), fileContext(opts.files, "")...)
+	messages, logs := a.selectContext(parts, opts.excluded)
+	generation := opts.generation.over(a.personaDefaults(session))
+	req := generateRequest{
+		Messages:     append(messages, userMsg),
+		Temperature:  generation.Temperature,
+		MaxTokens:    generation.MaxTokens,
+		TopP:         generation.TopP,
+		TopK:         generation.TopK,
+		Stop:         generation.Stop,
+		Language:     a.sessionLanguage(session),
+		TargetLength: a.GetTargetLength(),
+		Tier:         intent.Tier,
+		Unredacted:   opts.unredacted,
+		Reviewed:     opts.reviewed,
+	}
+	if session.CleanRoom {
+		req.CleanRoom = sessionID
+	}
+	req.Private = session.Locked
+	if onChunk != nil {
+		req.OnChunk = func(chunk string) { onChunk(sessionID, chunk) }
+	}
+	ctx, done := a.trackPrompt(sessionID)
+	defer done()
+	ctx, span := a.tracing.start(ctx, "SendPrompt", trace.SpanKindInternal,
+		attribute.String("vibecoder.session_id", sessionID),
+		attribute.String("vibecoder.intent", intent.Label),
+		attribute.Bool("vibecoder.stream", onChunk != nil),
+	)
+	defer span.End()
+	req.Context = ctx
+	var result generateResult
+	var err error
+	switch {
+	case opts.schema != nil:
+		result, err = a.generateStructured(req, opts.schema)
+	case len(opts.tools) > 0:
+		result, err = a.generateWithTools(req, opts.tools, sessionID)
+	case len(logs) > 0:
+		result, err = a.generateWithLogTools(req, logs)
+	default:
+		result, err = a.generate(req)
+	}
+	if err != nil {
+		endSpan(span, err)
+		return result, err
+	}
+
+	if err := a.sessions.appendMessages(sessionID,
+		userMsg,
+		Message{Role: "assistant", Content: result.Response, Provider: result.Provider, Remainder: result.Remainder},
+	); err != nil {
+		println("Error saving session:", err.Error())
+	}
+	if diffPattern.MatchString(result.Response) {
+		a.activity.record(a.sessionRoot(sessionID), projectActivity{DiffsProposed: 1})
+	}
+	a.maybeAutoSummarize(sessionID)
+	a.maybeCompact(sessionID)
+	a.maybeSuggestSplit(sessionID)
+	a.maybeSuggestFollowUps(sessionID)
+
+	return result, nil
 }
 
 func main() {
@@ -202,9 +965,14 @@ func main() {
 		Height:           800,
 		LogLevel:         logger.INFO,
 		OnStartup:        app.startup,
+		OnShutdown:       app.shutdown,
 		Bind:             []interface{}{app},
 		AssetServer:      &assetserver.Options{Assets: assets},
 		BackgroundColour: &options.RGBA{R: 30, G: 30, B: 30, A: 255},
+		SingleInstanceLock: &options.SingleInstanceLock{
+			UniqueId:               singleInstanceID,
+			OnSecondInstanceLaunch: app.onSecondInstance,
+		},
 	})
 	if err != nil {
 		println("Error:", err.Error())
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// maintenanceInterval is how often scheduled maintenance runs
+	maintenanceInterval = 24 * time.Hour
+	// maintenanceIdle is how long the app must go without requests before scheduled maintenance starts
+	maintenanceIdle = 5 * time.Minute
+	// maintenanceCheckInterval is how often the scheduler looks for an idle window
+	maintenanceCheckInterval = 10 * time.Minute
+	// staleTempAge is how old a leftover temporary file must be before it is removed
+	staleTempAge = time.Hour
+)
+
+// MaintenanceTaskResult reports one maintenance task
+type MaintenanceTaskResult struct {
+	Name           string `json:"name"`
+	ReclaimedBytes int64  `json:"reclaimedBytes"`
+	Detail         string `json:"detail,omitempty"`
+	Error          string `json:"error,omitempty"`
+}
+
+// MaintenanceReport summarizes a maintenance run
+type MaintenanceReport struct {
+	StartedAt      time.Time               `json:"startedAt"`
+	DurationMs     int64                   `json:"durationMs"`
+	ReclaimedBytes int64                   `json:"reclaimedBytes"`
+	Scheduled      bool                    `json:"scheduled"`
+	Tasks          []MaintenanceTaskResult `json:"tasks"`
+}
+
+// maintenanceTask reclaims space or tidies one store, returning the bytes
+// freed and a short description of what it did
+type maintenanceTask struct {
+	name string
+	run  func(a *App) (int64, string, error)
+}
+
+var maintenanceTasks = []maintenanceTask{
+	{"request-log", func(a *App) (int64, string, error) {
+		return a.requests.compact()
+	}},
+	{"attachments", func(a *App) (int64, string, error) {
+		return a.attachments.sweep()
+	}},
+	{"temp-files", func(a *App) (int64, string, error) {
+		return sweepTempFiles(a.dataDir, staleTempAge)
+	}},
+	{"response-cache", (*App).purgeExpiredResponses},
+	{"trash", (*App).purgeExpiredTrash},
+	{"snapshots", func(a *App) (int64, string, error) {
+		return a.snapshots.prune()
+	}},
+	{"vector-store", (*App).compactVectorStore},
+}
+
+// compact rewrites the request log with only the records still kept in
+// memory, rotating out entries older than the last maxRequestRecords
+func (rs *requestStore) compact() (int64, string, error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	if rs.path == "" {
+		return 0, "", nil
+	}
+	info, err := os.Stat(rs.path)
+	if os.IsNotExist(err) {
+		return 0, "", nil
+	} else if err != nil {
+		return 0, "", err
+	}
+
+	var b strings.Builder
+	for _, rec := range rs.records {
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return 0, "", err
+		}
+		b.Write(data)
+		b.WriteByte('\n')
+	}
+	if int64(b.Len()) >= info.Size() {
+		return 0, "already compact", nil
+	}
+	if err := writeFileAtomic(rs.path, []byte(b.String()), 0o600); err != nil {
+		return 0, "", err
+	}
+	return info.Size() - int64(b.Len()), fmt.Sprintf("kept the newest %d records", len(rs.records)), nil
+}
+
+// sweep deletes blobs and interrupted uploads that the index does not
+// reference, such as those left behind by a crash
+func (as *attachmentStore) sweep() (int64, string, error) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	if as.dir == "" {
+		return 0, "", nil
+	}
+	var reclaimed int64
+	removed := 0
+	err := filepath.WalkDir(as.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		name := d.Name()
+		orphan := hashPattern.MatchString(name) && as.index.Refs[name] == 0
+		if !orphan && !strings.HasPrefix(name, ".upload-") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		// An upload in progress holds the lock, so any upload file seen here is abandoned
+		if err := os.Remove(p); err == nil {
+			reclaimed += info.Size()
+			removed++
+		}
+		return nil
+	})
+	return reclaimed, fmt.Sprintf("removed %d unreferenced files", removed), err
+}
+
+// sweepTempFiles removes temporary files left by interrupted atomic writes
+func sweepTempFiles(dir string, olderThan time.Duration) (int64, string, error) {
+	if dir == "" {
+		return 0, "", nil
+	}
+	var reclaimed int64
+	removed := 0
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || !strings.HasPrefix(d.Name(), ".") || !strings.Contains(d.Name(), ".tmp-") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || time.Since(info.ModTime()) < olderThan {
+			return nil
+		}
+		if err := os.Remove(p); err == nil {
+			reclaimed += info.Size()
+			removed++
+		}
+		return nil
+	})
+	return reclaimed, fmt.Sprintf("removed %d temporary files", removed), err
+}
+
+// runMaintenance runs every maintenance task and saves the report. Only one
+// run happens at a time; a run requested while another is in progress waits.
+func (a *App) runMaintenance(scheduled bool) MaintenanceReport {
+	a.maintenanceMutex.Lock()
+	defer a.maintenanceMutex.Unlock()
+
+	report := MaintenanceReport{StartedAt: time.Now(), Scheduled: scheduled, Tasks: []MaintenanceTaskResult{}}
+	for _, task := range maintenanceTasks {
+		reclaimed, detail, err := task.run(a)
+		result := MaintenanceTaskResult{Name: task.name, ReclaimedBytes: reclaimed, Detail: detail}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		report.ReclaimedBytes += reclaimed
+		report.Tasks = append(report.Tasks, result)
+	}
+	report.DurationMs = time.Since(report.StartedAt).Milliseconds()
+
+	a.lastMaintenance = &report
+	if a.dataDir != "" {
+		if err := writeJSONFile(filepath.Join(a.dataDir, "maintenance.json"), report); err != nil {
+			println("Error saving maintenance report:", err.Error())
+		}
+	}
+	a.emit(EventMaintenanceDone, report)
+	return report
+}
+
+// maintenanceLoop runs maintenance once a day, waiting until the app has been
+// idle for maintenanceIdle so it does not compete with requests
+func (a *App) maintenanceLoop(ctx context.Context) {
+	ticker := time.NewTicker(maintenanceCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			last := a.GetMaintenanceReport()
+			if last != nil && time.Since(last.StartedAt) < maintenanceInterval {
+				continue
+			}
+			if time.Since(time.Unix(0, a.lastActivity.Load())) < maintenanceIdle {
+				continue
+			}
+			a.runMaintenance(true)
+		}
+	}
+}
+
+// ManualMaintenance runs all maintenance tasks now and reports the space reclaimed
+func (a *App) ManualMaintenance() MaintenanceReport {
+	a.telemetry.recordFeature("manual_maintenance")
+	return a.runMaintenance(false)
+}
+
+// GetMaintenanceReport returns the report of the last maintenance run, or nil if none has run
+func (a *App) GetMaintenanceReport() *MaintenanceReport {
+	a.maintenanceMutex.Lock()
+	defer a.maintenanceMutex.Unlock()
+
+	if a.lastMaintenance == nil && a.dataDir != "" {
+		var report MaintenanceReport
+		if err := readJSONFile(filepath.Join(a.dataDir, "maintenance.json"), &report); err == nil {
+			a.lastMaintenance = &report
+		}
+	}
+	return a.lastMaintenance
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// memoryMaxChars caps the text of one remembered exchange
+	memoryMaxChars = 2000
+	// memoryRecallDefault and memoryRecallMax bound how many memories a recall returns
+	memoryRecallDefault = 5
+	memoryRecallMax     = 20
+)
+
+// MemoryMatch is an exchange of an earlier conversation found by RecallMemories
+type MemoryMatch struct {
+	SessionID string `json:"sessionId"`
+	Title     string `json:"title"`
+	// MessageIndex is the index of the exchange's user message
+	MessageIndex int     `json:"messageIndex"`
+	Text         string  `json:"text"`
+	Score        float64 `json:"score"`
+}
+
+// memoryNamespace is the vector store namespace of the conversations held
+// in a directory
+func memoryNamespace(dir string) string {
+	return "memory:" + dir
+}
+
+// memoryID is the record ID of the exchange starting at message i; the
+// padding keeps a conversation's exchanges in order
+func memoryID(sessionID string, i int) string {
+	return fmt.Sprintf("%s/%06d", sessionID, i)
+}
+
+// RememberConversation adds each exchange of a conversation, a prompt and
+// its reply, to the memory of its directory, so RecallMemories can find it
+// from other conversations. Exchanges already remembered are skipped, and
+// the number added is returned. Locked conversations can't be remembered.
+func (a *App) RememberConversation(sessionID string) (int, error) {
+	a.telemetry.recordFeature("conversation_memory")
+	s, ok := a.sessions.get(sessionID)
+	if !ok {
+		return 0, fmt.Errorf("session %q not found", sessionID)
+	}
+	if s.Locked {
+		return 0, fmt.Errorf("locked conversations can't be remembered")
+	}
+	provider, err := a.embeddingProvider("")
+	if err != nil {
+		return 0, err
+	}
+	name, model := provider.GetName(), embeddingModel(provider)
+	if s.CleanRoom && !a.cleanRoomAllowed(provider.GetConfig()) {
+		a.auditCleanRoom(cleanRoomBlock, s.ID, name, "embeddings")
+		return 0, errCleanRoom(name)
+	}
+
+	ns := memoryNamespace(a.sessionRoot(s.ID))
+	info, ok, err := a.vectors.namespace(ns)
+	if err != nil {
+		return 0, err
+	}
+	// Memories embedded by another model can't be compared with new ones
+	if ok && (info.Provider != name || info.Model != model) {
+		if err := a.vectors.drop(ns); err != nil {
+			return 0, err
+		}
+	}
+	if err := a.vectors.updateNamespace(ns, func(info *vectorNamespace) {
+		info.Provider, info.Model = name, model
+	}); err != nil {
+		return 0, err
+	}
+	known := make(map[string]bool)
+	if err := a.vectors.scan(ns, false, func(r vectorRecord) error {
+		if r.Meta["session"] == s.ID {
+			known[r.ID] = true
+		}
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+
+	var records []vectorRecord
+	var texts []string
+	for i := 0; i+1 < len(s.Messages); i++ {
+		user, reply := s.Messages[i], s.Messages[i+1]
+		if user.Role != "user" || reply.Role != "assistant" || known[memoryID(s.ID, i)] {
+			continue
+		}
+		if strings.TrimSpace(user.Content) == "" || strings.TrimSpace(reply.Content) == "" {
+			continue
+		}
+		text := truncateRunes("User: "+user.Content+"\n\nAssistant: "+reply.Content, memoryMaxChars)
+		texts = append(texts, text)
+		records = append(records, vectorRecord{
+			ID:   memoryID(s.ID, i),
+			Text: text,
+			Meta: map[string]string{"session": s.ID, "title": s.Title, "message": strconv.Itoa(i)},
+		})
+	}
+	for start := 0; start < len(texts); start += embedBatchSize {
+		end := min(start+embedBatchSize, len(texts))
+		vectors, err := a.embedWith(provider, texts[start:end])
+		if err != nil {
+			return start, err
+		}
+		for j, vec := range vectors {
+			records[start+j].Vector = vec
+		}
+		if err := a.vectors.replace(ns, nil, records[start:end]); err != nil {
+			return start, err
+		}
+	}
+	if err := a.vectors.updateNamespace(ns, func(info *vectorNamespace) {
+		info.UpdatedAt = time.Now().UTC()
+	}); err != nil {
+		return len(records), err
+	}
+	return len(records), nil
+}
+
+// RecallMemories returns the remembered exchanges of other conversations
+// in the active conversation's directory most similar in meaning to query,
+// best first, five when limit is 0. Locked conversations are never recalled.
+func (a *App) RecallMemories(query string, limit int) ([]MemoryMatch, error) {
+	a.telemetry.recordFeature("conversation_memory")
+	if strings.TrimSpace(query) == "" {
+		return nil, fmt.Errorf("the search query is empty")
+	}
+	if limit <= 0 {
+		limit = memoryRecallDefault
+	}
+	limit = min(limit, memoryRecallMax)
+	active := a.GetActiveSession()
+	ns := memoryNamespace(a.sessionRoot(active))
+	info, ok, err := a.vectors.namespace(ns)
+	if err != nil {
+		return nil, err
+	}
+	if !ok || info.Dims == 0 {
+		return []MemoryMatch{}, nil
+	}
+	provider, err := a.embeddingProvider(info.Provider)
+	if err != nil {
+		return nil, err
+	}
+	if provider.GetName() != info.Provider || embeddingModel(provider) != info.Model {
+		return nil, fmt.Errorf("memories were embedded with %s; remember the conversations again to recall them with %s", info.Provider, provider.GetName())
+	}
+	vectors, _, err := a.embed([]string{query}, info.Provider)
+	if err != nil {
+		return nil, err
+	}
+
+	found, err := a.vectors.search(ns, vectors[0], limit, func(r vectorRecord) bool {
+		id := r.Meta["session"]
+		return id != active && !a.sessions.locked(id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	matches := make([]MemoryMatch, len(found))
+	for i, m := range found {
+		index, _ := strconv.Atoi(m.Meta["message"])
+		matches[i] = MemoryMatch{SessionID: m.Meta["session"], Title: m.Meta["title"], MessageIndex: index, Text: m.Text, Score: m.Score}
+	}
+	return matches, nil
+}
+
+// forgetMemories deletes the memories of conversations removed for good or
+// locked
+func (a *App) forgetMemories(purged []Session) {
+	if !a.vectors.opened() {
+		return
+	}
+	for _, s := range purged {
+		if _, err := a.vectors.deletePrefix("memory:", s.ID+"/"); err != nil {
+			println("Error forgetting conversation memories:", err.Error())
+		}
+	}
+}
+
+// ListVectorNamespaces describes the namespaces of the vector store: the
+// code index of each workspace ("code:" and its path) and the conversation
+// memory of each directory ("memory:" and its path)
+func (a *App) ListVectorNamespaces() ([]VectorNamespaceStats, error) {
+	return a.vectors.namespaces("")
+}
+
+// DeleteVectorNamespace deletes a namespace of the vector store with its
+// vectors; the space is reclaimed at the next maintenance
+func (a *App) DeleteVectorNamespace(name string) error {
+	if _, ok, err := a.vectors.namespace(name); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("vector namespace %q not found", name)
+	}
+	return a.vectors.drop(name)
+}
+
+// compactVectorStore is the maintenance task that drops the code indexes
+// of workspaces that no longer exist and rewrites the vector store without
+// the space deleted vectors leave behind
+func (a *App) compactVectorStore() (int64, string, error) {
+	if !a.vectors.opened() {
+		return 0, "", nil
+	}
+	indexes, err := a.vectors.namespaces("code:")
+	if err != nil {
+		return 0, "", err
+	}
+	dropped := 0
+	for _, ns := range indexes {
+		if _, err := os.Stat(strings.TrimPrefix(ns.Name, "code:")); os.IsNotExist(err) {
+			if err := a.vectors.drop(ns.Name); err != nil {
+				return 0, "", err
+			}
+			dropped++
+		}
+	}
+	reclaimed, err := a.vectors.compact()
+	return reclaimed, fmt.Sprintf("dropped %d indexes of missing workspaces", dropped), err
+}
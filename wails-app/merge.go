@@ -0,0 +1,605 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultMergeEngine is the built-in line-based three-way merge
+	defaultMergeEngine = "diff3"
+	// maxMergeCells bounds the line matching table; larger changed regions
+	// are compared as a whole
+	maxMergeCells = 1 << 22
+	// snapshotRetention is how long base snapshots are kept
+	snapshotRetention = 30 * 24 * time.Hour
+)
+
+// Conflict markers written around each conflict in merged content
+const (
+	markerCurrent  = "<<<<<<< current"
+	markerBase     = "||||||| base"
+	markerSplit    = "======="
+	markerProposed = ">>>>>>> proposed"
+)
+
+// mergeRegion is a stretch of merged lines, a conflict when conflict is set
+type mergeRegion struct {
+	lines    []string
+	conflict bool
+	base     []string
+	current  []string
+	proposed []string
+}
+
+// mergeEngine merges the current and proposed versions of a file that both
+// started from base. Lines keep their line endings.
+type mergeEngine interface {
+	merge(base, current, proposed []string) ([]mergeRegion, error)
+}
+
+// mergeEngines are the engines SetMergeEngine can pick
+var mergeEngines = map[string]mergeEngine{
+	"diff3": diff3Engine{},
+	"git":   gitMergeEngine{},
+}
+
+// FileSnapshot is a file's contents when they were given to the model, the
+// base that a proposed edit is merged against
+type FileSnapshot struct {
+	ID        string    `json:"id"`
+	Path      string    `json:"path"`
+	SHA256    string    `json:"sha256"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type storedSnapshot struct {
+	FileSnapshot
+	Content string `json:"content"`
+}
+
+// MergeConflict is a region both the file and the proposed edit changed.
+// StartLine and EndLine (1-based, inclusive) locate its markers in the
+// merged content.
+type MergeConflict struct {
+	Index     int    `json:"index"`
+	StartLine int    `json:"startLine"`
+	EndLine   int    `json:"endLine"`
+	Base      string `json:"base"`
+	Current   string `json:"current"`
+	Proposed  string `json:"proposed"`
+}
+
+// MergeResult is a proposed edit merged into a file. Content is the merged
+// file, with conflict markers around each conflict; Applied reports whether
+// it was written.
+type MergeResult struct {
+	Path       string          `json:"path"`
+	SnapshotID string          `json:"snapshotId"`
+	Engine     string          `json:"engine"`
+	Content    string          `json:"content"`
+	Conflicts  []MergeConflict `json:"conflicts"`
+	Applied    bool            `json:"applied"`
+}
+
+// snapshotStore keeps base snapshots as one JSON file each in dir, or in
+// memory when no directory is configured
+type snapshotStore struct {
+	mu     sync.Mutex
+	dir    string
+	memory map[string]storedSnapshot
+}
+
+func newSnapshotStore() *snapshotStore {
+	return &snapshotStore{memory: make(map[string]storedSnapshot)}
+}
+
+func (ss *snapshotStore) open(dir string) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	ss.dir = dir
+	return nil
+}
+
+func (ss *snapshotStore) put(s storedSnapshot) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if ss.dir == "" {
+		ss.memory[s.ID] = s
+		return nil
+	}
+	return writeJSONFile(filepath.Join(ss.dir, s.ID+".json"), s)
+}
+
+func (ss *snapshotStore) get(id string) (storedSnapshot, error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if ss.dir == "" {
+		s, ok := ss.memory[id]
+		if !ok {
+			return s, fmt.Errorf("snapshot %q not found", id)
+		}
+		return s, nil
+	}
+	var s storedSnapshot
+	if filepath.Base(id) != id || readJSONFile(filepath.Join(ss.dir, id+".json"), &s) != nil {
+		return s, fmt.Errorf("snapshot %q not found", id)
+	}
+	return s, nil
+}
+
+// prune removes snapshots older than snapshotRetention
+func (ss *snapshotStore) prune() (int64, string, error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if ss.dir == "" {
+		return 0, "", nil
+	}
+	entries, err := os.ReadDir(ss.dir)
+	if err != nil {
+		return 0, "", err
+	}
+	var freed int64
+	removed := 0
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || time.Since(info.ModTime()) < snapshotRetention {
+			continue
+		}
+		if os.Remove(filepath.Join(ss.dir, e.Name())) == nil {
+			freed += info.Size()
+			removed++
+		}
+	}
+	return freed, fmt.Sprintf("removed %d expired snapshots", removed), nil
+}
+
+// splitLines splits s after each newline, keeping the line endings
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// matchLines returns, for each line of a, the index of the line of b it is
+// matched with by a longest common subsequence, or -1
+func matchLines(a, b []string) []int {
+	match := make([]int, len(a))
+	for i := range match {
+		match[i] = -1
+	}
+	// Common prefixes and suffixes match without the table
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		match[pre] = pre
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		match[len(a)-1-suf] = len(b) - 1 - suf
+		suf++
+	}
+	ma, mb := a[pre:len(a)-suf], b[pre:len(b)-suf]
+	n, m := len(ma), len(mb)
+	if n == 0 || m == 0 || (n+1)*(m+1) > maxMergeCells {
+		return match
+	}
+
+	// lcs[i][j] is the LCS length of ma[i:] and mb[j:]
+	lcs := make([]int32, (n+1)*(m+1))
+	at := func(i, j int) int32 { return lcs[i*(m+1)+j] }
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			v := max(at(i+1, j), at(i, j+1))
+			if ma[i] == mb[j] {
+				v = at(i+1, j+1) + 1
+			}
+			lcs[i*(m+1)+j] = v
+		}
+	}
+	for i, j := 0, 0; i < n && j < m; {
+		switch {
+		case ma[i] == mb[j]:
+			match[pre+i] = pre + j
+			i++
+			j++
+		case at(i+1, j) >= at(i, j+1):
+			i++
+		default:
+			j++
+		}
+	}
+	return match
+}
+
+// diff3Engine is a line-based three-way merge in the manner of diff3: lines
+// of base kept by both sides anchor the merge, and each stretch between
+// anchors takes whichever side changed it, or is a conflict when both did
+// so differently
+type diff3Engine struct{}
+
+func (diff3Engine) merge(base, current, proposed []string) ([]mergeRegion, error) {
+	mc, mp := matchLines(base, current), matchLines(base, proposed)
+	var regions []mergeRegion
+	stable := func(line string) {
+		if n := len(regions); n > 0 && !regions[n-1].conflict {
+			regions[n-1].lines = append(regions[n-1].lines, line)
+			return
+		}
+		regions = append(regions, mergeRegion{lines: []string{line}})
+	}
+	chunk := func(b, c, p []string) {
+		switch {
+		case equalLines(c, b):
+			for _, l := range p {
+				stable(l)
+			}
+		case equalLines(p, b), equalLines(c, p):
+			for _, l := range c {
+				stable(l)
+			}
+		default:
+			regions = append(regions, mergeRegion{conflict: true, base: b, current: c, proposed: p})
+		}
+	}
+
+	o, c, p := 0, 0, 0
+	for {
+		k := o
+		for k < len(base) && (mc[k] < c || mp[k] < p) {
+			k++
+		}
+		if k == len(base) {
+			chunk(base[o:], current[c:], proposed[p:])
+			return regions, nil
+		}
+		if k == o && mc[k] == c && mp[k] == p {
+			stable(base[k])
+			o, c, p = o+1, c+1, p+1
+			continue
+		}
+		chunk(base[o:k], current[c:mc[k]], proposed[p:mp[k]])
+		o, c, p = k, mc[k], mp[k]
+	}
+}
+
+// gitMergeEngine merges with git merge-file, for its diff algorithms and
+// the same results as merges in git itself
+type gitMergeEngine struct{}
+
+func (gitMergeEngine) merge(base, current, proposed []string) ([]mergeRegion, error) {
+	dir, err := os.MkdirTemp("", "vibe-merge-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	names := []string{"current", "base", "proposed"}
+	for i, lines := range [][]string{current, base, proposed} {
+		if err := os.WriteFile(filepath.Join(dir, names[i]), []byte(strings.Join(lines, "")), 0o600); err != nil {
+			return nil, err
+		}
+	}
+	cmd := exec.Command("git", "merge-file", "-p", "--diff3", "-L", "current", "-L", "base", "-L", "proposed", "current", "base", "proposed")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	// merge-file exits with the number of conflicts
+	if exit, ok := err.(*exec.ExitError); err != nil && (!ok || exit.ExitCode() < 0 || exit.ExitCode() > 127) {
+		return nil, fmt.Errorf("git merge-file: %v", err)
+	}
+	return parseConflictMarkers(splitLines(string(out))), nil
+}
+
+// parseConflictMarkers splits diff3-style merged lines into regions
+func parseConflictMarkers(lines []string) []mergeRegion {
+	var regions []mergeRegion
+	var cur *mergeRegion
+	section := 0
+	for _, line := range lines {
+		trimmed := strings.TrimRight(line, "\r\n")
+		switch {
+		case strings.HasPrefix(trimmed, "<<<<<<< "):
+			regions = append(regions, mergeRegion{conflict: true})
+			cur, section = &regions[len(regions)-1], 1
+			continue
+		case cur != nil && strings.HasPrefix(trimmed, "||||||| "):
+			section = 2
+			continue
+		case cur != nil && trimmed == markerSplit:
+			section = 3
+			continue
+		case cur != nil && strings.HasPrefix(trimmed, ">>>>>>> "):
+			cur = nil
+			continue
+		}
+		if cur == nil {
+			if n := len(regions); n > 0 && !regions[n-1].conflict {
+				regions[n-1].lines = append(regions[n-1].lines, line)
+			} else {
+				regions = append(regions, mergeRegion{lines: []string{line}})
+			}
+			continue
+		}
+		switch section {
+		case 1:
+			cur.current = append(cur.current, line)
+		case 2:
+			cur.base = append(cur.base, line)
+		case 3:
+			cur.proposed = append(cur.proposed, line)
+		}
+	}
+	return regions
+}
+
+// renderMerge writes merged regions out with conflict markers, returning the
+// content and where each conflict is in it
+func renderMerge(regions []mergeRegion) (string, []MergeConflict) {
+	var b strings.Builder
+	var conflicts []MergeConflict
+	line := 1
+	write := func(s string) {
+		b.WriteString(s)
+		if !strings.HasSuffix(s, "\n") {
+			b.WriteString("\n")
+		}
+		line++
+	}
+	for _, r := range regions {
+		if !r.conflict {
+			for _, l := range r.lines {
+				b.WriteString(l)
+				line++
+			}
+			continue
+		}
+		// A last line without a newline still needs one before a marker
+		if s := b.String(); s != "" && !strings.HasSuffix(s, "\n") {
+			b.WriteString("\n")
+		}
+		c := MergeConflict{
+			Index:     len(conflicts),
+			StartLine: line,
+			Base:      strings.Join(r.base, ""),
+			Current:   strings.Join(r.current, ""),
+			Proposed:  strings.Join(r.proposed, ""),
+		}
+		write(markerCurrent)
+		for _, l := range r.current {
+			write(l)
+		}
+		write(markerBase)
+		for _, l := range r.base {
+			write(l)
+		}
+		write(markerSplit)
+		for _, l := range r.proposed {
+			write(l)
+		}
+		c.EndLine = line
+		write(markerProposed)
+		conflicts = append(conflicts, c)
+	}
+	return b.String(), conflicts
+}
+
+// stripCodeFence removes a code fence the model put around its whole reply
+func stripCodeFence(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	if len(lines) >= 2 && fencePattern.MatchString(lines[0]) && fencePattern.MatchString(strings.TrimSpace(lines[len(lines)-1])) {
+		lines = lines[1 : len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+func hashContent(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// SnapshotFile records a workspace file's current contents as the base of
+// edits the model proposes to it, so ApplyEdit can merge them with changes
+// made to the file in the meantime
+func (a *App) SnapshotFile(path string) (FileSnapshot, error) {
+	target, err := a.sandboxedPath("", path)
+	if err != nil {
+		return FileSnapshot{}, err
+	}
+	data, err := os.ReadFile(target)
+	if err != nil {
+		return FileSnapshot{}, err
+	}
+	s := storedSnapshot{
+		FileSnapshot: FileSnapshot{
+			ID:        newID(),
+			Path:      filepath.ToSlash(path),
+			SHA256:    hashContent(string(data)),
+			CreatedAt: time.Now().UTC(),
+		},
+		Content: string(data),
+	}
+	if err := a.snapshots.put(s); err != nil {
+		return FileSnapshot{}, fmt.Errorf("save snapshot: %v", err)
+	}
+	return s.FileSnapshot, nil
+}
+
+// ApplyEdit applies proposed, the model's new version of a snapshotted file.
+// When the file changed since the snapshot, the edit is merged three-way
+// with the snapshot as the base; a clean merge is written, while a merge
+// with conflicts is returned without touching the file, for ResolveConflict
+// and SaveMerge.
+func (a *App) ApplyEdit(snapshotID string, proposed string) (MergeResult, error) {
+	a.telemetry.recordFeature("apply_edit")
+	snap, err := a.snapshots.get(snapshotID)
+	if err != nil {
+		return MergeResult{}, err
+	}
+	target, err := a.sandboxedPath("", snap.Path)
+	if err != nil {
+		return MergeResult{}, err
+	}
+	data, err := os.ReadFile(target)
+	if err != nil {
+		return MergeResult{}, err
+	}
+	name := a.GetMergeEngine()
+	result := MergeResult{Path: snap.Path, SnapshotID: snapshotID, Engine: name, Content: proposed}
+	if current := string(data); current != snap.Content {
+		regions, err := mergeEngines[name].merge(splitLines(snap.Content), splitLines(current), splitLines(proposed))
+		if err != nil {
+			return MergeResult{}, err
+		}
+		result.Content, result.Conflicts = renderMerge(regions)
+	}
+	if len(result.Conflicts) > 0 {
+		a.emit(EventEditConflicts, EditConflictsEvent{Path: result.Path, Conflicts: len(result.Conflicts)})
+		return result, nil
+	}
+	if err := writeFileAtomic(target, []byte(result.Content), 0o644); err != nil {
+		return MergeResult{}, fmt.Errorf("write %s: %v", snap.Path, err)
+	}
+	result.Applied = true
+	return result, nil
+}
+
+const conflictInstructions = `Resolve this merge conflict in %s. The file was edited in two ways from the same base version.
+Combine both edits so that neither side's intent is lost. Reply with only the resolved lines: no code fences, markers or commentary.
+
+Base version:
+%s
+Current version (edited in the file):
+%s
+Proposed version (the suggested edit):
+%s`
+
+// ResolveConflict asks the model to resolve one conflict of a merge, giving
+// it the base and both sides, and returns the merge with the resolution in
+// place of the conflict markers
+func (a *App) ResolveConflict(result MergeResult, index int) (MergeResult, error) {
+	a.telemetry.recordFeature("resolve_conflict")
+	if index < 0 || index >= len(result.Conflicts) {
+		return result, fmt.Errorf("conflict %d not found", index)
+	}
+	c := result.Conflicts[index]
+	temperature := 0.2
+	reply, err := a.generate(generateRequest{
+		Prompt:      fmt.Sprintf(conflictInstructions, result.Path, c.Base, c.Current, c.Proposed),
+		Temperature: &temperature,
+	})
+	if err != nil {
+		return result, fmt.Errorf("resolve conflict: %v", err)
+	}
+	resolved := stripCodeFence(reply.Response)
+	if resolved != "" && !strings.HasSuffix(resolved, "\n") {
+		resolved += "\n"
+	}
+
+	lines := splitLines(result.Content)
+	if c.StartLine < 1 || c.EndLine > len(lines) || strings.TrimRight(lines[c.StartLine-1], "\r\n") != markerCurrent {
+		return result, fmt.Errorf("conflict %d is no longer at lines %d-%d", index, c.StartLine, c.EndLine)
+	}
+	content := strings.Join(lines[:c.StartLine-1], "") + resolved + strings.Join(lines[c.EndLine:], "")
+	shift := len(splitLines(resolved)) - (c.EndLine - c.StartLine + 1)
+
+	next := result
+	next.Content = content
+	next.Conflicts = nil
+	for _, other := range result.Conflicts {
+		switch {
+		case other.Index == index:
+			continue
+		case other.StartLine > c.EndLine:
+			other.StartLine += shift
+			other.EndLine += shift
+		}
+		other.Index = len(next.Conflicts)
+		next.Conflicts = append(next.Conflicts, other)
+	}
+	sort.Slice(next.Conflicts, func(i, j int) bool { return next.Conflicts[i].StartLine < next.Conflicts[j].StartLine })
+	return next, nil
+}
+
+// SaveMerge writes a merge once its conflicts are resolved, whether by
+// ResolveConflict or by editing its content
+func (a *App) SaveMerge(result MergeResult) (MergeResult, error) {
+	for _, line := range splitLines(result.Content) {
+		switch strings.TrimRight(line, "\r\n") {
+		case markerCurrent, markerBase, markerProposed:
+			return result, fmt.Errorf("%s still has conflict markers", result.Path)
+		}
+	}
+	target, err := a.sandboxedPath("", result.Path)
+	if err != nil {
+		return result, err
+	}
+	if err := writeFileAtomic(target, []byte(result.Content), 0o644); err != nil {
+		return result, fmt.Errorf("write %s: %v", result.Path, err)
+	}
+	result.Conflicts = nil
+	result.Applied = true
+	return result, nil
+}
+
+// SetMergeEngine picks the engine ApplyEdit merges with: "diff3", built in,
+// or "git", which runs git merge-file
+func (a *App) SetMergeEngine(name string) error {
+	if _, ok := mergeEngines[name]; !ok {
+		names := make([]string, 0, len(mergeEngines))
+		for n := range mergeEngines {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown merge engine %q; use %s", name, strings.Join(names, " or "))
+	}
+	if name == "git" {
+		if _, err := exec.LookPath("git"); err != nil {
+			return fmt.Errorf("the git merge engine needs git installed")
+		}
+	}
+	a.providersMutex.Lock()
+	defer a.providersMutex.Unlock()
+	a.mergeEngine = name
+	return a.saveConfigLocked()
+}
+
+// GetMergeEngine returns the engine ApplyEdit merges with
+func (a *App) GetMergeEngine() string {
+	a.providersMutex.RLock()
+	defer a.providersMutex.RUnlock()
+	if _, ok := mergeEngines[a.mergeEngine]; !ok {
+		return defaultMergeEngine
+	}
+	return a.mergeEngine
+}
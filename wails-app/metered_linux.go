@@ -0,0 +1,35 @@
+package main
+
+import "github.com/godbus/dbus/v5"
+
+// NetworkManager's NMMetered values
+const (
+	nmMeteredYes      = 1
+	nmMeteredNo       = 2
+	nmMeteredGuessYes = 3
+	nmMeteredGuessNo  = 4
+)
+
+// osMeteredConnection asks NetworkManager whether the primary connection is
+// metered. ok is false when NetworkManager is not available.
+func osMeteredConnection() (metered bool, ok bool) {
+	conn, err := dbus.ConnectSystemBus()
+	if err != nil {
+		return false, false
+	}
+	defer conn.Close()
+
+	obj := conn.Object("org.freedesktop.NetworkManager", "/org/freedesktop/NetworkManager")
+	v, err := obj.GetProperty("org.freedesktop.NetworkManager.Metered")
+	if err != nil {
+		return false, false
+	}
+	state, _ := v.Value().(uint32)
+	switch state {
+	case nmMeteredYes, nmMeteredGuessYes:
+		return true, true
+	case nmMeteredNo, nmMeteredGuessNo:
+		return false, true
+	}
+	return false, false
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+// osMeteredConnection is not implemented on this platform; automatic
+// low-data mode never engages
+func osMeteredConnection() (metered bool, ok bool) {
+	return false, false
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// metricsRetentionDays is how many daily aggregates are kept
+	metricsRetentionDays = 90
+	// latencyBucketBase is the upper bound of the first latency bucket; each
+	// following bucket doubles it, up to about 14 minutes
+	latencyBucketBase = 25 * time.Millisecond
+	latencyBuckets    = 16
+)
+
+// ProviderMetrics summarizes the requests one provider served over a period
+type ProviderMetrics struct {
+	ProviderID string  `json:"providerId"`
+	Provider   string  `json:"provider"`
+	Model      string  `json:"model"`
+	Requests   int64   `json:"requests"`
+	Errors     int64   `json:"errors"`
+	ErrorRate  float64 `json:"errorRate"`
+	Cancelled  int64   `json:"cancelled"`
+	// Stalls counts replies the watchdog reported silent
+	Stalls    int64 `json:"stalls"`
+	StalledMs int64 `json:"stalledMs"`
+	P50Ms     int64 `json:"p50Ms"`
+	P95Ms     int64 `json:"p95Ms"`
+	AverageMs int64 `json:"averageMs"`
+	TokensIn  int64 `json:"tokensIn"`
+	TokensOut int64 `json:"tokensOut"`
+	// CostUSD is the estimated charge of hosted models, in US dollars
+	CostUSD float64 `json:"costUsd"`
+	// Daily breaks the totals down by local date, oldest first
+	Daily []DailyMetrics `json:"daily,omitempty"`
+}
+
+// DailyMetrics is one provider's aggregate for one day
+type DailyMetrics struct {
+	Date      string  `json:"date"`
+	Requests  int64   `json:"requests"`
+	Errors    int64   `json:"errors"`
+	Stalls    int64   `json:"stalls"`
+	P50Ms     int64   `json:"p50Ms"`
+	P95Ms     int64   `json:"p95Ms"`
+	TokensIn  int64   `json:"tokensIn"`
+	TokensOut int64   `json:"tokensOut"`
+	CostUSD   float64 `json:"costUsd"`
+}
+
+// metricsAggregate accumulates requests. Latencies go into exponential
+// buckets so percentiles can be estimated from persisted days as well as
+// the current one.
+type metricsAggregate struct {
+	Provider  string  `json:"provider"`
+	Model     string  `json:"model"`
+	Requests  int64   `json:"requests"`
+	Errors    int64   `json:"errors"`
+	Cancelled int64   `json:"cancelled,omitempty"`
+	Stalls    int64   `json:"stalls,omitempty"`
+	StalledMs int64   `json:"stalledMs,omitempty"`
+	TokensIn  int64   `json:"tokensIn"`
+	TokensOut int64   `json:"tokensOut"`
+	CostUSD   float64 `json:"costUsd,omitempty"`
+	// LatencyMs is the summed latency of successful requests
+	LatencyMs int64                 `json:"latencyMs"`
+	Latency   [latencyBuckets]int64 `json:"latency"`
+}
+
+func latencyBucket(d time.Duration) int {
+	bound := latencyBucketBase
+	for i := 0; i < latencyBuckets-1; i++ {
+		if d <= bound {
+			return i
+		}
+		bound *= 2
+	}
+	return latencyBuckets - 1
+}
+
+func (m *metricsAggregate) merge(o *metricsAggregate) {
+	m.Provider, m.Model = o.Provider, o.Model
+	m.Requests += o.Requests
+	m.Errors += o.Errors
+	m.Cancelled += o.Cancelled
+	m.Stalls += o.Stalls
+	m.StalledMs += o.StalledMs
+	m.TokensIn += o.TokensIn
+	m.TokensOut += o.TokensOut
+	m.CostUSD += o.CostUSD
+	m.LatencyMs += o.LatencyMs
+	for i, n := range o.Latency {
+		m.Latency[i] += n
+	}
+}
+
+// percentile estimates the latency below which fraction q of successful
+// requests completed, interpolating within the bucket it falls in
+func (m *metricsAggregate) percentile(q float64) int64 {
+	var total int64
+	for _, n := range m.Latency {
+		total += n
+	}
+	if total == 0 {
+		return 0
+	}
+	rank := q * float64(total)
+	var seen int64
+	lower, upper := time.Duration(0), latencyBucketBase
+	for _, n := range m.Latency {
+		if n > 0 && float64(seen+n) >= rank {
+			frac := (rank - float64(seen)) / float64(n)
+			return (lower + time.Duration(frac*float64(upper-lower))).Milliseconds()
+		}
+		seen += n
+		lower, upper = upper, upper*2
+	}
+	return lower.Milliseconds()
+}
+
+// metricsStore keeps per-provider aggregates by day in memory and persists
+// them to a JSON file when a path is configured
+type metricsStore struct {
+	mu   sync.Mutex
+	path string
+	// days maps a local date to aggregates by provider ID
+	days map[string]map[string]*metricsAggregate
+}
+
+func newMetricsStore() *metricsStore {
+	return &metricsStore{days: make(map[string]map[string]*metricsAggregate)}
+}
+
+func (ms *metricsStore) open(path string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.path = path
+	var days map[string]map[string]*metricsAggregate
+	if err := readJSONFile(path, &days); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for date, providers := range days {
+		for id, agg := range providers {
+			ms.dayLocked(date, id).merge(agg)
+		}
+	}
+	return nil
+}
+
+func (ms *metricsStore) dayLocked(date, providerID string) *metricsAggregate {
+	providers, ok := ms.days[date]
+	if !ok {
+		providers = make(map[string]*metricsAggregate)
+		ms.days[date] = providers
+	}
+	agg, ok := providers[providerID]
+	if !ok {
+		agg = &metricsAggregate{}
+		providers[providerID] = agg
+	}
+	return agg
+}
+
+// record adds one finished request. Latency excludes time spent queued for
+// rate limits or concurrency, so it reflects how fast the provider is.
+func (ms *metricsStore) record(rec RequestRecord, tokensIn, tokensOut int, cancelled bool) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	agg := ms.dayLocked(rec.Timestamp.Local().Format("2006-01-02"), rec.ProviderID)
+	agg.Provider, agg.Model = rec.Provider, rec.Model
+	switch {
+	case cancelled:
+		agg.Cancelled++
+	case rec.Error != "":
+		agg.Requests++
+		agg.Errors++
+	default:
+		latency := time.Duration(rec.DurationMs-rec.QueuedMs) * time.Millisecond
+		agg.Requests++
+		agg.LatencyMs += latency.Milliseconds()
+		agg.Latency[latencyBucket(latency)]++
+	}
+	agg.Stalls += int64(rec.Stalls)
+	agg.StalledMs += rec.StalledMs
+	agg.TokensIn += int64(tokensIn)
+	agg.TokensOut += int64(tokensOut)
+	agg.CostUSD += rec.CostUSD
+
+	cutoff := time.Now().AddDate(0, 0, -metricsRetentionDays).Format("2006-01-02")
+	for date := range ms.days {
+		if date < cutoff {
+			delete(ms.days, date)
+		}
+	}
+	if ms.path != "" {
+		if err := writeJSONFile(ms.path, ms.days); err != nil {
+			println("Error saving metrics:", err.Error())
+		}
+	}
+}
+
+// report aggregates the days on or after since by provider
+func (ms *metricsStore) report(since string) []ProviderMetrics {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	totals := make(map[string]*metricsAggregate)
+	daily := make(map[string][]DailyMetrics)
+	for date, providers := range ms.days {
+		if date < since {
+			continue
+		}
+		for id, agg := range providers {
+			if totals[id] == nil {
+				totals[id] = &metricsAggregate{}
+			}
+			totals[id].merge(agg)
+			daily[id] = append(daily[id], DailyMetrics{
+				Date:      date,
+				Requests:  agg.Requests,
+				Errors:    agg.Errors,
+				Stalls:    agg.Stalls,
+				P50Ms:     agg.percentile(0.5),
+				P95Ms:     agg.percentile(0.95),
+				TokensIn:  agg.TokensIn,
+				TokensOut: agg.TokensOut,
+				CostUSD:   agg.CostUSD,
+			})
+		}
+	}
+
+	out := make([]ProviderMetrics, 0, len(totals))
+	for id, agg := range totals {
+		m := ProviderMetrics{
+			ProviderID: id,
+			Provider:   agg.Provider,
+			Model:      agg.Model,
+			Requests:   agg.Requests,
+			Errors:     agg.Errors,
+			Cancelled:  agg.Cancelled,
+			Stalls:     agg.Stalls,
+			StalledMs:  agg.StalledMs,
+			P50Ms:      agg.percentile(0.5),
+			P95Ms:      agg.percentile(0.95),
+			TokensIn:   agg.TokensIn,
+			TokensOut:  agg.TokensOut,
+			CostUSD:    agg.CostUSD,
+			Daily:      daily[id],
+		}
+		if agg.Requests > 0 {
+			m.ErrorRate = float64(agg.Errors) / float64(agg.Requests)
+		}
+		if ok := agg.Requests - agg.Errors; ok > 0 {
+			m.AverageMs = agg.LatencyMs / ok
+		}
+		sort.Slice(m.Daily, func(i, j int) bool { return m.Daily[i].Date < m.Daily[j].Date })
+		out = append(out, m)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Requests > out[j].Requests })
+	return out
+}
+
+// costSince sums the estimated cost of the days on or after since
+func (ms *metricsStore) costSince(since string) float64 {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var total float64
+	for date, providers := range ms.days {
+		if date < since {
+			continue
+		}
+		for _, agg := range providers {
+			total += agg.CostUSD
+		}
+	}
+	return total
+}
+
+// GetMetrics reports latency percentiles, token usage and error rates for
+// each provider over the last days days, today included (days <= 0 means
+// today only). Cached replies are not counted.
+func (a *App) GetMetrics(days int) []ProviderMetrics {
+	if days <= 0 {
+		days = 1
+	}
+	since := time.Now().AddDate(0, 0, 1-days).Format("2006-01-02")
+	return a.metrics.report(since)
+}
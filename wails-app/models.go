@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// ModelInfo describes a model offered by a provider's backend
+type ModelInfo struct {
+	Name          string    `json:"name"`
+	Size          int64     `json:"size,omitempty"`
+	Family        string    `json:"family,omitempty"`
+	ParameterSize string    `json:"parameterSize,omitempty"`
+	Quantization  string    `json:"quantization,omitempty"`
+	ModifiedAt    time.Time `json:"modifiedAt,omitempty"`
+}
+
+// ModelLister is implemented by providers that can enumerate their models
+type ModelLister interface {
+	ListModels() ([]ModelInfo, error)
+}
+
+// ListModels returns the models installed in Ollama, from /api/tags
+func (p *OllamaProvider) ListModels() ([]ModelInfo, error) {
+	resp, err := p.client.Get(fmt.Sprintf("%s/api/tags", p.config.Endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("network error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(body))
+	}
+
+	var result struct {
+		Models []struct {
+			Name       string    `json:"name"`
+			Size       int64     `json:"size"`
+			ModifiedAt time.Time `json:"modified_at"`
+			Details    struct {
+				Family            string `json:"family"`
+				ParameterSize     string `json:"parameter_size"`
+				QuantizationLevel string `json:"quantization_level"`
+			} `json:"details"`
+		} `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("invalid response: %v", err)
+	}
+
+	models := make([]ModelInfo, len(result.Models))
+	for i, m := range result.Models {
+		models[i] = ModelInfo{
+			Name:          m.Name,
+			Size:          m.Size,
+			Family:        m.Details.Family,
+			ParameterSize: m.Details.ParameterSize,
+			Quantization:  m.Details.QuantizationLevel,
+			ModifiedAt:    m.ModifiedAt,
+		}
+	}
+	return models, nil
+}
+
+// ListModels returns the models available to the API key, from /models
+func (p *OpenAIProvider) ListModels() ([]ModelInfo, error) {
+	req, err := p.newRequest(http.MethodGet, "/models", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("network error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(body))
+	}
+
+	var result struct {
+		Data []struct {
+			ID      string `json:"id"`
+			Created int64  `json:"created"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("invalid response: %v", err)
+	}
+
+	models := make([]ModelInfo, len(result.Data))
+	for i, m := range result.Data {
+		models[i] = ModelInfo{Name: m.ID}
+		if m.Created > 0 {
+			models[i].ModifiedAt = time.Unix(m.Created, 0)
+		}
+	}
+	return models, nil
+}
+
+// ListModels returns the configured mock model
+func (p *MockProvider) ListModels() ([]ModelInfo, error) {
+	name := p.config.Model
+	if name == "" {
+		name = "mock-model-v1"
+	}
+	return []ModelInfo{{Name: name}}, nil
+}
+
+// ListModels queries a provider's backend for the models it offers, sorted by name
+func (a *App) ListModels(providerID string) ([]ModelInfo, error) {
+	p, err := a.providerByID(providerID)
+	if err != nil {
+		return nil, err
+	}
+	lister, ok := p.(ModelLister)
+	if !ok {
+		return nil, fmt.Errorf("provider %s cannot list models", p.GetName())
+	}
+
+	models, err := lister.ListModels()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(models, func(i, j int) bool {
+		return models[i].Name < models[j].Name
+	})
+	return models, nil
+}
@@ -0,0 +1,557 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+const (
+	// oauthRefreshMargin refreshes access tokens this long before they expire
+	oauthRefreshMargin = 30 * time.Second
+	// browserSignInTimeout is how long the loopback listener waits for the redirect
+	browserSignInTimeout = 5 * time.Minute
+	deviceCodeGrant      = "urn:ietf:params:oauth:grant-type:device_code"
+)
+
+// ProviderOAuth configures OAuth 2.0 sign-in for gateways that don't accept
+// static keys. DeviceAuthURL enables the device-code flow (RFC 8628) and
+// AuthURL the browser flow with PKCE (RFC 7636); both need TokenURL.
+type ProviderOAuth struct {
+	ClientID      string   `json:"clientId,omitempty"`
+	DeviceAuthURL string   `json:"deviceAuthUrl,omitempty"`
+	AuthURL       string   `json:"authUrl,omitempty"`
+	TokenURL      string   `json:"tokenUrl,omitempty"`
+	Scopes        []string `json:"scopes,omitempty"`
+}
+
+func (o ProviderOAuth) enabled() bool {
+	return o.ClientID != "" && o.TokenURL != ""
+}
+
+// oauthToken is a signed-in provider's tokens, kept in the secret store
+type oauthToken struct {
+	AccessToken  string    `json:"accessToken"`
+	RefreshToken string    `json:"refreshToken,omitempty"`
+	Expiry       time.Time `json:"expiry,omitempty"`
+}
+
+func (t *oauthToken) fresh() bool {
+	return t.Expiry.IsZero() || time.Until(t.Expiry) > oauthRefreshMargin
+}
+
+// oauthTokenRef is the secret store entry holding a provider's tokens
+func oauthTokenRef(providerID string) string {
+	return "oauth-token:" + providerID
+}
+
+// oauthState caches tokens and tracks sign-ins in progress by provider ID
+type oauthState struct {
+	mu     sync.Mutex
+	tokens map[string]*oauthToken
+	// cancels stops the sign-in in progress
+	cancels map[string]context.CancelFunc
+	// refresh serializes token refreshes, as servers may rotate refresh tokens
+	refresh sync.Mutex
+}
+
+// tokenResponse is a token endpoint reply, success or error (RFC 6749 5.1, 5.2)
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	RefreshToken     string `json:"refresh_token"`
+	ExpiresIn        int64  `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+func (r tokenResponse) err() error {
+	if r.ErrorDescription != "" {
+		return fmt.Errorf("%s: %s", r.Error, r.ErrorDescription)
+	}
+	return fmt.Errorf("%s", r.Error)
+}
+
+func (r tokenResponse) token() *oauthToken {
+	t := &oauthToken{AccessToken: r.AccessToken, RefreshToken: r.RefreshToken}
+	if r.ExpiresIn > 0 {
+		t.Expiry = time.Now().Add(time.Duration(r.ExpiresIn) * time.Second)
+	}
+	return t
+}
+
+// oauthClient sends requests to a provider's authorization server, through
+// the provider's proxy and into the audit trail
+func (a *App) oauthClient(config ProviderConfig) *http.Client {
+	base := newHTTPClient(ProviderConfig{ProxyURL: config.ProxyURL})
+	return &http.Client{Transport: a.audit.wrap(auditSourceOAuth, config.Name, base.Transport)}
+}
+
+// postForm posts an OAuth form and decodes the JSON reply
+func postForm(ctx context.Context, client *http.Client, endpoint string, form url.Values, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("authorization server returned %s", resp.Status)
+	}
+	return nil
+}
+
+// requestToken calls the token endpoint; error replies are returned in the
+// response, other failures as the error
+func (a *App) requestToken(ctx context.Context, config ProviderConfig, form url.Values) (tokenResponse, error) {
+	form.Set("client_id", config.OAuth.ClientID)
+	var resp tokenResponse
+	if err := postForm(ctx, a.oauthClient(config), config.OAuth.TokenURL, form, &resp); err != nil {
+		return resp, fmt.Errorf("token request: %v", err)
+	}
+	if resp.Error == "" && resp.AccessToken == "" {
+		return resp, fmt.Errorf("token request: no access token in reply")
+	}
+	return resp, nil
+}
+
+func (a *App) saveOAuthToken(providerID string, token *oauthToken) error {
+	data, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	if err := a.secrets.Set(oauthTokenRef(providerID), string(data)); err != nil {
+		return fmt.Errorf("store sign-in: %v", err)
+	}
+	a.oauth.mu.Lock()
+	if a.oauth.tokens == nil {
+		a.oauth.tokens = make(map[string]*oauthToken)
+	}
+	a.oauth.tokens[providerID] = token
+	a.oauth.mu.Unlock()
+	return nil
+}
+
+// forgetOAuthToken signs a provider out
+func (a *App) forgetOAuthToken(providerID string) error {
+	a.oauth.mu.Lock()
+	delete(a.oauth.tokens, providerID)
+	a.oauth.mu.Unlock()
+	return a.secrets.Delete(oauthTokenRef(providerID))
+}
+
+// loadOAuthTokenLocked returns the cached or stored tokens of a provider, or nil
+// when it isn't signed in. The caller must hold oauth.mu.
+func (a *App) loadOAuthTokenLocked(providerID string) *oauthToken {
+	if t, ok := a.oauth.tokens[providerID]; ok {
+		return t
+	}
+	raw, err := a.secrets.Get(oauthTokenRef(providerID))
+	if err != nil || raw == "" {
+		return nil
+	}
+	var t oauthToken
+	if err := json.Unmarshal([]byte(raw), &t); err != nil {
+		println("Error loading sign-in:", err.Error())
+		return nil
+	}
+	if a.oauth.tokens == nil {
+		a.oauth.tokens = make(map[string]*oauthToken)
+	}
+	a.oauth.tokens[providerID] = &t
+	return &t
+}
+
+// oauthAccessToken returns a valid access token for a provider, refreshing
+// it when it is about to expire
+func (a *App) oauthAccessToken(ctx context.Context, config ProviderConfig) (string, error) {
+	a.oauth.mu.Lock()
+	token := a.loadOAuthTokenLocked(config.ID)
+	a.oauth.mu.Unlock()
+	if token == nil {
+		return "", fmt.Errorf("not signed in to %s; sign in from the provider settings", config.Name)
+	}
+	if token.fresh() {
+		return token.AccessToken, nil
+	}
+
+	a.oauth.refresh.Lock()
+	defer a.oauth.refresh.Unlock()
+	a.oauth.mu.Lock()
+	token = a.loadOAuthTokenLocked(config.ID)
+	a.oauth.mu.Unlock()
+	if token == nil {
+		return "", fmt.Errorf("not signed in to %s; sign in from the provider settings", config.Name)
+	}
+	if token.fresh() {
+		return token.AccessToken, nil
+	}
+	if token.RefreshToken == "" {
+		return "", fmt.Errorf("sign-in to %s has expired; sign in again", config.Name)
+	}
+
+	resp, err := a.requestToken(ctx, config, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {token.RefreshToken},
+	})
+	if err != nil {
+		return "", err
+	}
+	if resp.Error != "" {
+		if err := a.forgetOAuthToken(config.ID); err != nil {
+			println("Error deleting sign-in:", err.Error())
+		}
+		return "", fmt.Errorf("sign-in to %s has expired; sign in again (%v)", config.Name, resp.err())
+	}
+	refreshed := resp.token()
+	if refreshed.RefreshToken == "" {
+		// Servers that don't rotate refresh tokens keep the old one valid
+		refreshed.RefreshToken = token.RefreshToken
+	}
+	if err := a.saveOAuthToken(config.ID, refreshed); err != nil {
+		return "", err
+	}
+	return refreshed.AccessToken, nil
+}
+
+// oauthTransport sends a signed-in provider's access token with each request
+type oauthTransport struct {
+	base   http.RoundTripper
+	app    *App
+	config ProviderConfig
+}
+
+func (t *oauthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.app.oauthAccessToken(req.Context(), t.config)
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return t.base.RoundTrip(req)
+}
+
+// wrapOAuth adds OAuth to a provider's transport when it is configured
+func (a *App) wrapOAuth(config ProviderConfig, base http.RoundTripper) http.RoundTripper {
+	if !config.OAuth.enabled() {
+		return base
+	}
+	return &oauthTransport{base: base, app: a, config: config}
+}
+
+// beginSignIn registers a sign-in for a provider, cancelling any earlier one
+func (a *App) beginSignIn(providerID string, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	a.oauth.mu.Lock()
+	if a.oauth.cancels == nil {
+		a.oauth.cancels = make(map[string]context.CancelFunc)
+	}
+	if previous, ok := a.oauth.cancels[providerID]; ok {
+		previous()
+	}
+	a.oauth.cancels[providerID] = cancel
+	a.oauth.mu.Unlock()
+	return ctx, cancel
+}
+
+// finishSignIn stores the result of a sign-in and reports it to the frontend
+func (a *App) finishSignIn(config ProviderConfig, token *oauthToken, err error) {
+	if err == nil {
+		err = a.saveOAuthToken(config.ID, token)
+	}
+	if err != nil {
+		a.emit(EventOAuthFailed, OAuthEvent{ProviderID: config.ID, Error: err.Error()})
+		return
+	}
+	a.emit(EventOAuthSignedIn, OAuthEvent{ProviderID: config.ID})
+}
+
+func (a *App) oauthProviderConfig(providerID string) (ProviderConfig, error) {
+	p, err := a.providerByID(providerID)
+	if err != nil {
+		return ProviderConfig{}, err
+	}
+	config := p.GetConfig()
+	if !config.OAuth.enabled() {
+		return ProviderConfig{}, fmt.Errorf("provider %s has no OAuth settings", config.Name)
+	}
+	return config, nil
+}
+
+// DeviceSignIn is what the user needs to approve a device-code sign-in
+type DeviceSignIn struct {
+	UserCode        string `json:"userCode"`
+	VerificationURI string `json:"verificationUri"`
+	// VerificationURIComplete includes the code, for a link or QR code
+	VerificationURIComplete string    `json:"verificationUriComplete,omitempty"`
+	ExpiresAt               time.Time `json:"expiresAt"`
+}
+
+// deviceAuthResponse is a device authorization reply (RFC 8628 3.2)
+type deviceAuthResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+	Error                   string `json:"error"`
+}
+
+// StartDeviceSignIn begins a device-code sign-in for a provider and returns
+// the code for the user to enter at the verification page. The app polls
+// for approval in the background and emits "oauth:signed-in" or
+// "oauth:failed".
+func (a *App) StartDeviceSignIn(providerID string) (DeviceSignIn, error) {
+	a.telemetry.recordFeature("oauth_device")
+	config, err := a.oauthProviderConfig(providerID)
+	if err != nil {
+		return DeviceSignIn{}, err
+	}
+	if config.OAuth.DeviceAuthURL == "" {
+		return DeviceSignIn{}, fmt.Errorf("provider %s has no deviceAuthUrl", config.Name)
+	}
+
+	form := url.Values{"client_id": {config.OAuth.ClientID}}
+	if len(config.OAuth.Scopes) > 0 {
+		form.Set("scope", strings.Join(config.OAuth.Scopes, " "))
+	}
+	var device deviceAuthResponse
+	if err := postForm(context.Background(), a.oauthClient(config), config.OAuth.DeviceAuthURL, form, &device); err != nil {
+		return DeviceSignIn{}, fmt.Errorf("device authorization: %v", err)
+	}
+	if device.Error != "" || device.DeviceCode == "" {
+		return DeviceSignIn{}, fmt.Errorf("device authorization failed: %s", device.Error)
+	}
+	if device.ExpiresIn <= 0 {
+		device.ExpiresIn = 600
+	}
+	interval := time.Duration(device.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+
+	lifetime := time.Duration(device.ExpiresIn) * time.Second
+	ctx, cancel := a.beginSignIn(providerID, lifetime)
+	go func() {
+		defer cancel()
+		token, err := a.pollDeviceToken(ctx, config, device.DeviceCode, interval)
+		if ctx.Err() == context.Canceled {
+			return
+		}
+		a.finishSignIn(config, token, err)
+	}()
+	return DeviceSignIn{
+		UserCode:                device.UserCode,
+		VerificationURI:         device.VerificationURI,
+		VerificationURIComplete: device.VerificationURIComplete,
+		ExpiresAt:               time.Now().Add(lifetime),
+	}, nil
+}
+
+// pollDeviceToken waits for the user to approve a device code (RFC 8628 3.5)
+func (a *App) pollDeviceToken(ctx context.Context, config ProviderConfig, deviceCode string, interval time.Duration) (*oauthToken, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("the sign-in code expired before it was approved")
+		case <-time.After(interval):
+		}
+		resp, err := a.requestToken(ctx, config, url.Values{
+			"grant_type":  {deviceCodeGrant},
+			"device_code": {deviceCode},
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				continue
+			}
+			return nil, err
+		}
+		switch resp.Error {
+		case "":
+			return resp.token(), nil
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * time.Second
+		default:
+			return nil, resp.err()
+		}
+	}
+}
+
+// pkceVerifier returns a random code verifier and its S256 challenge
+func pkceVerifier() (verifier, challenge string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	verifier = base64.RawURLEncoding.EncodeToString(b)
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// StartBrowserSignIn begins a browser sign-in with PKCE for a provider. It
+// opens the authorization page, waits on a loopback address for the
+// redirect, and emits "oauth:signed-in" or "oauth:failed". The returned URL
+// is the authorization page, for when no browser could be opened.
+func (a *App) StartBrowserSignIn(providerID string) (string, error) {
+	a.telemetry.recordFeature("oauth_browser")
+	config, err := a.oauthProviderConfig(providerID)
+	if err != nil {
+		return "", err
+	}
+	if config.OAuth.AuthURL == "" {
+		return "", fmt.Errorf("provider %s has no authUrl", config.Name)
+	}
+	authURL, err := url.Parse(config.OAuth.AuthURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid authUrl: %v", err)
+	}
+	verifier, challenge, err := pkceVerifier()
+	if err != nil {
+		return "", err
+	}
+	state, _, err := pkceVerifier()
+	if err != nil {
+		return "", err
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", fmt.Errorf("listen for sign-in redirect: %v", err)
+	}
+	redirect := fmt.Sprintf("http://%s/callback", listener.Addr())
+	query := authURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", config.OAuth.ClientID)
+	query.Set("redirect_uri", redirect)
+	query.Set("state", state)
+	query.Set("code_challenge", challenge)
+	query.Set("code_challenge_method", "S256")
+	if len(config.OAuth.Scopes) > 0 {
+		query.Set("scope", strings.Join(config.OAuth.Scopes, " "))
+	}
+	authURL.RawQuery = query.Encode()
+
+	ctx, cancel := a.beginSignIn(providerID, browserSignInTimeout)
+	var once sync.Once
+	done := func(token *oauthToken, err error) {
+		once.Do(func() {
+			if ctx.Err() != context.Canceled {
+				a.finishSignIn(config, token, err)
+			}
+			cancel()
+		})
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/callback" {
+			http.NotFound(w, r)
+			return
+		}
+		q := r.URL.Query()
+		if q.Get("state") != state {
+			http.Error(w, "Sign-in state did not match; start the sign-in again.", http.StatusBadRequest)
+			return
+		}
+		if e := q.Get("error"); e != "" {
+			fmt.Fprintln(w, "Sign-in failed. You can close this window.")
+			done(nil, tokenResponse{Error: e, ErrorDescription: q.Get("error_description")}.err())
+			return
+		}
+		resp, err := a.requestToken(ctx, config, url.Values{
+			"grant_type":    {"authorization_code"},
+			"code":          {q.Get("code")},
+			"redirect_uri":  {redirect},
+			"code_verifier": {verifier},
+		})
+		if err == nil && resp.Error != "" {
+			err = resp.err()
+		}
+		if err != nil {
+			fmt.Fprintln(w, "Sign-in failed. You can close this window.")
+			done(nil, err)
+			return
+		}
+		fmt.Fprintln(w, "Signed in to Vibe Coder. You can close this window.")
+		done(resp.token(), nil)
+	})}
+	go server.Serve(listener)
+	go func() {
+		<-ctx.Done()
+		done(nil, fmt.Errorf("no sign-in completed within %v", browserSignInTimeout))
+		shutdown, cancelShutdown := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancelShutdown()
+		server.Shutdown(shutdown)
+	}()
+
+	if a.ctx != nil {
+		runtime.BrowserOpenURL(a.ctx, authURL.String())
+	}
+	return authURL.String(), nil
+}
+
+// SignInStatus reports whether an OAuth provider is signed in
+type SignInStatus struct {
+	// Configured is false for providers without OAuth settings
+	Configured bool `json:"configured"`
+	SignedIn   bool `json:"signedIn"`
+	// ExpiresAt is when the access token expires; with a refresh token it
+	// is renewed automatically
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
+	Refreshable bool       `json:"refreshable"`
+}
+
+// GetSignInStatus reports the OAuth sign-in of a provider
+func (a *App) GetSignInStatus(providerID string) (SignInStatus, error) {
+	p, err := a.providerByID(providerID)
+	if err != nil {
+		return SignInStatus{}, err
+	}
+	status := SignInStatus{Configured: p.GetConfig().OAuth.enabled()}
+	a.oauth.mu.Lock()
+	token := a.loadOAuthTokenLocked(providerID)
+	a.oauth.mu.Unlock()
+	if token != nil {
+		status.SignedIn = token.fresh() || token.RefreshToken != ""
+		status.Refreshable = token.RefreshToken != ""
+		if !token.Expiry.IsZero() {
+			expiry := token.Expiry
+			status.ExpiresAt = &expiry
+		}
+	}
+	return status, nil
+}
+
+// SignOut forgets a provider's OAuth tokens and stops any sign-in in progress
+func (a *App) SignOut(providerID string) error {
+	a.oauth.mu.Lock()
+	if cancel, ok := a.oauth.cancels[providerID]; ok {
+		cancel()
+		delete(a.oauth.cancels, providerID)
+	}
+	a.oauth.mu.Unlock()
+	return a.forgetOAuthToken(providerID)
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// pullProgressInterval throttles "model:pull" events while a layer downloads
+const pullProgressInterval = 100 * time.Millisecond
+
+// PullProgress is emitted as a "model:pull" event while a model downloads
+type PullProgress struct {
+	ProviderID string  `json:"providerId"`
+	Model      string  `json:"model"`
+	Status     string  `json:"status"`
+	Digest     string  `json:"digest,omitempty"`
+	Completed  int64   `json:"completed"`
+	Total      int64   `json:"total"`
+	Percent    float64 `json:"percent"`
+	Done       bool    `json:"done"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// ModelDetails is the metadata Ollama reports for an installed model
+type ModelDetails struct {
+	Name          string                 `json:"name"`
+	Family        string                 `json:"family,omitempty"`
+	ParameterSize string                 `json:"parameterSize,omitempty"`
+	Quantization  string                 `json:"quantization,omitempty"`
+	Format        string                 `json:"format,omitempty"`
+	License       string                 `json:"license,omitempty"`
+	Modelfile     string                 `json:"modelfile,omitempty"`
+	Parameters    string                 `json:"parameters,omitempty"`
+	Template      string                 `json:"template,omitempty"`
+	ModelInfo     map[string]interface{} `json:"modelInfo,omitempty"`
+}
+
+// ollamaProvider returns the configured provider with id, which must be an Ollama provider
+func (a *App) ollamaProvider(id string) (*OllamaProvider, error) {
+	p, err := a.providerByID(id)
+	if err != nil {
+		return nil, err
+	}
+	ollama, ok := p.(*OllamaProvider)
+	if !ok {
+		return nil, fmt.Errorf("provider %s is not an Ollama provider", p.GetName())
+	}
+	return ollama, nil
+}
+
+// post sends a JSON body to url without checking the status
+func (p *OllamaProvider) post(url string, jsonData []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(requestContext(p.traceCtx), http.MethodPost, url, bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return p.client.Do(req)
+}
+
+// call sends a JSON request to an Ollama API path and checks the status
+func (p *OllamaProvider) call(method, path string, payload interface{}) (*http.Response, error) {
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(requestContext(p.traceCtx), method, p.config.Endpoint+path, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("network error: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(body))
+	}
+	return resp, nil
+}
+
+// PullModel downloads a model into an Ollama provider, emitting "model:pull"
+// progress events until it completes
+func (a *App) PullModel(providerID string, model string) error {
+	a.telemetry.recordFeature("pull_model")
+	p, err := a.ollamaProvider(providerID)
+	if err != nil {
+		return err
+	}
+
+	progress := PullProgress{ProviderID: providerID, Model: model, Status: "starting"}
+	a.emit(EventModelPull, progress)
+
+	err = p.pull(model, func(status, digest string, completed, total int64) {
+		progress.Status = status
+		progress.Digest = digest
+		progress.Completed = completed
+		progress.Total = total
+		progress.Percent = 0
+		if total > 0 {
+			progress.Percent = float64(completed) / float64(total) * 100
+		}
+		a.emit(EventModelPull, progress)
+	})
+
+	progress.Done = true
+	if err != nil {
+		progress.Error = err.Error()
+	}
+	a.emit(EventModelPull, progress)
+	return err
+}
+
+// pull streams /api/pull, calling onProgress for status changes and at most
+// every pullProgressInterval while a layer downloads
+func (p *OllamaProvider) pull(model string, onProgress func(status, digest string, completed, total int64)) error {
+	resp, err := p.call(http.MethodPost, "/api/pull", map[string]interface{}{
+		"model":  model,
+		"stream": true,
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var last time.Time
+	var lastStatus string
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg struct {
+			Status    string `json:"status"`
+			Digest    string `json:"digest"`
+			Total     int64  `json:"total"`
+			Completed int64  `json:"completed"`
+			Error     string `json:"error"`
+		}
+		if err := dec.Decode(&msg); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("invalid response: %v", err)
+		}
+		if msg.Error != "" {
+			return fmt.Errorf("pull %s: %s", model, msg.Error)
+		}
+
+		if msg.Status != lastStatus || msg.Completed == msg.Total || time.Since(last) >= pullProgressInterval {
+			onProgress(msg.Status, msg.Digest, msg.Completed, msg.Total)
+			last = time.Now()
+			lastStatus = msg.Status
+		}
+		if msg.Status == "success" {
+			return nil
+		}
+	}
+}
+
+// DeleteModel removes an installed model from an Ollama provider
+func (a *App) DeleteModel(providerID string, model string) error {
+	p, err := a.ollamaProvider(providerID)
+	if err != nil {
+		return err
+	}
+	resp, err := p.call(http.MethodDelete, "/api/delete", map[string]interface{}{"model": model})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// ShowModel returns the metadata of an installed Ollama model
+func (a *App) ShowModel(providerID string, model string) (ModelDetails, error) {
+	p, err := a.ollamaProvider(providerID)
+	if err != nil {
+		return ModelDetails{}, err
+	}
+	resp, err := p.call(http.MethodPost, "/api/show", map[string]interface{}{"model": model})
+	if err != nil {
+		return ModelDetails{}, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		License    string                 `json:"license"`
+		Modelfile  string                 `json:"modelfile"`
+		Parameters string                 `json:"parameters"`
+		Template   string                 `json:"template"`
+		ModelInfo  map[string]interface{} `json:"model_info"`
+		Details    struct {
+			Format            string `json:"format"`
+			Family            string `json:"family"`
+			ParameterSize     string `json:"parameter_size"`
+			QuantizationLevel string `json:"quantization_level"`
+		} `json:"details"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return ModelDetails{}, fmt.Errorf("invalid response: %v", err)
+	}
+
+	return ModelDetails{
+		Name:          model,
+		Family:        result.Details.Family,
+		ParameterSize: result.Details.ParameterSize,
+		Quantization:  result.Details.QuantizationLevel,
+		Format:        result.Details.Format,
+		License:       result.License,
+		Modelfile:     result.Modelfile,
+		Parameters:    result.Parameters,
+		Template:      result.Template,
+		ModelInfo:     result.ModelInfo,
+	}, nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// OpenAIProvider talks to the OpenAI API or any server exposing the same
+// /chat/completions interface. Endpoint is the API base, e.g. https://api.openai.com/v1.
+type OpenAIProvider struct {
+	config ProviderConfig
+	client *http.Client
+	// traceCtx carries the span requests are sent under
+	traceCtx context.Context
+	// schema, when set, is sent as the response format replies must follow
+	schema json.RawMessage
+}
+
+func NewOpenAIProvider(config ProviderConfig) *OpenAIProvider {
+	return &OpenAIProvider{
+		config: config,
+		client: newHTTPClient(config),
+	}
+}
+
+func (p *OpenAIProvider) GetName() string {
+	if p.config.Name != "" {
+		return p.config.Name
+	}
+	return "OpenAI"
+}
+
+func (p *OpenAIProvider) GetConfig() ProviderConfig {
+	return p.config
+}
+
+// newRequest builds a request against the API base with authentication set
+func (p *OpenAIProvider) newRequest(method, path string, body io.Reader) (*http.Request, error) {
+	url := strings.TrimSuffix(p.config.Endpoint, "/") + path
+	req, err := http.NewRequestWithContext(requestContext(p.traceCtx), method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if p.config.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.config.APIKey)
+	}
+	// Headers set on the provider still take precedence over these
+	if id := strings.TrimSpace(p.config.OrganizationID); id != "" {
+		req.Header.Set("OpenAI-Organization", id)
+	}
+	if id := strings.TrimSpace(p.config.ProjectID); id != "" {
+		req.Header.Set("OpenAI-Project", id)
+	}
+	return req, nil
+}
+
+func (p *OpenAIProvider) chatPayload(prompt string, temperature float64, maxTokens int, stream bool) map[string]interface{} {
+	payload := map[string]interface{}{
+		"model": p.config.Model,
+		"messages": []map[string]interface{}{
+			{"role": "user", "content": prompt},
+		},
+		"temperature": temperature,
+		"max_tokens":  maxTokens,
+	}
+	if stream {
+		payload["stream"] = true
+	}
+	// The chat completions API has no top_k
+	d := p.config.Defaults
+	if d.TopP != nil {
+		payload["top_p"] = *d.TopP
+	}
+	if len(d.Stop) > 0 {
+		payload["stop"] = d.Stop
+	}
+	if len(p.schema) > 0 {
+		payload["response_format"] = map[string]interface{}{
+			"type":        "json_schema",
+			"json_schema": map[string]interface{}{"name": "response", "schema": p.schema},
+		}
+	}
+	return payload
+}
+
+func (p *OpenAIProvider) SendRequest(prompt string, temperature float64, maxTokens int) (string, error) {
+	jsonData, err := json.Marshal(p.chatPayload(prompt, temperature, maxTokens, false))
+	if err != nil {
+		return "", err
+	}
+
+	req, err := p.newRequest(http.MethodPost, "/chat/completions", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return "", err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("network error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(body))
+	}
+
+	var result struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("invalid response: %v", err)
+	}
+	if len(result.Choices) == 0 {
+		return "", fmt.Errorf("missing 'choices' field")
+	}
+
+	return result.Choices[0].Message.Content, nil
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// hunkHeaderPattern matches "@@ -12,5 +12,6 @@", with the counts optional
+var hunkHeaderPattern = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// patchHunk is one hunk of a unified diff
+type patchHunk struct {
+	header   string
+	oldStart int
+	// lines keep their ' ', '-' or '+' prefix and their line ending, which
+	// is missing where the diff says "\ No newline at end of file"
+	lines []string
+}
+
+// filePatch is the part of a unified diff that changes one file
+type filePatch struct {
+	// oldPath is "" for a created file and newPath "" for a deleted one
+	oldPath, newPath string
+	// headers is set once the "---" and "+++" lines are read
+	headers bool
+	binary  bool
+	hunks   []patchHunk
+}
+
+// old returns the lines a hunk expects to find in the file
+func (h patchHunk) old() []string {
+	var old []string
+	for _, l := range h.lines {
+		if l[0] == ' ' || l[0] == '-' {
+			old = append(old, l[1:])
+		}
+	}
+	return old
+}
+
+// gitDiffPaths returns the paths in a "diff --git a/x b/y" line
+func gitDiffPaths(line string) (oldPath, newPath string) {
+	rest := strings.TrimPrefix(line, "diff --git ")
+	if i := strings.Index(rest, " b/"); strings.HasPrefix(rest, "a/") && i > 0 {
+		return diffHeaderPath("--- " + rest[:i]), diffHeaderPath("+++ " + rest[i+1:])
+	}
+	return "", ""
+}
+
+// parseUnifiedDiff reads the unified diff that starts at lines[i], returning
+// the files it changes and the index of the first line after it
+func parseUnifiedDiff(lines []string, i int) ([]filePatch, int) {
+	var patches []filePatch
+	var cur *filePatch
+	start := func(oldPath, newPath string) {
+		patches = append(patches, filePatch{oldPath: oldPath, newPath: newPath})
+		cur = &patches[len(patches)-1]
+	}
+	for i < len(lines) {
+		line := lines[i]
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			start(gitDiffPaths(line))
+			i++
+		case cur != nil && len(cur.hunks) == 0 && isGitHeader(line):
+			switch {
+			case strings.HasPrefix(line, "new file mode "):
+				cur.oldPath = ""
+			case strings.HasPrefix(line, "deleted file mode "):
+				cur.newPath = ""
+			case strings.HasPrefix(line, "rename from "):
+				cur.oldPath = diffHeaderPath("--- " + strings.TrimPrefix(line, "rename from "))
+			case strings.HasPrefix(line, "rename to "):
+				cur.newPath = diffHeaderPath("+++ " + strings.TrimPrefix(line, "rename to "))
+			case strings.HasPrefix(line, "Binary files "):
+				cur.binary = true
+			}
+			i++
+		case strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			if cur == nil || cur.headers || len(cur.hunks) > 0 {
+				start("", "")
+			}
+			cur.oldPath, cur.newPath, cur.headers = diffHeaderPath(line), diffHeaderPath(lines[i+1]), true
+			i += 2
+		case strings.HasPrefix(line, "@@") && cur != nil:
+			var h patchHunk
+			h, i = parseHunk(lines, i)
+			cur.hunks = append(cur.hunks, h)
+		default:
+			return patches, i
+		}
+	}
+	return patches, i
+}
+
+// parseHunk reads the hunk whose header is lines[i]. The counts in the
+// header are only trusted to tell empty context lines from the end of the
+// hunk, because models often get them wrong.
+func parseHunk(lines []string, i int) (patchHunk, int) {
+	h := patchHunk{header: lines[i]}
+	oldLeft, newLeft := 0, 0
+	if m := hunkHeaderPattern.FindStringSubmatch(lines[i]); m != nil {
+		h.oldStart, _ = strconv.Atoi(m[1])
+		oldLeft, newLeft = 1, 1
+		if m[2] != "" {
+			oldLeft, _ = strconv.Atoi(m[2])
+		}
+		if m[4] != "" {
+			newLeft, _ = strconv.Atoi(m[4])
+		}
+	}
+	for i++; i < len(lines); i++ {
+		l := lines[i]
+		if l == "" {
+			if oldLeft <= 0 || newLeft <= 0 {
+				break
+			}
+			l = " "
+		}
+		switch l[0] {
+		case ' ':
+			oldLeft, newLeft = oldLeft-1, newLeft-1
+		case '-':
+			if strings.HasPrefix(l, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ ") && oldLeft <= 0 {
+				return h, i
+			}
+			oldLeft--
+		case '+':
+			newLeft--
+		case '\\':
+			if n := len(h.lines); n > 0 {
+				h.lines[n-1] = strings.TrimSuffix(h.lines[n-1], "\n")
+			}
+			if oldLeft <= 0 && newLeft <= 0 {
+				return h, i + 1
+			}
+			continue
+		default:
+			return h, i
+		}
+		h.lines = append(h.lines, l+"\n")
+	}
+	return h, i
+}
+
+// PatchConflict is a hunk that could not be applied
+type PatchConflict struct {
+	// Hunk counts from 1 within the file
+	Hunk   int    `json:"hunk"`
+	Header string `json:"header,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// PatchedFile is the effect of a diff on one file
+type PatchedFile struct {
+	Path string `json:"path"`
+	// OldPath is set when the file is renamed
+	OldPath string `json:"oldPath,omitempty"`
+	// Status is "created", "modified", "deleted" or "renamed"
+	Status    string          `json:"status"`
+	Hunks     int             `json:"hunks"`
+	Conflicts []PatchConflict `json:"conflicts,omitempty"`
+	// Diff is the change as it would be applied, which may sit at other
+	// lines than the diff's hunk headers say
+	Diff string `json:"diff"`
+}
+
+// DiffResult is the outcome of ApplyDiff
+type DiffResult struct {
+	Files []PatchedFile `json:"files"`
+	// Applied is set when the files were changed, which happens only when
+	// no hunk conflicts and it is not a dry run
+	Applied bool `json:"applied"`
+}
+
+// sameLine compares lines ignoring trailing whitespace and line endings
+func sameLine(a, b string) bool {
+	return strings.TrimRight(a, " \t\r\n") == strings.TrimRight(b, " \t\r\n")
+}
+
+// findHunk returns where old occurs in lines at or after from, nearest to
+// want, comparing exactly before ignoring trailing whitespace
+func findHunk(lines, old []string, from, want int) int {
+	matches := func(at int, eq func(a, b string) bool) bool {
+		for k := range old {
+			if !eq(lines[at+k], old[k]) {
+				return false
+			}
+		}
+		return true
+	}
+	exact := func(a, b string) bool { return a == b }
+	last := len(lines) - len(old)
+	want = min(max(want, from), max(last, from))
+	for _, eq := range []func(a, b string) bool{exact, sameLine} {
+		for d := 0; want-d >= from || want+d <= last; d++ {
+			if at := want - d; at >= from && at <= last && matches(at, eq) {
+				return at
+			}
+			if at := want + d; d > 0 && at >= from && at <= last && matches(at, eq) {
+				return at
+			}
+		}
+	}
+	return -1
+}
+
+// applyHunks applies a file's hunks to its content in order
+func applyHunks(content string, hunks []patchHunk) (string, []PatchConflict) {
+	lines := splitLines(content)
+	crlf := len(lines) > 0 && strings.HasSuffix(lines[0], "\r\n")
+	var out []string
+	var conflicts []PatchConflict
+	pos, offset := 0, 0
+	for n, h := range hunks {
+		old := h.old()
+		var at int
+		if len(old) == 0 {
+			// A hunk that only adds lines goes after line oldStart
+			at = min(max(h.oldStart+offset, pos), len(lines))
+		} else {
+			at = findHunk(lines, old, pos, h.oldStart-1+offset)
+		}
+		if at < 0 {
+			conflicts = append(conflicts, PatchConflict{Hunk: n + 1, Header: h.header, Reason: "the lines it changes are not in the file"})
+			continue
+		}
+		out = append(out, lines[pos:at]...)
+		// Context lines are kept as they are in the file
+		k := at
+		for _, l := range h.lines {
+			switch l[0] {
+			case ' ':
+				out = append(out, lines[k])
+				k++
+			case '-':
+				k++
+			case '+':
+				if l = l[1:]; crlf && strings.HasSuffix(l, "\n") {
+					l = strings.TrimSuffix(l, "\n") + "\r\n"
+				}
+				out = append(out, l)
+			}
+		}
+		if len(old) > 0 {
+			offset = at - (h.oldStart - 1)
+		}
+		pos = at + len(old)
+	}
+	out = append(out, lines[pos:]...)
+	return strings.Join(out, ""), conflicts
+}
+
+// patchedFile is what a file becomes as the diffs of a reply are applied
+// one after another
+type patchedFile struct {
+	name    string
+	target  string
+	content string
+	perm    os.FileMode
+	// exists is false once a diff deletes the file or renames it away
+	exists bool
+	// changed is set once a diff changes the file
+	changed bool
+}
+
+// ApplyDiff applies a unified diff to the files in a conversation's directory,
+// or the active one's when sessionID is empty. The diff may be a whole reply,
+// whose diffs are applied in order: a diff to a file an earlier one changed
+// applies to the changed text. Hunks are placed by their context, so line
+// numbers that are off still apply. Files are only changed when no hunk
+// conflicts, and then all at once, or none when writing one fails; dryRun
+// reports what would happen without changing anything.
+func (a *App) ApplyDiff(diff string, sessionID string, dryRun bool) (DiffResult, error) {
+	a.telemetry.recordFeature("apply_diff")
+	var patches []filePatch
+	for _, s := range parseResponse(diff) {
+		if s.Type == segmentDiff {
+			p, _ := parseUnifiedDiff(strings.Split(s.Text, "\n"), 0)
+			patches = append(patches, p...)
+		}
+	}
+	if len(patches) == 0 {
+		return DiffResult{}, fmt.Errorf("no unified diff found")
+	}
+
+	// files holds each file as the diffs so far left it, in the order first seen
+	files := make(map[string]*patchedFile)
+	var order []*patchedFile
+	track := func(f *patchedFile) *patchedFile {
+		files[f.target] = f
+		order = append(order, f)
+		return f
+	}
+	result := DiffResult{Files: []PatchedFile{}}
+	conflicted := false
+	for _, p := range patches {
+		name := p.newPath
+		if name == "" {
+			name = p.oldPath
+		}
+		if name == "" {
+			return DiffResult{}, fmt.Errorf("diff names no file")
+		}
+		if p.binary {
+			return DiffResult{}, fmt.Errorf("%s: binary diffs can't be applied", name)
+		}
+		f := PatchedFile{Path: name, Hunks: len(p.hunks)}
+		var src, dst *patchedFile
+		if p.oldPath != "" {
+			target, err := a.sandboxedPath(sessionID, p.oldPath)
+			if err != nil {
+				return DiffResult{}, err
+			}
+			if src = files[target]; src == nil {
+				old, exists, err := a.currentText(target, p.oldPath)
+				if err != nil {
+					return DiffResult{}, err
+				}
+				src = track(&patchedFile{name: p.oldPath, target: target, content: old, exists: exists, perm: 0o644})
+				if info, err := os.Stat(target); err == nil {
+					src.perm = info.Mode().Perm()
+				}
+			}
+		}
+		switch {
+		case p.oldPath == "":
+			f.Status = "created"
+		case p.newPath == "":
+			f.Status = "deleted"
+		case p.newPath != p.oldPath:
+			f.Status, f.OldPath = "renamed", p.oldPath
+		default:
+			f.Status = "modified"
+		}
+		taken := false
+		if p.newPath != "" {
+			target, err := a.sandboxedPath(sessionID, p.newPath)
+			if err != nil {
+				return DiffResult{}, err
+			}
+			if dst = files[target]; dst != nil {
+				taken = dst != src && dst.exists
+			} else if _, err := os.Lstat(target); err == nil {
+				taken = true
+			} else {
+				dst = track(&patchedFile{name: p.newPath, target: target, perm: 0o644})
+			}
+		}
+
+		old := ""
+		if src != nil {
+			old = src.content
+		}
+		content := ""
+		switch {
+		case src != nil && !src.exists:
+			f.Conflicts = []PatchConflict{{Reason: p.oldPath + " does not exist"}}
+		case taken:
+			f.Conflicts = []PatchConflict{{Reason: name + " already exists"}}
+		default:
+			content, f.Conflicts = applyHunks(old, p.hunks)
+			if dst == nil && content != "" && len(f.Conflicts) == 0 {
+				f.Conflicts = []PatchConflict{{Reason: "the diff deletes the file but does not remove all of its lines"}}
+			}
+		}
+		if len(f.Conflicts) > 0 {
+			conflicted = true
+			result.Files = append(result.Files, f)
+			continue
+		}
+
+		oldLabel, newLabel := "a/"+p.oldPath, "b/"+p.newPath
+		if p.oldPath == "" {
+			oldLabel = "/dev/null"
+		}
+		if p.newPath == "" {
+			newLabel = "/dev/null"
+		}
+		f.Diff = labeledDiff(oldLabel, newLabel, old, content)
+		if src != nil && src != dst {
+			src.content, src.exists, src.changed = "", false, true
+		}
+		if dst != nil {
+			if src != nil && src != dst {
+				dst.perm = src.perm
+			}
+			dst.content, dst.exists, dst.changed = content, true, true
+		}
+		result.Files = append(result.Files, f)
+	}
+	if dryRun || conflicted {
+		return result, nil
+	}
+
+	var changes []fileChange
+	for _, f := range order {
+		if f.changed {
+			changes = append(changes, fileChange{name: f.name, target: f.target, content: f.content, perm: f.perm, remove: !f.exists})
+		}
+	}
+	if err := commitFiles(changes); err != nil {
+		return result, err
+	}
+	a.activity.record(a.sessionRoot(sessionID), projectActivity{Applied: 1, DiffsApplied: 1})
+	result.Applied = true
+	return result, nil
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of pending change
+const (
+	changeCreate = "create"
+	changeEdit   = "edit"
+)
+
+var writeFileDefinition = ToolDefinition{
+	Name: "write_file",
+	Description: "Proposes creating a text file in the workspace, or replacing all of an existing one, with content. " +
+		"Nothing is written until the user approves the change.",
+	Parameters: json.RawMessage(`{"type":"object","required":["path","content"],"properties":{` +
+		`"path":{"type":"string","minLength":1},` +
+		`"content":{"type":"string"}}}`),
+}
+
+var editFileDefinition = ToolDefinition{
+	Name: "edit_file",
+	Description: "Proposes an edit to a text file in the workspace: oldText, which must appear exactly once in the file, is replaced with newText. " +
+		"Nothing is written until the user approves the change.",
+	Parameters: json.RawMessage(`{"type":"object","required":["path","oldText","newText"],"properties":{` +
+		`"path":{"type":"string","minLength":1},` +
+		`"oldText":{"type":"string","minLength":1},` +
+		`"newText":{"type":"string"}}}`),
+}
+
+// PendingChange is a file change the model proposed, waiting for the user
+// to approve or reject it
+type PendingChange struct {
+	ID        string `json:"id"`
+	SessionID string `json:"sessionId"`
+	// Path is relative to the conversation's directory
+	Path string `json:"path"`
+	// Kind is "create" for a new file and "edit" for a change to one
+	Kind string `json:"kind"`
+	// Diff is the change as a unified diff
+	Diff      string    `json:"diff"`
+	CreatedAt time.Time `json:"createdAt"`
+
+	target  string
+	content string
+	// base is the hash of the file when the change was proposed, "" for new files
+	base string
+}
+
+// pendingChanges holds proposed changes, oldest first, until they are
+// approved or rejected. They are not saved.
+type pendingChanges struct {
+	mu      sync.Mutex
+	changes []PendingChange
+}
+
+func newPendingChanges() *pendingChanges {
+	return &pendingChanges{}
+}
+
+func (pc *pendingChanges) add(c PendingChange) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pc.changes = append(pc.changes, c)
+}
+
+// take removes a change and returns it
+func (pc *pendingChanges) take(id string) (PendingChange, bool) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	for i, c := range pc.changes {
+		if c.ID == id {
+			pc.changes = append(pc.changes[:i], pc.changes[i+1:]...)
+			return c, true
+		}
+	}
+	return PendingChange{}, false
+}
+
+func (pc *pendingChanges) list() []PendingChange {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	return append([]PendingChange{}, pc.changes...)
+}
+
+// currentText returns a file's text for a change, and false when it does not exist
+func (a *App) currentText(target, name string) (string, bool, error) {
+	info, err := os.Stat(target)
+	if os.IsNotExist(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("%s: %v", name, fileError(err))
+	}
+	if info.IsDir() {
+		return "", false, fmt.Errorf("%s is a directory", name)
+	}
+	if info.Size() > readFileMaxBytes {
+		return "", false, fmt.Errorf("%s is over %s bytes and can't be changed", name, a.reportFormatter().Int(readFileMaxBytes))
+	}
+	content, ok := readText(target)
+	if !ok {
+		return "", false, fmt.Errorf("%s is not a text file", name)
+	}
+	return content, true, nil
+}
+
+// proposeChange records a change to a file for the user to approve
+func (a *App) proposeChange(sessionID, path string, change func(old string, exists bool) (string, error)) (string, error) {
+	target, err := a.sandboxedPath(sessionID, path)
+	if err != nil {
+		return "", err
+	}
+	name := filepath.ToSlash(filepath.Clean(filepath.FromSlash(path)))
+	old, exists, err := a.currentText(target, name)
+	if err != nil {
+		return "", err
+	}
+	content, err := change(old, exists)
+	if err != nil {
+		return "", err
+	}
+	if len(content) > readFileMaxBytes {
+		return "", fmt.Errorf("the new content is over %s bytes", a.reportFormatter().Int(readFileMaxBytes))
+	}
+	if exists && content == old {
+		return fmt.Sprintf("%s already has this content; there is nothing to change", name), nil
+	}
+
+	c := PendingChange{ID: newID(), SessionID: sessionID, Path: name, Kind: changeCreate, CreatedAt: time.Now().UTC(), target: target, content: content}
+	if exists {
+		c.Kind, c.base = changeEdit, hashContent(old)
+		c.Diff = unifiedDiff(name, old, content)
+	} else {
+		c.Diff = labeledDiff("/dev/null", "b/"+name, "", content)
+	}
+	a.changes.add(c)
+	a.emit(EventChangePending, c)
+	return fmt.Sprintf("Proposed change %s to %s. It is written once the user approves it:\n%s", c.ID, name, c.Diff), nil
+}
+
+// writeFile runs the write_file tool
+func (a *App) writeFile(ctx context.Context, sessionID string, args json.RawMessage) (string, error) {
+	var in struct {
+		Path    string `json:"path"`
+		Content string `json:"content"`
+	}
+	if err := json.Unmarshal(args, &in); err != nil {
+		return "", err
+	}
+	return a.proposeChange(sessionID, in.Path, func(string, bool) (string, error) {
+		return in.Content, nil
+	})
+}
+
+// editFile runs the edit_file tool
+func (a *App) editFile(ctx context.Context, sessionID string, args json.RawMessage) (string, error) {
+	var in struct {
+		Path    string `json:"path"`
+		OldText string `json:"oldText"`
+		NewText string `json:"newText"`
+	}
+	if err := json.Unmarshal(args, &in); err != nil {
+		return "", err
+	}
+	return a.proposeChange(sessionID, in.Path, func(old string, exists bool) (string, error) {
+		if !exists {
+			return "", fmt.Errorf("%s does not exist; use write_file to create it", in.Path)
+		}
+		switch n := strings.Count(old, in.OldText); n {
+		case 0:
+			return "", fmt.Errorf("oldText is not in %s; read the file again and copy the text exactly", in.Path)
+		case 1:
+			return strings.Replace(old, in.OldText, in.NewText, 1), nil
+		default:
+			return "", fmt.Errorf("oldText appears %d times in %s; include more lines so it appears once", n, in.Path)
+		}
+	})
+}
+
+// ListPendingChanges returns the file changes the model proposed that wait
+// for approval, oldest first, each with its diff
+func (a *App) ListPendingChanges() []PendingChange {
+	return a.changes.list()
+}
+
+// ApprovePendingChange writes a proposed file change. It fails, and the
+// change is dropped, when the file changed since the change was proposed.
+func (a *App) ApprovePendingChange(changeID string) error {
+	a.telemetry.recordFeature("approve_change")
+	c, ok := a.changes.take(changeID)
+	if !ok {
+		return fmt.Errorf("pending change %q not found", changeID)
+	}
+
+	// The directory may have changed, e.g. a symlink put in its place
+	if target, err := a.sandboxedPath(c.SessionID, c.Path); err != nil {
+		return err
+	} else if target != c.target {
+		return fmt.Errorf("%s is no longer where the change was proposed; ask for it again", c.Path)
+	}
+
+	perm := os.FileMode(0o644)
+	info, err := os.Lstat(c.target)
+	switch {
+	case err == nil && c.base == "":
+		return fmt.Errorf("%s was created since the change was proposed; ask for it again", c.Path)
+	case err == nil:
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("%s is not a regular file", c.Path)
+		}
+		if old, _ := readText(c.target); hashContent(old) != c.base {
+			return fmt.Errorf("%s changed since the change was proposed; ask for it again", c.Path)
+		}
+		perm = info.Mode().Perm()
+	case !os.IsNotExist(err):
+		return err
+	case c.base != "":
+		return fmt.Errorf("%s was removed since the change was proposed", c.Path)
+	}
+
+	if _, err := mkdirAllTracked(filepath.Dir(c.target)); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(c.target, []byte(c.content), perm); err != nil {
+		return fmt.Errorf("write %s: %v", c.Path, err)
+	}
+	a.activity.record(a.sessionRoot(c.SessionID), projectActivity{Applied: 1})
+	return nil
+}
+
+// RejectPendingChange drops a proposed file change without writing it
+func (a *App) RejectPendingChange(changeID string) error {
+	if _, ok := a.changes.take(changeID); !ok {
+		return fmt.Errorf("pending change %q not found", changeID)
+	}
+	return nil
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// maxPersonaVersions is how many earlier versions of a persona are kept
+const maxPersonaVersions = 50
+
+// Persona is a preset system prompt a conversation can run under
+type Persona struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Prompt string `json:"prompt"`
+	// Defaults apply to prompts sent in the persona's conversations that
+	// don't set their own
+	Defaults GenerationDefaults `json:"defaults"`
+	// BuiltIn personas ship with the app and can't be changed or deleted
+	BuiltIn bool `json:"builtIn,omitempty"`
+	// Version counts the saved edits of a user-defined persona, from 1
+	Version   int       `json:"version,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt,omitempty"`
+	// DeletedAt is set while the persona is in the trash
+	DeletedAt time.Time `json:"deletedAt,omitempty"`
+	// History holds the earlier versions, oldest first. It is saved with the
+	// config but left out of ListPersonas; see ListPersonaVersions.
+	History []PersonaVersion `json:"history,omitempty"`
+}
+
+// PersonaVersion is a persona as it was saved at one version
+type PersonaVersion struct {
+	Version  int                `json:"version"`
+	Name     string             `json:"name"`
+	Prompt   string             `json:"prompt"`
+	Defaults GenerationDefaults `json:"defaults"`
+	SavedAt  time.Time          `json:"savedAt,omitempty"`
+}
+
+var builtInPersonas = []Persona{
+	{
+		ID:   "strict-go-reviewer",
+		Name: "Strict Go reviewer",
+		Prompt: "You are a strict, senior Go code reviewer. Point out bugs, races, unhandled errors, leaked goroutines and resources, and non-idiomatic code, most serious first. " +
+			"Cite the line or identifier for each issue and show the corrected code. Do not praise; if the code is fine, say so in one sentence.",
+		BuiltIn: true,
+	},
+	{
+		ID:   "go-beginner",
+		Name: "Explain like I'm new to Go",
+		Prompt: "You are a patient teacher for someone new to Go who knows another programming language. " +
+			"Explain concepts in plain words before showing code, define Go-specific terms (goroutine, interface, slice, defer) the first time they come up, and keep examples small and runnable.",
+		BuiltIn: true,
+	},
+	{
+		ID:      "concise",
+		Name:    "Concise",
+		Prompt:  "Answer as briefly as possible. Lead with the answer or the code; skip introductions, restating the question and closing summaries.",
+		BuiltIn: true,
+	},
+}
+
+// ListPersonas returns the built-in personas followed by the user's own
+func (a *App) ListPersonas() []Persona {
+	a.providersMutex.RLock()
+	defer a.providersMutex.RUnlock()
+	out := append([]Persona(nil), builtInPersonas...)
+	for _, p := range a.personas {
+		p.History = nil
+		out = append(out, p)
+	}
+	return out
+}
+
+// persona returns the persona with the given ID
+func (a *App) persona(id string) (Persona, bool) {
+	for _, p := range a.ListPersonas() {
+		if p.ID == id {
+			return p, true
+		}
+	}
+	return Persona{}, false
+}
+
+// SavePersona creates a persona, or updates one when its ID is set. An
+// update that changes the name, prompt or defaults keeps the previous
+// version.
+func (a *App) SavePersona(p Persona) (Persona, error) {
+	a.telemetry.recordFeature("save_persona")
+	p.Name, p.Prompt = strings.TrimSpace(p.Name), strings.TrimSpace(p.Prompt)
+	if p.Name == "" || p.Prompt == "" {
+		return Persona{}, fmt.Errorf("a persona needs a name and a prompt")
+	}
+	if err := p.Defaults.validate(); err != nil {
+		return Persona{}, fmt.Errorf("persona %s: %v", p.Name, err)
+	}
+	for _, b := range builtInPersonas {
+		if b.ID == p.ID {
+			return Persona{}, fmt.Errorf("%s is built in and can't be changed; save a copy under a new name", b.Name)
+		}
+	}
+	p.BuiltIn = false
+
+	a.providersMutex.Lock()
+	defer a.providersMutex.Unlock()
+	if p.ID == "" {
+		p.ID, p.Version, p.UpdatedAt, p.History = newID(), 1, time.Now().UTC(), nil
+		a.personas = append(a.personas, p)
+		return p, a.saveConfigLocked()
+	}
+	for i := range a.personas {
+		if a.personas[i].ID == p.ID {
+			a.personas[i] = a.personas[i].edited(p.Name, p.Prompt, p.Defaults)
+			saved := a.personas[i]
+			saved.History = nil
+			return saved, a.saveConfigLocked()
+		}
+	}
+	return Persona{}, fmt.Errorf("persona %q not found", p.ID)
+}
+
+// current returns the version of p as it is now
+func (p Persona) current() PersonaVersion {
+	return PersonaVersion{Version: max(p.Version, 1), Name: p.Name, Prompt: p.Prompt, Defaults: p.Defaults, SavedAt: p.UpdatedAt}
+}
+
+// edited returns p with a new name, prompt and defaults, as a new version
+// when they changed
+func (p Persona) edited(name, prompt string, defaults GenerationDefaults) Persona {
+	if name == p.Name && prompt == p.Prompt && defaults.equal(p.Defaults) {
+		return p
+	}
+	history := append(append([]PersonaVersion(nil), p.History...), p.current())
+	if over := len(history) - maxPersonaVersions; over > 0 {
+		history = history[over:]
+	}
+	p.History = history
+	p.Version = max(p.Version, 1) + 1
+	p.Name, p.Prompt, p.Defaults, p.UpdatedAt = name, prompt, defaults, time.Now().UTC()
+	return p
+}
+
+// personaVersions returns every kept version of a persona, oldest first
+func (a *App) personaVersions(id string) ([]PersonaVersion, error) {
+	for _, b := range builtInPersonas {
+		if b.ID == id {
+			return []PersonaVersion{b.current()}, nil
+		}
+	}
+	a.providersMutex.RLock()
+	defer a.providersMutex.RUnlock()
+	for _, p := range a.personas {
+		if p.ID == id {
+			return append(append([]PersonaVersion(nil), p.History...), p.current()), nil
+		}
+	}
+	return nil, fmt.Errorf("persona %q not found", id)
+}
+
+// ListPersonaVersions returns the kept versions of a persona, newest first
+func (a *App) ListPersonaVersions(id string) ([]PersonaVersion, error) {
+	versions, err := a.personaVersions(id)
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(versions)-1; i < j; i, j = i+1, j-1 {
+		versions[i], versions[j] = versions[j], versions[i]
+	}
+	return versions, nil
+}
+
+// DiffPersonaVersions returns a unified diff of a persona's prompt from one
+// version to another, preceded by the rename and the change of defaults if
+// there were any
+func (a *App) DiffPersonaVersions(id string, from, to int) (string, error) {
+	versions, err := a.personaVersions(id)
+	if err != nil {
+		return "", err
+	}
+	find := func(n int) (PersonaVersion, error) {
+		for _, v := range versions {
+			if v.Version == n {
+				return v, nil
+			}
+		}
+		return PersonaVersion{}, fmt.Errorf("version %d of persona %q is not kept", n, id)
+	}
+	old, err := find(from)
+	if err != nil {
+		return "", err
+	}
+	new, err := find(to)
+	if err != nil {
+		return "", err
+	}
+	var out strings.Builder
+	if old.Name != new.Name {
+		fmt.Fprintf(&out, "name: %q -> %q\n", old.Name, new.Name)
+	}
+	if !old.Defaults.equal(new.Defaults) {
+		fmt.Fprintf(&out, "defaults: %s -> %s\n", old.Defaults, new.Defaults)
+	}
+	if old.Prompt != new.Prompt {
+		out.WriteString(labeledDiff(fmt.Sprintf("v%d", from), fmt.Sprintf("v%d", to), old.Prompt+"\n", new.Prompt+"\n"))
+	}
+	return out.String(), nil
+}
+
+// RollbackPersona restores an earlier version of a persona. The restored
+// name, prompt and defaults are saved as a new version, so the rollback can
+// be undone too.
+func (a *App) RollbackPersona(id string, version int) (Persona, error) {
+	versions, err := a.personaVersions(id)
+	if err != nil {
+		return Persona{}, err
+	}
+	for _, v := range versions {
+		if v.Version == version {
+			return a.SavePersona(Persona{ID: id, Name: v.Name, Prompt: v.Prompt, Defaults: v.Defaults})
+		}
+	}
+	return Persona{}, fmt.Errorf("version %d of persona %q is not kept", version, id)
+}
+
+// DeletePersona moves a user-defined persona to the trash with its version
+// history. Conversations using it go back to having none, and get it back
+// when RestoreFromTrash restores it.
+func (a *App) DeletePersona(id string) error {
+	a.providersMutex.Lock()
+	defer a.providersMutex.Unlock()
+	for i := range a.personas {
+		if a.personas[i].ID == id {
+			p := a.personas[i]
+			p.DeletedAt = time.Now()
+			a.personas = append(a.personas[:i], a.personas[i+1:]...)
+			a.deletedPersonas = append(a.deletedPersonas, p)
+			return a.saveConfigLocked()
+		}
+	}
+	return fmt.Errorf("persona %q not found", id)
+}
+
+// trashedPersonas lists the personas in the trash
+func (a *App) trashedPersonas() []TrashItem {
+	a.providersMutex.RLock()
+	defer a.providersMutex.RUnlock()
+	out := make([]TrashItem, 0, len(a.deletedPersonas))
+	for _, p := range a.deletedPersonas {
+		out = append(out, TrashItem{
+			ID:        p.ID,
+			Kind:      "persona",
+			Title:     p.Name,
+			DeletedAt: p.DeletedAt,
+			ExpiresAt: p.DeletedAt.Add(trashRetention),
+		})
+	}
+	return out
+}
+
+// restorePersona moves a persona from the trash back to the user's
+// personas; found is false when it is not in the trash
+func (a *App) restorePersona(id string) (found bool, err error) {
+	a.providersMutex.Lock()
+	defer a.providersMutex.Unlock()
+	for i, p := range a.deletedPersonas {
+		if p.ID == id {
+			for _, existing := range a.personas {
+				if existing.ID == id {
+					return true, fmt.Errorf("persona %s already exists", existing.Name)
+				}
+			}
+			p.DeletedAt = time.Time{}
+			a.deletedPersonas = append(a.deletedPersonas[:i], a.deletedPersonas[i+1:]...)
+			a.personas = append(a.personas, p)
+			return true, a.saveConfigLocked()
+		}
+	}
+	return false, nil
+}
+
+// purgePersonas deletes the trashed personas match selects for good and
+// returns how many there were
+func (a *App) purgePersonas(match func(p Persona) bool) (int, error) {
+	a.providersMutex.Lock()
+	defer a.providersMutex.Unlock()
+	kept := a.deletedPersonas[:0]
+	for _, p := range a.deletedPersonas {
+		if !match(p) {
+			kept = append(kept, p)
+		}
+	}
+	purged := len(a.deletedPersonas) - len(kept)
+	if purged == 0 {
+		return 0, nil
+	}
+	a.deletedPersonas = kept
+	return purged, a.saveConfigLocked()
+}
+
+// SetSessionPersona runs a conversation under a persona, whose prompt is
+// sent as the system message; an empty ID removes it
+func (a *App) SetSessionPersona(sessionID string, personaID string) error {
+	if personaID != "" {
+		if _, ok := a.persona(personaID); !ok {
+			return fmt.Errorf("persona %q not found", personaID)
+		}
+	}
+	_, err := a.sessions.update(sessionID, func(s *Session) error {
+		s.Persona = personaID
+		return nil
+	})
+	return err
+}
+
+// personaDefaults returns the generation options of a conversation's persona
+func (a *App) personaDefaults(s Session) GenerationDefaults {
+	if s.Persona == "" {
+		return GenerationDefaults{}
+	}
+	p, _ := a.persona(s.Persona)
+	return p.Defaults
+}
+
+// personaContext returns the system message of a conversation's persona, if any
+func (a *App) personaContext(s Session) []Message {
+	if s.Persona == "" {
+		return nil
+	}
+	p, ok := a.persona(s.Persona)
+	if !ok {
+		return nil
+	}
+	return []Message{{Role: "system", Content: p.Prompt}}
+}
@@ -0,0 +1,484 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	pluginRequestTimeout = 5 * time.Minute
+	pluginHealthTimeout  = 5 * time.Second
+	pluginHealthInterval = 30 * time.Second
+	pluginBaseBackoff    = time.Second
+	pluginMaxBackoff     = time.Minute
+	// pluginStableAfter is how long a plugin must stay up for its failure count to reset
+	pluginStableAfter = time.Minute
+	// pluginMaxFailures consecutive crashes mark a plugin as crash-looping
+	pluginMaxFailures = 5
+	pluginStderrTail  = 2048
+)
+
+// Plugin states reported by ListPlugins
+const (
+	pluginStopped  = "stopped"
+	pluginRunning  = "running"
+	pluginBackoff  = "backoff"
+	pluginCrashed  = "crashed"
+	pluginDisabled = "disabled"
+)
+
+// PluginStatus describes the process behind a plugin provider
+type PluginStatus struct {
+	ProviderID  string    `json:"providerId"`
+	Name        string    `json:"name"`
+	State       string    `json:"state"`
+	PID         int       `json:"pid,omitempty"`
+	Restarts    int       `json:"restarts"`
+	LastError   string    `json:"lastError,omitempty"`
+	NextRestart time.Time `json:"nextRestart,omitempty"`
+}
+
+type pluginRequest struct {
+	ID     int64       `json:"id"`
+	Method string      `json:"method"`
+	Params interface{} `json:"params,omitempty"`
+}
+
+type pluginResponse struct {
+	ID     int64           `json:"id"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// PluginProvider runs an external provider as a child process speaking
+// newline-delimited JSON over stdin/stdout, so a misbehaving plugin cannot
+// take down the app. The process is started on first use, health-checked
+// while it runs, and restarted with exponential backoff when it exits. After
+// pluginMaxFailures consecutive crashes it stays down until re-enabled.
+type PluginProvider struct {
+	// writeMu serializes writes to stdin without holding mu, so a plugin
+	// that stops reading cannot block status queries or the health monitor
+	writeMu sync.Mutex
+
+	mu        sync.Mutex
+	config    ProviderConfig
+	cmd       *exec.Cmd
+	stdin     io.WriteCloser
+	stderr    *tailBuffer
+	pending   map[int64]chan pluginResponse
+	nextID    int64
+	state     string
+	startedAt time.Time
+	restarts  int
+	failures  int
+	lastError string
+	nextStart time.Time
+	stopping  bool
+	stopped   chan struct{}
+	// healthErr is why the monitor killed the process, if it did
+	healthErr error
+}
+
+func NewPluginProvider(config ProviderConfig) *PluginProvider {
+	state := pluginStopped
+	if config.Disabled {
+		state = pluginDisabled
+	}
+	return &PluginProvider{
+		config:  config,
+		pending: make(map[int64]chan pluginResponse),
+		state:   state,
+	}
+}
+
+func (p *PluginProvider) GetName() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.config.Name != "" {
+		return p.config.Name
+	}
+	return "Plugin"
+}
+
+func (p *PluginProvider) GetConfig() ProviderConfig {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.config
+}
+
+func (p *PluginProvider) SendRequest(prompt string, temperature float64, maxTokens int) (string, error) {
+	raw, err := p.call("generate", map[string]interface{}{
+		"prompt":      prompt,
+		"temperature": temperature,
+		"maxTokens":   maxTokens,
+		"model":       p.GetConfig().Model,
+	}, pluginRequestTimeout)
+	if err != nil {
+		return "", err
+	}
+
+	var result struct {
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return "", fmt.Errorf("invalid response: %v", err)
+	}
+	return result.Text, nil
+}
+
+// HealthCheck starts the plugin if needed and asks it for its version
+func (p *PluginProvider) HealthCheck() (string, error) {
+	raw, err := p.call("health", nil, pluginHealthTimeout)
+	if err != nil {
+		return "", err
+	}
+	var result struct {
+		Version string `json:"version"`
+	}
+	json.Unmarshal(raw, &result)
+	if result.Version == "" {
+		return "Plugin is healthy", nil
+	}
+	return "Plugin " + result.Version, nil
+}
+
+// Close stops the plugin process without restarting it
+func (p *PluginProvider) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stopLocked()
+	if p.state != pluginDisabled {
+		p.state = pluginStopped
+	}
+	return nil
+}
+
+// setEnabled is the plugin's kill switch: disabling kills the process
+// immediately and fails requests fast; enabling clears any crash-loop state
+func (p *PluginProvider) setEnabled(enabled bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.config.Disabled = !enabled
+	if enabled {
+		p.failures = 0
+		p.lastError = ""
+		if p.cmd == nil {
+			p.state = pluginStopped
+		}
+		return
+	}
+	p.stopLocked()
+	p.state = pluginDisabled
+}
+
+func (p *PluginProvider) status() PluginStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s := PluginStatus{
+		ProviderID: p.config.ID,
+		Name:       p.config.Name,
+		State:      p.state,
+		Restarts:   p.restarts,
+		LastError:  p.lastError,
+	}
+	if p.cmd != nil && p.cmd.Process != nil {
+		s.PID = p.cmd.Process.Pid
+	}
+	if p.state == pluginBackoff {
+		s.NextRestart = p.nextStart
+	}
+	return s
+}
+
+// call sends one request to the plugin and waits for the matching response
+func (p *PluginProvider) call(method string, params interface{}, timeout time.Duration) (json.RawMessage, error) {
+	p.mu.Lock()
+	if err := p.ensureRunningLocked(); err != nil {
+		p.mu.Unlock()
+		return nil, err
+	}
+	p.nextID++
+	id := p.nextID
+	ch := make(chan pluginResponse, 1)
+	p.pending[id] = ch
+	stdin := p.stdin
+	name := p.config.Name
+	p.mu.Unlock()
+
+	forget := func() {
+		p.mu.Lock()
+		delete(p.pending, id)
+		p.mu.Unlock()
+	}
+
+	line, err := json.Marshal(pluginRequest{ID: id, Method: method, Params: params})
+	if err != nil {
+		forget()
+		return nil, err
+	}
+	p.writeMu.Lock()
+	_, err = stdin.Write(append(line, '\n'))
+	p.writeMu.Unlock()
+	if err != nil {
+		forget()
+		return nil, fmt.Errorf("plugin %s: %v", name, err)
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case resp := <-ch:
+		if resp.Error != "" {
+			return nil, fmt.Errorf("plugin %s: %s", name, resp.Error)
+		}
+		return resp.Result, nil
+	case <-timer.C:
+		forget()
+		return nil, fmt.Errorf("plugin %s: timed out after %s", name, timeout)
+	}
+}
+
+func (p *PluginProvider) ensureRunningLocked() error {
+	switch p.state {
+	case pluginRunning:
+		return nil
+	case pluginDisabled:
+		return fmt.Errorf("plugin %s is disabled", p.config.Name)
+	case pluginCrashed:
+		return fmt.Errorf("plugin %s is crash-looping (%s); re-enable it to retry", p.config.Name, p.lastError)
+	case pluginBackoff:
+		return fmt.Errorf("plugin %s is restarting in %s", p.config.Name, time.Until(p.nextStart).Round(time.Second))
+	}
+	return p.startLocked()
+}
+
+func (p *PluginProvider) startLocked() error {
+	if p.config.Command == "" {
+		return fmt.Errorf("plugin %s has no command configured", p.config.Name)
+	}
+
+	cmd := exec.Command(p.config.Command, p.config.Args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	p.stderr = &tailBuffer{max: pluginStderrTail}
+	cmd.Stderr = p.stderr
+
+	if err := cmd.Start(); err != nil {
+		p.failedLocked(err)
+		return fmt.Errorf("plugin %s: %v", p.config.Name, err)
+	}
+
+	p.cmd = cmd
+	p.stdin = stdin
+	p.state = pluginRunning
+	p.startedAt = time.Now()
+	p.stopped = make(chan struct{})
+	go p.readLoop(cmd, stdout)
+	go p.monitor(cmd, p.stopped)
+	return nil
+}
+
+// stopLocked kills the running process; exited then skips the restart
+func (p *PluginProvider) stopLocked() {
+	if p.cmd == nil {
+		return
+	}
+	p.stopping = true
+	p.cmd.Process.Kill()
+}
+
+// readLoop delivers responses to waiting callers until the process exits
+func (p *PluginProvider) readLoop(cmd *exec.Cmd, stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var resp pluginResponse
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+			continue
+		}
+		p.mu.Lock()
+		ch, ok := p.pending[resp.ID]
+		delete(p.pending, resp.ID)
+		p.mu.Unlock()
+		if ok {
+			ch <- resp
+		}
+	}
+	p.exited(cmd, cmd.Wait())
+}
+
+// monitor health-checks a running process and kills it when it stops answering
+func (p *PluginProvider) monitor(cmd *exec.Cmd, stopped chan struct{}) {
+	ticker := time.NewTicker(pluginHealthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopped:
+			return
+		case <-ticker.C:
+			if _, err := p.call("health", nil, pluginHealthTimeout); err != nil {
+				p.mu.Lock()
+				if p.cmd == cmd {
+					p.healthErr = fmt.Errorf("health check failed: %v", err)
+					cmd.Process.Kill()
+				}
+				p.mu.Unlock()
+				return
+			}
+		}
+	}
+}
+
+func (p *PluginProvider) exited(cmd *exec.Cmd, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cmd != cmd {
+		return
+	}
+	close(p.stopped)
+	p.cmd = nil
+	p.stdin = nil
+	for id, ch := range p.pending {
+		ch <- pluginResponse{ID: id, Error: "plugin exited"}
+		delete(p.pending, id)
+	}
+
+	if p.stopping {
+		p.stopping = false
+		return
+	}
+	if time.Since(p.startedAt) >= pluginStableAfter {
+		p.failures = 0
+	}
+	switch {
+	case p.healthErr != nil:
+		err = p.healthErr
+		p.healthErr = nil
+	case err == nil:
+		err = fmt.Errorf("exited unexpectedly")
+	}
+	if tail := p.stderr.lastLine(); tail != "" {
+		err = fmt.Errorf("%v: %s", err, tail)
+	}
+	p.failedLocked(err)
+}
+
+// failedLocked records a crash or failed start and schedules a restart with
+// exponential backoff, or gives up once the plugin is crash-looping
+func (p *PluginProvider) failedLocked(err error) {
+	p.failures++
+	p.lastError = err.Error()
+	if p.failures >= pluginMaxFailures {
+		p.state = pluginCrashed
+		println("Plugin crash-looping:", p.config.Name, p.lastError)
+		return
+	}
+
+	backoff := pluginBaseBackoff << (p.failures - 1)
+	if backoff > pluginMaxBackoff {
+		backoff = pluginMaxBackoff
+	}
+	p.state = pluginBackoff
+	p.nextStart = time.Now().Add(backoff)
+	time.AfterFunc(backoff, func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.state == pluginBackoff && p.cmd == nil {
+			p.restarts++
+			p.startLocked()
+		}
+	})
+}
+
+// tailBuffer keeps the last max bytes written to it
+type tailBuffer struct {
+	mu  sync.Mutex
+	buf []byte
+	max int
+}
+
+func (t *tailBuffer) Write(b []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf = append(t.buf, b...)
+	if len(t.buf) > t.max {
+		t.buf = t.buf[len(t.buf)-t.max:]
+	}
+	return len(b), nil
+}
+
+func (t *tailBuffer) lastLine() string {
+	if t == nil {
+		return ""
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	lines := strings.Split(strings.TrimSpace(string(t.buf)), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// String returns what the buffer holds as text
+func (t *tailBuffer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return strings.ToValidUTF8(string(t.buf), "")
+}
+
+// ListPlugins reports the process state of every plugin provider
+func (a *App) ListPlugins() []PluginStatus {
+	a.providersMutex.RLock()
+	defer a.providersMutex.RUnlock()
+
+	var out []PluginStatus
+	for _, p := range a.providers {
+		if pp, ok := p.(*PluginProvider); ok {
+			out = append(out, pp.status())
+		}
+	}
+	return out
+}
+
+// SetPluginEnabled is the per-plugin kill switch. Disabling stops the plugin
+// process at once; enabling lets it start again on the next request.
+func (a *App) SetPluginEnabled(providerID string, enabled bool) error {
+	a.providersMutex.Lock()
+	defer a.providersMutex.Unlock()
+
+	i := a.providerIndexLocked(providerID)
+	if i == -1 {
+		return fmt.Errorf("provider %q not found", providerID)
+	}
+	pp, ok := a.providers[i].(*PluginProvider)
+	if !ok {
+		return fmt.Errorf("provider %s is not a plugin", a.providers[i].GetName())
+	}
+	pp.setEnabled(enabled)
+	return a.saveConfigLocked()
+}
+
+// closePlugins stops every plugin process
+func (a *App) closePlugins() {
+	a.providersMutex.RLock()
+	defer a.providersMutex.RUnlock()
+
+	for _, p := range a.providers {
+		if c, ok := p.(io.Closer); ok {
+			c.Close()
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// contextPreviewChars is how much of each context item a review shows
+const contextPreviewChars = 400
+
+// Kinds of context items
+const (
+	contextPersona    = "persona"
+	contextSummary    = "summary"
+	contextMessage    = "message"
+	contextStackTrace = "stacktrace"
+	contextLog        = "log"
+)
+
+// ContextItem is one part of the context sent with a prompt
+type ContextItem struct {
+	// ID is stable for the same content, so a selection made in a review
+	// still applies when the conversation is compacted in the meantime
+	ID string `json:"id"`
+	// Kind is persona, summary, message, stacktrace, log or file
+	Kind    string `json:"kind"`
+	Label   string `json:"label"`
+	Preview string `json:"preview"`
+	Tokens  int    `json:"tokens"`
+}
+
+// ContextReview is the context a prompt would be sent with
+type ContextReview struct {
+	Provider string `json:"provider"`
+	// Local is true when the provider runs on this machine or network, so
+	// review is not required
+	Local bool          `json:"local"`
+	Items []ContextItem `json:"items"`
+	// Tokens counts the prompt and every item
+	Tokens int `json:"tokens"`
+}
+
+// contextPart is a context item with what it contributes to the request
+type contextPart struct {
+	item     ContextItem
+	messages []Message
+	log      *AttachmentRef
+}
+
+func contextID(kind string, parts ...string) string {
+	h := sha256.New()
+	h.Write([]byte(kind))
+	for _, p := range parts {
+		h.Write([]byte{0})
+		h.Write([]byte(p))
+	}
+	return kind + ":" + hex.EncodeToString(h.Sum(nil))[:12]
+}
+
+func newContextPart(kind, label string, m Message, model string) contextPart {
+	preview := m.Content
+	if r := []rune(preview); len(r) > contextPreviewChars {
+		preview = string(r[:contextPreviewChars]) + "…"
+	}
+	return contextPart{
+		item: ContextItem{
+			ID:      contextID(kind, m.Role, m.Content),
+			Kind:    kind,
+			Label:   label,
+			Preview: preview,
+			Tokens:  countTokens(m.Content, model).Tokens,
+		},
+		messages: []Message{m},
+	}
+}
+
+// assembleContext returns the parts of the context a prompt in session is
+// sent with, in the order they are sent
+func (a *App) assembleContext(session Session, userMsg Message, model string) []contextPart {
+	var parts []contextPart
+	for _, m := range a.personaContext(session) {
+		p, _ := a.persona(session.Persona)
+		parts = append(parts, newContextPart(contextPersona, "Persona: "+p.Name, m, model))
+	}
+	history := sessionContext(session)
+	for i, m := range history {
+		kind, label := contextMessage, fmt.Sprintf("%s message %d of %d", m.Role, i+1, len(history))
+		if m.Role == "system" {
+			kind, label = contextSummary, "Summary of earlier messages"
+		}
+		parts = append(parts, newContextPart(kind, label, m, model))
+	}
+	if len(userMsg.StackTraces) > 0 {
+		m := Message{Role: "system", Content: stackTraceContext(userMsg.StackTraces)}
+		parts = append(parts, newContextPart(contextStackTrace, "Parsed stack traces", m, model))
+	}
+	for _, ref := range logAttachments(session) {
+		ref := ref
+		parts = append(parts, contextPart{
+			item: ContextItem{
+				ID:      contextID(contextLog, ref.Hash),
+				Kind:    contextLog,
+				Label:   "Log file " + ref.Name,
+				Preview: fmt.Sprintf("%s (%s bytes), searched with the log tool", ref.Name, a.reportFormatter().Int(ref.Size)),
+			},
+			log: &ref,
+		})
+	}
+	return parts
+}
+
+// selectContext drops excluded parts and returns the messages and log files
+// the rest contribute
+func (a *App) selectContext(parts []contextPart, excluded []string) ([]Message, []AttachmentRef) {
+	skip := make(map[string]bool, len(excluded))
+	for _, id := range excluded {
+		skip[id] = true
+	}
+	var messages []Message
+	var logs []AttachmentRef
+	for _, p := range parts {
+		if skip[p.item.ID] {
+			continue
+		}
+		messages = append(messages, p.messages...)
+		if p.log != nil {
+			logs = append(logs, *p.log)
+		}
+	}
+	if len(logs) > 0 {
+		messages = append(messages, Message{Role: "system", Content: logToolContext(logs, a.reportFormatter())})
+	}
+	return messages, logs
+}
+
+// errReviewRequired is returned for requests to hosted providers that did not
+// go through a pre-send review while it is on
+func errReviewRequired(provider string) error {
+	return fmt.Errorf("pre-send review is on: nothing is sent to %s before you review it; use ReviewContext and SendReviewedPrompt", provider)
+}
+
+// SetPreSendReview switches pre-send review. While it is on, nothing is
+// sent to a hosted provider unless it was reviewed with ReviewContext and
+// sent with SendReviewedPrompt; any other request to one fails, including
+// background summaries and suggestions. Local providers are not affected.
+func (a *App) SetPreSendReview(enabled bool) error {
+	a.providersMutex.Lock()
+	defer a.providersMutex.Unlock()
+	a.preSendReview = enabled
+	return a.saveConfigLocked()
+}
+
+// GetPreSendReview reports whether pre-send review is on
+func (a *App) GetPreSendReview() bool {
+	a.providersMutex.RLock()
+	defer a.providersMutex.RUnlock()
+	return a.preSendReview
+}
+
+// ReviewContext returns the context a prompt would be sent with in the
+// active conversation, item by item, for choosing what to leave out before
+// sending it with SendReviewedPrompt
+func (a *App) ReviewContext(prompt string) (ContextReview, error) {
+	a.telemetry.recordFeature("review_context")
+	provider, err := a.selectProvider("")
+	if err != nil {
+		return ContextReview{}, err
+	}
+	config := provider.GetConfig()
+	var session Session
+	if id := a.GetActiveSession(); id != "" {
+		session, _ = a.sessions.get(id)
+	}
+	userMsg := Message{Role: "user", Content: prompt, StackTraces: a.ParseStackTrace(prompt)}
+	review := ContextReview{Provider: provider.GetName(), Local: isLocalProvider(config), Items: []ContextItem{}}
+	review.Tokens = countTokens(prompt, config.Model).Tokens
+	for _, p := range a.assembleContext(session, userMsg, config.Model) {
+		review.Items = append(review.Items, p.item)
+		review.Tokens += p.item.Tokens
+	}
+	return review, nil
+}
+
+// SendReviewedPrompt is SendPrompt after a pre-send review: the items of
+// ReviewContext whose IDs are in excluded are left out of the request
+func (a *App) SendReviewedPrompt(prompt string, excluded []string) (string, error) {
+	a.telemetry.recordFeature("send_reviewed_prompt")
+	result, err := a.sendPrompt(prompt, sendOptions{reviewed: true, excluded: excluded}, nil)
+	return result.Response, err
+}
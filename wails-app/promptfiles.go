@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// fileContextTokens is the budget for the files attached to one prompt
+const fileContextTokens = 16000
+
+// contextFile is the kind of context items for attached files
+const contextFile = "file"
+
+// PromptFile is a workspace file sent with a prompt
+type PromptFile struct {
+	Path   string `json:"path"`
+	Tokens int    `json:"tokens"`
+	// Truncated is set when only the start of the file fit in the budget
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// attachedFile is a file read for a prompt, ready to be fenced
+type attachedFile struct {
+	PromptFile
+	language string
+	content  string
+}
+
+// codeFence returns a backtick fence longer than any run of backticks in
+// content, so the content can't close it
+func codeFence(content string) string {
+	longest, run := 0, 0
+	for _, r := range content {
+		if r == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	return strings.Repeat("`", max(3, longest+1))
+}
+
+// fencedFile renders a file as a code block named after its path
+func fencedFile(f attachedFile) string {
+	content := f.content
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	fence := codeFence(content)
+	text := fmt.Sprintf("File: %s\n%s%s\n%s%s", f.Path, fence, f.language, content, fence)
+	if f.Truncated {
+		text += "\n[the rest of the file was left out to fit the context budget]"
+	}
+	return text
+}
+
+// cutToTokens returns the longest run of whole lines at the start of content
+// that fits in tokens
+func cutToTokens(content string, tokens int) string {
+	lines := strings.SplitAfter(content, "\n")
+	used := 0
+	for i, line := range lines {
+		if used += estimateTokens(line); used > tokens {
+			return strings.Join(lines[:i], "")
+		}
+	}
+	return content
+}
+
+// fitFiles keeps files within budget tokens in order: each file is kept
+// whole while it fits, the first that doesn't is cut to what is left, and
+// the files after it are returned as skipped
+func fitFiles(files []attachedFile, budget int, model string) (kept, skipped []attachedFile) {
+	left := budget
+	for i, f := range files {
+		f.Tokens = countTokens(f.content, model).Tokens
+		if f.Tokens <= left {
+			left -= f.Tokens
+			kept = append(kept, f)
+			continue
+		}
+		f.content, f.Truncated = cutToTokens(f.content, left), true
+		if f.content == "" {
+			return kept, files[i:]
+		}
+		f.Tokens = countTokens(f.content, model).Tokens
+		return append(kept, f), files[i+1:]
+	}
+	return kept, nil
+}
+
+// readPromptFile reads a text file in a conversation's directory for a prompt
+func (a *App) readPromptFile(sessionID, path string) (attachedFile, error) {
+	target, err := a.sandboxedPath(sessionID, path)
+	if err != nil {
+		return attachedFile{}, err
+	}
+	name := filepath.ToSlash(filepath.Clean(filepath.FromSlash(path)))
+	content, exists, err := a.currentText(target, name)
+	if err != nil {
+		return attachedFile{}, err
+	}
+	if !exists {
+		return attachedFile{}, fmt.Errorf("%s does not exist", name)
+	}
+	return attachedFile{PromptFile: PromptFile{Path: name}, language: fileLanguage(name), content: content}, nil
+}
+
+// fileContext returns a context part for each attached file
+func fileContext(files []attachedFile, model string) []contextPart {
+	parts := make([]contextPart, len(files))
+	for i, f := range files {
+		m := Message{Role: "system", Content: fencedFile(f)}
+		parts[i] = newContextPart(contextFile, "File "+f.Path, m, model)
+	}
+	return parts
+}
+
+// activeModel returns the active provider's model, for counting tokens
+func (a *App) activeModel() string {
+	if p, err := a.selectProvider(""); err == nil {
+		return p.GetConfig().Model
+	}
+	return ""
+}
+
+// SendPromptWithFiles is SendPrompt with workspace files attached as
+// context, each in a code block named after its path with a language hint.
+// The files share a budget of 16,000 tokens: the first that doesn't fit is
+// cut short and the ones after it are left out. The user message records
+// which files were sent.
+func (a *App) SendPromptWithFiles(prompt string, paths []string) (string, error) {
+	a.telemetry.recordFeature("send_prompt_with_files")
+	if len(paths) == 0 {
+		return "", fmt.Errorf("no files to attach")
+	}
+	files := make([]attachedFile, 0, len(paths))
+	seen := make(map[string]bool)
+	for _, p := range paths {
+		f, err := a.readPromptFile("", p)
+		if err != nil {
+			return "", err
+		}
+		if !seen[f.Path] {
+			seen[f.Path] = true
+			files = append(files, f)
+		}
+	}
+	kept, _ := fitFiles(files, fileContextTokens, a.activeModel())
+	result, err := a.sendPrompt(prompt, sendOptions{files: kept}, nil)
+	return result.Response, err
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxPromptHistory is how many prompts the history keeps
+const maxPromptHistory = 1000
+
+// PromptHistoryEntry is a prompt as it was typed
+type PromptHistoryEntry struct {
+	Prompt    string    `json:"prompt"`
+	SessionID string    `json:"sessionId,omitempty"`
+	SentAt    time.Time `json:"sentAt"`
+}
+
+// promptHistory keeps raw prompts, oldest first, apart from the sessions
+// they were sent in
+type promptHistory struct {
+	mu      sync.Mutex
+	path    string
+	entries []PromptHistoryEntry
+}
+
+func newPromptHistory() *promptHistory {
+	return &promptHistory{}
+}
+
+func (ph *promptHistory) open(path string) error {
+	ph.mu.Lock()
+	defer ph.mu.Unlock()
+
+	ph.path = path
+	if err := readJSONFile(path, &ph.entries); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (ph *promptHistory) saveLocked() {
+	if ph.path == "" {
+		return
+	}
+	if err := writeJSONFile(ph.path, ph.entries); err != nil {
+		println("Error saving prompt history:", err.Error())
+	}
+}
+
+// add records a prompt; sending the previous prompt again only moves it forward
+func (ph *promptHistory) add(prompt, sessionID string) {
+	if strings.TrimSpace(prompt) == "" {
+		return
+	}
+	ph.mu.Lock()
+	defer ph.mu.Unlock()
+
+	entry := PromptHistoryEntry{Prompt: prompt, SessionID: sessionID, SentAt: time.Now().UTC()}
+	if n := len(ph.entries); n > 0 && ph.entries[n-1].Prompt == prompt {
+		ph.entries[n-1] = entry
+	} else {
+		ph.entries = append(ph.entries, entry)
+	}
+	if over := len(ph.entries) - maxPromptHistory; over > 0 {
+		ph.entries = append([]PromptHistoryEntry(nil), ph.entries[over:]...)
+	}
+	ph.saveLocked()
+}
+
+// forgetSession drops the prompts sent in a session
+func (ph *promptHistory) forgetSession(sessionID string) {
+	ph.mu.Lock()
+	defer ph.mu.Unlock()
+	kept := ph.entries[:0]
+	for _, e := range ph.entries {
+		if e.SessionID != sessionID {
+			kept = append(kept, e)
+		}
+	}
+	ph.entries = kept
+	ph.saveLocked()
+}
+
+// GetPromptHistory returns earlier prompts, newest first, for recalling
+// them with the up arrow. query, when set, keeps prompts containing it
+// (ignoring case), and limit caps how many are returned when positive.
+func (a *App) GetPromptHistory(query string, limit int) []PromptHistoryEntry {
+	ph := a.history
+	ph.mu.Lock()
+	defer ph.mu.Unlock()
+
+	query = strings.ToLower(strings.TrimSpace(query))
+	out := []PromptHistoryEntry{}
+	for i := len(ph.entries) - 1; i >= 0; i-- {
+		if limit > 0 && len(out) == limit {
+			break
+		}
+		if query != "" && !strings.Contains(strings.ToLower(ph.entries[i].Prompt), query) {
+			continue
+		}
+		out = append(out, ph.entries[i])
+	}
+	return out
+}
+
+// ClearPromptHistory forgets every prompt in the history
+func (a *App) ClearPromptHistory() {
+	ph := a.history
+	ph.mu.Lock()
+	defer ph.mu.Unlock()
+	ph.entries = nil
+	ph.saveLocked()
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// The provider conformance suite runs the same cases against every Provider
+// implementation, each talking to a fake backend that echoes prompts back.
+// A new provider joins the matrix by adding an entry to contractBackends.
+// No provider supports tool calling yet; tool-call round trips become a case
+// when one does.
+
+// contractProvider is a provider under test with hooks into its backend.
+// Hooks a backend cannot support are nil and the cases needing them are skipped.
+type contractProvider struct {
+	Provider
+	app *App
+	// failNext makes the backend fail its next request with an HTTP status
+	failNext func(status int)
+	// hold pauses the next streamed reply after its first chunk until release is called
+	hold func() (release func())
+}
+
+type contractBackend struct {
+	name  string
+	start func(t *testing.T) contractProvider
+}
+
+// contractEcho is the reply every fake backend gives, so cases can check the
+// prompt survived the round trip
+func contractEcho(prompt string) string {
+	return "You said: " + prompt
+}
+
+// addContractProvider adds a provider to a test app and returns the live instance
+func addContractProvider(t *testing.T, a *App, config ProviderConfig) Provider {
+	t.Helper()
+	info, err := a.AddProvider(config)
+	if err != nil {
+		t.Fatalf("AddProvider: %v", err)
+	}
+	p, err := a.providerByID(info.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, ok := p.(io.Closer); ok {
+		t.Cleanup(func() { c.Close() })
+	}
+	return p
+}
+
+// holdChannel installs a hold channel under mu and returns an idempotent
+// release, which also runs on cleanup so the fake server can shut down
+func holdChannel(t *testing.T, mu *sync.Mutex, hold *chan struct{}) func() {
+	ch := make(chan struct{})
+	mu.Lock()
+	*hold = ch
+	mu.Unlock()
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			mu.Lock()
+			*hold = nil
+			mu.Unlock()
+			close(ch)
+		})
+	}
+	t.Cleanup(release)
+	return release
+}
+
+func fakeOllamaContract(t *testing.T) (*App, *fakeOllama, Provider) {
+	a := newTestApp()
+	f := newFakeOllama(t, fakeModel)
+	f.reply = contractEcho
+	p := addContractProvider(t, a, ProviderConfig{Name: "Ollama", Type: "Ollama", Endpoint: f.URL, Model: fakeModel})
+	return a, f, p
+}
+
+var contractBackends = []contractBackend{
+	{"Mock", func(t *testing.T) contractProvider {
+		a := newTestApp()
+		return contractProvider{Provider: addContractProvider(t, a, ProviderConfig{Name: "Mock", Type: "Mock"}), app: a}
+	}},
+	{"Ollama", func(t *testing.T) contractProvider {
+		a, f, p := fakeOllamaContract(t)
+		return contractProvider{
+			Provider: p,
+			app:      a,
+			failNext: func(status int) { f.failNext(status) },
+			hold:     func() func() { return holdChannel(t, &f.mu, &f.hold) },
+		}
+	}},
+	{"OpenAI", func(t *testing.T) contractProvider {
+		a := newTestApp()
+		f := newFakeOpenAI(t)
+		f.reply = contractEcho
+		p := addContractProvider(t, a, ProviderConfig{Name: "OpenAI", Type: "OpenAI", Endpoint: f.URL, Model: "gpt-4o-mini", APIKey: "sk-test"})
+		return contractProvider{
+			Provider: p,
+			app:      a,
+			failNext: func(status int) { f.failNext(status) },
+			hold:     func() func() { return holdChannel(t, &f.mu, &f.hold) },
+		}
+	}},
+	{"Group", func(t *testing.T) contractProvider {
+		a, f, member := fakeOllamaContract(t)
+		p := addContractProvider(t, a, ProviderConfig{Name: "Group", Type: "Group", Members: []string{member.GetConfig().ID}})
+		return contractProvider{
+			Provider: p,
+			app:      a,
+			failNext: func(status int) { f.failNext(status) },
+			hold:     func() func() { return holdChannel(t, &f.mu, &f.hold) },
+		}
+	}},
+	{"Plugin", func(t *testing.T) contractProvider {
+		t.Setenv(contractPluginEnv, "1")
+		a := newTestApp()
+		p := addContractProvider(t, a, ProviderConfig{
+			Name:    "Plugin",
+			Type:    "Plugin",
+			Command: os.Args[0],
+			Args:    []string{"-test.run=^TestContractPluginProcess$"},
+		})
+		return contractProvider{Provider: p, app: a}
+	}},
+}
+
+const contractPluginEnv = "VIBE_CODER_CONTRACT_PLUGIN"
+
+// TestContractPluginProcess is not a test of its own: the Plugin backend runs
+// the test binary with it selected to act as an echoing plugin process
+func TestContractPluginProcess(t *testing.T) {
+	if os.Getenv(contractPluginEnv) != "1" {
+		return
+	}
+	out := json.NewEncoder(os.Stdout)
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var req struct {
+			ID     int64  `json:"id"`
+			Method string `json:"method"`
+			Params struct {
+				Prompt string `json:"prompt"`
+			} `json:"params"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			continue
+		}
+		switch req.Method {
+		case "generate":
+			out.Encode(map[string]interface{}{"id": req.ID, "result": map[string]string{"text": contractEcho(req.Params.Prompt)}})
+		case "health":
+			out.Encode(map[string]interface{}{"id": req.ID, "result": map[string]string{"version": "contract"}})
+		default:
+			out.Encode(map[string]interface{}{"id": req.ID, "error": "unknown method " + req.Method})
+		}
+	}
+	os.Exit(0)
+}
+
+const contractUnicodePrompt = "Grüße, 世界! 👋🏽 مرحبا بالعالم 👨‍👩‍👧 🇯🇵 é"
+
+func TestProviderContract(t *testing.T) {
+	for _, backend := range contractBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			for _, c := range contractCases {
+				t.Run(c.name, func(t *testing.T) {
+					c.run(t, backend.start(t))
+				})
+			}
+		})
+	}
+}
+
+var contractCases = []struct {
+	name string
+	run  func(t *testing.T, p contractProvider)
+}{
+	{"send", contractSend},
+	{"stream", contractStream},
+	{"unicode", contractUnicode},
+	{"errors", contractErrors},
+	{"cancel before send", contractCancelBeforeSend},
+	{"cancel mid-stream", contractCancelMidStream},
+}
+
+// contractSend: a reply carries the prompt back and reports no error
+func contractSend(t *testing.T, p contractProvider) {
+	prompt := "What is the capital of France?"
+	response, err := p.SendRequest(prompt, 0.7, 256)
+	if err != nil {
+		t.Fatalf("SendRequest: %v", err)
+	}
+	if !strings.Contains(response, prompt) {
+		t.Fatalf("response %q does not contain the prompt", response)
+	}
+}
+
+// contractStream: streamed chunks add up to the returned reply, which matches
+// the unstreamed reply
+func contractStream(t *testing.T, p contractProvider) {
+	sp, ok := p.Provider.(StreamingProvider)
+	if !ok {
+		t.Skip("provider does not stream")
+	}
+	prompt := "Explain goroutines in a few words please"
+	var chunks []string
+	response, err := sp.StreamRequest(prompt, 0.7, 256, func(chunk string) {
+		chunks = append(chunks, chunk)
+	})
+	if err != nil {
+		t.Fatalf("StreamRequest: %v", err)
+	}
+	if len(chunks) < 2 {
+		t.Fatalf("got %d chunks, want the reply streamed incrementally", len(chunks))
+	}
+	if joined := strings.Join(chunks, ""); joined != response {
+		t.Fatalf("chunks add up to %q, reply is %q", joined, response)
+	}
+	sent, err := p.SendRequest(prompt, 0.7, 256)
+	if err != nil {
+		t.Fatalf("SendRequest: %v", err)
+	}
+	if sent != response {
+		t.Fatalf("streamed reply %q differs from unstreamed reply %q", response, sent)
+	}
+}
+
+// contractUnicode: multi-byte text, combining marks, emoji sequences and
+// right-to-left text survive the round trip, and every streamed event the
+// app emits is valid UTF-8 on its own
+func contractUnicode(t *testing.T, p contractProvider) {
+	response, err := p.SendRequest(contractUnicodePrompt, 0.7, 256)
+	if err != nil {
+		t.Fatalf("SendRequest: %v", err)
+	}
+	if !strings.Contains(response, contractUnicodePrompt) {
+		t.Fatalf("response %q does not contain the prompt intact", response)
+	}
+
+	var events []string
+	var streamed bool
+	req := generateRequest{Prompt: contractUnicodePrompt, MaxTokens: 256, OnChunk: func(chunk string) {
+		events = append(events, chunk)
+	}}
+	response, err = p.app.send(p.Provider, req, 0.7, &streamed)
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	for _, e := range events {
+		if !utf8.ValidString(e) {
+			t.Fatalf("streamed event %q is not valid UTF-8", e)
+		}
+	}
+	if joined := strings.Join(events, ""); joined != response || !streamed {
+		t.Fatalf("events add up to %q, reply is %q", joined, response)
+	}
+}
+
+// contractErrors: backend failures surface as errors the retry loop and error
+// descriptions recognize, with no partial reply
+func contractErrors(t *testing.T, p contractProvider) {
+	if p.failNext == nil {
+		t.Skip("backend cannot inject HTTP failures")
+	}
+	for _, tc := range []struct {
+		status    int
+		want      string
+		transient bool
+	}{
+		{503, "HTTP 503", true},
+		{429, "HTTP 429", true},
+		{401, "HTTP 401", false},
+		{400, "HTTP 400", false},
+	} {
+		p.failNext(tc.status)
+		response, err := p.SendRequest("hello", 0.7, 256)
+		if err == nil {
+			t.Fatalf("HTTP %d: no error, response %q", tc.status, response)
+		}
+		if response != "" {
+			t.Errorf("HTTP %d: got partial response %q", tc.status, response)
+		}
+		if !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("HTTP %d: error %q does not mention %q", tc.status, err, tc.want)
+		}
+		if isTransientError(err) != tc.transient {
+			t.Errorf("HTTP %d: isTransientError(%q) = %v, want %v", tc.status, err, !tc.transient, tc.transient)
+		}
+	}
+}
+
+// contractCancelBeforeSend: a request cancelled before it starts never reaches the backend
+func contractCancelBeforeSend(t *testing.T, p contractProvider) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var streamed bool
+	_, err := p.app.sendCancellable(ctx, p.Provider, generateRequest{Prompt: "hello", MaxTokens: 256}, 0.7, &streamed, nil)
+	if !errors.Is(err, errCancelled) {
+		t.Fatalf("error = %v, want %v", err, errCancelled)
+	}
+}
+
+// contractCancelMidStream: cancelling a streaming request returns at once and
+// nothing streamed afterwards reaches the caller
+func contractCancelMidStream(t *testing.T, p contractProvider) {
+	if _, ok := p.Provider.(StreamingProvider); !ok || p.hold == nil {
+		t.Skip("backend cannot pause a stream")
+	}
+	release := p.hold()
+
+	var mu sync.Mutex
+	var chunks []string
+	first := make(chan struct{})
+	req := generateRequest{Prompt: "Write a long story", MaxTokens: 256, OnChunk: func(chunk string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if chunks = append(chunks, chunk); len(chunks) == 1 {
+			close(first)
+		}
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	result := make(chan error, 1)
+	go func() {
+		var streamed bool
+		_, err := p.app.sendCancellable(ctx, p.Provider, req, 0.7, &streamed, nil)
+		result <- err
+	}()
+
+	select {
+	case <-first:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no chunk arrived before the stream paused")
+	}
+	cancel()
+	select {
+	case err := <-result:
+		if !errors.Is(err, errCancelled) {
+			t.Fatalf("error = %v, want %v", err, errCancelled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("request did not return after cancellation")
+	}
+
+	mu.Lock()
+	seen := len(chunks)
+	mu.Unlock()
+	release()
+	// Give the abandoned stream time to deliver the rest of the reply
+	time.Sleep(100 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(chunks) != seen {
+		t.Fatalf("%d chunks arrived after cancellation", len(chunks)-seen)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+const (
+	// defaultOllamaConcurrency keeps a local Ollama from loading itself with
+	// more generations than it can run at once; other providers are unlimited
+	// unless configured
+	defaultOllamaConcurrency = 2
+	// maxQueuedRequests is how many requests may wait for one provider before
+	// new ones are turned away
+	maxQueuedRequests = 32
+)
+
+// QueueStatus reports the request queue of one provider
+type QueueStatus struct {
+	ProviderID    string `json:"providerId"`
+	Provider      string `json:"provider"`
+	MaxConcurrent int    `json:"maxConcurrent"`
+	Running       int    `json:"running"`
+	Waiting       int    `json:"waiting"`
+}
+
+// providerQueue limits the generations running against a provider. Requests
+// over the limit wait in arrival order, and at most maxQueuedRequests wait.
+type providerQueue struct {
+	limit int
+	name  string
+	slots chan struct{}
+
+	mu      sync.Mutex
+	waiting int
+}
+
+// maxConcurrent returns the concurrency limit for a provider, or 0 for none
+func maxConcurrent(config ProviderConfig) int {
+	if config.MaxConcurrent > 0 {
+		return config.MaxConcurrent
+	}
+	if config.Type == "Ollama" {
+		return defaultOllamaConcurrency
+	}
+	return 0
+}
+
+// acquire takes a slot, waiting for one when all are busy. onWait is told
+// the request's place in line before it starts waiting.
+func (q *providerQueue) acquire(ctx context.Context, onWait func(position int)) error {
+	select {
+	case q.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	q.mu.Lock()
+	if q.waiting >= maxQueuedRequests {
+		q.mu.Unlock()
+		return fmt.Errorf("request queue for %s is full (%d waiting)", q.name, q.waiting)
+	}
+	q.waiting++
+	position := q.waiting
+	q.mu.Unlock()
+	defer func() {
+		q.mu.Lock()
+		q.waiting--
+		q.mu.Unlock()
+	}()
+
+	onWait(position)
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	select {
+	case q.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return errCancelled
+	}
+}
+
+// done returns the function that frees a slot taken by acquire, or nil for a nil queue
+func (q *providerQueue) done() func() {
+	if q == nil {
+		return nil
+	}
+	return func() { <-q.slots }
+}
+
+func (q *providerQueue) status() (running, waiting int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.slots), q.waiting
+}
+
+// requestQueue returns the queue for a provider, or nil when its
+// generations are not limited. Members of a group are limited only when
+// requests are sent to them directly.
+func (a *App) requestQueue(provider Provider) *providerQueue {
+	config := provider.GetConfig()
+	limit := maxConcurrent(config)
+	if limit <= 0 {
+		return nil
+	}
+	a.queuesMutex.Lock()
+	defer a.queuesMutex.Unlock()
+
+	q := a.queues[config.ID]
+	// Requests holding a slot in a replaced queue release it there
+	if q == nil || q.limit != limit {
+		q = &providerQueue{limit: limit, name: provider.GetName(), slots: make(chan struct{}, limit)}
+		if a.queues == nil {
+			a.queues = make(map[string]*providerQueue)
+		}
+		a.queues[config.ID] = q
+	}
+	return q
+}
+
+// GetRequestQueue reports running and waiting generations for each provider
+// with a concurrency limit
+func (a *App) GetRequestQueue() []QueueStatus {
+	a.providersMutex.RLock()
+	providers := append([]Provider(nil), a.providers...)
+	a.providersMutex.RUnlock()
+
+	statuses := []QueueStatus{}
+	for _, p := range providers {
+		q := a.requestQueue(p)
+		if q == nil {
+			continue
+		}
+		running, waiting := q.status()
+		statuses = append(statuses, QueueStatus{
+			ProviderID:    p.GetConfig().ID,
+			Provider:      p.GetName(),
+			MaxConcurrent: q.limit,
+			Running:       running,
+			Waiting:       waiting,
+		})
+	}
+	return statuses
+}
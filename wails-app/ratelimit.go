@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// tokenBucket is a token-bucket limiter refilled continuously at a per-minute
+// rate. Reservations may take the level below zero: a caller that finds the
+// bucket empty is told how long to wait, and callers after it queue up behind
+// it instead of being turned away.
+type tokenBucket struct {
+	mu       sync.Mutex
+	capacity float64
+	perSec   float64
+	level    float64
+	last     time.Time
+}
+
+func newTokenBucket(perMinute int) *tokenBucket {
+	if perMinute <= 0 {
+		return nil
+	}
+	return &tokenBucket{
+		capacity: float64(perMinute),
+		perSec:   float64(perMinute) / 60,
+		level:    float64(perMinute),
+		last:     time.Now(),
+	}
+}
+
+func (b *tokenBucket) refillLocked() {
+	now := time.Now()
+	b.level = min(b.capacity, b.level+now.Sub(b.last).Seconds()*b.perSec)
+	b.last = now
+}
+
+// reserve takes n tokens and returns how long to wait before using them. A
+// nil bucket is unlimited.
+func (b *tokenBucket) reserve(n float64) time.Duration {
+	if b == nil || n <= 0 {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refillLocked()
+	// A request bigger than the whole bucket would otherwise never fit
+	b.level -= min(n, b.capacity)
+	if b.level >= 0 {
+		return 0
+	}
+	return time.Duration(-b.level / b.perSec * float64(time.Second))
+}
+
+// refund returns tokens that were reserved but not used
+func (b *tokenBucket) refund(n float64) {
+	if b == nil || n <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refillLocked()
+	b.level = min(b.capacity, b.level+min(n, b.capacity))
+}
+
+// providerLimiter enforces a provider's requests-per-minute and
+// tokens-per-minute limits
+type providerLimiter struct {
+	rpm, tpm int
+	requests *tokenBucket
+	tokens   *tokenBucket
+}
+
+// reserve books one request of cost tokens and returns the wait before it may be sent
+func (l *providerLimiter) reserve(cost int) time.Duration {
+	if l == nil {
+		return 0
+	}
+	return max(l.requests.reserve(1), l.tokens.reserve(float64(cost)))
+}
+
+// release gives back a reservation that was never sent
+func (l *providerLimiter) release(cost int) {
+	if l == nil {
+		return
+	}
+	l.requests.refund(1)
+	l.tokens.refund(float64(cost))
+}
+
+// settle returns the tokens a sent request reserved beyond what it used
+func (l *providerLimiter) settle(cost, used int) {
+	if l == nil {
+		return
+	}
+	l.tokens.refund(float64(cost - used))
+}
+
+// rateLimiter returns the limiter for a provider, or nil when it has no
+// limits. Limiters are shared by every request to the provider and are
+// rebuilt when its limits change. Members of a group are limited the same
+// way when the group sends them a request, by limitMember.
+func (a *App) rateLimiter(config ProviderConfig) *providerLimiter {
+	if config.RequestsPerMinute <= 0 && config.TokensPerMinute <= 0 {
+		return nil
+	}
+	a.limitersMutex.Lock()
+	defer a.limitersMutex.Unlock()
+
+	l := a.limiters[config.ID]
+	if l == nil || l.rpm != config.RequestsPerMinute || l.tpm != config.TokensPerMinute {
+		l = &providerLimiter{
+			rpm:      config.RequestsPerMinute,
+			tpm:      config.TokensPerMinute,
+			requests: newTokenBucket(config.RequestsPerMinute),
+			tokens:   newTokenBucket(config.TokensPerMinute),
+		}
+		if a.limiters == nil {
+			a.limiters = make(map[string]*providerLimiter)
+		}
+		a.limiters[config.ID] = l
+	}
+	return l
+}
+
+// dispatchKey carries a request's dispatchInfo to the providers serving it
+type dispatchKey struct{}
+
+// dispatchInfo is what a group learns about the request it serves, and the
+// time it spent waiting for its members' rate limits
+type dispatchInfo struct {
+	requestID string
+	queuedMs  atomic.Int64
+}
+
+func withDispatch(ctx context.Context, requestID string) (context.Context, *dispatchInfo) {
+	info := &dispatchInfo{requestID: requestID}
+	return context.WithValue(requestContext(ctx), dispatchKey{}, info), info
+}
+
+// limitMember waits until a group member's rate limits allow a request and
+// returns the function that settles the reservation with the reply. It fails
+// only when ctx is cancelled while waiting.
+func (a *App) limitMember(ctx context.Context, member Provider, prompt string, maxTokens int) (func(response string), error) {
+	config := member.GetConfig()
+	limiter := a.rateLimiter(config)
+	if limiter == nil {
+		return func(string) {}, nil
+	}
+	promptTokens := countTokens(prompt, config.Model).Tokens
+	cost := promptTokens + maxTokens
+	if wait := limiter.reserve(cost); wait > 0 {
+		info, _ := requestContext(ctx).Value(dispatchKey{}).(*dispatchInfo)
+		e := RequestQueuedEvent{Provider: member.GetName(), Reason: "rate-limit", DelayMs: wait.Milliseconds()}
+		if info != nil {
+			e.RequestID = info.requestID
+			info.queuedMs.Add(wait.Milliseconds())
+		}
+		a.emit(EventRequestQueued, e)
+		if !sleepContext(ctx, wait) {
+			limiter.release(cost)
+			return nil, errCancelled
+		}
+	}
+	return func(response string) {
+		limiter.settle(cost, promptTokens+countTokens(response, config.Model).Tokens)
+	}, nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	// readFileMaxBytes is the largest file the read_file tool opens
+	readFileMaxBytes = 1 << 20
+	// readFileChars caps one read_file result, leaving room under
+	// toolOutputChars for its header
+	readFileChars = toolOutputChars - 200
+	// readDirEntries caps a directory listing
+	readDirEntries = 500
+)
+
+var readFileDefinition = ToolDefinition{
+	Name: "read_file",
+	Description: "Reads a text file in the workspace, or lists a directory. Paths are relative to the workspace root; use \".\" for the root. " +
+		"Long files are returned a part at a time: call again with startLine to read on.",
+	Parameters: json.RawMessage(`{"type":"object","required":["path"],"properties":{` +
+		`"path":{"type":"string","minLength":1},` +
+		`"startLine":{"type":"integer","minimum":1},` +
+		`"endLine":{"type":"integer","minimum":1}}}`),
+}
+
+// readFile runs the read_file tool in the conversation's directory
+func (a *App) readFile(ctx context.Context, sessionID string, args json.RawMessage) (string, error) {
+	var in struct {
+		Path      string `json:"path"`
+		StartLine int    `json:"startLine"`
+		EndLine   int    `json:"endLine"`
+	}
+	if err := json.Unmarshal(args, &in); err != nil {
+		return "", err
+	}
+	path, err := a.sandboxedPath(sessionID, in.Path)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", in.Path, fileError(err))
+	}
+	if info.IsDir() {
+		return readDir(path, in.Path)
+	}
+	if info.Size() > readFileMaxBytes {
+		return "", fmt.Errorf("%s is %s bytes; files over %s bytes can't be read", in.Path, a.reportFormatter().Int(info.Size()), a.reportFormatter().Int(readFileMaxBytes))
+	}
+	content, ok := readText(path)
+	if !ok {
+		return "", fmt.Errorf("%s is not a text file", in.Path)
+	}
+	return readLines(in.Path, content, in.StartLine, in.EndLine), nil
+}
+
+// fileError strips the path from a file error, so the absolute path of the
+// workspace is not shown to the model
+func fileError(err error) error {
+	if pe, ok := err.(*os.PathError); ok {
+		return pe.Err
+	}
+	return err
+}
+
+// readLines returns lines start to end of content, cut to readFileChars
+// at a line boundary, under a header saying which lines they are
+func readLines(name, content string, start, end int) string {
+	lines := strings.SplitAfter(content, "\n")
+	if n := len(lines); n > 0 && lines[n-1] == "" {
+		lines = lines[:n-1]
+	}
+	total := len(lines)
+	if start == 0 {
+		start = 1
+	}
+	if end == 0 || end > total {
+		end = total
+	}
+	if start > end {
+		return fmt.Sprintf("%s has %d lines; there is nothing from line %d", name, total, start)
+	}
+
+	var b strings.Builder
+	last := start - 1
+	for _, line := range lines[start-1 : end] {
+		if b.Len()+len(line) > readFileChars && last >= start {
+			break
+		}
+		if len(line) > readFileChars {
+			line = strings.ToValidUTF8(line[:readFileChars], "") + "\n"
+		}
+		b.WriteString(line)
+		last++
+	}
+	header := fmt.Sprintf("%s, lines %d–%d of %d", name, start, last, total)
+	if last < end {
+		header += fmt.Sprintf("; call again with startLine %d for the rest", last+1)
+	}
+	return header + ":\n" + b.String()
+}
+
+// readDir lists a directory, subdirectories first with a trailing slash
+func readDir(path, name string) (string, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", name, fileError(err))
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].IsDir() && !entries[j].IsDir() })
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s has %d entries:\n", name, len(entries))
+	for i, e := range entries {
+		if i == readDirEntries {
+			fmt.Fprintf(&b, "and %d more\n", len(entries)-i)
+			break
+		}
+		entry := e.Name()
+		if e.IsDir() {
+			entry += "/"
+		}
+		b.WriteString(entry + "\n")
+	}
+	return b.String(), nil
+}
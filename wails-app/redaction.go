@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// minRedactedKeyLength keeps short configured values, which would match
+// ordinary text, out of the exact-match list
+const minRedactedKeyLength = 8
+
+// RedactionPolicy controls the masking of secrets in prompts before they
+// are sent. Redaction is on by default and applies to remote providers.
+type RedactionPolicy struct {
+	// Disabled sends prompts as typed
+	Disabled bool `json:"disabled,omitempty"`
+	// Local also masks prompts to providers on this machine or network
+	Local bool `json:"local,omitempty"`
+	// Patterns are extra regular expressions to mask; when a pattern has a
+	// group, only the first group is masked
+	Patterns []string `json:"patterns,omitempty"`
+}
+
+// SecretFinding is a secret found in text, by kind and line
+type SecretFinding struct {
+	Kind string `json:"kind"`
+	Line int    `json:"line"`
+}
+
+// secretPattern is a kind of secret; when the expression has a group, only
+// the first group is the secret
+type secretPattern struct {
+	kind string
+	re   *regexp.Regexp
+}
+
+var secretPatterns = []secretPattern{
+	{"private_key", regexp.MustCompile(`-----BEGIN [A-Z0-9 ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z0-9 ]*PRIVATE KEY-----`)},
+	{"aws_secret_key", regexp.MustCompile(`(?i)aws_?secret_?access_?key["']?\s*[:=]\s*["']?([A-Za-z0-9/+=]{40})`)},
+	// .env-style assignments, upper case as in .env files, so code such as
+	// token = getToken() is left alone
+	{"env_secret", regexp.MustCompile(`(?m)^[ \t]*(?:export[ \t]+)?[A-Z0-9_]*(?:SECRET|TOKEN|PASSWORD|PASSWD|API_?KEY|ACCESS_?KEY|PRIVATE_?KEY|CREDENTIALS?)[A-Z0-9_]*[ \t]*=[ \t]*("[^"\n]+"|'[^'\n]+'|[^\s#]+)`)},
+	{"aws_access_key", regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"github_token", regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`)},
+	{"google_api_key", regexp.MustCompile(`\bAIza[0-9A-Za-z_\-]{35}\b`)},
+	{"api_key", credentialPattern},
+}
+
+// secretRedactor masks secrets in outgoing prompts
+type secretRedactor struct {
+	// known are the values of configured provider keys and headers
+	known    []string
+	patterns []secretPattern
+}
+
+// redactionMask is what a secret of kind is replaced with
+func redactionMask(kind string) string {
+	return "[redacted:" + kind + "]"
+}
+
+// secretSpan is where a secret is in a text
+type secretSpan struct {
+	kind       string
+	start, end int
+}
+
+// spans finds the secrets in s in order; a match overlapping an earlier
+// one, such as a key inside a private key block, is left to the first
+func (r secretRedactor) spans(s string) []secretSpan {
+	var found []secretSpan
+	taken := func(start, end int) bool {
+		for _, sp := range found {
+			if start < sp.end && sp.start < end {
+				return true
+			}
+		}
+		return false
+	}
+	for _, k := range r.known {
+		for from := 0; ; {
+			i := strings.Index(s[from:], k)
+			if i == -1 {
+				break
+			}
+			start := from + i
+			if !taken(start, start+len(k)) {
+				found = append(found, secretSpan{"configured_key", start, start + len(k)})
+			}
+			from = start + len(k)
+		}
+	}
+	for _, p := range r.patterns {
+		for _, m := range p.re.FindAllStringSubmatchIndex(s, -1) {
+			start, end := m[0], m[1]
+			if len(m) >= 4 && m[2] >= 0 {
+				start, end = m[2], m[3]
+			}
+			if start < end && !taken(start, end) {
+				found = append(found, secretSpan{p.kind, start, end})
+			}
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].start < found[j].start })
+	return found
+}
+
+// mask replaces the secrets in s, returning the kind of each one replaced
+func (r secretRedactor) mask(s string) (string, []string) {
+	spans := r.spans(s)
+	if len(spans) == 0 {
+		return s, nil
+	}
+	var b strings.Builder
+	kinds := make([]string, len(spans))
+	last := 0
+	for i, sp := range spans {
+		b.WriteString(s[last:sp.start])
+		b.WriteString(redactionMask(sp.kind))
+		last = sp.end
+		kinds[i] = sp.kind
+	}
+	b.WriteString(s[last:])
+	return b.String(), kinds
+}
+
+// findings returns where the secrets in s are, without changing it
+func (r secretRedactor) findings(s string) []SecretFinding {
+	var out []SecretFinding
+	for _, sp := range r.spans(s) {
+		out = append(out, SecretFinding{Kind: sp.kind, Line: strings.Count(s[:sp.start], "\n") + 1})
+	}
+	return out
+}
+
+// secretRedactor returns the redactor for policy, with the configured
+// provider secrets and the policy's own patterns
+func (a *App) secretRedactor(policy RedactionPolicy) secretRedactor {
+	r := secretRedactor{patterns: secretPatterns}
+	for _, expr := range policy.Patterns {
+		// Patterns are checked when the policy is set
+		if re, err := regexp.Compile(expr); err == nil {
+			r.patterns = append(r.patterns[:len(r.patterns):len(r.patterns)], secretPattern{"custom", re})
+		}
+	}
+
+	a.providersMutex.RLock()
+	defer a.providersMutex.RUnlock()
+	for _, p := range a.providers {
+		config := p.GetConfig()
+		values := []string{config.APIKey}
+		for _, v := range config.Headers {
+			values = append(values, v)
+		}
+		for _, v := range values {
+			if len(v) >= minRedactedKeyLength {
+				r.known = append(r.known, v)
+			}
+		}
+	}
+	// Longer values go first so one containing another is masked whole
+	sort.Slice(r.known, func(i, j int) bool { return len(r.known[i]) > len(r.known[j]) })
+	return r
+}
+
+// redactRequest masks secrets in a request's prompt and messages when the
+// policy applies to the provider, reporting masked secrets with a
+// "prompt:redacted" event
+func (a *App) redactRequest(config ProviderConfig, prompt string, messages []Message) (string, []Message) {
+	policy := a.GetRedactionPolicy()
+	if policy.Disabled || (!policy.Local && isLocalProvider(config)) {
+		return prompt, messages
+	}
+	r := a.secretRedactor(policy)
+	prompt, kinds := r.mask(prompt)
+	if len(messages) > 0 {
+		masked := make([]Message, len(messages))
+		for i, m := range messages {
+			var found []string
+			m.Content, found = r.mask(m.Content)
+			kinds = append(kinds, found...)
+			masked[i] = m
+		}
+		messages = masked
+	}
+	if len(kinds) > 0 {
+		a.emit(EventPromptRedacted, PromptRedactedEvent{Provider: config.Name, Count: len(kinds), Kinds: uniqueSorted(kinds)})
+	}
+	return prompt, messages
+}
+
+func uniqueSorted(values []string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// SetRedactionPolicy sets how secrets are masked in prompts before they are sent
+func (a *App) SetRedactionPolicy(policy RedactionPolicy) error {
+	for _, expr := range policy.Patterns {
+		if _, err := regexp.Compile(expr); err != nil {
+			return fmt.Errorf("invalid redaction pattern %q: %v", expr, err)
+		}
+	}
+	a.providersMutex.Lock()
+	defer a.providersMutex.Unlock()
+	a.redaction = policy
+	return a.saveConfigLocked()
+}
+
+// GetRedactionPolicy returns how secrets are masked in prompts
+func (a *App) GetRedactionPolicy() RedactionPolicy {
+	a.providersMutex.RLock()
+	defer a.providersMutex.RUnlock()
+	return a.redaction
+}
+
+// ScanSecrets returns the secrets that redaction would mask in text, so a
+// prompt can be checked before it is sent
+func (a *App) ScanSecrets(text string) []SecretFinding {
+	return a.secretRedactor(a.GetRedactionPolicy()).findings(text)
+}
+
+// SendPromptUnredacted is SendPrompt for one prompt that is sent as typed,
+// for when a masked value is needed and is not a secret
+func (a *App) SendPromptUnredacted(prompt string) (string, error) {
+	a.telemetry.recordFeature("send_prompt_unredacted")
+	result, err := a.sendPrompt(prompt, sendOptions{unredacted: true}, nil)
+	return result.Response, err
+}
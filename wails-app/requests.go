@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// RequestRecord is one completion sent through generate, kept so it can be
+// inspected and replayed later
+type RequestRecord struct {
+	ID          string    `json:"id"`
+	Timestamp   time.Time `json:"timestamp"`
+	Provider    string    `json:"provider"`
+	ProviderID  string    `json:"providerId,omitempty"`
+	Model       string    `json:"model,omitempty"`
+	Prompt      string    `json:"prompt"`
+	Temperature float64   `json:"temperature"`
+	MaxTokens   int       `json:"maxTokens"`
+	Response    string    `json:"response,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	DurationMs  int64     `json:"durationMs"`
+	// Attempts counts sends including retries of transient failures
+	Attempts int `json:"attempts,omitempty"`
+	// QueuedMs is how long the request waited for the provider's rate limit
+	QueuedMs int64 `json:"queuedMs,omitempty"`
+	// Stalls counts watchdog reports of the reply going silent, and
+	// StalledMs the time spent stalled
+	Stalls    int   `json:"stalls,omitempty"`
+	StalledMs int64 `json:"stalledMs,omitempty"`
+	// CostUSD is the estimated charge in US dollars, for hosted models with a known price
+	CostUSD float64 `json:"costUsd,omitempty"`
+	// Cached marks a request answered from the response cache
+	Cached bool `json:"cached,omitempty"`
+	// ReplayOf links a replayed request to the original it re-executed
+	ReplayOf string `json:"replayOf,omitempty"`
+	// Private marks requests of locked conversations, recorded without
+	// their prompt and reply
+	Private bool `json:"private,omitempty"`
+}
+
+const maxRequestRecords = 1000
+
+// requestStore keeps recent request records in memory and appends every
+// record to a JSON-lines file when a path is configured
+type requestStore struct {
+	mu      sync.RWMutex
+	path    string
+	records []RequestRecord
+}
+
+func newRequestStore() *requestStore {
+	return &requestStore{}
+}
+
+// open loads the most recent records from path and appends future records to it
+func (rs *requestStore) open(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	var loaded []RequestRecord
+	f, err := os.Open(path)
+	if err == nil {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			var rec RequestRecord
+			if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+				continue
+			}
+			loaded = append(loaded, rec)
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	pending := rs.records
+	rs.path = path
+	rs.records = append(loaded, pending...)
+	rs.trimLocked()
+
+	for _, rec := range pending {
+		if err := rs.appendFileLocked(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (rs *requestStore) trimLocked() {
+	if len(rs.records) > maxRequestRecords {
+		rs.records = append([]RequestRecord(nil), rs.records[len(rs.records)-maxRequestRecords:]...)
+	}
+}
+
+func (rs *requestStore) appendFileLocked(rec RequestRecord) error {
+	if rs.path == "" {
+		return nil
+	}
+	f, err := os.OpenFile(rs.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+func (rs *requestStore) add(rec RequestRecord) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	rs.records = append(rs.records, rec)
+	rs.trimLocked()
+	if err := rs.appendFileLocked(rec); err != nil {
+		println("Error writing request log:", err.Error())
+	}
+}
+
+func (rs *requestStore) get(id string) (RequestRecord, bool) {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+
+	for i := len(rs.records) - 1; i >= 0; i-- {
+		if rs.records[i].ID == id {
+			return rs.records[i], true
+		}
+	}
+	return RequestRecord{}, false
+}
+
+// recent returns up to limit records, newest first
+func (rs *requestStore) recent(limit int) []RequestRecord {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+
+	if limit <= 0 || limit > len(rs.records) {
+		limit = len(rs.records)
+	}
+	out := make([]RequestRecord, 0, limit)
+	for i := len(rs.records) - 1; i >= 0 && len(out) < limit; i-- {
+		out = append(out, rs.records[i])
+	}
+	return out
+}
+
+// ListRequests returns up to limit recent requests, newest first (limit <= 0 returns all)
+func (a *App) ListRequests(limit int) []RequestRecord {
+	return a.requests.recent(limit)
+}
+
+// ReplayRequest re-executes a recorded request with the same prompt and
+// options against the provider with the given ID (or the active one when
+// providerID is empty) and returns the new record, linked to the original. A replay that
+// fails at the provider is still recorded and returned with Error set.
+func (a *App) ReplayRequest(requestID string, providerID string) (RequestRecord, error) {
+	a.telemetry.recordFeature("replay_request")
+	orig, ok := a.requests.get(requestID)
+	if !ok {
+		return RequestRecord{}, fmt.Errorf("request %q not found", requestID)
+	}
+	if orig.Private {
+		return RequestRecord{}, fmt.Errorf("request %q was made for a locked conversation; its prompt was not kept", requestID)
+	}
+
+	result, err := a.generate(generateRequest{
+		Prompt:      orig.Prompt,
+		Temperature: &orig.Temperature,
+		MaxTokens:   orig.MaxTokens,
+		Provider:    providerID,
+		ReplayOf:    orig.ID,
+	})
+
+	rec, ok := a.requests.get(result.RequestID)
+	if !ok {
+		return RequestRecord{}, err
+	}
+	return rec, nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"path"
+	"strings"
+)
+
+// Kinds of response segments
+const (
+	segmentProse = "prose"
+	segmentCode  = "code"
+	segmentDiff  = "diff"
+)
+
+// ResponseSegment is one part of a model response: prose, a code block or a diff
+type ResponseSegment struct {
+	// Type is "prose", "code" or "diff"
+	Type string `json:"type"`
+	// Text is the segment without its code fence
+	Text     string `json:"text"`
+	Language string `json:"language,omitempty"`
+	// Path is the file a code block is for, when the response names one
+	Path string `json:"path,omitempty"`
+	// Files are the files a diff changes
+	Files []string `json:"files,omitempty"`
+	// Fenced is set for code blocks and diffs that were in a code fence
+	Fenced bool `json:"fenced,omitempty"`
+}
+
+// gitHeaderPrefixes begin the lines git writes between "diff --git" and "---"
+var gitHeaderPrefixes = []string{
+	"index ", "new file mode ", "deleted file mode ", "old mode ", "new mode ",
+	"similarity index ", "dissimilarity index ", "rename from ", "rename to ", "Binary files ",
+}
+
+// isGitHeader reports whether line is one of git's extended diff headers
+func isGitHeader(line string) bool {
+	for _, p := range gitHeaderPrefixes {
+		if strings.HasPrefix(line, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// diffStart reports whether a unified diff begins at lines[i]
+func diffStart(lines []string, i int) bool {
+	if strings.HasPrefix(lines[i], "diff --git ") {
+		return true
+	}
+	return strings.HasPrefix(lines[i], "--- ") && i+2 < len(lines) &&
+		strings.HasPrefix(lines[i+1], "+++ ") && strings.HasPrefix(lines[i+2], "@@")
+}
+
+// looksLikeDiff reports whether a code block holds a unified diff
+func looksLikeDiff(lines []string) bool {
+	hunk, header := false, false
+	for i, line := range lines {
+		hunk = hunk || strings.HasPrefix(line, "@@")
+		header = header || diffStart(lines, i)
+	}
+	return hunk && header
+}
+
+// diffHeaderPath returns the path in a "--- a/x" or "+++ b/x" line, or ""
+// for /dev/null
+func diffHeaderPath(line string) string {
+	p := strings.TrimSpace(line[4:])
+	if tab := strings.IndexByte(p, '\t'); tab >= 0 {
+		p = p[:tab]
+	}
+	if p == "/dev/null" {
+		return ""
+	}
+	if strings.HasPrefix(p, "a/") || strings.HasPrefix(p, "b/") {
+		p = p[2:]
+	}
+	return path.Clean(p)
+}
+
+// diffFiles lists the files a unified diff changes, in order
+func diffFiles(lines []string) []string {
+	var files []string
+	seen := make(map[string]bool)
+	for i, line := range lines {
+		if !strings.HasPrefix(line, "--- ") || i+1 >= len(lines) || !strings.HasPrefix(lines[i+1], "+++ ") {
+			continue
+		}
+		p := diffHeaderPath(lines[i+1])
+		if p == "" {
+			p = diffHeaderPath(line)
+		}
+		if p != "" && !seen[p] {
+			seen[p] = true
+			files = append(files, p)
+		}
+	}
+	return files
+}
+
+// appendProse splits prose into text and the unfenced diffs within it
+func appendProse(segments []ResponseSegment, lines []string) []ResponseSegment {
+	var prose []string
+	flush := func() {
+		if text := strings.Trim(strings.Join(prose, "\n"), "\n"); strings.TrimSpace(text) != "" {
+			segments = append(segments, ResponseSegment{Type: segmentProse, Text: text})
+		}
+		prose = nil
+	}
+	for i := 0; i < len(lines); i++ {
+		if !diffStart(lines, i) {
+			prose = append(prose, lines[i])
+			continue
+		}
+		_, end := parseUnifiedDiff(lines, i)
+		flush()
+		diff := lines[i:end]
+		segments = append(segments, ResponseSegment{Type: segmentDiff, Text: strings.Join(diff, "\n") + "\n", Language: "diff", Files: diffFiles(diff)})
+		i = end - 1
+	}
+	flush()
+	return segments
+}
+
+// parseResponse splits a markdown response into prose, code blocks and diffs
+func parseResponse(text string) []ResponseSegment {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	segments := []ResponseSegment{}
+	start := 0
+	for i := 0; i < len(lines); i++ {
+		m := fencePattern.FindStringSubmatch(strings.TrimSpace(lines[i]))
+		if m == nil {
+			continue
+		}
+		fence := m[1]
+		p, language := fencePath(strings.TrimSpace(m[2]))
+		end := len(lines)
+		for j := i + 1; j < len(lines); j++ {
+			if line := strings.TrimSpace(lines[j]); strings.HasPrefix(line, fence) && strings.Trim(line, fence[:1]) == "" {
+				end = j
+				break
+			}
+		}
+		body := lines[i+1 : end]
+
+		if p == "" && i > 0 {
+			if hm := headingPathPattern.FindStringSubmatch(messageBefore(lines, i)); hm != nil && looksLikePath(hm[1]) {
+				p = hm[1]
+			}
+		}
+		if p == "" && len(body) > 0 {
+			if cm := commentPathPattern.FindStringSubmatch(body[0]); cm != nil {
+				p = cm[1]
+			}
+		}
+
+		segments = appendProse(segments, lines[start:i])
+		seg := ResponseSegment{Type: segmentCode, Text: strings.Join(body, "\n"), Language: language, Path: cleanArtifactPath(p), Fenced: true}
+		if len(body) > 0 {
+			seg.Text += "\n"
+		}
+		if language == "diff" || language == "patch" || looksLikeDiff(body) {
+			seg.Type, seg.Files = segmentDiff, diffFiles(body)
+		}
+		segments = append(segments, seg)
+		i = end
+		start = min(end+1, len(lines))
+	}
+	return appendProse(segments, lines[start:])
+}
+
+// ParseResponse splits a model response into typed segments in order: prose,
+// code blocks with their language and file, and unified diffs, fenced or not
+func (a *App) ParseResponse(text string) []ResponseSegment {
+	return parseResponse(text)
+}
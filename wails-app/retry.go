@@ -0,0 +1,58 @@
+package main
+
+import (
+	"math/rand/v2"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultMaxAttempts is how many times a request is sent before a transient failure is reported
+	defaultMaxAttempts = 3
+	retryBaseDelay     = 500 * time.Millisecond
+	retryMaxDelay      = 8 * time.Second
+)
+
+// transientErrors are failures worth retrying: the server was briefly
+// unreachable, overloaded, or rate limiting
+var transientErrors = []string{
+	"connection refused",
+	"connection reset",
+	"broken pipe",
+	"EOF",
+	"no response from server",
+	"Client.Timeout",
+	"HTTP 429",
+	"HTTP 500",
+	"HTTP 502",
+	"HTTP 503",
+	"HTTP 504",
+}
+
+func isTransientError(err error) bool {
+	msg := err.Error()
+	for _, s := range transientErrors {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// maxAttempts returns the attempt limit configured for a provider
+func maxAttempts(config ProviderConfig) int {
+	if config.MaxAttempts > 0 {
+		return config.MaxAttempts
+	}
+	return defaultMaxAttempts
+}
+
+// retryDelay is the wait before retry number attempt (from 1): exponential
+// backoff with equal jitter, so concurrent clients spread out
+func retryDelay(attempt int) time.Duration {
+	d := retryBaseDelay << (attempt - 1)
+	if d > retryMaxDelay || d <= 0 {
+		d = retryMaxDelay
+	}
+	return d/2 + rand.N(d/2+1)
+}
@@ -0,0 +1,601 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/metrics"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+const (
+	// defaultScriptTimeout bounds a script run, prompts included
+	defaultScriptTimeout = 10 * time.Minute
+	// maxScriptReadBytes caps the files a script can read
+	maxScriptReadBytes = 4 << 20
+	// maxScriptGrepMatches caps the matches vibe.grep returns
+	maxScriptGrepMatches = 1000
+	maxScriptOutputLines = 5000
+	// maxScriptStringBytes caps the strings string.rep builds
+	maxScriptStringBytes = 16 << 20
+	// maxScriptMemoryBytes is how far the heap may grow while a script runs
+	maxScriptMemoryBytes = 512 << 20
+)
+
+// errScriptMemory stops a script whose allocations outgrow maxScriptMemoryBytes
+var errScriptMemory = errors.New("script used too much memory")
+
+// ScriptPermissions list what a script may do beyond computing and logging.
+// They are granted per script and checked on every call.
+type ScriptPermissions struct {
+	// Prompt allows sending prompts to the providers
+	Prompt bool `json:"prompt"`
+	// ReadWorkspace allows listing, reading and searching workspace files
+	ReadWorkspace bool `json:"readWorkspace"`
+	// WriteWorkspace allows creating and overwriting workspace files
+	WriteWorkspace bool `json:"writeWorkspace"`
+	// Tools allows running the tools prompts can use, as listed by ListTools
+	Tools bool `json:"tools"`
+}
+
+// Script is a saved Lua automation script
+type Script struct {
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	Source      string            `json:"source"`
+	Permissions ScriptPermissions `json:"permissions"`
+	// TimeoutSeconds overrides the 10 minute run limit
+	TimeoutSeconds int       `json:"timeoutSeconds,omitempty"`
+	CreatedAt      time.Time `json:"createdAt"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
+
+// ScriptRun is the result of running a script
+type ScriptRun struct {
+	RunID    string   `json:"runId"`
+	ScriptID string   `json:"scriptId"`
+	Output   []string `json:"output"`
+	// Result is the script's return value as text
+	Result     string    `json:"result,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	StartedAt  time.Time `json:"startedAt"`
+	DurationMs int64     `json:"durationMs"`
+}
+
+// scriptStore keeps the saved scripts in one JSON file
+type scriptStore struct {
+	mu      sync.Mutex
+	path    string
+	scripts map[string]*Script
+	// running cancels the runs in progress by run ID
+	running map[string]context.CancelFunc
+}
+
+func newScriptStore() *scriptStore {
+	return &scriptStore{scripts: make(map[string]*Script), running: make(map[string]context.CancelFunc)}
+}
+
+func (st *scriptStore) open(path string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.path = path
+	var scripts []*Script
+	if err := readJSONFile(path, &scripts); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, s := range scripts {
+		st.scripts[s.ID] = s
+	}
+	return nil
+}
+
+func (st *scriptStore) listLocked() []Script {
+	out := make([]Script, 0, len(st.scripts))
+	for _, s := range st.scripts {
+		out = append(out, *s)
+	}
+	sort.Slice(out, func(i, j int) bool { return strings.ToLower(out[i].Name) < strings.ToLower(out[j].Name) })
+	return out
+}
+
+func (st *scriptStore) saveLocked() error {
+	if st.path == "" {
+		return nil
+	}
+	return writeJSONFile(st.path, st.listLocked())
+}
+
+// ListScripts returns the saved scripts sorted by name
+func (a *App) ListScripts() []Script {
+	a.scripts.mu.Lock()
+	defer a.scripts.mu.Unlock()
+	return a.scripts.listLocked()
+}
+
+// SaveScript creates a script, or updates the one with the same ID, after
+// checking that it compiles
+func (a *App) SaveScript(script Script) (Script, error) {
+	script.Name = strings.TrimSpace(script.Name)
+	if script.Name == "" {
+		return Script{}, fmt.Errorf("script name is required")
+	}
+	if _, err := compileScript(script); err != nil {
+		return Script{}, err
+	}
+
+	a.scripts.mu.Lock()
+	defer a.scripts.mu.Unlock()
+	now := time.Now().UTC()
+	if existing, ok := a.scripts.scripts[script.ID]; ok && script.ID != "" {
+		script.CreatedAt = existing.CreatedAt
+	} else {
+		script.ID = newID()
+		script.CreatedAt = now
+	}
+	script.UpdatedAt = now
+	a.scripts.scripts[script.ID] = &script
+	if err := a.scripts.saveLocked(); err != nil {
+		return Script{}, fmt.Errorf("save scripts: %v", err)
+	}
+	return script, nil
+}
+
+// DeleteScript removes a saved script
+func (a *App) DeleteScript(id string) error {
+	a.scripts.mu.Lock()
+	defer a.scripts.mu.Unlock()
+	if _, ok := a.scripts.scripts[id]; !ok {
+		return fmt.Errorf("script %q not found", id)
+	}
+	delete(a.scripts.scripts, id)
+	return a.scripts.saveLocked()
+}
+
+func compileScript(script Script) (*lua.FunctionProto, error) {
+	chunk, err := parse.Parse(strings.NewReader(script.Source), script.Name)
+	if err != nil {
+		return nil, fmt.Errorf("script %s: %v", script.Name, err)
+	}
+	return lua.Compile(chunk, script.Name)
+}
+
+// RunScript runs a saved script with input available to it as vibe.input,
+// emitting "script:output" for each line it logs. The run stops when the
+// script ends, on CancelScript, or after its timeout.
+func (a *App) RunScript(id string, input string) (ScriptRun, error) {
+	a.telemetry.recordFeature("run_script")
+	return a.runScript(context.Background(), id, input)
+}
+
+// runScript runs a script under parent, which is cancelled or carries the
+// local server token for runs started over the API
+func (a *App) runScript(parent context.Context, id string, input string) (ScriptRun, error) {
+	a.scripts.mu.Lock()
+	s, ok := a.scripts.scripts[id]
+	var script Script
+	if ok {
+		script = *s
+	}
+	a.scripts.mu.Unlock()
+	if !ok {
+		return ScriptRun{}, fmt.Errorf("script %q not found", id)
+	}
+	proto, err := compileScript(script)
+	if err != nil {
+		return ScriptRun{}, err
+	}
+
+	timeout := defaultScriptTimeout
+	if script.TimeoutSeconds > 0 {
+		timeout = time.Duration(script.TimeoutSeconds) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+	run := &ScriptRun{RunID: newID(), ScriptID: script.ID, Output: []string{}, StartedAt: time.Now().UTC()}
+	a.scripts.mu.Lock()
+	a.scripts.running[run.RunID] = cancel
+	a.scripts.mu.Unlock()
+	defer func() {
+		a.scripts.mu.Lock()
+		delete(a.scripts.running, run.RunID)
+		a.scripts.mu.Unlock()
+	}()
+	a.emit(EventScriptStart, ScriptStartEvent{RunID: run.RunID, ScriptID: script.ID})
+
+	result, err := a.execScript(ctx, script, proto, input, run)
+	run.DurationMs = time.Since(run.StartedAt).Milliseconds()
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		run.Error = fmt.Sprintf("script timed out after %v", timeout)
+	case ctx.Err() == context.Canceled:
+		run.Error = "script cancelled"
+	case err != nil:
+		run.Error = err.Error()
+	default:
+		run.Result = result
+	}
+	a.emit(EventScriptDone, *run)
+	return *run, nil
+}
+
+// CancelScript stops a script run in progress
+func (a *App) CancelScript(runID string) error {
+	a.scripts.mu.Lock()
+	defer a.scripts.mu.Unlock()
+	cancel, ok := a.scripts.running[runID]
+	if !ok {
+		return fmt.Errorf("script run %q not found", runID)
+	}
+	cancel()
+	return nil
+}
+
+// heapBytes is the memory held by heap objects, live or not yet collected
+func heapBytes() uint64 {
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	metrics.Read(sample)
+	if sample[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return sample[0].Value.Uint64()
+}
+
+// watchScriptMemory cancels a script once the heap has grown by more than
+// maxScriptMemoryBytes since it started, and the growth survives a garbage
+// collection. gopher-lua can't limit what a state allocates, so this is
+// measured for the whole app.
+func watchScriptMemory(ctx context.Context, cancel context.CancelCauseFunc) {
+	base := heapBytes()
+	tick := time.NewTicker(50 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			if heapBytes() < base+maxScriptMemoryBytes {
+				continue
+			}
+			runtime.GC()
+			if heapBytes() >= base+maxScriptMemoryBytes {
+				cancel(errScriptMemory)
+				return
+			}
+		}
+	}
+}
+
+// scriptStringRep is string.rep refusing to build strings over
+// maxScriptStringBytes
+func scriptStringRep(L *lua.LState) int {
+	s, n := L.CheckString(1), L.CheckInt(2)
+	if n <= 0 || s == "" {
+		L.Push(lua.LString(""))
+		return 1
+	}
+	if n > maxScriptStringBytes/len(s) {
+		L.RaiseError("string.rep: the result would be over %d bytes", maxScriptStringBytes)
+	}
+	L.Push(lua.LString(strings.Repeat(s, n)))
+	return 1
+}
+
+// execScript runs a compiled script in a fresh sandboxed state: only the
+// base, table, string and math libraries, without file loading, plus the
+// vibe API gated by the script's permissions. A script that takes more than
+// maxScriptMemoryBytes is stopped.
+func (a *App) execScript(ctx context.Context, script Script, proto *lua.FunctionProto, input string, run *ScriptRun) (string, error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	go watchScriptMemory(ctx, cancel)
+	L := lua.NewState(lua.Options{SkipOpenLibs: true, CallStackSize: 200, RegistryMaxSize: 1 << 20})
+	defer L.Close()
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, name := range []string{"dofile", "loadfile", "require", "module", "collectgarbage"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+	L.SetField(L.GetGlobal(lua.StringLibName), "rep", L.NewFunction(scriptStringRep))
+	L.SetContext(ctx)
+
+	api := &scriptAPI{app: a, ctx: ctx, script: script, run: run, root: a.sessionRoot("")}
+	vibe := L.NewTable()
+	L.SetFuncs(vibe, map[string]lua.LGFunction{
+		"log":    api.log,
+		"prompt": api.prompt,
+		"files":  api.files,
+		"read":   api.read,
+		"write":  api.write,
+		"grep":   api.grep,
+		"tool":   api.tool,
+	})
+	vibe.RawSetString("input", lua.LString(input))
+	L.SetGlobal("vibe", vibe)
+	L.SetGlobal("print", L.GetField(vibe, "log"))
+
+	L.Push(L.NewFunctionFromProto(proto))
+	if err := L.PCall(0, 1, nil); err != nil {
+		if context.Cause(ctx) == errScriptMemory {
+			return "", fmt.Errorf("%v (over %d MiB)", errScriptMemory, maxScriptMemoryBytes>>20)
+		}
+		return "", err
+	}
+	ret := L.Get(-1)
+	if ret == lua.LNil {
+		return "", nil
+	}
+	return ret.String(), nil
+}
+
+// scriptAPI implements the vibe table given to scripts
+type scriptAPI struct {
+	app    *App
+	ctx    context.Context
+	script Script
+	run    *ScriptRun
+	root   string
+}
+
+func (s *scriptAPI) require(L *lua.LState, allowed bool, name string) {
+	if !allowed {
+		L.RaiseError("script %s does not have the %s permission", s.script.Name, name)
+	}
+}
+
+// path resolves a workspace-relative path the way the file tools do,
+// refusing paths that leave the workspace through a symlink
+func (s *scriptAPI) path(L *lua.LState, rel string) string {
+	p, err := s.app.sandboxedPath("", rel)
+	if err != nil {
+		L.RaiseError("%v", err)
+	}
+	return p
+}
+
+// log(...) appends a line to the run's output
+func (s *scriptAPI) log(L *lua.LState) int {
+	parts := make([]string, L.GetTop())
+	for i := range parts {
+		parts[i] = L.ToStringMeta(L.Get(i + 1)).String()
+	}
+	line := strings.Join(parts, "\t")
+	if len(s.run.Output) < maxScriptOutputLines {
+		s.run.Output = append(s.run.Output, line)
+	}
+	s.app.emit(EventScriptOutput, ScriptOutputEvent{RunID: s.run.RunID, Line: line})
+	return 0
+}
+
+// prompt(text [, {provider=, temperature=, maxTokens=}]) returns the reply
+func (s *scriptAPI) prompt(L *lua.LState) int {
+	s.require(L, s.script.Permissions.Prompt, "prompt")
+	req := generateRequest{Prompt: L.CheckString(1), Context: s.ctx}
+	if opts, ok := L.Get(2).(*lua.LTable); ok {
+		if v, ok := opts.RawGetString("provider").(lua.LString); ok {
+			req.Provider = string(v)
+		}
+		if v, ok := opts.RawGetString("temperature").(lua.LNumber); ok {
+			t := float64(v)
+			req.Temperature = &t
+		}
+		if v, ok := opts.RawGetString("maxTokens").(lua.LNumber); ok {
+			req.MaxTokens = int(v)
+		}
+	}
+	result, err := s.app.generate(req)
+	if err != nil {
+		L.RaiseError("prompt: %v", err)
+	}
+	L.Push(lua.LString(result.Response))
+	return 1
+}
+
+// walk visits the workspace files matching an optional glob, skipping the
+// directories the workspace index skips
+func (s *scriptAPI) walk(L *lua.LState, glob string, visit func(rel, full string) bool) {
+	if s.root == "" {
+		L.RaiseError("no workspace is open")
+	}
+	count := 0
+	filepath.WalkDir(s.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || s.ctx.Err() != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if p != s.root && skipIndexDir(d.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if count++; count > maxIndexedFiles {
+			return filepath.SkipAll
+		}
+		rel, err := filepath.Rel(s.root, p)
+		if err != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if glob != "" {
+			if ok, _ := path.Match(glob, rel); !ok {
+				if ok, _ := path.Match(glob, path.Base(rel)); !ok {
+					return nil
+				}
+			}
+		}
+		if !visit(rel, p) {
+			return filepath.SkipAll
+		}
+		return nil
+	})
+}
+
+// files([glob]) lists workspace files as slash-separated relative paths
+func (s *scriptAPI) files(L *lua.LState) int {
+	s.require(L, s.script.Permissions.ReadWorkspace, "readWorkspace")
+	out := L.NewTable()
+	s.walk(L, L.OptString(1, ""), func(rel, _ string) bool {
+		out.Append(lua.LString(rel))
+		return true
+	})
+	L.Push(out)
+	return 1
+}
+
+// read(path) returns a workspace file's contents
+func (s *scriptAPI) read(L *lua.LState) int {
+	s.require(L, s.script.Permissions.ReadWorkspace, "readWorkspace")
+	p := s.path(L, L.CheckString(1))
+	info, err := os.Stat(p)
+	if err != nil {
+		L.RaiseError("read: %v", err)
+	}
+	if info.Size() > maxScriptReadBytes {
+		L.RaiseError("read: %s is larger than %d bytes", L.CheckString(1), maxScriptReadBytes)
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		L.RaiseError("read: %v", err)
+	}
+	L.Push(lua.LString(data))
+	return 1
+}
+
+// write(path, content) creates or replaces a workspace file
+func (s *scriptAPI) write(L *lua.LState) int {
+	s.require(L, s.script.Permissions.WriteWorkspace, "writeWorkspace")
+	p := s.path(L, L.CheckString(1))
+	content := L.CheckString(2)
+	perm := os.FileMode(0o644)
+	if info, err := os.Stat(p); err == nil {
+		if info.IsDir() {
+			L.RaiseError("write: %s is a directory", L.CheckString(1))
+		}
+		perm = info.Mode().Perm()
+	}
+	if _, err := mkdirAllTracked(filepath.Dir(p)); err != nil {
+		L.RaiseError("write: %v", err)
+	}
+	if err := writeFileAtomic(p, []byte(content), perm); err != nil {
+		L.RaiseError("write: %v", err)
+	}
+	return 0
+}
+
+// tool(name [, args]) runs a tool from ListTools in the active conversation
+// and returns its output; opt-in tools such as run_command must be on
+func (s *scriptAPI) tool(L *lua.LState) int {
+	s.require(L, s.script.Permissions.Tools, "tools")
+	name := L.CheckString(1)
+	tools, err := s.app.tools.lookup([]string{name})
+	if err != nil {
+		L.RaiseError("tool: %v", err)
+	}
+	args := json.RawMessage("{}")
+	if t, ok := L.Get(2).(*lua.LTable); ok {
+		if args, err = json.Marshal(luaJSON(t)); err != nil {
+			L.RaiseError("tool %s: %v", name, err)
+		}
+	}
+	out, err := s.app.callTool(s.ctx, "", tools[0], args)
+	if err != nil {
+		L.RaiseError("tool %s: %v", name, err)
+	}
+	L.Push(lua.LString(out))
+	return 1
+}
+
+// luaJSON converts a Lua value to what encoding/json marshals: a table
+// with only the keys 1..n becomes an array, any other table an object
+func luaJSON(v lua.LValue) interface{} {
+	switch v := v.(type) {
+	case lua.LBool:
+		return bool(v)
+	case lua.LNumber:
+		return float64(v)
+	case lua.LString:
+		return string(v)
+	case *lua.LTable:
+		if n := v.Len(); n > 0 {
+			keys := 0
+			v.ForEach(func(lua.LValue, lua.LValue) { keys++ })
+			if keys == n {
+				arr := make([]interface{}, n)
+				for i := range arr {
+					arr[i] = luaJSON(v.RawGetInt(i + 1))
+				}
+				return arr
+			}
+		}
+		obj := make(map[string]interface{})
+		v.ForEach(func(k, val lua.LValue) {
+			obj[k.String()] = luaJSON(val)
+		})
+		return obj
+	}
+	return nil
+}
+
+// grep(regexp [, glob]) returns {path=, line=, text=} for each matching line
+func (s *scriptAPI) grep(L *lua.LState) int {
+	s.require(L, s.script.Permissions.ReadWorkspace, "readWorkspace")
+	re, err := regexp.Compile(L.CheckString(1))
+	if err != nil {
+		L.RaiseError("grep: %v", err)
+	}
+	out := L.NewTable()
+	matches := 0
+	s.walk(L, L.OptString(2, ""), func(rel, _ string) bool {
+		// Files linked from outside the workspace are passed over
+		full, err := s.app.sandboxedPath("", rel)
+		if err != nil {
+			return true
+		}
+		err = scanLog(full, func(n int, line string) bool {
+			if !re.MatchString(line) {
+				return true
+			}
+			m := L.NewTable()
+			m.RawSetString("path", lua.LString(rel))
+			m.RawSetString("line", lua.LNumber(n))
+			m.RawSetString("text", lua.LString(line))
+			out.Append(m)
+			matches++
+			return matches < maxScriptGrepMatches
+		})
+		if err != nil {
+			return true
+		}
+		return matches < maxScriptGrepMatches
+	})
+	L.Push(out)
+	return 1
+}
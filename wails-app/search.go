@@ -0,0 +1,236 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// docRef identifies a single message within a session
+type docRef struct {
+	sessionID string
+	message   int
+}
+
+// searchIndex is an in-memory inverted index over message contents
+type searchIndex struct {
+	mu       sync.RWMutex
+	postings map[string]map[docRef]int
+	docs     map[docRef][]string
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		postings: make(map[string]map[docRef]int),
+		docs:     make(map[docRef][]string),
+	}
+}
+
+// tokenize lowercases text and splits it into letter/digit/underscore runs
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+}
+
+func (ix *searchIndex) add(sessionID string, message int, content string) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	ref := docRef{sessionID, message}
+	ix.removeLocked(ref)
+
+	terms := tokenize(content)
+	ix.docs[ref] = terms
+	for _, t := range terms {
+		p, ok := ix.postings[t]
+		if !ok {
+			p = make(map[docRef]int)
+			ix.postings[t] = p
+		}
+		p[ref]++
+	}
+}
+
+func (ix *searchIndex) remove(sessionID string, message int) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.removeLocked(docRef{sessionID, message})
+}
+
+func (ix *searchIndex) removeLocked(ref docRef) {
+	for _, t := range ix.docs[ref] {
+		if p, ok := ix.postings[t]; ok {
+			delete(p, ref)
+			if len(p) == 0 {
+				delete(ix.postings, t)
+			}
+		}
+	}
+	delete(ix.docs, ref)
+}
+
+// matchTerm returns postings for a query term; a trailing '*' makes it a prefix match
+func (ix *searchIndex) matchTerm(term string) map[docRef]int {
+	if !strings.HasSuffix(term, "*") {
+		return ix.postings[term]
+	}
+
+	prefix := strings.TrimSuffix(term, "*")
+	merged := make(map[docRef]int)
+	for t, p := range ix.postings {
+		if strings.HasPrefix(t, prefix) {
+			for ref, n := range p {
+				merged[ref] += n
+			}
+		}
+	}
+	return merged
+}
+
+type scoredRef struct {
+	ref   docRef
+	score float64
+}
+
+// search returns messages containing every query term, best matches first
+func (ix *searchIndex) search(query string) []scoredRef {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	var terms []string
+	for _, field := range strings.Fields(strings.ToLower(query)) {
+		prefix := strings.HasSuffix(field, "*")
+		terms = append(terms, tokenize(field)...)
+		if prefix && len(terms) > 0 {
+			terms[len(terms)-1] += "*"
+		}
+	}
+	if len(terms) == 0 {
+		return nil
+	}
+
+	total := float64(len(ix.docs))
+	scores := make(map[docRef]float64)
+	for i, term := range terms {
+		p := ix.matchTerm(term)
+		if len(p) == 0 {
+			return nil
+		}
+		idf := math.Log(1 + total/float64(len(p)))
+		next := make(map[docRef]float64)
+		for ref, tf := range p {
+			if i > 0 {
+				if _, ok := scores[ref]; !ok {
+					continue
+				}
+			}
+			next[ref] = scores[ref] + float64(tf)*idf
+		}
+		scores = next
+	}
+
+	out := make([]scoredRef, 0, len(scores))
+	for ref, score := range scores {
+		out = append(out, scoredRef{ref, score})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].score != out[j].score {
+			return out[i].score > out[j].score
+		}
+		if out[i].ref.sessionID != out[j].ref.sessionID {
+			return out[i].ref.sessionID < out[j].ref.sessionID
+		}
+		return out[i].ref.message < out[j].ref.message
+	})
+	return out
+}
+
+// SearchResult is a message matching a SearchMessages query
+type SearchResult struct {
+	SessionID    string    `json:"sessionId"`
+	SessionTitle string    `json:"sessionTitle"`
+	MessageIndex int       `json:"messageIndex"`
+	Role         string    `json:"role"`
+	Snippet      string    `json:"snippet"`
+	Timestamp    time.Time `json:"timestamp"`
+	Score        float64   `json:"score"`
+	Archived     bool      `json:"archived,omitempty"`
+}
+
+const (
+	maxSearchResults    = 50
+	snippetContextRunes = 60
+)
+
+// snippet returns a short excerpt of content around the first matching query term
+func snippet(content, query string) string {
+	runes := []rune(content)
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+	lowerText := string(lower)
+
+	pos := -1
+	for _, t := range tokenize(query) {
+		if i := strings.Index(lowerText, t); i >= 0 {
+			pos = len([]rune(lowerText[:i]))
+			break
+		}
+	}
+	if pos < 0 {
+		pos = 0
+	}
+
+	start := pos - snippetContextRunes
+	if start < 0 {
+		start = 0
+	}
+	end := pos + snippetContextRunes
+	if end > len(runes) {
+		end = len(runes)
+	}
+
+	out := strings.Join(strings.Fields(string(runes[start:end])), " ")
+	if start > 0 {
+		out = "…" + out
+	}
+	if end < len(runes) {
+		out += "…"
+	}
+	return out
+}
+
+// SearchMessages finds messages across all conversations containing every
+// word of query; a trailing '*' on a word matches it as a prefix
+func (a *App) SearchMessages(query string) []SearchResult {
+	a.telemetry.recordFeature("search_messages")
+	hits := a.sessions.index.search(query)
+
+	results := make([]SearchResult, 0, len(hits))
+	for _, hit := range hits {
+		if len(results) >= maxSearchResults {
+			break
+		}
+		s, ok := a.sessions.get(hit.ref.sessionID)
+		if !ok || hit.ref.message >= len(s.Messages) {
+			continue
+		}
+		m := s.Messages[hit.ref.message]
+		results = append(results, SearchResult{
+			SessionID:    s.ID,
+			SessionTitle: s.Title,
+			MessageIndex: hit.ref.message,
+			Role:         m.Role,
+			Snippet:      snippet(m.Content, query),
+			Timestamp:    m.Timestamp,
+			Score:        hit.score,
+			Archived:     s.Archived,
+		})
+	}
+	return results
+}
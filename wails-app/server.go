@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const defaultLocalServerAddr = "127.0.0.1:11435"
+
+// localServer exposes parts of the app over HTTP to other tools on this machine
+type localServer struct {
+	srv      *http.Server
+	listener net.Listener
+}
+
+// StartLocalServer starts the local HTTP server on a loopback address
+func (a *App) StartLocalServer(addr string) error {
+	a.telemetry.recordFeature("local_server")
+	a.serverMutex.Lock()
+	defer a.serverMutex.Unlock()
+
+	if a.server != nil {
+		return fmt.Errorf("local server already running on %s", a.server.listener.Addr())
+	}
+	if addr == "" {
+		addr = defaultLocalServerAddr
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid address: %v", err)
+	}
+	if !isLoopbackHost(host) {
+		return fmt.Errorf("local server must bind to a loopback address, got %q", host)
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{
+		Handler:           a.localServerHandler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	a.server = &localServer{srv: srv, listener: listener}
+
+	go func() {
+		if err := srv.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			println("Local server error:", err.Error())
+		}
+	}()
+
+	return nil
+}
+
+// StopLocalServer shuts down the local HTTP server if it is running
+func (a *App) StopLocalServer() error {
+	a.serverMutex.Lock()
+	defer a.serverMutex.Unlock()
+
+	if a.server == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := a.server.srv.Shutdown(ctx)
+	a.server = nil
+	return err
+}
+
+// LocalServerAddress returns the address the local server listens on, or "" when stopped
+func (a *App) LocalServerAddress() string {
+	a.serverMutex.Lock()
+	defer a.serverMutex.Unlock()
+
+	if a.server == nil {
+		return ""
+	}
+	return a.server.listener.Addr().String()
+}
+
+func (a *App) localServerHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/embeddings", a.authorize(ScopePrompt, a.handleEmbeddings))
+	mux.HandleFunc("POST /v1/chat/completions", a.authorize(ScopePrompt, a.handleChatCompletions))
+	mux.HandleFunc("GET /v1/models", a.authorize(ScopePrompt, a.handleModels))
+	mux.HandleFunc("GET /v1/events/schema", a.authorize(ScopePrompt, a.handleEventSchema))
+	mux.HandleFunc("GET /v1/conversations", a.authorize(ScopeConversationsRead, a.handleListConversations))
+	mux.HandleFunc("GET /v1/conversations/{id}", a.authorize(ScopeConversationsRead, a.handleGetConversation))
+	mux.HandleFunc("POST /v1/scripts/{id}/run", a.authorize(ScopeTools, a.handleRunScript))
+	return loopbackOnly(mux)
+}
+
+// isLoopbackHost reports whether host, without a port, names this machine
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// loopbackOnly turns away requests a web page could make to the server: a
+// Host that isn't loopback (DNS rebinding), an Origin that isn't loopback
+// (cross-site requests), and bodies that aren't JSON, which browsers can
+// only send cross-site after a CORS preflight this server never answers
+func loopbackOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if !isLoopbackHost(host) {
+			writeAPIError(w, http.StatusForbidden, "permission_error", fmt.Errorf("host %q is not a loopback address", r.Host))
+			return
+		}
+		if origin := r.Header.Get("Origin"); origin != "" {
+			u, err := url.Parse(origin)
+			if err != nil || !isLoopbackHost(u.Hostname()) {
+				writeAPIError(w, http.StatusForbidden, "permission_error", fmt.Errorf("requests from origin %q are not allowed", origin))
+				return
+			}
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			if mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mt != "application/json" {
+				writeAPIError(w, http.StatusUnsupportedMediaType, "invalid_request_error", fmt.Errorf("request bodies must be application/json"))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeAPIError writes an error in the OpenAI error envelope
+func writeAPIError(w http.ResponseWriter, status int, errType string, err error) {
+	writeJSON(w, status, map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": err.Error(),
+			"type":    errType,
+		},
+	})
+}
+
+type embeddingsRequest struct {
+	Model string          `json:"model"`
+	Input json.RawMessage `json:"input"`
+}
+
+// parseEmbeddingInput accepts either a single string or an array of strings
+func parseEmbeddingInput(raw json.RawMessage) ([]string, error) {
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil {
+		return []string{single}, nil
+	}
+
+	var batch []string
+	if err := json.Unmarshal(raw, &batch); err != nil {
+		return nil, fmt.Errorf("input must be a string or an array of strings")
+	}
+	if len(batch) == 0 {
+		return nil, fmt.Errorf("input must not be empty")
+	}
+	return batch, nil
+}
+
+// handleEmbeddings serves an OpenAI-compatible POST /v1/embeddings
+func (a *App) handleEmbeddings(w http.ResponseWriter, r *http.Request) {
+	var req embeddingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request_error", fmt.Errorf("invalid JSON body: %v", err))
+		return
+	}
+
+	texts, err := parseEmbeddingInput(req.Input)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request_error", err)
+		return
+	}
+
+	vectors, provider, err := a.embed(texts, req.Model)
+	if err != nil {
+		writeAPIError(w, http.StatusBadGateway, "provider_error", err)
+		return
+	}
+
+	promptTokens := 0
+	data := make([]map[string]interface{}, len(vectors))
+	for i, vec := range vectors {
+		data[i] = map[string]interface{}{
+			"object":    "embedding",
+			"index":     i,
+			"embedding": vec,
+		}
+		promptTokens += estimateTokens(texts[i])
+	}
+
+	model := req.Model
+	if model == "" {
+		model = provider
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"object": "list",
+		"data":   data,
+		"model":  model,
+		"usage": map[string]interface{}{
+			"prompt_tokens": promptTokens,
+			"total_tokens":  promptTokens,
+		},
+	})
+}
+
+type chatCompletionRequest struct {
+	Model       string    `json:"model"`
+	Messages    []Message `json:"messages"`
+	Temperature *float64  `json:"temperature"`
+	MaxTokens   int       `json:"max_tokens"`
+	Stream      bool      `json:"stream"`
+}
+
+// handleChatCompletions serves an OpenAI-compatible POST /v1/chat/completions
+// by routing the conversation through the app's generation pipeline
+func (a *App) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
+	var req chatCompletionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request_error", fmt.Errorf("invalid JSON body: %v", err))
+		return
+	}
+	if len(req.Messages) == 0 {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request_error", fmt.Errorf("messages must not be empty"))
+		return
+	}
+
+	result, err := a.generate(generateRequest{
+		Messages:    req.Messages,
+		Temperature: req.Temperature,
+		MaxTokens:   req.MaxTokens,
+		Provider:    req.Model,
+		// A client that hangs up cancels the request
+		Context: r.Context(),
+	})
+	if err != nil {
+		writeAPIError(w, http.StatusBadGateway, "provider_error", err)
+		return
+	}
+
+	id := "chatcmpl-" + newID()
+	created := time.Now().Unix()
+	model := req.Model
+	if model == "" {
+		model = result.Provider
+	}
+
+	if req.Stream {
+		writeChatCompletionStream(w, id, created, model, result.Response)
+		return
+	}
+
+	promptTokens := estimateTokens(renderTranscript(req.Messages))
+	completionTokens := estimateTokens(result.Response)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":      id,
+		"object":  "chat.completion",
+		"created": created,
+		"model":   model,
+		"choices": []map[string]interface{}{{
+			"index":         0,
+			"message":       map[string]interface{}{"role": "assistant", "content": result.Response},
+			"finish_reason": "stop",
+		}},
+		"usage": map[string]interface{}{
+			"prompt_tokens":     promptTokens,
+			"completion_tokens": completionTokens,
+			"total_tokens":      promptTokens + completionTokens,
+		},
+	})
+}
+
+// writeChatCompletionStream sends a completed response as an OpenAI-style
+// server-sent event stream for clients that requested stream=true
+func writeChatCompletionStream(w http.ResponseWriter, id string, created int64, model, content string) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	chunk := func(delta map[string]interface{}, finish interface{}) {
+		data, _ := json.Marshal(map[string]interface{}{
+			"id":      id,
+			"object":  "chat.completion.chunk",
+			"created": created,
+			"model":   model,
+			"choices": []map[string]interface{}{{
+				"index":         0,
+				"delta":         delta,
+				"finish_reason": finish,
+			}},
+		})
+		fmt.Fprintf(w, "data: %s\n\n", data)
+	}
+
+	chunk(map[string]interface{}{"role": "assistant", "content": content}, nil)
+	chunk(map[string]interface{}{}, "stop")
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// handleModels lists configured providers as models so OpenAI clients can pick one by name
+func (a *App) handleModels(w http.ResponseWriter, r *http.Request) {
+	providers := a.ListProviders()
+	data := make([]map[string]interface{}, len(providers))
+	for i, p := range providers {
+		data[i] = map[string]interface{}{
+			"id":       p.Name,
+			"object":   "model",
+			"owned_by": "vibe-coder",
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"object": "list",
+		"data":   data,
+	})
+}
+
+// handleEventSchema serves GET /v1/events/schema
+func (a *App) handleEventSchema(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.GetEventSchema())
+}
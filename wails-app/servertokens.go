@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Capabilities a local server token can be scoped to
+const (
+	// ScopeConversationsRead allows reading saved conversations
+	ScopeConversationsRead = "conversations:read"
+	// ScopePrompt allows chat completions, embeddings, listing models and
+	// reading the event schema
+	ScopePrompt = "prompt"
+	// ScopeTools allows everything, including running scripts
+	ScopeTools = "tools"
+)
+
+var serverScopes = []string{ScopeConversationsRead, ScopePrompt, ScopeTools}
+
+// serverTokenPrefix marks local server tokens so they are recognizable in
+// configs and caught by secret scanners
+const serverTokenPrefix = "vc_"
+
+// maxServerRequestBytes caps request bodies to the local server
+const maxServerRequestBytes = 16 << 20
+
+// ServerToken is an API token for the local server. Only a hash of the
+// token is kept; the token itself is shown once, when it is created.
+type ServerToken struct {
+	ID     string   `json:"id"`
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// Hint is the start of the token, to tell tokens apart
+	Hint       string     `json:"hint"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+}
+
+func (t ServerToken) allows(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope || s == ScopeTools {
+			return true
+		}
+	}
+	return false
+}
+
+// NewServerToken is a token just created, with the only copy of its secret
+type NewServerToken struct {
+	ServerToken
+	Token string `json:"token"`
+}
+
+type storedServerToken struct {
+	ServerToken
+	Hash string `json:"hash"`
+}
+
+// serverTokenStore keeps the local server's tokens in a JSON file
+type serverTokenStore struct {
+	mu     sync.Mutex
+	path   string
+	tokens []*storedServerToken
+}
+
+func newServerTokenStore() *serverTokenStore {
+	return &serverTokenStore{}
+}
+
+func hashServerToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (st *serverTokenStore) open(path string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.path = path
+	if err := readJSONFile(path, &st.tokens); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (st *serverTokenStore) saveLocked() error {
+	if st.path == "" {
+		return nil
+	}
+	return writeJSONFile(st.path, st.tokens)
+}
+
+// empty reports whether no tokens exist, in which case prompt endpoints are
+// open to every local client as before tokens were introduced
+func (st *serverTokenStore) empty() bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return len(st.tokens) == 0
+}
+
+// authenticate returns the token presented in an Authorization header
+func (st *serverTokenStore) authenticate(header string) (ServerToken, bool) {
+	secret, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok || !strings.HasPrefix(secret, serverTokenPrefix) {
+		return ServerToken{}, false
+	}
+	hash := hashServerToken(strings.TrimSpace(secret))
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for _, t := range st.tokens {
+		if t.Hash != hash {
+			continue
+		}
+		now := time.Now().UTC()
+		// Usage is saved at most once a minute per token
+		if t.LastUsedAt == nil || now.Sub(*t.LastUsedAt) > time.Minute {
+			t.LastUsedAt = &now
+			if err := st.saveLocked(); err != nil {
+				println("Error saving server tokens:", err.Error())
+			}
+		}
+		return t.ServerToken, true
+	}
+	return ServerToken{}, false
+}
+
+// CreateServerToken creates a local server token limited to scopes
+// (conversations:read, prompt, tools). Once any token exists, the local
+// server rejects requests without one.
+func (a *App) CreateServerToken(name string, scopes []string) (NewServerToken, error) {
+	a.telemetry.recordFeature("server_token")
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return NewServerToken{}, fmt.Errorf("token name is required")
+	}
+	if len(scopes) == 0 {
+		return NewServerToken{}, fmt.Errorf("a token needs at least one scope")
+	}
+	for _, s := range scopes {
+		known := false
+		for _, k := range serverScopes {
+			known = known || s == k
+		}
+		if !known {
+			return NewServerToken{}, fmt.Errorf("unknown scope %q; use %s", s, strings.Join(serverScopes, ", "))
+		}
+	}
+
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return NewServerToken{}, err
+	}
+	secret := serverTokenPrefix + hex.EncodeToString(b)
+	t := &storedServerToken{
+		ServerToken: ServerToken{
+			ID:        newID(),
+			Name:      name,
+			Scopes:    append([]string(nil), scopes...),
+			Hint:      secret[:len(serverTokenPrefix)+6],
+			CreatedAt: time.Now().UTC(),
+		},
+		Hash: hashServerToken(secret),
+	}
+
+	st := a.serverTokens
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.tokens = append(st.tokens, t)
+	if err := st.saveLocked(); err != nil {
+		st.tokens = st.tokens[:len(st.tokens)-1]
+		return NewServerToken{}, fmt.Errorf("save server tokens: %v", err)
+	}
+	return NewServerToken{ServerToken: t.ServerToken, Token: secret}, nil
+}
+
+// ListServerTokens returns the local server tokens, newest first, without their secrets
+func (a *App) ListServerTokens() []ServerToken {
+	st := a.serverTokens
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	out := make([]ServerToken, len(st.tokens))
+	for i, t := range st.tokens {
+		out[i] = t.ServerToken
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	return out
+}
+
+// RevokeServerToken deletes a local server token; requests using it are refused from then on
+func (a *App) RevokeServerToken(id string) error {
+	st := a.serverTokens
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for i, t := range st.tokens {
+		if t.ID == id {
+			st.tokens = append(st.tokens[:i], st.tokens[i+1:]...)
+			return st.saveLocked()
+		}
+	}
+	return fmt.Errorf("server token %q not found", id)
+}
+
+// serverTokenKey is the context key of the token a local server request was made with
+type serverTokenKey struct{}
+
+// serverTokenID returns the ID of the token ctx was authorized with, if any
+func serverTokenID(ctx context.Context) string {
+	id, _ := ctx.Value(serverTokenKey{}).(string)
+	return id
+}
+
+// statusRecorder remembers the status a handler wrote
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// authorize serves a local server endpoint to tokens with scope. Each
+// request is recorded in the audit log under its token, and so are the
+// provider requests made for it. While no token exists, prompt endpoints
+// are open; conversations and scripts always need a token.
+func (a *App) authorize(scope string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.serverTokens.empty() {
+			if scope != ScopePrompt {
+				writeAPIError(w, http.StatusUnauthorized, "authentication_error", fmt.Errorf("this endpoint needs an API token; create one with CreateServerToken"))
+				return
+			}
+			h(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxServerRequestBytes))
+		if err != nil {
+			writeAPIError(w, http.StatusRequestEntityTooLarge, "invalid_request_error", err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(body)
+		e := AuditEntry{
+			Timestamp:     time.Now().UTC(),
+			Source:        auditSourceServer,
+			Method:        r.Method,
+			Endpoint:      r.URL.Path,
+			PayloadBytes:  int64(len(body)),
+			PayloadSHA256: hex.EncodeToString(sum[:]),
+		}
+
+		token, ok := a.serverTokens.authenticate(r.Header.Get("Authorization"))
+		switch {
+		case !ok:
+			e.Status = http.StatusUnauthorized
+			a.audit.add(e)
+			writeAPIError(w, e.Status, "authentication_error", fmt.Errorf("missing or invalid API token"))
+			return
+		case !token.allows(scope):
+			e.Token, e.Status = token.ID, http.StatusForbidden
+			a.audit.add(e)
+			writeAPIError(w, e.Status, "permission_error", fmt.Errorf("token %s does not have the %s scope", token.Name, scope))
+			return
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h(rec, r.WithContext(context.WithValue(r.Context(), serverTokenKey{}, token.ID)))
+		e.Token, e.Status = token.ID, rec.status
+		a.audit.add(e)
+	}
+}
+
+// handleListConversations serves GET /v1/conversations
+func (a *App) handleListConversations(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"object": "list", "data": a.ListSessions()})
+}
+
+// handleGetConversation serves GET /v1/conversations/{id}
+func (a *App) handleGetConversation(w http.ResponseWriter, r *http.Request) {
+	s, err := a.GetSession(r.PathValue("id"))
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, "not_found_error", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, s)
+}
+
+// handleRunScript serves POST /v1/scripts/{id}/run with an optional {"input": "..."} body
+func (a *App) handleRunScript(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Input string `json:"input"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request_error", fmt.Errorf("invalid JSON body: %v", err))
+		return
+	}
+	run, err := a.runScript(r.Context(), r.PathValue("id"), req.Input)
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, "not_found_error", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, run)
+}
@@ -0,0 +1,560 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a single turn in a conversation
+type Message struct {
+	Role      string    `json:"role"`
+	Content   string    `json:"content"`
+	Provider  string    `json:"provider,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+	// Intent is the classifier's label for a user prompt
+	Intent string `json:"intent,omitempty"`
+	// StackTraces holds traces parsed from the message, with workspace file links
+	StackTraces []StackTrace `json:"stackTraces,omitempty"`
+	// Files are the workspace files sent with a user prompt
+	Files []PromptFile `json:"files,omitempty"`
+	// Remainder is reply text held back by the target length until ContinueResponse shows it
+	Remainder string `json:"remainder,omitempty"`
+	// FollowUps are suggested next prompts for an assistant reply
+	FollowUps []FollowUpSuggestion `json:"followUps,omitempty"`
+	// ToolCalls are the tool calls of an assistant reply, and ToolCallID
+	// links a "tool" message with a result to its call
+	ToolCalls  []ToolCall `json:"toolCalls,omitempty"`
+	ToolCallID string     `json:"toolCallId,omitempty"`
+}
+
+// Session is a persisted conversation
+type Session struct {
+	ID        string    `json:"id"`
+	Title     string    `json:"title"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	Messages  []Message `json:"messages"`
+
+	// ParentID and ParentMessage record the session and message this one was forked from
+	ParentID      string   `json:"parentId,omitempty"`
+	ParentMessage int      `json:"parentMessage,omitempty"`
+	ChildIDs      []string `json:"childIds,omitempty"`
+	// SplitFromID and SplitIntoIDs link conversations split apart by topic
+	SplitFromID  string   `json:"splitFromId,omitempty"`
+	SplitIntoIDs []string `json:"splitIntoIds,omitempty"`
+
+	// Summary condenses older messages so they can be left out of the prompt
+	Summary *ConversationSummary `json:"summary,omitempty"`
+	// AutoSummarize rolls older turns into Summary as the conversation grows
+	AutoSummarize bool `json:"autoSummarize,omitempty"`
+	// Compaction holds the summarized and compressed tiers of a long
+	// conversation, oldest first, covering its leading messages
+	Compaction []ContextSegment `json:"compaction,omitempty"`
+
+	Attachments []AttachmentRef `json:"attachments,omitempty"`
+	Notes       []SessionNote   `json:"notes,omitempty"`
+	// Language overrides the default response language for this conversation
+	Language string `json:"language,omitempty"`
+	// Persona is the ID of the persona whose prompt is the system message
+	Persona string `json:"persona,omitempty"`
+	// WorkingDir pins the conversation to a directory that its tools resolve
+	// paths against instead of the open workspace
+	WorkingDir string `json:"workingDir,omitempty"`
+
+	// Archived sessions are read-only and hidden from ListSessions, but still searchable
+	Archived   bool      `json:"archived,omitempty"`
+	ArchivedAt time.Time `json:"archivedAt,omitempty"`
+	// DeletedAt is set while the session is in the trash
+	DeletedAt time.Time `json:"deletedAt,omitempty"`
+	// Locked conversations are encrypted at rest with their own passphrase.
+	// Sealed holds the encrypted content while the conversation is not
+	// unlocked, and its messages are then empty.
+	Locked bool           `json:"locked,omitempty"`
+	Sealed *sealedContent `json:"sealed,omitempty"`
+	// CleanRoom conversations only use approved providers that do not train
+	// on data (see SetConversationCleanRoom)
+	CleanRoom bool `json:"cleanRoom,omitempty"`
+}
+
+// ConversationSummary is a model-written summary of the first Through messages of a session
+type ConversationSummary struct {
+	Content   string    `json:"content"`
+	Through   int       `json:"through"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// SessionNote is a generated note attached to a conversation, such as a focus session wrap-up
+type SessionNote struct {
+	Kind      string    `json:"kind"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// SessionSummary is the lightweight form of a Session used for listings
+type SessionSummary struct {
+	ID           string    `json:"id"`
+	Title        string    `json:"title"`
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+	MessageCount int       `json:"messageCount"`
+	ParentID     string    `json:"parentId,omitempty"`
+	Archived     bool      `json:"archived,omitempty"`
+	Locked       bool      `json:"locked,omitempty"`
+	CleanRoom    bool      `json:"cleanRoom,omitempty"`
+}
+
+func (s *Session) summary() SessionSummary {
+	return SessionSummary{
+		ID:           s.ID,
+		Title:        s.Title,
+		CreatedAt:    s.CreatedAt,
+		UpdatedAt:    s.UpdatedAt,
+		MessageCount: len(s.Messages),
+		ParentID:     s.ParentID,
+		Archived:     s.Archived,
+		Locked:       s.Locked,
+		CleanRoom:    s.CleanRoom,
+	}
+}
+
+func (s *Session) clone() Session {
+	c := *s
+	c.Messages = append([]Message(nil), s.Messages...)
+	c.ChildIDs = append([]string(nil), s.ChildIDs...)
+	c.SplitIntoIDs = append([]string(nil), s.SplitIntoIDs...)
+	c.Attachments = append([]AttachmentRef(nil), s.Attachments...)
+	c.Notes = append([]SessionNote(nil), s.Notes...)
+	c.Compaction = append([]ContextSegment(nil), s.Compaction...)
+	return c
+}
+
+// SessionStore keeps conversations in memory and mirrors them to one JSON
+// file per session when a directory is configured
+type SessionStore struct {
+	mu       sync.RWMutex
+	dir      string
+	sessions map[string]*Session
+	// trash holds deleted sessions until they are restored or purged
+	trash map[string]*Session
+	index *searchIndex
+	// keys are those of the locked sessions unlocked in this run
+	keys map[string]conversationKey
+}
+
+func newSessionStore() *SessionStore {
+	return &SessionStore{
+		sessions: make(map[string]*Session),
+		trash:    make(map[string]*Session),
+		index:    newSearchIndex(),
+		keys:     make(map[string]conversationKey),
+	}
+}
+
+// open loads all sessions from dir and persists future changes there
+func (st *SessionStore) open(dir string) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.dir = dir
+	for _, path := range paths {
+		var s Session
+		if err := readJSONFile(path, &s); err != nil {
+			return fmt.Errorf("load session %s: %v", filepath.Base(path), err)
+		}
+		if _, exists := st.sessions[s.ID]; exists {
+			continue
+		}
+		st.sessions[s.ID] = &s
+		if s.Sealed != nil {
+			continue
+		}
+		for i, m := range s.Messages {
+			st.index.add(s.ID, i, m.Content)
+		}
+	}
+
+	if err := st.openTrash(); err != nil {
+		return err
+	}
+
+	// Persist anything created before the store was opened
+	for _, s := range st.sessions {
+		if err := st.saveLocked(s); err != nil {
+			return err
+		}
+	}
+	for id, s := range st.trash {
+		c, err := st.diskFormLocked(s)
+		if err != nil {
+			return err
+		}
+		if err := writeJSONFile(st.trashPathLocked(id), c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (st *SessionStore) saveLocked(s *Session) error {
+	if st.dir == "" {
+		return nil
+	}
+	c, err := st.diskFormLocked(s)
+	if err != nil {
+		return err
+	}
+	return writeJSONFile(filepath.Join(st.dir, s.ID+".json"), c)
+}
+
+func (st *SessionStore) create(title string) Session {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	now := time.Now()
+	s := &Session{
+		ID:        newID(),
+		Title:     title,
+		CreatedAt: now,
+		UpdatedAt: now,
+		Messages:  []Message{},
+	}
+	st.sessions[s.ID] = s
+	if err := st.saveLocked(s); err != nil {
+		println("Error saving session:", err.Error())
+	}
+	return s.clone()
+}
+
+func (st *SessionStore) get(id string) (Session, bool) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	s, ok := st.sessions[id]
+	if !ok {
+		return Session{}, false
+	}
+	return s.clone(), true
+}
+
+func (st *SessionStore) list() []SessionSummary {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	out := make([]SessionSummary, 0, len(st.sessions))
+	for _, s := range st.sessions {
+		out = append(out, s.summary())
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].UpdatedAt.After(out[j].UpdatedAt)
+	})
+	return out
+}
+
+// errArchived is returned when modifying an archived session
+func errArchived(id string) error {
+	return fmt.Errorf("session %q is archived and read-only", id)
+}
+
+// update applies fn to a session under the store lock and persists the
+// result; a non-nil error from fn leaves the session unsaved. Archived
+// sessions cannot be updated.
+func (st *SessionStore) update(id string, fn func(s *Session) error) (Session, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	s, ok := st.sessions[id]
+	if !ok {
+		return Session{}, fmt.Errorf("session %q not found", id)
+	}
+	if s.Archived {
+		return Session{}, errArchived(id)
+	}
+	if s.Sealed != nil {
+		return Session{}, errConversationLocked(id)
+	}
+	if err := fn(s); err != nil {
+		return Session{}, err
+	}
+	s.UpdatedAt = time.Now()
+	if err := st.saveLocked(s); err != nil {
+		return Session{}, err
+	}
+	return s.clone(), nil
+}
+
+// appendMessages adds messages to a session, indexes them, and persists the session
+func (st *SessionStore) appendMessages(id string, msgs ...Message) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	s, ok := st.sessions[id]
+	if !ok {
+		return fmt.Errorf("session %q not found", id)
+	}
+	if s.Archived {
+		return errArchived(id)
+	}
+	if s.Sealed != nil {
+		return errConversationLocked(id)
+	}
+
+	for _, m := range msgs {
+		if m.Timestamp.IsZero() {
+			m.Timestamp = time.Now()
+		}
+		s.Messages = append(s.Messages, m)
+		st.index.add(s.ID, len(s.Messages)-1, m.Content)
+	}
+	s.UpdatedAt = time.Now()
+	return st.saveLocked(s)
+}
+
+// updateMessage applies fn to one message of a session, then reindexes and
+// persists it
+func (st *SessionStore) updateMessage(id string, i int, fn func(m *Message)) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	s, ok := st.sessions[id]
+	if !ok {
+		return fmt.Errorf("session %q not found", id)
+	}
+	if s.Archived {
+		return errArchived(id)
+	}
+	if s.Sealed != nil {
+		return errConversationLocked(id)
+	}
+	if i < 0 || i >= len(s.Messages) {
+		return fmt.Errorf("message %d out of range", i)
+	}
+
+	fn(&s.Messages[i])
+	st.index.add(s.ID, i, s.Messages[i].Content)
+	s.UpdatedAt = time.Now()
+	return st.saveLocked(s)
+}
+
+// setArchived moves a session into or out of the read-only archive
+func (st *SessionStore) setArchived(id string, archived bool) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	s, ok := st.sessions[id]
+	if !ok {
+		return fmt.Errorf("session %q not found", id)
+	}
+	s.Archived = archived
+	if archived {
+		s.ArchivedAt = time.Now()
+	} else {
+		s.ArchivedAt = time.Time{}
+	}
+	return st.saveLocked(s)
+}
+
+// fork creates a new session holding a copy of the parent's messages up to
+// and including messageIndex, linked to the parent in both directions
+func (st *SessionStore) fork(id string, messageIndex int) (Session, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	parent, ok := st.sessions[id]
+	if !ok {
+		return Session{}, fmt.Errorf("session %q not found", id)
+	}
+	if parent.Sealed != nil {
+		return Session{}, errConversationLocked(id)
+	}
+	if messageIndex < 0 || messageIndex >= len(parent.Messages) {
+		return Session{}, fmt.Errorf("invalid message index")
+	}
+
+	now := time.Now()
+	child := &Session{
+		ID:            newID(),
+		Title:         parent.Title + " (fork)",
+		CreatedAt:     now,
+		UpdatedAt:     now,
+		Messages:      append([]Message(nil), parent.Messages[:messageIndex+1]...),
+		ParentID:      parent.ID,
+		ParentMessage: messageIndex,
+		Attachments:   append([]AttachmentRef(nil), parent.Attachments...),
+		Persona:       parent.Persona,
+		WorkingDir:    parent.WorkingDir,
+		CleanRoom:     parent.CleanRoom,
+	}
+	if parent.Summary != nil && parent.Summary.Through <= len(child.Messages) {
+		child.Summary = parent.Summary
+	}
+	child.Compaction = append([]ContextSegment(nil), validSegments(Session{Messages: child.Messages, Compaction: parent.Compaction})...)
+	parent.ChildIDs = append(parent.ChildIDs, child.ID)
+	st.inheritLockLocked(parent, child)
+
+	st.sessions[child.ID] = child
+	for i, m := range child.Messages {
+		st.index.add(child.ID, i, m.Content)
+	}
+
+	if err := st.saveLocked(child); err != nil {
+		return Session{}, err
+	}
+	if err := st.saveLocked(parent); err != nil {
+		return Session{}, err
+	}
+	return child.clone(), nil
+}
+
+// sessionTitle derives a short title from the first prompt of a conversation
+func sessionTitle(prompt string) string {
+	title := strings.Join(strings.Fields(prompt), " ")
+	const maxLen = 40
+	if r := []rune(title); len(r) > maxLen {
+		title = string(r[:maxLen]) + "…"
+	}
+	if title == "" {
+		title = "New Chat"
+	}
+	return title
+}
+
+// NewSession creates an empty conversation and makes it active
+func (a *App) NewSession(title string) Session {
+	if title == "" {
+		title = "New Chat"
+	}
+	s := a.sessions.create(title)
+
+	a.sessionMutex.Lock()
+	a.activeSession = s.ID
+	a.sessionMutex.Unlock()
+
+	return s
+}
+
+// ListSessions returns all conversations that are not archived, most recently updated first
+func (a *App) ListSessions() []SessionSummary {
+	var out []SessionSummary
+	for _, s := range a.sessions.list() {
+		if !s.Archived {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// ListArchivedSessions returns archived conversations, most recently updated first
+func (a *App) ListArchivedSessions() []SessionSummary {
+	var out []SessionSummary
+	for _, s := range a.sessions.list() {
+		if s.Archived {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// ArchiveConversation makes a conversation read-only: it can no longer be
+// continued, summarized, or edited, and is hidden from ListSessions, but it
+// stays searchable and can still be forked
+func (a *App) ArchiveConversation(sessionID string) error {
+	if err := a.sessions.setArchived(sessionID, true); err != nil {
+		return err
+	}
+
+	a.sessionMutex.Lock()
+	if a.activeSession == sessionID {
+		a.activeSession = ""
+	}
+	a.sessionMutex.Unlock()
+	return nil
+}
+
+// UnarchiveConversation returns an archived conversation to the normal listing
+func (a *App) UnarchiveConversation(sessionID string) error {
+	return a.sessions.setArchived(sessionID, false)
+}
+
+// GetSession returns a conversation with all of its messages
+func (a *App) GetSession(id string) (Session, error) {
+	s, ok := a.sessions.get(id)
+	if !ok {
+		return Session{}, fmt.Errorf("session %q not found", id)
+	}
+	return s, nil
+}
+
+// SetActiveSession selects the conversation that SendPrompt appends to
+func (a *App) SetActiveSession(id string) error {
+	s, ok := a.sessions.get(id)
+	if !ok {
+		return fmt.Errorf("session %q not found", id)
+	}
+	if s.Archived {
+		return errArchived(id)
+	}
+
+	a.sessionMutex.Lock()
+	defer a.sessionMutex.Unlock()
+	a.activeSession = id
+	return nil
+}
+
+// GetActiveSession returns the ID of the active conversation, or "" if none
+func (a *App) GetActiveSession() string {
+	a.sessionMutex.Lock()
+	defer a.sessionMutex.Unlock()
+	return a.activeSession
+}
+
+// ForkSession starts a new conversation from the given message of an
+// existing one, leaving the original thread untouched, and makes it active
+func (a *App) ForkSession(sessionID string, messageIndex int) (Session, error) {
+	a.telemetry.recordFeature("fork_session")
+	s, err := a.sessions.fork(sessionID, messageIndex)
+	if err != nil {
+		return Session{}, err
+	}
+	for _, ref := range s.Attachments {
+		if err := a.attachments.retain(ref.Hash); err != nil {
+			println("Error retaining attachment:", err.Error())
+		}
+	}
+
+	a.sessionMutex.Lock()
+	a.activeSession = s.ID
+	a.sessionMutex.Unlock()
+
+	return s, nil
+}
+
+// ensureActiveSession returns the active session ID, starting a new
+// conversation titled after prompt when there is none
+func (a *App) ensureActiveSession(prompt string) string {
+	a.sessionMutex.Lock()
+	defer a.sessionMutex.Unlock()
+
+	if a.activeSession != "" {
+		if s, ok := a.sessions.get(a.activeSession); ok && !s.Archived {
+			return a.activeSession
+		}
+	}
+
+	s := a.sessions.create(sessionTitle(prompt))
+	a.activeSession = s.ID
+	return s.ID
+}
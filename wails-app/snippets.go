@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// snippetCompileTimeout is how long compiling a snippet may take
+	snippetCompileTimeout = 60 * time.Second
+	// snippetRunTimeout is how long a snippet may run
+	snippetRunTimeout = 10 * time.Second
+	// snippetCPUSeconds and snippetMemoryBytes limit a running snippet
+	// where the OS allows it
+	snippetCPUSeconds  = 10
+	snippetMemoryBytes = 512 << 20
+	// snippetOutputChars is how much of the end of stdout and of stderr is kept
+	snippetOutputChars = 64000
+)
+
+// Phases of a snippet run
+const (
+	snippetCompile = "compile"
+	snippetRun     = "run"
+)
+
+// SnippetResult is the outcome of running a code snippet
+type SnippetResult struct {
+	Language string `json:"language"`
+	// Phase is "compile" when the snippet failed to compile, else "run"
+	Phase    string `json:"phase"`
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+	ExitCode int    `json:"exitCode"`
+	// TimedOut is set when the snippet was stopped for running too long
+	TimedOut   bool  `json:"timedOut,omitempty"`
+	DurationMs int64 `json:"durationMs"`
+}
+
+// snippetLanguage is how snippets in one language are run
+type snippetLanguage struct {
+	name string
+	file string
+	// files are written next to the snippet
+	files map[string]string
+	// build, when set, is the command that compiles the snippet in dir
+	build func(dir string) []string
+	run   func(dir string) []string
+	// env is added to the environment of the running snippet
+	env []string
+}
+
+var goSnippets = snippetLanguage{
+	name:  "go",
+	file:  "main.go",
+	files: map[string]string{"go.mod": "module snippet\n"},
+	build: func(dir string) []string {
+		return []string{"go", "build", "-o", filepath.Join(dir, "snippet"), "."}
+	},
+	run: func(dir string) []string { return []string{filepath.Join(dir, "snippet")} },
+	env: []string{fmt.Sprintf("GOMEMLIMIT=%dMiB", snippetMemoryBytes>>20)},
+}
+
+var pythonSnippets = snippetLanguage{
+	name: "python",
+	file: "main.py",
+	run:  func(dir string) []string { return []string{"python3", filepath.Join(dir, "main.py")} },
+}
+
+var javascriptSnippets = snippetLanguage{
+	name: "javascript",
+	file: "main.js",
+	run: func(dir string) []string {
+		return []string{"node", fmt.Sprintf("--max-old-space-size=%d", snippetMemoryBytes>>20), filepath.Join(dir, "main.js")}
+	},
+}
+
+// snippetLanguages are the languages RunSnippet runs, by the names code
+// blocks are tagged with
+var snippetLanguages = map[string]snippetLanguage{
+	"go":         goSnippets,
+	"golang":     goSnippets,
+	"python":     pythonSnippets,
+	"py":         pythonSnippets,
+	"javascript": javascriptSnippets,
+	"js":         javascriptSnippets,
+	"node":       javascriptSnippets,
+}
+
+// runSnippetStep runs one step of a snippet run and records its output
+func runSnippetStep(timeout time.Duration, limited bool, dir string, argv, env []string, result *SnippetResult) error {
+	if _, err := exec.LookPath(argv[0]); err != nil {
+		return fmt.Errorf("%s is not installed", argv[0])
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var cmd *exec.Cmd
+	if limited {
+		cmd = limitedCommand(ctx, snippetCPUSeconds, snippetMemoryBytes, argv[0], argv[1:]...)
+	} else {
+		cmd = exec.CommandContext(ctx, argv[0], argv[1:]...)
+	}
+	cmd.Dir = dir
+	cmd.Env = append(append(os.Environ(), "GOTOOLCHAIN=local"), env...)
+	cmd.WaitDelay = 2 * time.Second
+	stdout, stderr := &tailBuffer{max: snippetOutputChars}, &tailBuffer{max: snippetOutputChars}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+
+	start := time.Now()
+	err := cmd.Run()
+	result.DurationMs += time.Since(start).Milliseconds()
+	result.Stdout, result.Stderr = stdout.String(), stderr.String()
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		result.TimedOut, result.ExitCode = true, -1
+	case errors.As(err, &exitErr):
+		result.ExitCode = exitErr.ExitCode()
+	case err != nil:
+		return err
+	}
+	return nil
+}
+
+// RunSnippet runs a generated code snippet and returns its output to show
+// under the code block. language is go, python or javascript. Go snippets
+// are built as a temporary module, so they can only use the standard
+// library. A snippet runs in a temporary directory for at most ten
+// seconds, with CPU time and memory limited where the OS allows it. It is
+// not isolated from the file system or the network, so only run snippets
+// you have read.
+func (a *App) RunSnippet(code string, language string) (SnippetResult, error) {
+	a.telemetry.recordFeature("run_snippet")
+	lang, ok := snippetLanguages[strings.ToLower(strings.TrimSpace(language))]
+	if !ok {
+		return SnippetResult{}, fmt.Errorf("snippets in %q can't be run; use go, python or javascript", language)
+	}
+	if strings.TrimSpace(code) == "" {
+		return SnippetResult{}, fmt.Errorf("snippet is empty")
+	}
+
+	dir, err := os.MkdirTemp("", "vibe-snippet-*")
+	if err != nil {
+		return SnippetResult{}, err
+	}
+	defer os.RemoveAll(dir)
+	if err := os.WriteFile(filepath.Join(dir, lang.file), []byte(code), 0o600); err != nil {
+		return SnippetResult{}, err
+	}
+	for name, content := range lang.files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			return SnippetResult{}, err
+		}
+	}
+
+	result := SnippetResult{Language: lang.name, Phase: snippetRun}
+	if lang.build != nil {
+		result.Phase = snippetCompile
+		if err := runSnippetStep(snippetCompileTimeout, false, dir, lang.build(dir), nil, &result); err != nil {
+			return SnippetResult{}, err
+		}
+		if result.ExitCode != 0 || result.TimedOut {
+			return result, nil
+		}
+		result.Phase = snippetRun
+	}
+	if err := runSnippetStep(snippetRunTimeout, true, dir, lang.run(dir), lang.env, &result); err != nil {
+		return SnippetResult{}, err
+	}
+	return result, nil
+}
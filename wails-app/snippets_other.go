@@ -0,0 +1,14 @@
+//go:build !unix
+
+package main
+
+import (
+	"context"
+	"os/exec"
+)
+
+// limitedCommand runs a program without CPU or memory limits, which are not
+// implemented on this platform; only the timeout applies
+func limitedCommand(ctx context.Context, cpuSeconds int, memoryBytes int64, name string, args ...string) *exec.Cmd {
+	return exec.CommandContext(ctx, name, args...)
+}
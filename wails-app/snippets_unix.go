@@ -0,0 +1,16 @@
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+)
+
+// limitedCommand runs a program under a shell that first lowers its CPU
+// time and data size limits
+func limitedCommand(ctx context.Context, cpuSeconds int, memoryBytes int64, name string, args ...string) *exec.Cmd {
+	script := fmt.Sprintf(`ulimit -t %d && ulimit -d %d && exec "$0" "$@"`, cpuSeconds, memoryBytes>>10)
+	return exec.CommandContext(ctx, "/bin/sh", append([]string{"-c", script, name}, args...)...)
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	// minSplitMessages is how long a conversation must be before splits are suggested
+	minSplitMessages = 10
+	// topicWindow is how many exchanges on each side of a turn are compared
+	topicWindow = 3
+	// topicShiftThreshold is the similarity below which a turn starts a new topic
+	topicShiftThreshold = 0.08
+)
+
+// TopicShift is a point where a conversation appears to change subject
+type TopicShift struct {
+	// MessageIndex is the user message that starts the new topic
+	MessageIndex int `json:"messageIndex"`
+	// Similarity is the cosine similarity of the vocabulary before and after, from 0 to 1
+	Similarity float64  `json:"similarity"`
+	Keywords   []string `json:"keywords"`
+}
+
+// topicStopwords are frequent words that say nothing about the subject
+var topicStopwords = map[string]bool{
+	"this": true, "that": true, "with": true, "have": true, "what": true, "when": true,
+	"there": true, "their": true, "would": true, "could": true, "should": true, "about": true,
+	"from": true, "your": true, "will": true, "which": true, "does": true,
+	"here": true, "just": true, "like": true, "also": true, "then": true, "than": true,
+	"them": true, "they": true, "some": true, "into": true, "make": true, "need": true,
+	"want": true, "more": true, "only": true, "each": true, "other": true, "these": true,
+	"those": true, "because": true, "example": true, "using": true, "thanks": true, "please": true,
+}
+
+// topicTerms counts the subject-bearing words of messages
+func topicTerms(messages []Message) map[string]float64 {
+	terms := make(map[string]float64)
+	for _, m := range messages {
+		for _, t := range tokenize(m.Content) {
+			if len(t) >= 4 && !topicStopwords[t] {
+				terms[t]++
+			}
+		}
+	}
+	return terms
+}
+
+func termCosine(a, b map[string]float64) float64 {
+	var dot, na, nb float64
+	for t, x := range a {
+		na += x * x
+		dot += x * b[t]
+	}
+	for _, y := range b {
+		nb += y * y
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
+
+// topKeywords returns the most frequent terms
+func topKeywords(terms map[string]float64, n int) []string {
+	words := make([]string, 0, len(terms))
+	for t := range terms {
+		words = append(words, t)
+	}
+	sort.Slice(words, func(i, j int) bool {
+		if terms[words[i]] != terms[words[j]] {
+			return terms[words[i]] > terms[words[j]]
+		}
+		return words[i] < words[j]
+	})
+	if len(words) > n {
+		words = words[:n]
+	}
+	return words
+}
+
+// detectTopicShifts compares the vocabulary of the exchanges before and after
+// each user turn and reports turns where it changes sharply. Only the
+// strongest shift among neighbouring turns is kept.
+func detectTopicShifts(messages []Message) []TopicShift {
+	if len(messages) < minSplitMessages {
+		return nil
+	}
+	var turns []int
+	for i, m := range messages {
+		if m.Role == "user" {
+			turns = append(turns, i)
+		}
+	}
+
+	var candidates []TopicShift
+	for k := 2; k < len(turns); k++ {
+		start := turns[max(0, k-topicWindow)]
+		end := len(messages)
+		if k+topicWindow < len(turns) {
+			end = turns[k+topicWindow]
+		}
+		before := topicTerms(messages[start:turns[k]])
+		after := topicTerms(messages[turns[k]:end])
+		if len(after) == 0 {
+			continue
+		}
+		if sim := termCosine(before, after); sim < topicShiftThreshold {
+			candidates = append(candidates, TopicShift{
+				MessageIndex: turns[k],
+				Similarity:   math.Round(sim*1000) / 1000,
+				Keywords:     topKeywords(after, 5),
+			})
+		}
+	}
+
+	var shifts []TopicShift
+	for _, c := range candidates {
+		if n := len(shifts); n > 0 && c.MessageIndex-shifts[n-1].MessageIndex <= 2*topicWindow {
+			if c.Similarity < shifts[n-1].Similarity {
+				shifts[n-1] = c
+			}
+			continue
+		}
+		shifts = append(shifts, c)
+	}
+	return shifts
+}
+
+// split moves the messages from messageIndex on into a new session and links
+// the two sessions to each other
+func (st *SessionStore) split(id string, messageIndex int) (Session, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	s, ok := st.sessions[id]
+	if !ok {
+		return Session{}, fmt.Errorf("session %q not found", id)
+	}
+	if s.Archived {
+		return Session{}, errArchived(id)
+	}
+	if s.Sealed != nil {
+		return Session{}, errConversationLocked(id)
+	}
+	if messageIndex <= 0 || messageIndex >= len(s.Messages) {
+		return Session{}, fmt.Errorf("invalid message index")
+	}
+
+	moved := append([]Message(nil), s.Messages[messageIndex:]...)
+	title := s.Title + " (split)"
+	for _, m := range moved {
+		if m.Role == "user" {
+			title = sessionTitle(m.Content)
+			break
+		}
+	}
+
+	now := time.Now()
+	thread := &Session{
+		ID:          newID(),
+		Title:       title,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		Messages:    moved,
+		SplitFromID: s.ID,
+		Attachments: append([]AttachmentRef(nil), s.Attachments...),
+		Language:    s.Language,
+		Persona:     s.Persona,
+		WorkingDir:  s.WorkingDir,
+		CleanRoom:   s.CleanRoom,
+	}
+
+	for i := messageIndex; i < len(s.Messages); i++ {
+		st.index.remove(s.ID, i)
+	}
+	s.Messages = s.Messages[:messageIndex]
+	if s.Summary != nil && s.Summary.Through > messageIndex {
+		s.Summary = nil
+	}
+	s.SplitIntoIDs = append(s.SplitIntoIDs, thread.ID)
+	st.inheritLockLocked(s, thread)
+	s.UpdatedAt = now
+
+	st.sessions[thread.ID] = thread
+	for i, m := range thread.Messages {
+		st.index.add(thread.ID, i, m.Content)
+	}
+
+	if err := st.saveLocked(thread); err != nil {
+		return Session{}, err
+	}
+	if err := st.saveLocked(s); err != nil {
+		return Session{}, err
+	}
+	return thread.clone(), nil
+}
+
+// SplitConversation moves a conversation's messages from messageIndex on into
+// a new conversation, which becomes active. The two are cross-linked through
+// SplitFromID and SplitIntoIDs.
+func (a *App) SplitConversation(sessionID string, messageIndex int) (Session, error) {
+	a.telemetry.recordFeature("split_conversation")
+	s, err := a.sessions.split(sessionID, messageIndex)
+	if err != nil {
+		return Session{}, err
+	}
+	for _, ref := range s.Attachments {
+		if err := a.attachments.retain(ref.Hash); err != nil {
+			println("Error retaining attachment:", err.Error())
+		}
+	}
+
+	a.sessionMutex.Lock()
+	a.activeSession = s.ID
+	a.sessionMutex.Unlock()
+
+	return s, nil
+}
+
+// SuggestSplits returns the points where a long conversation changes topic
+func (a *App) SuggestSplits(sessionID string) ([]TopicShift, error) {
+	s, ok := a.sessions.get(sessionID)
+	if !ok {
+		return nil, fmt.Errorf("session %q not found", sessionID)
+	}
+	return detectTopicShifts(s.Messages), nil
+}
+
+// maybeSuggestSplit emits "session:splitSuggested" when the latest exchange
+// of a conversation starts a new topic
+func (a *App) maybeSuggestSplit(sessionID string) {
+	s, ok := a.sessions.get(sessionID)
+	if !ok {
+		return
+	}
+	shifts := detectTopicShifts(s.Messages)
+	if len(shifts) == 0 {
+		return
+	}
+	last := shifts[len(shifts)-1]
+	if last.MessageIndex != len(s.Messages)-2 {
+		return
+	}
+	a.emit(EventSessionSplitSuggested, SplitSuggestedEvent{SessionID: sessionID, Shift: last})
+}
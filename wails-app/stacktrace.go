@@ -0,0 +1,307 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxIndexedFiles bounds the workspace walk used to resolve frame paths
+const maxIndexedFiles = 50000
+
+// StackFrame is one frame of a parsed stack trace
+type StackFrame struct {
+	Function string `json:"function,omitempty"`
+	File     string `json:"file"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+	// Path is the matching file relative to the open workspace, if one was found
+	Path string `json:"path,omitempty"`
+}
+
+// StackTrace is a stack trace found in pasted text, with frames innermost first
+type StackTrace struct {
+	Language string       `json:"language"`
+	Message  string       `json:"message,omitempty"`
+	Frames   []StackFrame `json:"frames"`
+}
+
+// stackTraceParser recognizes one language's stack trace format
+type stackTraceParser interface {
+	parse(lines []string) (StackTrace, bool)
+}
+
+// stackTraceParsers are tried in order; each contributes at most one trace
+var stackTraceParsers = []stackTraceParser{
+	goTraceParser{},
+	pythonTraceParser{},
+	javaTraceParser{},
+	jsTraceParser{},
+}
+
+// parseStackTraces extracts every recognizable stack trace from text
+func parseStackTraces(text string) []StackTrace {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	var traces []StackTrace
+	for _, p := range stackTraceParsers {
+		if t, ok := p.parse(lines); ok {
+			traces = append(traces, t)
+		}
+	}
+	return traces
+}
+
+// messageBefore returns the nearest non-empty line before index i, which is
+// where most formats put the exception message
+func messageBefore(lines []string, i int) string {
+	for j := i - 1; j >= 0; j-- {
+		if line := strings.TrimSpace(lines[j]); line != "" {
+			return line
+		}
+	}
+	return ""
+}
+
+type goTraceParser struct{}
+
+var (
+	goMessagePattern  = regexp.MustCompile(`^(panic: |fatal error: )`)
+	goLocationPattern = regexp.MustCompile(`^\s+(\S+\.go):(\d+)(?: \+0x[0-9a-f]+)?$`)
+)
+
+// parse reads goroutine dumps: a function line followed by an indented file:line
+func (goTraceParser) parse(lines []string) (StackTrace, bool) {
+	t := StackTrace{Language: "go"}
+	for i, line := range lines {
+		if t.Message == "" && goMessagePattern.MatchString(line) {
+			t.Message = strings.TrimSpace(line)
+		}
+		if i == 0 {
+			continue
+		}
+		m := goLocationPattern.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		fn := strings.TrimSpace(lines[i-1])
+		if j := strings.LastIndex(fn, "("); j > 0 && strings.HasSuffix(fn, ")") {
+			fn = fn[:j]
+		}
+		n, _ := strconv.Atoi(m[2])
+		t.Frames = append(t.Frames, StackFrame{Function: fn, File: m[1], Line: n})
+	}
+	return t, len(t.Frames) > 0
+}
+
+type pythonTraceParser struct{}
+
+var pythonFramePattern = regexp.MustCompile(`^\s*File "(.+?)", line (\d+)(?:, in (.+))?$`)
+
+// parse reads tracebacks, which list the innermost frame last
+func (pythonTraceParser) parse(lines []string) (StackTrace, bool) {
+	t := StackTrace{Language: "python"}
+	last := -1
+	for i, line := range lines {
+		m := pythonFramePattern.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		n, _ := strconv.Atoi(m[2])
+		t.Frames = append(t.Frames, StackFrame{Function: m[3], File: m[1], Line: n})
+		last = i
+	}
+	if last == -1 {
+		return t, false
+	}
+
+	// The exception is the first unindented line after the final frame's source line
+	for _, line := range lines[last+1:] {
+		if line != "" && line[0] != ' ' && line[0] != '\t' {
+			t.Message = strings.TrimSpace(line)
+			break
+		}
+	}
+	for i, j := 0, len(t.Frames)-1; i < j; i, j = i+1, j-1 {
+		t.Frames[i], t.Frames[j] = t.Frames[j], t.Frames[i]
+	}
+	return t, true
+}
+
+type javaTraceParser struct{}
+
+var javaFramePattern = regexp.MustCompile(`^\s*at ([\w$.<>/]+)\(([^()]*)\)`)
+
+// parse reads JVM traces, including "Caused by:" sections
+func (javaTraceParser) parse(lines []string) (StackTrace, bool) {
+	t := StackTrace{Language: "java"}
+	for i, line := range lines {
+		m := javaFramePattern.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		if len(t.Frames) == 0 {
+			t.Message = messageBefore(lines, i)
+		}
+		f := StackFrame{Function: m[1], File: m[2]}
+		if file, num, ok := strings.Cut(m[2], ":"); ok {
+			f.File = file
+			f.Line, _ = strconv.Atoi(num)
+		}
+		t.Frames = append(t.Frames, f)
+	}
+	return t, len(t.Frames) > 0
+}
+
+type jsTraceParser struct{}
+
+var (
+	// V8 (Node, Chrome): "at fn (file:line:col)" or "at file:line:col"
+	v8FramePattern = regexp.MustCompile(`^\s*at (?:(?:async )?(.+?) \()?(.+?):(\d+):(\d+)\)?$`)
+	// SpiderMonkey and JavaScriptCore: "fn@file:line:col"
+	geckoFramePattern = regexp.MustCompile(`^\s*(.*?)@(.+?):(\d+):(\d+)$`)
+	urlPrefixPattern  = regexp.MustCompile(`^(?:[a-z][\w+.-]*://[^/]*|webpack://[^/]*)`)
+)
+
+func (jsTraceParser) parse(lines []string) (StackTrace, bool) {
+	t := StackTrace{Language: "javascript"}
+	for i, line := range lines {
+		m := v8FramePattern.FindStringSubmatch(line)
+		if m == nil {
+			m = geckoFramePattern.FindStringSubmatch(line)
+		}
+		if m == nil || strings.HasSuffix(m[2], ".java") {
+			continue
+		}
+		if len(t.Frames) == 0 {
+			t.Message = messageBefore(lines, i)
+		}
+		n, _ := strconv.Atoi(m[3])
+		col, _ := strconv.Atoi(m[4])
+		t.Frames = append(t.Frames, StackFrame{Function: m[1], File: m[2], Line: n, Column: col})
+	}
+	return t, len(t.Frames) > 0
+}
+
+// framePath normalizes a frame's file into a slash-separated path to match
+// against the workspace, stripping URL schemes, hosts, and query strings. JVM
+// frames only name the file, so the package path is recovered from the function.
+func framePath(language string, f StackFrame) string {
+	p := urlPrefixPattern.ReplaceAllString(f.File, "")
+	if i := strings.IndexAny(p, "?#"); i >= 0 {
+		p = p[:i]
+	}
+	p = filepath.ToSlash(p)
+
+	if language == "java" && !strings.Contains(p, "/") {
+		parts := strings.Split(f.Function, ".")
+		if len(parts) > 2 {
+			p = strings.Join(parts[:len(parts)-2], "/") + "/" + p
+		}
+	}
+	return path.Clean(p)
+}
+
+// resolveFrames links frames to workspace files, choosing the file that
+// shares the longest trailing path with the frame. Frames whose best match
+// is ambiguous are left unresolved.
+func resolveFrames(root string, traces []StackTrace) {
+	if root == "" || len(traces) == 0 {
+		return
+	}
+
+	byName := make(map[string][]string)
+	count := 0
+	filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if p != root && skipIndexDir(d.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if count++; count > maxIndexedFiles {
+			return filepath.SkipAll
+		}
+		rel, err := filepath.Rel(root, p)
+		if err == nil {
+			byName[d.Name()] = append(byName[d.Name()], filepath.ToSlash(rel))
+		}
+		return nil
+	})
+
+	for ti := range traces {
+		for fi := range traces[ti].Frames {
+			f := &traces[ti].Frames[fi]
+			want := strings.Split(framePath(traces[ti].Language, *f), "/")
+			best, bestScore, tied := "", 0, false
+			for _, candidate := range byName[want[len(want)-1]] {
+				score := commonSuffix(strings.Split(candidate, "/"), want)
+				switch {
+				case score > bestScore:
+					best, bestScore, tied = candidate, score, false
+				case score == bestScore:
+					tied = true
+				}
+			}
+			if bestScore > 0 && !tied {
+				f.Path = best
+			}
+		}
+	}
+}
+
+// skipIndexDir reports directories that never hold source worth linking to
+func skipIndexDir(name string) bool {
+	switch name {
+	case ".git", "node_modules", "vendor", "dist", "build", "target", "__pycache__", ".venv", ".idea":
+		return true
+	}
+	return false
+}
+
+func commonSuffix(a, b []string) int {
+	n := 0
+	for i, j := len(a)-1, len(b)-1; i >= 0 && j >= 0 && a[i] == b[j]; i, j = i-1, j-1 {
+		n++
+	}
+	return n
+}
+
+// stackTraceContext renders parsed traces as context for the model
+func stackTraceContext(traces []StackTrace) string {
+	var b strings.Builder
+	b.WriteString("The user's message contains stack traces. Parsed frames, innermost first; paths are relative to the workspace when the file was found:\n")
+	for _, t := range traces {
+		fmt.Fprintf(&b, "\n%s: %s\n", t.Language, t.Message)
+		for _, f := range t.Frames {
+			file := f.File
+			if f.Path != "" {
+				file = f.Path
+			}
+			loc := file
+			if f.Line > 0 {
+				loc = fmt.Sprintf("%s:%d", file, f.Line)
+			}
+			if f.Function != "" {
+				fmt.Fprintf(&b, "- %s at %s\n", f.Function, loc)
+			} else {
+				fmt.Fprintf(&b, "- %s\n", loc)
+			}
+		}
+	}
+	return b.String()
+}
+
+// ParseStackTrace finds stack traces in pasted text and links their frames
+// to files in the active conversation's directory or the open workspace
+func (a *App) ParseStackTrace(text string) []StackTrace {
+	traces := parseStackTraces(text)
+	resolveFrames(a.sessionRoot(""), traces)
+	return traces
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// slackWebhookRef is the secret store entry holding the Slack incoming webhook URL
+const slackWebhookRef = "slack-webhook"
+
+const standupInstructions = `Write a short daily standup update from the activity below, in the first person.
+Use three markdown sections: "Yesterday", "Today" (the natural next steps), and "Blockers" (or "None").
+Keep it under 150 words. Reply with the update only.`
+
+// SetSlackWebhook saves the Slack incoming webhook that standups are posted
+// to; an empty url removes it. The URL is kept in the OS credential store.
+func (a *App) SetSlackWebhook(url string) error {
+	if url == "" {
+		return a.secrets.Delete(slackWebhookRef)
+	}
+	if !strings.HasPrefix(url, "https://") {
+		return fmt.Errorf("webhook URL must use https")
+	}
+	return a.secrets.Set(slackWebhookRef, url)
+}
+
+// GenerateStandup summarizes yesterday's conversations, the changes discussed
+// in them, and the user's git commits in the open workspace into a short
+// standup update using the active model. When post is true the update is
+// also sent to the configured Slack webhook.
+func (a *App) GenerateStandup(post bool) (string, error) {
+	a.telemetry.recordFeature("standup")
+
+	y, m, d := time.Now().AddDate(0, 0, -1).Date()
+	start := time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+	end := start.AddDate(0, 0, 1)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Activity for %s\n\n", a.reportFormatter().LongDate(start))
+
+	b.WriteString("Conversations:\n")
+	var replies, texts []string
+	conversations := 0
+	// Any clean-room conversation in the update restricts its providers,
+	// and any locked one keeps the request out of the logs
+	var cleanRoom string
+	private := false
+	for _, summary := range a.sessions.list() {
+		s, ok := a.sessions.get(summary.ID)
+		if !ok {
+			continue
+		}
+		var prompts []string
+		for _, msg := range s.Messages {
+			if msg.Timestamp.Before(start) || !msg.Timestamp.Before(end) {
+				continue
+			}
+			texts = append(texts, msg.Content)
+			switch msg.Role {
+			case "user":
+				prompts = append(prompts, sessionTitle(msg.Content))
+			case "assistant":
+				replies = append(replies, msg.Content)
+			}
+		}
+		if len(prompts) == 0 {
+			continue
+		}
+		conversations++
+		if cleanRoom == "" {
+			cleanRoom = cleanRoomID(s)
+		}
+		private = private || s.Locked
+		fmt.Fprintf(&b, "- %s: %s\n", s.Title, strings.Join(prompts, "; "))
+	}
+	if conversations == 0 {
+		b.WriteString("- None\n")
+	}
+
+	b.WriteString("\nChanges:\n")
+	files := changedFiles(replies...)
+	if len(files) == 0 {
+		b.WriteString("- None\n")
+	}
+	for _, file := range files {
+		fmt.Fprintf(&b, "- %s\n", file)
+	}
+
+	b.WriteString("\nOpen tasks:\n")
+	tasks := extractTasks(texts...)
+	if len(tasks) == 0 {
+		b.WriteString("- None\n")
+	}
+	for _, task := range tasks {
+		fmt.Fprintf(&b, "- %s\n", task)
+	}
+
+	b.WriteString("\nCommits:\n")
+	commits, err := gitCommits(a.GetWorkspace(), start, end)
+	if err != nil {
+		println("Error reading git log:", err.Error())
+	}
+	if len(commits) == 0 {
+		b.WriteString("- None\n")
+	}
+	for _, c := range commits {
+		fmt.Fprintf(&b, "- %s\n", c)
+	}
+
+	temperature := 0.3
+	result, err := a.generate(generateRequest{
+		Prompt:      standupInstructions + "\n\n" + b.String(),
+		Temperature: &temperature,
+		MaxTokens:   summaryMaxTokens,
+		CleanRoom:   cleanRoom,
+		Private:     private,
+	})
+	if err != nil {
+		return "", fmt.Errorf("standup: %v", err)
+	}
+	standup := strings.TrimSpace(result.Response)
+
+	if post {
+		url, err := a.secrets.Get(slackWebhookRef)
+		if err != nil {
+			return standup, fmt.Errorf("no Slack webhook configured")
+		}
+		if err := postSlackMessage(a.audit.wrap(auditSourceSlack, "", nil), url, standup); err != nil {
+			return standup, err
+		}
+	}
+	return standup, nil
+}
+
+// gitCommits returns "<short hash> <subject>" for commits in dir authored by
+// the configured git user between since and until. It returns nothing when
+// dir is empty or not a git repository.
+func gitCommits(dir string, since, until time.Time) ([]string, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	if err := exec.Command("git", "-C", dir, "rev-parse", "--git-dir").Run(); err != nil {
+		return nil, nil
+	}
+
+	args := []string{"-C", dir, "log", "--no-merges",
+		"--since=" + since.Format(time.RFC3339),
+		"--until=" + until.Format(time.RFC3339),
+		"--pretty=format:%h %s",
+	}
+	if email, err := exec.Command("git", "-C", dir, "config", "user.email").Output(); err == nil {
+		if e := strings.TrimSpace(string(email)); e != "" {
+			args = append(args, "--author="+e)
+		}
+	}
+
+	out, err := exec.Command("git", args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("git log: %v", err)
+	}
+	var commits []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			commits = append(commits, line)
+		}
+	}
+	return commits, nil
+}
+
+// postSlackMessage sends text to a Slack incoming webhook
+func postSlackMessage(transport http.RoundTripper, url, text string) error {
+	payload, err := json.Marshal(map[string]interface{}{"text": text})
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Transport: transport, Timeout: 10 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("network error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("slack: HTTP %d: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/rivo/uniseg"
+)
+
+// StreamingProvider is implemented by providers that can deliver a completion incrementally
+type StreamingProvider interface {
+	// StreamRequest calls onChunk with each piece of the response as it
+	// arrives and returns the full response
+	StreamRequest(prompt string, temperature float64, maxTokens int, onChunk func(string)) (string, error)
+}
+
+// StreamRequest streams a completion from the Ollama /api/generate endpoint
+func (p *OllamaProvider) StreamRequest(prompt string, temperature float64, maxTokens int, onChunk func(string)) (string, error) {
+	url := fmt.Sprintf("%s/api/generate", p.config.Endpoint)
+
+	jsonData, err := json.Marshal(p.generatePayload(prompt, temperature, maxTokens, true))
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := p.post(url, jsonData)
+	if err != nil {
+		return "", fmt.Errorf("network error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(body))
+	}
+
+	var b strings.Builder
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var chunk struct {
+			Response string `json:"response"`
+			Done     bool   `json:"done"`
+			Error    string `json:"error"`
+		}
+		if err := dec.Decode(&chunk); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return b.String(), fmt.Errorf("invalid response: %v", err)
+		}
+		if chunk.Error != "" {
+			return b.String(), fmt.Errorf("ollama: %s", chunk.Error)
+		}
+		if chunk.Response != "" {
+			b.WriteString(chunk.Response)
+			onChunk(chunk.Response)
+		}
+		if chunk.Done {
+			break
+		}
+	}
+	return b.String(), nil
+}
+
+// StreamRequest streams a chat completion as server-sent events
+func (p *OpenAIProvider) StreamRequest(prompt string, temperature float64, maxTokens int, onChunk func(string)) (string, error) {
+	jsonData, err := json.Marshal(p.chatPayload(prompt, temperature, maxTokens, true))
+	if err != nil {
+		return "", err
+	}
+
+	req, err := p.newRequest(http.MethodPost, "/chat/completions", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return "", err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("network error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(body))
+	}
+
+	var b strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		if data == "[DONE]" {
+			break
+		}
+
+		var chunk struct {
+			Choices []struct {
+				Delta struct {
+					Content string `json:"content"`
+				} `json:"delta"`
+			} `json:"choices"`
+		}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return b.String(), fmt.Errorf("invalid response: %v", err)
+		}
+		if len(chunk.Choices) > 0 && chunk.Choices[0].Delta.Content != "" {
+			b.WriteString(chunk.Choices[0].Delta.Content)
+			onChunk(chunk.Choices[0].Delta.Content)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return b.String(), fmt.Errorf("network error: %v", err)
+	}
+	return b.String(), nil
+}
+
+// mockStreamChunkSize deliberately ignores rune boundaries so the mock
+// exercises the same splitting that byte-oriented backends produce
+const mockStreamChunkSize = 7
+
+// StreamRequest delivers the mock response in small byte slices
+func (p *MockProvider) StreamRequest(prompt string, temperature float64, maxTokens int, onChunk func(string)) (string, error) {
+	response, err := p.SendRequest(prompt, temperature, maxTokens)
+	if err != nil {
+		return "", err
+	}
+	for i := 0; i < len(response); i += mockStreamChunkSize {
+		onChunk(response[i:min(i+mockStreamChunkSize, len(response))])
+	}
+	return response, nil
+}
+
+// chunkAssembler coalesces streamed pieces of text into events that can each
+// be rendered on their own. An event never ends inside a UTF-8 sequence or a
+// grapheme cluster (combining marks, emoji ZWJ sequences, flags), and never
+// ends with a bidirectional formatting character, which stays with the
+// right-to-left or left-to-right text it applies to.
+type chunkAssembler struct {
+	pending []byte
+	emit    func(string)
+}
+
+func newChunkAssembler(emit func(string)) *chunkAssembler {
+	return &chunkAssembler{emit: emit}
+}
+
+// write buffers a piece of the stream and emits whatever is safe to show
+func (c *chunkAssembler) write(piece string) {
+	c.pending = append(c.pending, piece...)
+	n := safeChunkLength(c.pending)
+	if n == 0 {
+		return
+	}
+	c.emit(string(c.pending[:n]))
+	c.pending = append(c.pending[:0], c.pending[n:]...)
+}
+
+// close emits the remainder of the stream; bytes that never formed a
+// complete character are replaced with U+FFFD
+func (c *chunkAssembler) close() {
+	if len(c.pending) > 0 {
+		c.emit(strings.ToValidUTF8(string(c.pending), "�"))
+		c.pending = nil
+	}
+}
+
+// safeChunkLength returns how many leading bytes of b can be emitted without
+// splitting a character that later bytes may complete or extend
+func safeChunkLength(b []byte) int {
+	end := len(b)
+	for i := 1; i <= utf8.UTFMax && i <= end; i++ {
+		if utf8.RuneStart(b[end-i]) {
+			if !utf8.FullRune(b[end-i : end]) {
+				end -= i
+			}
+			break
+		}
+	}
+
+	// The final grapheme cluster may still grow, so it waits for the next piece
+	last, pos := 0, 0
+	state := -1
+	for rest := b[:end]; len(rest) > 0; {
+		var cluster []byte
+		cluster, rest, _, state = uniseg.Step(rest, state)
+		last = pos
+		pos += len(cluster)
+	}
+	end = last
+
+	for end > 0 {
+		r, size := utf8.DecodeLastRune(b[:end])
+		if !unicode.Is(unicode.Bidi_Control, r) {
+			break
+		}
+		end -= size
+	}
+	return end
+}
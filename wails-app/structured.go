@@ -0,0 +1,383 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// maxSchemaRetries is how often a reply that does not match its schema
+	// is asked for again
+	maxSchemaRetries = 2
+	// maxSchemaErrors caps the validation errors reported back to the model
+	maxSchemaErrors = 10
+)
+
+var schemaTypes = map[string]bool{
+	"object": true, "array": true, "string": true, "number": true,
+	"integer": true, "boolean": true, "null": true,
+}
+
+// jsonFence is a reply wrapped in a Markdown code block, which models
+// without a JSON mode often add
+var jsonFence = regexp.MustCompile("(?s)^```(?:json)?\\s*\\n(.*?)\\n?```$")
+
+// schemaProvider is a provider whose backend can be told to reply with JSON
+// matching a schema
+type schemaProvider interface {
+	withSchema(schema json.RawMessage) Provider
+}
+
+func (p *OllamaProvider) withSchema(schema json.RawMessage) Provider {
+	structured := *p
+	structured.schema = schema
+	return &structured
+}
+
+func (p *OpenAIProvider) withSchema(schema json.RawMessage) Provider {
+	structured := *p
+	structured.schema = schema
+	return &structured
+}
+
+// parseSchema parses a JSON Schema, checking the keywords the validator supports
+func parseSchema(text string) (map[string]interface{}, error) {
+	var schema map[string]interface{}
+	if err := json.Unmarshal([]byte(text), &schema); err != nil {
+		return nil, fmt.Errorf("schema is not a JSON object: %v", err)
+	}
+	if err := checkSchema(schema, "schema"); err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+func checkSchema(schema map[string]interface{}, path string) error {
+	if _, ok := schema["$ref"]; ok {
+		return fmt.Errorf("%s: $ref is not supported; inline the referenced schema", path)
+	}
+	switch t := schema["type"].(type) {
+	case nil:
+	case string:
+		if !schemaTypes[t] {
+			return fmt.Errorf("%s: unknown type %q", path, t)
+		}
+	case []interface{}:
+		for _, v := range t {
+			if s, _ := v.(string); !schemaTypes[s] {
+				return fmt.Errorf("%s: unknown type %v", path, v)
+			}
+		}
+	default:
+		return fmt.Errorf("%s: type must be a string or a list of strings", path)
+	}
+	if p, ok := schema["pattern"]; ok {
+		s, _ := p.(string)
+		if _, err := regexp.Compile(s); err != nil {
+			return fmt.Errorf("%s: invalid pattern %v", path, p)
+		}
+	}
+	if props, ok := schema["properties"].(map[string]interface{}); ok {
+		for name, sub := range props {
+			if err := checkSubschema(sub, path+".properties."+name); err != nil {
+				return err
+			}
+		}
+	}
+	for _, key := range []string{"items", "additionalProperties"} {
+		if sub, ok := schema[key]; ok {
+			if _, isBool := sub.(bool); !isBool {
+				if err := checkSubschema(sub, path+"."+key); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for _, key := range []string{"anyOf", "oneOf", "allOf"} {
+		if list, ok := schema[key]; ok {
+			subs, ok := list.([]interface{})
+			if !ok || len(subs) == 0 {
+				return fmt.Errorf("%s.%s must be a non-empty list of schemas", path, key)
+			}
+			for i, sub := range subs {
+				if err := checkSubschema(sub, fmt.Sprintf("%s.%s[%d]", path, key, i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func checkSubschema(sub interface{}, path string) error {
+	m, ok := sub.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%s must be a schema object", path)
+	}
+	return checkSchema(m, path)
+}
+
+// validateJSON returns what in value does not match schema, at most
+// maxSchemaErrors, with paths in the $.field[0] form
+func validateJSON(value interface{}, schema map[string]interface{}) []string {
+	var errs []string
+	validateValue(value, schema, "$", &errs)
+	if len(errs) > maxSchemaErrors {
+		errs = append(errs[:maxSchemaErrors], fmt.Sprintf("and %d more", len(errs)-maxSchemaErrors))
+	}
+	return errs
+}
+
+func validateValue(v interface{}, schema map[string]interface{}, path string, errs *[]string) {
+	fail := func(format string, args ...interface{}) {
+		*errs = append(*errs, path+": "+fmt.Sprintf(format, args...))
+	}
+
+	if t, ok := schema["type"]; ok {
+		var allowed []string
+		switch t := t.(type) {
+		case string:
+			allowed = []string{t}
+		case []interface{}:
+			for _, s := range t {
+				allowed = append(allowed, s.(string))
+			}
+		}
+		matched := false
+		for _, name := range allowed {
+			matched = matched || hasJSONType(v, name)
+		}
+		if !matched {
+			fail("expected %s, got %s", strings.Join(allowed, " or "), jsonTypeOf(v))
+			return
+		}
+	}
+	if c, ok := schema["const"]; ok && !jsonEqual(v, c) {
+		fail("must be %s", jsonText(c))
+	}
+	if e, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, option := range e {
+			found = found || jsonEqual(v, option)
+		}
+		if !found {
+			options := make([]string, len(e))
+			for i, option := range e {
+				options[i] = jsonText(option)
+			}
+			fail("must be one of %s", strings.Join(options, ", "))
+		}
+	}
+
+	switch v := v.(type) {
+	case string:
+		n := len([]rune(v))
+		if min, ok := schemaNumber(schema, "minLength"); ok && float64(n) < min {
+			fail("must be at least %v characters long", min)
+		}
+		if max, ok := schemaNumber(schema, "maxLength"); ok && float64(n) > max {
+			fail("must be at most %v characters long", max)
+		}
+		if p, ok := schema["pattern"].(string); ok {
+			if re, err := regexp.Compile(p); err == nil && !re.MatchString(v) {
+				fail("must match %s", p)
+			}
+		}
+	case float64:
+		if min, ok := schemaNumber(schema, "minimum"); ok && v < min {
+			fail("must be at least %v", min)
+		}
+		if max, ok := schemaNumber(schema, "maximum"); ok && v > max {
+			fail("must be at most %v", max)
+		}
+		if min, ok := schemaNumber(schema, "exclusiveMinimum"); ok && v <= min {
+			fail("must be more than %v", min)
+		}
+		if max, ok := schemaNumber(schema, "exclusiveMaximum"); ok && v >= max {
+			fail("must be less than %v", max)
+		}
+	case []interface{}:
+		if min, ok := schemaNumber(schema, "minItems"); ok && float64(len(v)) < min {
+			fail("must have at least %v items", min)
+		}
+		if max, ok := schemaNumber(schema, "maxItems"); ok && float64(len(v)) > max {
+			fail("must have at most %v items", max)
+		}
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range v {
+				validateValue(item, items, fmt.Sprintf("%s[%d]", path, i), errs)
+			}
+		}
+	case map[string]interface{}:
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, r := range required {
+				if name, _ := r.(string); name != "" {
+					if _, present := v[name]; !present {
+						fail("missing required field %q", name)
+					}
+				}
+			}
+		}
+		props, _ := schema["properties"].(map[string]interface{})
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if sub, ok := props[name].(map[string]interface{}); ok {
+				validateValue(v[name], sub, path+"."+name, errs)
+				continue
+			}
+			switch extra := schema["additionalProperties"].(type) {
+			case bool:
+				if !extra {
+					fail("unexpected field %q", name)
+				}
+			case map[string]interface{}:
+				validateValue(v[name], extra, path+"."+name, errs)
+			}
+		}
+	}
+
+	if subs, ok := schema["allOf"].([]interface{}); ok {
+		for _, sub := range subs {
+			validateValue(v, sub.(map[string]interface{}), path, errs)
+		}
+	}
+	if subs, ok := schema["anyOf"].([]interface{}); ok && countMatches(v, subs, path) == 0 {
+		fail("matches none of the allowed schemas")
+	}
+	if subs, ok := schema["oneOf"].([]interface{}); ok {
+		if n := countMatches(v, subs, path); n != 1 {
+			fail("must match exactly one of the allowed schemas, matches %d", n)
+		}
+	}
+}
+
+func countMatches(v interface{}, subs []interface{}, path string) int {
+	n := 0
+	for _, sub := range subs {
+		var errs []string
+		validateValue(v, sub.(map[string]interface{}), path, &errs)
+		if len(errs) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func hasJSONType(v interface{}, name string) bool {
+	switch name {
+	case "integer":
+		f, ok := v.(float64)
+		return ok && f == math.Trunc(f)
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	}
+	return jsonTypeOf(v) == name
+}
+
+func jsonTypeOf(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+func schemaNumber(schema map[string]interface{}, key string) (float64, bool) {
+	f, ok := schema[key].(float64)
+	return f, ok
+}
+
+func jsonText(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func jsonEqual(a, b interface{}) bool {
+	return jsonText(a) == jsonText(b)
+}
+
+// schemaInstruction asks for a reply that is only JSON matching schema, for
+// providers without a JSON mode and as a reminder for those with one
+func schemaInstruction(schema json.RawMessage) string {
+	return "Reply with a single JSON value that matches this JSON Schema, and nothing else:\n" + string(schema)
+}
+
+// checkStructuredReply parses a reply as JSON and validates it, returning
+// the JSON text without any code fence around it
+func checkStructuredReply(reply string, schema map[string]interface{}) (string, []string) {
+	text := strings.TrimSpace(reply)
+	if m := jsonFence.FindStringSubmatch(text); m != nil {
+		text = strings.TrimSpace(m[1])
+	}
+	var value interface{}
+	if err := json.Unmarshal([]byte(text), &value); err != nil {
+		return text, []string{"the reply is not valid JSON: " + err.Error()}
+	}
+	return text, validateJSON(value, schema)
+}
+
+// generateStructured runs req with the backend's JSON mode, asking again
+// with the validation errors while the reply does not match the schema
+func (a *App) generateStructured(req generateRequest, schema map[string]interface{}) (generateResult, error) {
+	req.Schema, _ = json.Marshal(schema)
+	messages := req.Messages
+	if len(messages) == 0 {
+		messages = []Message{{Role: "user", Content: req.Prompt}}
+	}
+	last := len(messages) - 1
+	messages = append(messages[:last:last], Message{Role: "system", Content: schemaInstruction(req.Schema)}, messages[last])
+	req.OnChunk = nil
+
+	for attempt := 0; ; attempt++ {
+		req.Messages = messages
+		result, err := a.generate(req)
+		if err != nil {
+			return result, err
+		}
+		text, errs := checkStructuredReply(result.Response, schema)
+		if len(errs) == 0 {
+			result.Response = text
+			return result, nil
+		}
+		if attempt == maxSchemaRetries {
+			return generateResult{RequestID: result.RequestID}, fmt.Errorf("reply does not match the schema after %d attempts: %s", attempt+1, strings.Join(errs, "; "))
+		}
+		messages = append(messages[:len(messages):len(messages)],
+			Message{Role: "assistant", Content: result.Response},
+			Message{Role: "user", Content: "Your reply does not match the schema:\n- " + strings.Join(errs, "\n- ") + "\nReply again with only the corrected JSON."},
+		)
+	}
+}
+
+// SendPromptStructured is SendPrompt for a reply in JSON matching
+// jsonSchema. The backend's JSON mode is used where it has one (Ollama's
+// format, OpenAI's response_format); either way the reply is validated and
+// asked for again with the validation errors, up to twice. The reply is
+// returned as JSON text.
+func (a *App) SendPromptStructured(prompt string, jsonSchema string) (string, error) {
+	a.telemetry.recordFeature("send_prompt_structured")
+	schema, err := parseSchema(jsonSchema)
+	if err != nil {
+		return "", err
+	}
+	result, err := a.sendPrompt(prompt, sendOptions{schema: schema}, nil)
+	return result.Response, err
+}
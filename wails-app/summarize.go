@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// summaryKeepRecent is how many of the latest messages stay verbatim
+	summaryKeepRecent = 6
+	// autoSummarizeThreshold is how many unsummarized messages trigger a rolling summary
+	autoSummarizeThreshold = 20
+	summaryMaxTokens       = 512
+)
+
+const summaryInstructions = `Summarize the conversation below so it can replace the original messages as context for future turns.
+Preserve the user's goals, decisions made, important code identifiers, file names, and any open questions.
+Be concise and write in the third person. Reply with the summary only.`
+
+// sessionContext returns the messages sent to the model for a session: its
+// compacted history or rolling summary (if any) in place of the messages
+// they cover, then the rest
+func sessionContext(s Session) []Message {
+	if compacted, ok := compactedContext(s); ok {
+		return compacted
+	}
+	if s.Summary == nil || s.Summary.Through <= 0 || s.Summary.Through > len(s.Messages) {
+		return s.Messages
+	}
+	out := []Message{{
+		Role:    "system",
+		Content: "Summary of the earlier conversation:\n" + s.Summary.Content,
+	}}
+	return append(out, s.Messages[s.Summary.Through:]...)
+}
+
+// summarize asks the active model to fold everything but the most recent
+// messages of a session into its rolling summary
+func (a *App) summarize(sessionID string) (Session, error) {
+	s, ok := a.sessions.get(sessionID)
+	if !ok {
+		return Session{}, fmt.Errorf("session %q not found", sessionID)
+	}
+	if s.Archived {
+		return Session{}, errArchived(sessionID)
+	}
+
+	through := len(s.Messages) - summaryKeepRecent
+	from := 0
+	if s.Summary != nil {
+		from = s.Summary.Through
+	}
+	if through <= from {
+		return s, nil
+	}
+
+	var b strings.Builder
+	b.WriteString(summaryInstructions)
+	b.WriteString("\n\n")
+	if s.Summary != nil {
+		fmt.Fprintf(&b, "Existing summary:\n%s\n\nNew messages:\n", s.Summary.Content)
+	}
+	for _, m := range s.Messages[from:through] {
+		fmt.Fprintf(&b, "%s: %s\n\n", m.Role, m.Content)
+	}
+
+	temperature := 0.2
+	result, err := a.generate(generateRequest{
+		Prompt:      b.String(),
+		Temperature: &temperature,
+		MaxTokens:   summaryMaxTokens,
+		CleanRoom:   cleanRoomID(s),
+		Private:     s.Locked,
+	})
+	if err != nil {
+		return Session{}, fmt.Errorf("summarize: %v", err)
+	}
+
+	return a.sessions.update(sessionID, func(s *Session) error {
+		s.Summary = &ConversationSummary{
+			Content:   strings.TrimSpace(result.Response),
+			Through:   through,
+			CreatedAt: time.Now(),
+		}
+		return nil
+	})
+}
+
+// SummarizeSession replaces all but the most recent messages of a session
+// with a model-written summary in the context sent to providers. The
+// original messages are kept for display and search.
+func (a *App) SummarizeSession(sessionID string) (Session, error) {
+	a.telemetry.recordFeature("summarize_session")
+	return a.summarize(sessionID)
+}
+
+// SetAutoSummarize enables or disables rolling summarization for a session
+func (a *App) SetAutoSummarize(sessionID string, enabled bool) error {
+	_, err := a.sessions.update(sessionID, func(s *Session) error {
+		s.AutoSummarize = enabled
+		return nil
+	})
+	return err
+}
+
+// maybeAutoSummarize summarizes a session in the background once enough
+// unsummarized messages have accumulated
+func (a *App) maybeAutoSummarize(sessionID string) {
+	s, ok := a.sessions.get(sessionID)
+	if !ok || !s.AutoSummarize {
+		return
+	}
+	from := 0
+	if s.Summary != nil {
+		from = s.Summary.Through
+	}
+	if len(s.Messages)-from < autoSummarizeThreshold {
+		return
+	}
+
+	if _, running := a.summarizing.LoadOrStore(sessionID, true); running {
+		return
+	}
+	go func() {
+		defer a.summarizing.Delete(sessionID)
+		if _, err := a.summarize(sessionID); err != nil {
+			println("Error summarizing session:", err.Error())
+		}
+	}()
+}
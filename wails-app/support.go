@@ -0,0 +1,270 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// supportBundleRequests is how many recent requests a support bundle includes
+	supportBundleRequests = 200
+	// supportBundleErrors is how many recent failed requests are listed with their errors
+	supportBundleErrors = 50
+)
+
+// SystemInfo describes the build and machine a support bundle came from
+type SystemInfo struct {
+	Version     string    `json:"version"`
+	Revision    string    `json:"revision,omitempty"`
+	GoVersion   string    `json:"goVersion"`
+	OS          string    `json:"os"`
+	Arch        string    `json:"arch"`
+	CPUs        int       `json:"cpus"`
+	Locale      string    `json:"locale"`
+	GeneratedAt time.Time `json:"generatedAt"`
+}
+
+// ProviderSnapshot is the state of one provider when a support bundle was made
+type ProviderSnapshot struct {
+	ID       string        `json:"id"`
+	Name     string        `json:"name"`
+	Type     string        `json:"type"`
+	Model    string        `json:"model"`
+	Health   *ProviderTest `json:"health,omitempty"`
+	Requests int           `json:"requests"`
+	Errors   int           `json:"errors"`
+}
+
+// ErrorTrace is a failed request as recorded in a support bundle
+type ErrorTrace struct {
+	RequestID string    `json:"requestId"`
+	Timestamp time.Time `json:"timestamp"`
+	Provider  string    `json:"provider"`
+	Model     string    `json:"model,omitempty"`
+	Category  string    `json:"category"`
+	Error     string    `json:"error"`
+	Attempts  int       `json:"attempts,omitempty"`
+}
+
+// credentialPattern matches tokens that look like API keys or bearer credentials
+var credentialPattern = regexp.MustCompile(`(?i)(bearer\s+|\b(sk|pk|rk|xox[abp])-)[A-Za-z0-9._\-]{8,}`)
+
+// supportRedactor removes secrets and personal paths from text going into a support bundle
+type supportRedactor struct {
+	secrets []string
+	home    string
+}
+
+func (r supportRedactor) redact(s string) string {
+	for _, secret := range r.secrets {
+		s = strings.ReplaceAll(s, secret, "[redacted]")
+	}
+	s = credentialPattern.ReplaceAllString(s, "[redacted]")
+	if r.home != "" {
+		s = strings.ReplaceAll(s, r.home, "~")
+	}
+	return s
+}
+
+// redactEndpoint drops credentials and query strings from an endpoint URL
+func redactEndpoint(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return endpoint
+	}
+	u.User = nil
+	if u.RawQuery != "" {
+		u.RawQuery = "redacted"
+	}
+	return u.String()
+}
+
+func systemInfo() SystemInfo {
+	info := SystemInfo{
+		Version:     "devel",
+		GoVersion:   runtime.Version(),
+		OS:          runtime.GOOS,
+		Arch:        runtime.GOARCH,
+		CPUs:        runtime.NumCPU(),
+		Locale:      systemLocale(),
+		GeneratedAt: time.Now(),
+	}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		if v := build.Main.Version; v != "" && v != "(devel)" {
+			info.Version = v
+		}
+		for _, s := range build.Settings {
+			if s.Key == "vcs.revision" {
+				info.Revision = s.Value
+			}
+		}
+	}
+	return info
+}
+
+// providerSnapshots checks every provider with a health check in parallel
+// and counts its recent requests. Providers without one are not sent a
+// completion, so making a bundle never spends tokens.
+func (a *App) providerSnapshots(records []RequestRecord) []ProviderSnapshot {
+	a.providersMutex.RLock()
+	providers := append([]Provider(nil), a.providers...)
+	a.providersMutex.RUnlock()
+
+	snapshots := make([]ProviderSnapshot, len(providers))
+	var wg sync.WaitGroup
+	for i, p := range providers {
+		config := p.GetConfig()
+		snapshots[i] = ProviderSnapshot{ID: config.ID, Name: p.GetName(), Type: config.Type, Model: config.Model}
+		for _, rec := range records {
+			if rec.ProviderID == config.ID {
+				snapshots[i].Requests++
+				if rec.Error != "" {
+					snapshots[i].Errors++
+				}
+			}
+		}
+
+		hc, ok := p.(HealthChecker)
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		go func(s *ProviderSnapshot, config ProviderConfig) {
+			defer wg.Done()
+			start := time.Now()
+			detail, err := hc.HealthCheck()
+			s.Health = &ProviderTest{OK: err == nil, LatencyMs: time.Since(start).Milliseconds(), Detail: detail}
+			if err != nil {
+				s.Health.Error = describeProviderError(err, config)
+			}
+		}(&snapshots[i], config)
+	}
+	wg.Wait()
+	return snapshots
+}
+
+// GenerateSupportBundle writes a zip to path for attaching to bug reports.
+// It holds version and platform details, the settings without API keys,
+// a health check of each provider, the recent request log with prompts and
+// responses left out, and the most recent errors. Known keys, bearer tokens
+// and the home directory are redacted from everything in it.
+func (a *App) GenerateSupportBundle(path string) error {
+	a.telemetry.recordFeature("support_bundle")
+
+	a.providersMutex.RLock()
+	cfg, keys := a.portableConfigLocked()
+	a.providersMutex.RUnlock()
+
+	r := supportRedactor{}
+	for name, key := range keys {
+		var headers map[string]string
+		if strings.HasPrefix(name, exportedHeadersKey) && json.Unmarshal([]byte(key), &headers) == nil {
+			for _, value := range headers {
+				if value != "" {
+					r.secrets = append(r.secrets, value)
+				}
+			}
+			continue
+		}
+		r.secrets = append(r.secrets, key)
+	}
+	if webhook, err := a.secrets.Get(slackWebhookRef); err == nil && webhook != "" {
+		r.secrets = append(r.secrets, webhook)
+	}
+	r.home, _ = os.UserHomeDir()
+
+	for i := range cfg.Providers {
+		cfg.Providers[i].Endpoint = redactEndpoint(cfg.Providers[i].Endpoint)
+		cfg.Providers[i].ProxyURL = redactEndpoint(cfg.Providers[i].ProxyURL)
+		cfg.Providers[i].Command = r.redact(cfg.Providers[i].Command)
+		args := make([]string, len(cfg.Providers[i].Args))
+		for j, arg := range cfg.Providers[i].Args {
+			args[j] = r.redact(arg)
+		}
+		cfg.Providers[i].Args = args
+	}
+
+	records := a.requests.recent(supportBundleRequests)
+	var log strings.Builder
+	traces := []ErrorTrace{}
+	for _, rec := range records {
+		if rec.Error != "" && len(traces) < supportBundleErrors {
+			traces = append(traces, ErrorTrace{
+				RequestID: rec.ID,
+				Timestamp: rec.Timestamp,
+				Provider:  rec.Provider,
+				Model:     rec.Model,
+				Category:  errorCategory(fmt.Errorf("%s", rec.Error)),
+				Error:     r.redact(rec.Error),
+				Attempts:  rec.Attempts,
+			})
+		}
+		rec.Prompt = fmt.Sprintf("[%d characters]", len(rec.Prompt))
+		if rec.Response != "" {
+			rec.Response = fmt.Sprintf("[%d characters]", len(rec.Response))
+		}
+		rec.Error = r.redact(rec.Error)
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		log.Write(data)
+		log.WriteByte('\n')
+	}
+
+	files := []struct {
+		name string
+		v    interface{}
+	}{
+		{"system.json", systemInfo()},
+		{"config.json", cfg},
+		{"providers.json", a.providerSnapshots(records)},
+		{"queues.json", a.GetRequestQueue()},
+		{"errors.json", traces},
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("create support bundle: %v", err)
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	for _, file := range files {
+		data, err := json.MarshalIndent(file.v, "", "  ")
+		if err != nil {
+			return err
+		}
+		w, err := zw.Create(file.name)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write([]byte(r.redact(string(data)))); err != nil {
+			return err
+		}
+	}
+	w, err := zw.Create("requests.jsonl")
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte(log.String())); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("write support bundle: %v", err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	openTaskPattern   = regexp.MustCompile(`(?m)^\s*[-*] \[ \]\s+(.+)$`)
+	doneTaskPattern   = regexp.MustCompile(`(?m)^\s*[-*] \[[xX]\]\s+(.+)$`)
+	todoMarkerPattern = regexp.MustCompile(`\b(?:TODO|FIXME)\b:?\s+(.+)$`)
+	diffFilePattern   = regexp.MustCompile(`(?m)^\+\+\+ (?:b/)?(\S+)`)
+)
+
+// extractTasks returns the action items still open across texts, in order of
+// appearance: unchecked markdown checkboxes and TODO/FIXME markers. An item
+// checked off in a later text is dropped.
+func extractTasks(texts ...string) []string {
+	var tasks []string
+	seen := make(map[string]bool)
+	add := func(task string) {
+		task = strings.TrimSpace(task)
+		if task == "" {
+			return
+		}
+		if seen[task] {
+			return
+		}
+		seen[task] = true
+		tasks = append(tasks, task)
+	}
+
+	done := make(map[string]bool)
+	for _, text := range texts {
+		for _, line := range strings.Split(text, "\n") {
+			if m := openTaskPattern.FindStringSubmatch(line); m != nil {
+				add(m[1])
+				delete(done, strings.TrimSpace(m[1]))
+			} else if m := doneTaskPattern.FindStringSubmatch(line); m != nil {
+				done[strings.TrimSpace(m[1])] = true
+			} else if m := todoMarkerPattern.FindStringSubmatch(line); m != nil {
+				add(m[1])
+			}
+		}
+	}
+
+	open := tasks[:0]
+	for _, task := range tasks {
+		if !done[task] {
+			open = append(open, task)
+		}
+	}
+	return open
+}
+
+// changedFiles returns the files touched by unified diffs in texts
+func changedFiles(texts ...string) []string {
+	var files []string
+	seen := make(map[string]bool)
+	for _, text := range texts {
+		for _, m := range diffFilePattern.FindAllStringSubmatch(text, -1) {
+			if m[1] == "/dev/null" || seen[m[1]] {
+				continue
+			}
+			seen[m[1]] = true
+			files = append(files, m[1])
+		}
+	}
+	return files
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// telemetryEndpoint is where opt-in usage reports are posted. It is empty in
+// development builds, which disables sending entirely; release builds set it
+// with -ldflags "-X main.telemetryEndpoint=https://...".
+var telemetryEndpoint string
+
+const (
+	telemetrySchemaVersion = 1
+	telemetryInterval      = 24 * time.Hour
+	// telemetryEpsilon is the differential-privacy budget for each reported count
+	telemetryEpsilon = 1.0
+	// telemetrySaveDelay batches the counts recorded in a burst into one write
+	telemetrySaveDelay = 5 * time.Second
+)
+
+// TelemetryReport is exactly what is sent when telemetry is enabled. It only
+// ever contains coarse counters: never prompts, responses, paths, or keys.
+type TelemetryReport struct {
+	SchemaVersion   int            `json:"schemaVersion"`
+	InstallID       string         `json:"installId"`
+	PeriodStart     time.Time      `json:"periodStart"`
+	PeriodEnd       time.Time      `json:"periodEnd"`
+	FeatureUsage    map[string]int `json:"featureUsage"`
+	ProviderTypes   map[string]int `json:"providerTypes"`
+	ErrorCategories map[string]int `json:"errorCategories"`
+	// Noise describes the perturbation applied to every count
+	Noise string `json:"noise"`
+}
+
+// TelemetryStatus describes the current telemetry settings
+type TelemetryStatus struct {
+	Enabled   bool      `json:"enabled"`
+	Available bool      `json:"available"`
+	InstallID string    `json:"installId"`
+	LastSent  time.Time `json:"lastSent"`
+}
+
+type telemetryState struct {
+	Enabled         bool           `json:"enabled"`
+	InstallID       string         `json:"installId"`
+	PeriodStart     time.Time      `json:"periodStart"`
+	LastSent        time.Time      `json:"lastSent"`
+	FeatureUsage    map[string]int `json:"featureUsage"`
+	ProviderTypes   map[string]int `json:"providerTypes"`
+	ErrorCategories map[string]int `json:"errorCategories"`
+}
+
+// telemetry counts feature usage locally while opted in and periodically
+// sends a noised report. Nothing is recorded while it is disabled.
+type telemetry struct {
+	mu      sync.Mutex
+	path    string
+	state   telemetryState
+	pending *TelemetryReport
+	client  *http.Client
+	// saveTimer is set while recorded counts wait to be written
+	saveTimer *time.Timer
+	// sending is set while a report is being posted
+	sending bool
+}
+
+func newTelemetry() *telemetry {
+	t := &telemetry{client: &http.Client{Timeout: 10 * time.Second}}
+	t.resetLocked()
+	return t
+}
+
+func (t *telemetry) resetLocked() {
+	enabled := t.state.Enabled
+	t.state = telemetryState{
+		Enabled:         enabled,
+		InstallID:       newID(),
+		PeriodStart:     time.Now().UTC(),
+		FeatureUsage:    make(map[string]int),
+		ProviderTypes:   make(map[string]int),
+		ErrorCategories: make(map[string]int),
+	}
+	t.pending = nil
+}
+
+func (t *telemetry) open(path string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.path = path
+	var state telemetryState
+	if err := readJSONFile(path, &state); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if state.FeatureUsage == nil {
+		state.FeatureUsage = make(map[string]int)
+	}
+	if state.ProviderTypes == nil {
+		state.ProviderTypes = make(map[string]int)
+	}
+	if state.ErrorCategories == nil {
+		state.ErrorCategories = make(map[string]int)
+	}
+	t.state = state
+	return nil
+}
+
+func (t *telemetry) saveLocked() {
+	if t.saveTimer != nil {
+		t.saveTimer.Stop()
+		t.saveTimer = nil
+	}
+	if t.path == "" {
+		return
+	}
+	if err := writeJSONFile(t.path, t.state); err != nil {
+		println("Error saving telemetry state:", err.Error())
+	}
+}
+
+// saveSoonLocked writes the state after telemetrySaveDelay, so a burst of
+// counts costs one write
+func (t *telemetry) saveSoonLocked() {
+	if t.path == "" || t.saveTimer != nil {
+		return
+	}
+	t.saveTimer = time.AfterFunc(telemetrySaveDelay, t.flush)
+}
+
+// flush writes counts still waiting to be saved
+func (t *telemetry) flush() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.saveTimer != nil {
+		t.saveLocked()
+	}
+}
+
+func (t *telemetry) record(counter map[string]int, key string) {
+	if !t.state.Enabled {
+		return
+	}
+	counter[key]++
+	t.pending = nil
+	t.saveSoonLocked()
+}
+
+func (t *telemetry) recordFeature(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.record(t.state.FeatureUsage, name)
+}
+
+func (t *telemetry) recordProviderType(typ string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.record(t.state.ProviderTypes, typ)
+}
+
+// recordError counts a failed request; cancelling one is not an error
+func (t *telemetry) recordError(err error) {
+	if errors.Is(err, errCancelled) {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.record(t.state.ErrorCategories, errorCategory(err))
+}
+
+// errorCategory reduces an error to a coarse label that cannot carry user data
+func errorCategory(err error) string {
+	var netErr net.Error
+	msg := err.Error()
+	switch {
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case strings.HasPrefix(msg, "network error"):
+		return "network"
+	case strings.HasPrefix(msg, "HTTP 4"):
+		return "http_4xx"
+	case strings.HasPrefix(msg, "HTTP 5"):
+		return "http_5xx"
+	case strings.HasPrefix(msg, "invalid response"), strings.HasPrefix(msg, "missing"):
+		return "invalid_response"
+	default:
+		return "other"
+	}
+}
+
+// laplaceNoise samples from a Laplace distribution with scale 1/epsilon
+func laplaceNoise(epsilon float64) float64 {
+	u := rand.Float64() - 0.5
+	sign := 1.0
+	if u < 0 {
+		sign = -1
+	}
+	return -sign / epsilon * math.Log(1-2*math.Abs(u))
+}
+
+func noisyCounts(counts map[string]int) map[string]int {
+	out := make(map[string]int, len(counts))
+	for k, v := range counts {
+		n := int(math.Round(float64(v) + laplaceNoise(telemetryEpsilon)))
+		if n < 0 {
+			n = 0
+		}
+		out[k] = n
+	}
+	return out
+}
+
+// reportLocked builds the next report once and reuses it until the counts
+// change, so the preview is byte-for-byte what would be sent
+func (t *telemetry) reportLocked() TelemetryReport {
+	if t.pending == nil {
+		t.pending = &TelemetryReport{
+			SchemaVersion:   telemetrySchemaVersion,
+			InstallID:       t.state.InstallID,
+			PeriodStart:     t.state.PeriodStart,
+			PeriodEnd:       time.Now().UTC(),
+			FeatureUsage:    noisyCounts(t.state.FeatureUsage),
+			ProviderTypes:   noisyCounts(t.state.ProviderTypes),
+			ErrorCategories: noisyCounts(t.state.ErrorCategories),
+			Noise:           fmt.Sprintf("laplace(epsilon=%g)", telemetryEpsilon),
+		}
+	}
+	return *t.pending
+}
+
+func (t *telemetry) post(path string, v interface{}) error {
+	if telemetryEndpoint == "" {
+		return fmt.Errorf("telemetry is not available in this build")
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	resp, err := t.client.Post(strings.TrimRight(telemetryEndpoint, "/")+path, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("network error: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+// subtractCounts removes the counts in sent from counts, keeping those
+// recorded since
+func subtractCounts(counts, sent map[string]int) {
+	for k, n := range sent {
+		if counts[k] -= n; counts[k] <= 0 {
+			delete(counts, k)
+		}
+	}
+}
+
+func copyCounts(counts map[string]int) map[string]int {
+	out := make(map[string]int, len(counts))
+	for k, n := range counts {
+		out[k] = n
+	}
+	return out
+}
+
+// send posts the pending report and starts a new period. The report is
+// posted without holding the lock, so counting goes on meanwhile; counts
+// recorded during the post are kept for the next report.
+func (t *telemetry) send() error {
+	t.mu.Lock()
+	if !t.state.Enabled {
+		t.mu.Unlock()
+		return fmt.Errorf("telemetry is disabled")
+	}
+	if t.sending {
+		t.mu.Unlock()
+		return fmt.Errorf("a telemetry report is already being sent")
+	}
+	t.sending = true
+	report := t.reportLocked()
+	installID := t.state.InstallID
+	features, providers, errs := copyCounts(t.state.FeatureUsage), copyCounts(t.state.ProviderTypes), copyCounts(t.state.ErrorCategories)
+	t.mu.Unlock()
+
+	err := t.post("/v1/reports", report)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sending = false
+	// Opting out or deleting during the post already discarded the counts
+	if err != nil || t.state.InstallID != installID {
+		return err
+	}
+	t.state.LastSent = time.Now().UTC()
+	t.state.PeriodStart = report.PeriodEnd
+	subtractCounts(t.state.FeatureUsage, features)
+	subtractCounts(t.state.ProviderTypes, providers)
+	subtractCounts(t.state.ErrorCategories, errs)
+	t.pending = nil
+	t.saveLocked()
+	return nil
+}
+
+// maybeSend sends a report when one is due
+func (t *telemetry) maybeSend() {
+	t.mu.Lock()
+	due := t.state.Enabled && telemetryEndpoint != "" && time.Since(t.state.LastSent) >= telemetryInterval
+	t.mu.Unlock()
+
+	if due {
+		if err := t.send(); err != nil {
+			println("Error sending telemetry:", err.Error())
+		}
+	}
+}
+
+// SetTelemetryEnabled opts in to or out of anonymous usage telemetry.
+// Opting out discards any counts collected so far.
+func (a *App) SetTelemetryEnabled(enabled bool) {
+	t := a.telemetry
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !enabled {
+		t.resetLocked()
+	}
+	t.state.Enabled = enabled
+	t.saveLocked()
+}
+
+// GetTelemetryStatus returns whether telemetry is enabled and when it last reported
+func (a *App) GetTelemetryStatus() TelemetryStatus {
+	t := a.telemetry
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return TelemetryStatus{
+		Enabled:   t.state.Enabled,
+		Available: telemetryEndpoint != "",
+		InstallID: t.state.InstallID,
+		LastSent:  t.state.LastSent,
+	}
+}
+
+// PreviewTelemetry returns exactly the report that would be sent next
+func (a *App) PreviewTelemetry() TelemetryReport {
+	t := a.telemetry
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.reportLocked()
+}
+
+// SendTelemetryNow sends the pending report immediately
+func (a *App) SendTelemetryNow() error {
+	return a.telemetry.send()
+}
+
+// RequestTelemetryDeletion asks the telemetry service to delete everything
+// reported under the current install ID, then discards local counts and
+// rotates the ID so future reports cannot be linked to past ones
+func (a *App) RequestTelemetryDeletion() error {
+	t := a.telemetry
+	t.mu.Lock()
+	installID, sent := t.state.InstallID, !t.state.LastSent.IsZero()
+	t.mu.Unlock()
+
+	if sent {
+		if err := t.post("/v1/deletions", map[string]string{"installId": installID}); err != nil {
+			return err
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// The ID may have been rotated meanwhile, by opting out
+	if t.state.InstallID == installID {
+		t.resetLocked()
+	}
+	t.saveLocked()
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// insecureTLSWarning is shown for providers that skip certificate verification
+const insecureTLSWarning = "TLS certificate verification is off; anyone on the network path can read and alter this provider's traffic"
+
+// ProviderTLS configures TLS to a self-hosted endpoint, e.g. Ollama or vLLM
+// behind a reverse proxy with an internal CA
+type ProviderTLS struct {
+	// CAFile is a PEM bundle trusted in addition to the system roots
+	CAFile string `json:"caFile,omitempty"`
+	// CertFile and KeyFile are a PEM client certificate and key for mutual TLS
+	CertFile string `json:"certFile,omitempty"`
+	KeyFile  string `json:"keyFile,omitempty"`
+	// ServerName overrides the name the server certificate is checked against
+	ServerName string `json:"serverName,omitempty"`
+	// InsecureSkipVerify accepts any server certificate. It is meant for
+	// trying out a test server and is reported as a provider warning.
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+}
+
+func (t ProviderTLS) empty() bool {
+	return t == ProviderTLS{}
+}
+
+// tlsClientConfig builds the TLS settings of a provider, or nil for the defaults
+func tlsClientConfig(t ProviderTLS) (*tls.Config, error) {
+	if t.empty() {
+		return nil, nil
+	}
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read CA file: %v", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificates found in CA file %s", t.CAFile)
+		}
+		config.RootCAs = pool
+	}
+	switch {
+	case t.CertFile != "" && t.KeyFile != "":
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	case t.CertFile != "" || t.KeyFile != "":
+		return nil, fmt.Errorf("a client certificate needs both certFile and keyFile")
+	}
+	return config, nil
+}
+
+// providerWarnings lists settings that weaken a provider's security
+func providerWarnings(config ProviderConfig) []string {
+	var warnings []string
+	if config.TLS.InsecureSkipVerify {
+		warnings = append(warnings, insecureTLSWarning)
+	}
+	return warnings
+}
+
+// failingTransport fails every request, for clients whose settings could not be applied
+type failingTransport struct {
+	err error
+}
+
+func (t failingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	return nil, t.err
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/tiktoken-go/tokenizer"
+)
+
+// TokenCount is the result of CountTokens
+type TokenCount struct {
+	Tokens int `json:"tokens"`
+	// Encoding is the tiktoken encoding used, or "heuristic" for estimates
+	Encoding string `json:"encoding"`
+	Exact    bool   `json:"exact"`
+}
+
+var (
+	codecsMutex sync.Mutex
+	codecs      = make(map[tokenizer.Encoding]tokenizer.Codec)
+)
+
+// codec returns a cached tiktoken codec; building one loads its whole vocabulary
+func codec(enc tokenizer.Encoding) (tokenizer.Codec, error) {
+	codecsMutex.Lock()
+	defer codecsMutex.Unlock()
+
+	if c, ok := codecs[enc]; ok {
+		return c, nil
+	}
+	c, err := tokenizer.Get(enc)
+	if err != nil {
+		return nil, err
+	}
+	codecs[enc] = c
+	return c, nil
+}
+
+// openAIEncoding returns the tiktoken encoding for OpenAI model names
+func openAIEncoding(model string) (tokenizer.Encoding, bool) {
+	model = strings.ToLower(model)
+	if c, err := tokenizer.ForModel(tokenizer.Model(model)); err == nil {
+		return tokenizer.Encoding(c.GetName()), true
+	}
+
+	switch {
+	case strings.HasPrefix(model, "gpt-4o"), strings.HasPrefix(model, "gpt-4.1"),
+		strings.HasPrefix(model, "gpt-5"), strings.HasPrefix(model, "chatgpt-"),
+		len(model) > 1 && model[0] == 'o' && model[1] >= '1' && model[1] <= '9':
+		return tokenizer.O200kBase, true
+	case strings.HasPrefix(model, "gpt-"), strings.HasPrefix(model, "text-embedding-"):
+		return tokenizer.Cl100kBase, true
+	}
+	return "", false
+}
+
+// countTokens counts tokens exactly for OpenAI models and estimates them for others
+func countTokens(text, model string) TokenCount {
+	if enc, ok := openAIEncoding(model); ok {
+		if c, err := codec(enc); err == nil {
+			if ids, _, err := c.Encode(text); err == nil {
+				return TokenCount{Tokens: len(ids), Encoding: string(enc), Exact: true}
+			}
+		}
+	}
+	return TokenCount{Tokens: estimateTokens(text), Encoding: "heuristic"}
+}
+
+// estimateTokens approximates a token count: roughly four characters per
+// token for alphabetic scripts and one token per ideographic character
+func estimateTokens(text string) int {
+	var other, wide int
+	for _, r := range text {
+		if unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) ||
+			unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r) {
+			wide++
+			continue
+		}
+		other++
+	}
+	return wide + (other+3)/4
+}
+
+// CountTokens returns the number of tokens text occupies for model, using
+// the model's tiktoken encoding for OpenAI models and a heuristic otherwise
+func (a *App) CountTokens(text string, model string) TokenCount {
+	a.telemetry.recordFeature("count_tokens")
+	return countTokens(text, model)
+}
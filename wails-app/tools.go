@@ -0,0 +1,452 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// maxToolRounds is how many rounds of tool calls a prompt may make
+	maxToolRounds = 8
+	// toolOutputChars caps the output of one tool call sent to the model
+	toolOutputChars = 16000
+)
+
+var (
+	toolNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+	toolBlock       = regexp.MustCompile("(?s)```tool\\s*\\n(.*?)```")
+)
+
+// ToolDefinition describes a tool the model can call
+type ToolDefinition struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Parameters is a JSON Schema for the arguments object
+	Parameters json.RawMessage `json:"parameters"`
+}
+
+// ToolCall is a model's request to run a tool
+type ToolCall struct {
+	ID        string          `json:"id"`
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// ToolExecutor runs a tool call in a session and returns the output for
+// the model; an error is passed to the model as the output instead
+type ToolExecutor func(ctx context.Context, sessionID string, args json.RawMessage) (string, error)
+
+// ToolProvider is a provider whose backend supports tool calling natively.
+// It returns the reply text and the tool calls the model made, if any.
+// Other providers are told about tools in the prompt and answer with tool
+// blocks, which are handled the same way.
+type ToolProvider interface {
+	SendWithTools(messages []Message, tools []ToolDefinition, temperature float64, maxTokens int) (string, []ToolCall, error)
+}
+
+type registeredTool struct {
+	def    ToolDefinition
+	schema map[string]interface{}
+	run    ToolExecutor
+	// enabled, when set, reports whether an opt-in tool is turned on
+	enabled func() bool
+}
+
+// toolRegistry holds the tools prompts can use
+type toolRegistry struct {
+	mu    sync.RWMutex
+	tools map[string]registeredTool
+}
+
+func newToolRegistry() *toolRegistry {
+	return &toolRegistry{tools: make(map[string]registeredTool)}
+}
+
+// register adds a tool; its parameters default to an object without fields
+func (r *toolRegistry) register(def ToolDefinition, run ToolExecutor) error {
+	return r.registerOptIn(def, run, nil)
+}
+
+// registerOptIn adds a tool that is only offered while enabled returns true
+func (r *toolRegistry) registerOptIn(def ToolDefinition, run ToolExecutor, enabled func() bool) error {
+	if !toolNamePattern.MatchString(def.Name) {
+		return fmt.Errorf("invalid tool name %q", def.Name)
+	}
+	if len(def.Parameters) == 0 {
+		def.Parameters = json.RawMessage(`{"type":"object","properties":{}}`)
+	}
+	schema, err := parseSchema(string(def.Parameters))
+	if err != nil {
+		return fmt.Errorf("tool %s: %v", def.Name, err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.tools[def.Name]; exists {
+		return fmt.Errorf("tool %q is already registered", def.Name)
+	}
+	r.tools[def.Name] = registeredTool{def: def, schema: schema, run: run, enabled: enabled}
+	return nil
+}
+
+// registerBuiltinTools adds the tools that come with the app
+func (a *App) registerBuiltinTools() {
+	builtin := []struct {
+		def     ToolDefinition
+		run     ToolExecutor
+		enabled func() bool
+	}{
+		{readFileDefinition, a.readFile, nil},
+		{writeFileDefinition, a.writeFile, nil},
+		{editFileDefinition, a.editFile, nil},
+		{runCommandDefinition, a.runCommand, a.GetCommandTool},
+		{gitStatusDefinition, a.gitStatusTool, nil},
+		{gitLogDefinition, a.gitLogTool, nil},
+		{gitDiffDefinition, a.gitDiffTool, nil},
+	}
+	for _, t := range builtin {
+		if err := a.tools.registerOptIn(t.def, t.run, t.enabled); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// lookup returns the named tools, or every tool that is on when names is empty
+func (r *toolRegistry) lookup(names []string) ([]registeredTool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	all := len(names) == 0
+	if all {
+		for name := range r.tools {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	out := make([]registeredTool, 0, len(names))
+	for _, name := range names {
+		t, ok := r.tools[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown tool %q", name)
+		}
+		if t.enabled != nil && !t.enabled() {
+			if all {
+				continue
+			}
+			return nil, fmt.Errorf("tool %q is turned off", name)
+		}
+		out = append(out, t)
+	}
+	return out, nil
+}
+
+// toolInstructions tells a model without native tool calling how to call tools
+func toolInstructions(tools []ToolDefinition) string {
+	var b strings.Builder
+	b.WriteString("You can call tools. To call one, reply with a block like this and nothing after it:\n")
+	b.WriteString("```tool\n{\"id\": \"call_1\", \"name\": \"tool_name\", \"arguments\": {}}\n```\n")
+	b.WriteString("One JSON object per call; the results come back as tool messages. When you have what you need, answer without a tool block. The tools are:\n")
+	for _, t := range tools {
+		fmt.Fprintf(&b, "- %s: %s Arguments: %s\n", t.Name, t.Description, t.Parameters)
+	}
+	return b.String()
+}
+
+// formatToolCalls renders native tool calls as tool blocks, so replies from
+// every provider carry their calls the same way
+func formatToolCalls(calls []ToolCall) string {
+	if len(calls) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("\n```tool\n")
+	for _, c := range calls {
+		line, _ := json.Marshal(c)
+		b.Write(line)
+		b.WriteString("\n")
+	}
+	b.WriteString("```")
+	return b.String()
+}
+
+// parseToolCalls returns the tool calls in a reply's tool blocks, giving
+// calls without an ID one
+func parseToolCalls(reply string) ([]ToolCall, error) {
+	var calls []ToolCall
+	for _, block := range toolBlock.FindAllStringSubmatch(reply, -1) {
+		dec := json.NewDecoder(strings.NewReader(block[1]))
+		for {
+			var c ToolCall
+			if err := dec.Decode(&c); errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				return calls, fmt.Errorf("invalid tool call: %v", err)
+			}
+			if c.ID == "" {
+				c.ID = "call_" + newID()
+			}
+			calls = append(calls, c)
+		}
+	}
+	return calls, nil
+}
+
+// stripToolBlocks returns a reply without its tool blocks
+func stripToolBlocks(reply string) string {
+	return strings.TrimSpace(toolBlock.ReplaceAllString(reply, ""))
+}
+
+// toolArguments returns a call's arguments as JSON text for a backend
+func toolArguments(c ToolCall) string {
+	if len(c.Arguments) == 0 {
+		return "{}"
+	}
+	return string(c.Arguments)
+}
+
+// runTool executes one call, returning the output or error for the model
+func (a *App) runTool(ctx context.Context, sessionID string, tools []registeredTool, call ToolCall) string {
+	var tool *registeredTool
+	for i := range tools {
+		if tools[i].def.Name == call.Name {
+			tool = &tools[i]
+		}
+	}
+	if tool == nil {
+		return fmt.Sprintf("error: unknown tool %q", call.Name)
+	}
+	out, err := a.callTool(ctx, sessionID, *tool, call.Arguments)
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	if len(out) > toolOutputChars {
+		out = strings.ToValidUTF8(out[:toolOutputChars], "") + "\n[output truncated]"
+	}
+	return out
+}
+
+// callTool checks args against a tool's parameters and runs it
+func (a *App) callTool(ctx context.Context, sessionID string, tool registeredTool, args json.RawMessage) (string, error) {
+	if len(args) == 0 {
+		args = json.RawMessage("{}")
+	}
+	var value interface{}
+	if err := json.Unmarshal(args, &value); err != nil {
+		return "", fmt.Errorf("arguments are not valid JSON: %v", err)
+	}
+	if errs := validateJSON(value, tool.schema); len(errs) > 0 {
+		return "", fmt.Errorf("invalid arguments: %s", strings.Join(errs, "; "))
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return tool.run(ctx, sessionID, args)
+}
+
+// generateWithTools runs req with tools, executing the calls the model
+// makes and sending the results back until it answers without calling one
+func (a *App) generateWithTools(req generateRequest, tools []registeredTool, sessionID string) (generateResult, error) {
+	for _, t := range tools {
+		req.Tools = append(req.Tools, t.def)
+	}
+	req.OnChunk = nil
+	messages := req.Messages
+	if len(messages) == 0 {
+		messages = []Message{{Role: "user", Content: req.Prompt}}
+	}
+	for round := 0; ; round++ {
+		req.Messages = messages
+		result, err := a.generate(req)
+		if err != nil {
+			return result, err
+		}
+		calls, perr := parseToolCalls(result.Response)
+		if (len(calls) == 0 && perr == nil) || round == maxToolRounds {
+			result.Response = stripToolBlocks(result.Response)
+			return result, nil
+		}
+
+		messages = append(messages[:len(messages):len(messages)], Message{Role: "assistant", Content: result.Response, ToolCalls: calls})
+		if perr != nil {
+			messages = append(messages, Message{Role: "user", Content: perr.Error() + ". Write each call as one JSON object in a tool block."})
+		}
+		for _, call := range calls {
+			a.emit(EventPromptTool, PromptToolEvent{Tool: call.Name, Commands: []string{toolArguments(call)}, Round: round + 1})
+			out := a.runTool(req.Context, sessionID, tools, call)
+			messages = append(messages, Message{Role: "tool", Content: out, ToolCallID: call.ID})
+		}
+		if round == maxToolRounds-1 {
+			messages = append(messages, Message{Role: "user", Content: "That was the last round of tool calls. Answer now with what you have."})
+		}
+	}
+}
+
+// ListTools returns the tools prompts sent with SendPromptWithTools can use
+func (a *App) ListTools() []ToolDefinition {
+	tools, _ := a.tools.lookup(nil)
+	out := make([]ToolDefinition, len(tools))
+	for i, t := range tools {
+		out[i] = t.def
+	}
+	return out
+}
+
+// SendPromptWithTools is SendPrompt with tools the model can call while
+// answering, by name from ListTools, or all of them when tools is empty.
+// The app runs each call and sends the result back, for up to eight rounds,
+// and returns the final answer. Each call is sent as a "prompt:tool" event.
+func (a *App) SendPromptWithTools(prompt string, tools []string) (string, error) {
+	a.telemetry.recordFeature("send_prompt_with_tools")
+	selected, err := a.tools.lookup(tools)
+	if err != nil {
+		return "", err
+	}
+	if len(selected) == 0 {
+		return "", fmt.Errorf("no tools are available")
+	}
+	result, err := a.sendPrompt(prompt, sendOptions{tools: selected}, nil)
+	return result.Response, err
+}
+
+// chatTools is the tools field of the Ollama and OpenAI chat APIs
+func chatTools(tools []ToolDefinition) []map[string]interface{} {
+	out := make([]map[string]interface{}, len(tools))
+	for i, t := range tools {
+		out[i] = map[string]interface{}{
+			"type":     "function",
+			"function": map[string]interface{}{"name": t.Name, "description": t.Description, "parameters": t.Parameters},
+		}
+	}
+	return out
+}
+
+// SendWithTools sends a conversation to /api/chat with tools. Ollama's tool
+// calls have no IDs, so they are given some.
+func (p *OllamaProvider) SendWithTools(messages []Message, tools []ToolDefinition, temperature float64, maxTokens int) (string, []ToolCall, error) {
+	chat := make([]map[string]interface{}, 0, len(messages))
+	for _, m := range messages {
+		msg := map[string]interface{}{"role": m.Role, "content": m.Content}
+		if len(m.ToolCalls) > 0 {
+			msg["content"] = stripToolBlocks(m.Content)
+			calls := make([]map[string]interface{}, len(m.ToolCalls))
+			for i, c := range m.ToolCalls {
+				calls[i] = map[string]interface{}{"function": map[string]interface{}{"name": c.Name, "arguments": json.RawMessage(toolArguments(c))}}
+			}
+			msg["tool_calls"] = calls
+		}
+		chat = append(chat, msg)
+	}
+	payload := p.generatePayload("", temperature, maxTokens, false)
+	delete(payload, "prompt")
+	payload["messages"] = chat
+	payload["tools"] = chatTools(tools)
+
+	resp, err := p.call(http.MethodPost, "/api/chat", payload)
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Message struct {
+			Content   string `json:"content"`
+			ToolCalls []struct {
+				Function struct {
+					Name      string          `json:"name"`
+					Arguments json.RawMessage `json:"arguments"`
+				} `json:"function"`
+			} `json:"tool_calls"`
+		} `json:"message"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", nil, fmt.Errorf("invalid response: %v", err)
+	}
+	var calls []ToolCall
+	for _, c := range result.Message.ToolCalls {
+		calls = append(calls, ToolCall{ID: "call_" + newID(), Name: c.Function.Name, Arguments: c.Function.Arguments})
+	}
+	return result.Message.Content, calls, nil
+}
+
+// SendWithTools sends a conversation to /chat/completions with tools
+func (p *OpenAIProvider) SendWithTools(messages []Message, tools []ToolDefinition, temperature float64, maxTokens int) (string, []ToolCall, error) {
+	chat := make([]map[string]interface{}, 0, len(messages))
+	for _, m := range messages {
+		msg := map[string]interface{}{"role": m.Role, "content": m.Content}
+		if m.ToolCallID != "" {
+			msg["tool_call_id"] = m.ToolCallID
+		}
+		if len(m.ToolCalls) > 0 {
+			msg["content"] = stripToolBlocks(m.Content)
+			calls := make([]map[string]interface{}, len(m.ToolCalls))
+			for i, c := range m.ToolCalls {
+				calls[i] = map[string]interface{}{
+					"id":       c.ID,
+					"type":     "function",
+					"function": map[string]interface{}{"name": c.Name, "arguments": toolArguments(c)},
+				}
+			}
+			msg["tool_calls"] = calls
+		}
+		chat = append(chat, msg)
+	}
+	payload := p.chatPayload("", temperature, maxTokens, false)
+	payload["messages"] = chat
+	payload["tools"] = chatTools(tools)
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return "", nil, err
+	}
+
+	req, err := p.newRequest(http.MethodPost, "/chat/completions", bytes.NewReader(jsonData))
+	if err != nil {
+		return "", nil, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", nil, fmt.Errorf("network error: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return "", nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(body))
+	}
+
+	var result struct {
+		Choices []struct {
+			Message struct {
+				Content   string `json:"content"`
+				ToolCalls []struct {
+					ID       string `json:"id"`
+					Function struct {
+						Name      string `json:"name"`
+						Arguments string `json:"arguments"`
+					} `json:"function"`
+				} `json:"tool_calls"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", nil, fmt.Errorf("invalid response: %v", err)
+	}
+	if len(result.Choices) == 0 {
+		return "", nil, fmt.Errorf("missing 'choices' field")
+	}
+	msg := result.Choices[0].Message
+	var calls []ToolCall
+	for _, c := range msg.ToolCalls {
+		// Arguments are JSON in a string; anything else is passed on as a string
+		args := json.RawMessage(c.Function.Arguments)
+		if !json.Valid(args) {
+			args, _ = json.Marshal(c.Function.Arguments)
+		}
+		calls = append(calls, ToolCall{ID: c.ID, Name: c.Function.Name, Arguments: args})
+	}
+	return msg.Content, calls, nil
+}
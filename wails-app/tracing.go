@@ -0,0 +1,387 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// tracerName is the instrumentation scope of the app's spans
+const tracerName = "github.com/aavishay/vibe-coder"
+
+// tracingHeadersRef is the secret store entry holding the OTLP headers,
+// which usually carry an API key for the collector
+const tracingHeadersRef = "otlp-headers"
+
+var noopTracer = noop.NewTracerProvider().Tracer(tracerName)
+
+// TracingConfig configures OpenTelemetry trace export
+type TracingConfig struct {
+	// Endpoint is the OTLP/HTTP collector, e.g. http://localhost:4318; empty turns tracing off
+	Endpoint string `json:"endpoint,omitempty"`
+	// ServiceName defaults to "vibe-coder"
+	ServiceName string `json:"serviceName,omitempty"`
+	// SampleRatio is the fraction of prompts traced; 0 traces all of them
+	SampleRatio float64 `json:"sampleRatio,omitempty"`
+	// Headers are sent with every export. They are kept in the secret store
+	// and never saved in the config.
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// tracing holds the tracer provider while export is on
+type tracing struct {
+	mu       sync.RWMutex
+	provider *sdktrace.TracerProvider
+}
+
+func newTracing() *tracing {
+	return &tracing{}
+}
+
+func (t *tracing) tracer() trace.Tracer {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.provider == nil {
+		return noopTracer
+	}
+	return t.provider.Tracer(tracerName)
+}
+
+// start begins a span under the span in parent, if any
+func (t *tracing) start(parent context.Context, name string, kind trace.SpanKind, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if parent == nil {
+		parent = context.Background()
+	}
+	return t.tracer().Start(parent, name, trace.WithSpanKind(kind), trace.WithAttributes(attrs...))
+}
+
+// tracesURL returns the OTLP traces URL for an endpoint given with or without the /v1/traces path
+func tracesURL(endpoint string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(endpoint))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid OTLP endpoint %q; use http://host:4318", endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/traces"
+	}
+	return u.String(), nil
+}
+
+// configure replaces the tracer provider. The previous one is flushed and
+// shut down in the background so spans already ended are still exported.
+func (t *tracing) configure(config TracingConfig) error {
+	var provider *sdktrace.TracerProvider
+	if config.Endpoint != "" {
+		endpoint, err := tracesURL(config.Endpoint)
+		if err != nil {
+			return err
+		}
+		opts := []otlptracehttp.Option{
+			otlptracehttp.WithEndpointURL(endpoint),
+			otlptracehttp.WithProxy(localOnlyProxy(http.ProxyFromEnvironment)),
+		}
+		if len(config.Headers) > 0 {
+			opts = append(opts, otlptracehttp.WithHeaders(config.Headers))
+		}
+		exporter, err := otlptracehttp.New(context.Background(), opts...)
+		if err != nil {
+			return fmt.Errorf("create OTLP exporter: %v", err)
+		}
+		name := config.ServiceName
+		if name == "" {
+			name = "vibe-coder"
+		}
+		sampler := sdktrace.AlwaysSample()
+		if config.SampleRatio > 0 && config.SampleRatio < 1 {
+			sampler = sdktrace.TraceIDRatioBased(config.SampleRatio)
+		}
+		provider = sdktrace.NewTracerProvider(
+			sdktrace.WithBatcher(exporter),
+			sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(name))),
+			sdktrace.WithSampler(sdktrace.ParentBased(sampler)),
+		)
+	}
+
+	t.mu.Lock()
+	old := t.provider
+	t.provider = provider
+	t.mu.Unlock()
+	if old != nil {
+		go t.shutdownProvider(old)
+	}
+	return nil
+}
+
+func (t *tracing) shutdownProvider(p *sdktrace.TracerProvider) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := p.Shutdown(ctx); err != nil {
+		println("Error exporting traces:", err.Error())
+	}
+}
+
+// flush exports the spans that have ended
+func (t *tracing) flush(ctx context.Context) error {
+	t.mu.RLock()
+	p := t.provider
+	t.mu.RUnlock()
+	if p == nil {
+		return nil
+	}
+	return p.ForceFlush(ctx)
+}
+
+func (t *tracing) shutdown() {
+	t.mu.Lock()
+	p := t.provider
+	t.provider = nil
+	t.mu.Unlock()
+	if p != nil {
+		t.shutdownProvider(p)
+	}
+}
+
+// endSpan marks span failed when err is set and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// genAISystem names a provider type the way the GenAI semantic conventions do
+func genAISystem(config ProviderConfig) string {
+	switch config.Type {
+	case "OpenAI":
+		return "openai"
+	case "Ollama":
+		return "ollama"
+	}
+	return strings.ToLower(config.Type)
+}
+
+// endRequestSpan records how a dispatched request went on its span
+func endRequestSpan(span trace.Span, rec RequestRecord, tokensIn, tokensOut int) {
+	span.SetAttributes(
+		attribute.Int("gen_ai.usage.input_tokens", tokensIn),
+		attribute.Int("gen_ai.usage.output_tokens", tokensOut),
+		attribute.Int("vibecoder.attempts", rec.Attempts),
+		attribute.Int64("vibecoder.queued_ms", rec.QueuedMs),
+		attribute.Bool("vibecoder.cached", rec.Cached),
+	)
+	if rec.Stalls > 0 {
+		span.SetAttributes(attribute.Int("vibecoder.stalls", rec.Stalls))
+	}
+	if rec.CostUSD > 0 {
+		span.SetAttributes(attribute.Float64("vibecoder.cost_usd", rec.CostUSD))
+	}
+	if rec.Error != "" {
+		span.SetStatus(codes.Error, rec.Error)
+	}
+	span.End()
+}
+
+// tracedProvider is a provider that can send its HTTP requests under a
+// span, so backend calls show up as children of the prompt that made them
+type tracedProvider interface {
+	withTrace(ctx context.Context) Provider
+}
+
+func (p *OllamaProvider) withTrace(ctx context.Context) Provider {
+	traced := *p
+	traced.traceCtx = ctx
+	return &traced
+}
+
+func (p *OpenAIProvider) withTrace(ctx context.Context) Provider {
+	traced := *p
+	traced.traceCtx = ctx
+	return &traced
+}
+
+// requestContext returns the trace context HTTP requests are made with
+func requestContext(ctx context.Context) context.Context {
+	if ctx == nil {
+		return context.Background()
+	}
+	return ctx
+}
+
+// wrap returns a transport that sends each request under a client span and
+// passes the trace on to the backend in a traceparent header
+func (t *tracing) wrap(base http.RoundTripper) http.RoundTripper {
+	return &tracingTransport{base: base, tracing: t}
+}
+
+type tracingTransport struct {
+	base    http.RoundTripper
+	tracing *tracing
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	attrs := []attribute.KeyValue{
+		attribute.String("http.request.method", req.Method),
+		attribute.String("url.full", redactEndpoint(req.URL.String())),
+		attribute.String("server.address", req.URL.Hostname()),
+	}
+	if port := req.URL.Port(); port != "" {
+		attrs = append(attrs, attribute.String("server.port", port))
+	}
+	ctx, span := t.tracing.start(req.Context(), req.Method, trace.SpanKindClient, attrs...)
+	if !span.IsRecording() {
+		span.End()
+		return t.base.RoundTrip(req)
+	}
+
+	req = req.Clone(ctx)
+	propagation.TraceContext{}.Inject(ctx, propagation.HeaderCarrier(req.Header))
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		endSpan(span, err)
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= 400 {
+		span.SetStatus(codes.Error, resp.Status)
+	}
+	resp.Body = &tracedBody{ReadCloser: resp.Body, span: span}
+	return resp, nil
+}
+
+// tracedBody ends the client span once the response has been read, so
+// streamed replies are timed in full
+type tracedBody struct {
+	io.ReadCloser
+	span trace.Span
+	once sync.Once
+}
+
+func (b *tracedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.finish(nil)
+	} else if err != nil {
+		b.finish(err)
+	}
+	return n, err
+}
+
+func (b *tracedBody) Close() error {
+	b.finish(nil)
+	return b.ReadCloser.Close()
+}
+
+func (b *tracedBody) finish(err error) {
+	b.once.Do(func() { endSpan(b.span, err) })
+}
+
+// tracingHeaders loads the saved OTLP headers
+func (a *App) tracingHeaders() map[string]string {
+	raw, err := a.secrets.Get(tracingHeadersRef)
+	if err != nil || raw == "" {
+		return nil
+	}
+	var headers map[string]string
+	if err := json.Unmarshal([]byte(raw), &headers); err != nil {
+		println("Error loading OTLP headers:", err.Error())
+		return nil
+	}
+	return headers
+}
+
+func (a *App) storeTracingHeaders(headers map[string]string) error {
+	if len(headers) == 0 {
+		return a.secrets.Delete(tracingHeadersRef)
+	}
+	data, err := json.Marshal(headers)
+	if err != nil {
+		return err
+	}
+	return a.secrets.Set(tracingHeadersRef, string(data))
+}
+
+// applyTracingLocked starts exporting with the saved tracing settings. The
+// caller must hold providersMutex.
+func (a *App) applyTracingLocked() {
+	config := a.tracingConfig
+	if config.Endpoint != "" {
+		config.Headers = a.tracingHeaders()
+	}
+	if err := a.tracing.configure(config); err != nil {
+		println("Error starting trace export:", err.Error())
+	}
+}
+
+// SetTracing exports OpenTelemetry traces of prompts, provider requests and
+// their HTTP calls to an OTLP/HTTP collector such as Jaeger or the
+// OpenTelemetry Collector. An empty endpoint turns export off. Header values
+// are not returned by GetTracing; a header sent with an empty value keeps
+// its saved value.
+func (a *App) SetTracing(config TracingConfig) error {
+	a.telemetry.recordFeature("tracing")
+	config.Endpoint = strings.TrimSpace(config.Endpoint)
+	if config.Endpoint != "" {
+		if _, err := tracesURL(config.Endpoint); err != nil {
+			return err
+		}
+	}
+	if config.SampleRatio < 0 || config.SampleRatio > 1 {
+		return fmt.Errorf("sample ratio must be between 0 and 1")
+	}
+
+	saved := a.tracingHeaders()
+	headers := make(map[string]string, len(config.Headers))
+	for name, value := range config.Headers {
+		if value == "" {
+			value = saved[name]
+		}
+		if name = strings.TrimSpace(name); name != "" && value != "" {
+			headers[name] = value
+		}
+	}
+	if err := a.storeTracingHeaders(headers); err != nil {
+		return fmt.Errorf("save OTLP headers: %v", err)
+	}
+	config.Headers = headers
+	if err := a.tracing.configure(config); err != nil {
+		return err
+	}
+
+	a.providersMutex.Lock()
+	defer a.providersMutex.Unlock()
+	config.Headers = nil
+	a.tracingConfig = config
+	return a.saveConfigLocked()
+}
+
+// GetTracing returns the trace export settings, with header values left out
+func (a *App) GetTracing() TracingConfig {
+	a.providersMutex.RLock()
+	config := a.tracingConfig
+	a.providersMutex.RUnlock()
+
+	if headers := a.tracingHeaders(); len(headers) > 0 {
+		config.Headers = make(map[string]string, len(headers))
+		for name := range headers {
+			config.Headers[name] = ""
+		}
+	}
+	return config
+}
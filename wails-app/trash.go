@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// trashRetention is how long deleted items stay restorable before maintenance removes them
+const trashRetention = 30 * 24 * time.Hour
+
+// TrashItem is a deleted item that can still be restored
+type TrashItem struct {
+	ID        string    `json:"id"`
+	Kind      string    `json:"kind"`
+	Title     string    `json:"title"`
+	DeletedAt time.Time `json:"deletedAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+func (st *SessionStore) trashPathLocked(id string) string {
+	return filepath.Join(st.dir, "trash", id+".json")
+}
+
+// openTrash loads the deleted sessions kept under dir/trash
+func (st *SessionStore) openTrash() error {
+	paths, err := filepath.Glob(filepath.Join(st.dir, "trash", "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		var s Session
+		if err := readJSONFile(path, &s); err != nil {
+			return fmt.Errorf("load deleted session %s: %v", filepath.Base(path), err)
+		}
+		if _, exists := st.sessions[s.ID]; !exists {
+			st.trash[s.ID] = &s
+		}
+	}
+	return nil
+}
+
+// moveToTrash takes a session out of the store and its search index and
+// keeps it in the trash until it is restored or purged
+func (st *SessionStore) moveToTrash(id string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	s, ok := st.sessions[id]
+	if !ok {
+		return fmt.Errorf("session %q not found", id)
+	}
+	// Unlocked conversations go to the trash locked again
+	if err := st.closeLocked(s); err != nil {
+		return err
+	}
+	s.DeletedAt = time.Now()
+	if st.dir != "" {
+		if err := writeJSONFile(st.trashPathLocked(id), s); err != nil {
+			s.DeletedAt = time.Time{}
+			return err
+		}
+		if err := os.Remove(filepath.Join(st.dir, id+".json")); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	delete(st.sessions, id)
+	st.trash[id] = s
+	for i := range s.Messages {
+		st.index.remove(id, i)
+	}
+	return nil
+}
+
+// restore moves a session from the trash back into the store
+func (st *SessionStore) restore(id string) (Session, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	s, ok := st.trash[id]
+	if !ok {
+		return Session{}, fmt.Errorf("%q is not in the trash", id)
+	}
+	s.DeletedAt = time.Time{}
+	if err := st.saveLocked(s); err != nil {
+		s.DeletedAt = time.Now()
+		return Session{}, err
+	}
+	if st.dir != "" {
+		if err := os.Remove(st.trashPathLocked(id)); err != nil && !os.IsNotExist(err) {
+			return Session{}, err
+		}
+	}
+	delete(st.trash, id)
+	st.sessions[id] = s
+	for i, m := range s.Messages {
+		st.index.add(id, i, m.Content)
+	}
+	return s.clone(), nil
+}
+
+// purgeTrash permanently deletes the trashed sessions match selects and
+// returns them so their attachments can be released
+func (st *SessionStore) purgeTrash(match func(s *Session) bool) ([]Session, int64, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	var purged []Session
+	var bytes int64
+	for id, s := range st.trash {
+		if !match(s) {
+			continue
+		}
+		if st.dir != "" {
+			path := st.trashPathLocked(id)
+			if info, err := os.Stat(path); err == nil {
+				bytes += info.Size()
+			}
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return purged, bytes, err
+			}
+		}
+		delete(st.trash, id)
+		purged = append(purged, s.clone())
+	}
+	return purged, bytes, nil
+}
+
+func (st *SessionStore) listTrash() []TrashItem {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	out := make([]TrashItem, 0, len(st.trash))
+	for _, s := range st.trash {
+		out = append(out, TrashItem{
+			ID:        s.ID,
+			Kind:      "conversation",
+			Title:     s.Title,
+			DeletedAt: s.DeletedAt,
+			ExpiresAt: s.DeletedAt.Add(trashRetention),
+		})
+	}
+	return out
+}
+
+// releaseAttachments drops the attachment references held by purged sessions
+func (a *App) releaseAttachments(purged []Session) {
+	for _, s := range purged {
+		for _, ref := range s.Attachments {
+			if err := a.attachments.release(ref.Hash); err != nil {
+				println("Error releasing attachment:", err.Error())
+			}
+		}
+	}
+}
+
+// DeleteConversation moves a conversation to the trash. It disappears from
+// listings and search, and RestoreFromTrash brings it back until it is
+// removed for good by EmptyTrash or after 30 days.
+func (a *App) DeleteConversation(sessionID string) error {
+	if err := a.sessions.moveToTrash(sessionID); err != nil {
+		return err
+	}
+
+	a.sessionMutex.Lock()
+	if a.activeSession == sessionID {
+		a.activeSession = ""
+	}
+	a.sessionMutex.Unlock()
+	return nil
+}
+
+// ListTrash returns deleted conversations and personas, most recently
+// deleted first
+func (a *App) ListTrash() []TrashItem {
+	out := append(a.sessions.listTrash(), a.trashedPersonas()...)
+	sort.Slice(out, func(i, j int) bool { return out[i].DeletedAt.After(out[j].DeletedAt) })
+	return out
+}
+
+// RestoreFromTrash returns a deleted item to where it was
+func (a *App) RestoreFromTrash(id string) error {
+	if found, err := a.restorePersona(id); found {
+		return err
+	}
+	_, err := a.sessions.restore(id)
+	return err
+}
+
+// EmptyTrash permanently deletes everything in the trash and returns how many items were removed
+func (a *App) EmptyTrash() (int, error) {
+	purged, _, err := a.sessions.purgeTrash(func(*Session) bool { return true })
+	a.releaseAttachments(purged)
+	a.forgetMemories(purged)
+	personas, perr := a.purgePersonas(func(Persona) bool { return true })
+	if err == nil {
+		err = perr
+	}
+	return len(purged) + personas, err
+}
+
+// purgeExpiredTrash is the maintenance task that removes items past the retention window
+func (a *App) purgeExpiredTrash() (int64, string, error) {
+	purged, bytes, err := a.sessions.purgeTrash(func(s *Session) bool {
+		return time.Since(s.DeletedAt) > trashRetention
+	})
+	a.releaseAttachments(purged)
+	a.forgetMemories(purged)
+	personas, perr := a.purgePersonas(func(p Persona) bool {
+		return time.Since(p.DeletedAt) > trashRetention
+	})
+	if err == nil {
+		err = perr
+	}
+	return bytes, fmt.Sprintf("removed %d expired items", len(purged)+personas), err
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// DayCount is the activity of one calendar day (YYYY-MM-DD, local time)
+type DayCount struct {
+	Day string `json:"day"`
+	// Count is the number of prompts sent
+	Count     int   `json:"count"`
+	Replies   int   `json:"replies"`
+	TokensIn  int64 `json:"tokensIn"`
+	TokensOut int64 `json:"tokensOut"`
+}
+
+// ModelUsage summarizes requests served by one provider/model pair
+type ModelUsage struct {
+	ProviderID       string  `json:"providerId"`
+	Provider         string  `json:"provider"`
+	Model            string  `json:"model"`
+	Requests         int64   `json:"requests"`
+	Errors           int64   `json:"errors"`
+	AverageLatencyMs float64 `json:"averageLatencyMs"`
+	// Share is Requests as a fraction of all requests in the period
+	Share     float64 `json:"share"`
+	TokensIn  int64   `json:"tokensIn"`
+	TokensOut int64   `json:"tokensOut"`
+	// AverageResponseChars is the mean length of the provider's replies in the conversations
+	AverageResponseChars float64 `json:"averageResponseChars"`
+}
+
+// replyLengths accumulates reply lengths
+type replyLengths struct {
+	replies       int
+	chars, tokens int64
+}
+
+func (r *replyLengths) add(content string) {
+	r.replies++
+	r.chars += int64(len([]rune(content)))
+	r.tokens += int64(estimateTokens(content))
+}
+
+func (r replyLengths) averages() (chars, tokens float64) {
+	if r.replies == 0 {
+		return 0, 0
+	}
+	return float64(r.chars) / float64(r.replies), float64(r.tokens) / float64(r.replies)
+}
+
+// usageAggregate is the activity of a period, read once from the saved
+// conversations and the request metrics for GetInsights and
+// GetUsageDashboard
+type usageAggregate struct {
+	// since is the start of the first day covered; zero means all history
+	since         time.Time
+	sinceDate     string
+	prompts       int
+	replies       int
+	conversations int
+	lengths       replyLengths
+	// days holds the days with prompts, replies or tokens
+	days map[string]*DayCount
+	// models are sorted by requests, most used first
+	models    []ModelUsage
+	tokensIn  int64
+	tokensOut int64
+	// latencyMs sums the latency of the succeeded requests
+	latencyMs float64
+	succeeded int64
+}
+
+// aggregateUsage sums the last days days of history (all history when
+// days <= 0). Prompts and replies are counted from the saved conversations;
+// tokens and models come from the request metrics, which keep 90 days.
+func (a *App) aggregateUsage(days int) *usageAggregate {
+	u := &usageAggregate{days: make(map[string]*DayCount)}
+	if days > 0 {
+		y, m, d := time.Now().AddDate(0, 0, -days+1).Date()
+		u.since = time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+		u.sinceDate = u.since.Format("2006-01-02")
+	}
+
+	byProvider := make(map[string]*replyLengths)
+	for _, summary := range a.sessions.list() {
+		s, ok := a.sessions.get(summary.ID)
+		if !ok {
+			continue
+		}
+		active := false
+		for _, m := range s.Messages {
+			if m.Timestamp.Before(u.since) {
+				continue
+			}
+			date := m.Timestamp.Local().Format("2006-01-02")
+			switch m.Role {
+			case "user":
+				u.prompts++
+				u.day(date).Count++
+			case "assistant":
+				u.replies++
+				u.day(date).Replies++
+				u.lengths.add(m.Content)
+				lengths, ok := byProvider[m.Provider]
+				if !ok {
+					lengths = &replyLengths{}
+					byProvider[m.Provider] = lengths
+				}
+				lengths.add(m.Content)
+			default:
+				continue
+			}
+			active = true
+		}
+		if active {
+			u.conversations++
+		}
+	}
+
+	var requests int64
+	for _, m := range a.metrics.report(u.sinceDate) {
+		requests += m.Requests
+		u.tokensIn += m.TokensIn
+		u.tokensOut += m.TokensOut
+		model := ModelUsage{
+			ProviderID:       m.ProviderID,
+			Provider:         m.Provider,
+			Model:            m.Model,
+			Requests:         m.Requests,
+			Errors:           m.Errors,
+			AverageLatencyMs: float64(m.AverageMs),
+			TokensIn:         m.TokensIn,
+			TokensOut:        m.TokensOut,
+		}
+		if lengths, ok := byProvider[m.Provider]; ok {
+			model.AverageResponseChars, _ = lengths.averages()
+		}
+		if ok := m.Requests - m.Errors; ok > 0 {
+			u.latencyMs += float64(m.AverageMs * ok)
+			u.succeeded += ok
+		}
+		u.models = append(u.models, model)
+		for _, d := range m.Daily {
+			entry := u.day(d.Date)
+			entry.TokensIn += d.TokensIn
+			entry.TokensOut += d.TokensOut
+		}
+	}
+	for i := range u.models {
+		if requests > 0 {
+			u.models[i].Share = float64(u.models[i].Requests) / float64(requests)
+		}
+	}
+	sort.SliceStable(u.models, func(i, j int) bool { return u.models[i].Requests > u.models[j].Requests })
+	return u
+}
+
+func (u *usageAggregate) day(date string) *DayCount {
+	d, ok := u.days[date]
+	if !ok {
+		d = &DayCount{Day: date}
+		u.days[date] = d
+	}
+	return d
+}
+
+// activeDays lists the days with prompts or replies, oldest first
+func (u *usageAggregate) activeDays() []DayCount {
+	out := []DayCount{}
+	for _, d := range u.days {
+		if d.Count > 0 || d.Replies > 0 {
+			out = append(out, *d)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Day < out[j].Day })
+	return out
+}
+
+// averageLatencyMs is the mean latency of the succeeded requests
+func (u *usageAggregate) averageLatencyMs() float64 {
+	if u.succeeded == 0 {
+		return 0
+	}
+	return u.latencyMs / float64(u.succeeded)
+}
+
+// filledDays lists the days from since (or the earliest day with activity
+// when since is zero) through today, with zero entries for days without
+// activity
+func (u *usageAggregate) filledDays() []DayCount {
+	first, last := u.sinceDate, time.Now().Format("2006-01-02")
+	if first == "" {
+		for date := range u.days {
+			if first == "" || date < first {
+				first = date
+			}
+		}
+	}
+	start, err := time.ParseInLocation("2006-01-02", first, time.Local)
+	if err != nil {
+		return []DayCount{}
+	}
+	out := []DayCount{}
+	for t := start; t.Format("2006-01-02") <= last; t = t.AddDate(0, 0, 1) {
+		date := t.Format("2006-01-02")
+		if d, ok := u.days[date]; ok {
+			out = append(out, *d)
+		} else {
+			out = append(out, DayCount{Day: date})
+		}
+	}
+	return out
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// vectorCompactTxSize is how much compaction copies in one transaction
+const vectorCompactTxSize = 16 << 20
+
+var (
+	// A namespace is a bucket holding its description under vectorInfoKey
+	// and its records in the vectorRecordsBucket bucket, keyed by ID
+	vectorInfoKey       = []byte("info")
+	vectorRecordsBucket = []byte("records")
+)
+
+// vectorRecord is a text and its embedding in the vector store
+type vectorRecord struct {
+	ID     string
+	Vector []float64
+	Text   string
+	// Meta is what the owner of the namespace needs to know about the text
+	Meta map[string]string
+}
+
+// vectorPayload is the part of a record stored as JSON after its vector
+type vectorPayload struct {
+	Text string            `json:"text"`
+	Meta map[string]string `json:"meta,omitempty"`
+}
+
+// vectorNamespace describes a namespace of the vector store. The vectors of
+// a namespace come from one embedding model, so they can be compared.
+type vectorNamespace struct {
+	Provider string `json:"provider"`
+	Model    string `json:"model"`
+	// Dims is the length of every vector, set by the first one stored
+	Dims      int               `json:"dims"`
+	UpdatedAt time.Time         `json:"updatedAt"`
+	Meta      map[string]string `json:"meta,omitempty"`
+}
+
+// vectorMatch is a record found by a search
+type vectorMatch struct {
+	vectorRecord
+	Score float64
+}
+
+// VectorNamespaceStats describes one namespace of the vector store
+type VectorNamespaceStats struct {
+	Name      string    `json:"name"`
+	Provider  string    `json:"provider"`
+	Model     string    `json:"model"`
+	Dims      int       `json:"dims"`
+	Records   int       `json:"records"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// vectorStore keeps embeddings on disk in a bbolt database, in namespaces
+// such as the code index of each workspace
+type vectorStore struct {
+	// mu is held for writing only while compaction swaps the database file
+	mu   sync.RWMutex
+	path string
+	db   *bolt.DB
+}
+
+func newVectorStore() *vectorStore {
+	return &vectorStore{}
+}
+
+func (vs *vectorStore) open(path string) error {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	// Another instance of the app holding the file shouldn't hang startup
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return err
+	}
+	vs.path, vs.db = path, db
+	return nil
+}
+
+func (vs *vectorStore) close() error {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+
+	if vs.db == nil {
+		return nil
+	}
+	err := vs.db.Close()
+	vs.db = nil
+	return err
+}
+
+// opened reports whether the store has a database to work on
+func (vs *vectorStore) opened() bool {
+	vs.mu.RLock()
+	defer vs.mu.RUnlock()
+	return vs.db != nil
+}
+
+// view and update run fn in a read-only or read-write transaction
+func (vs *vectorStore) view(fn func(tx *bolt.Tx) error) error {
+	vs.mu.RLock()
+	defer vs.mu.RUnlock()
+	if vs.db == nil {
+		return fmt.Errorf("the vector store is not open")
+	}
+	return vs.db.View(fn)
+}
+
+func (vs *vectorStore) update(fn func(tx *bolt.Tx) error) error {
+	vs.mu.RLock()
+	defer vs.mu.RUnlock()
+	if vs.db == nil {
+		return fmt.Errorf("the vector store is not open")
+	}
+	return vs.db.Update(fn)
+}
+
+// encodeVectorRecord stores the vector as little-endian float32s after its
+// length, then the text and metadata as JSON
+func encodeVectorRecord(r vectorRecord) ([]byte, error) {
+	payload, err := json.Marshal(vectorPayload{Text: r.Text, Meta: r.Meta})
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 4+4*len(r.Vector), 4+4*len(r.Vector)+len(payload))
+	binary.LittleEndian.PutUint32(buf, uint32(len(r.Vector)))
+	for i, x := range r.Vector {
+		binary.LittleEndian.PutUint32(buf[4+4*i:], math.Float32bits(float32(x)))
+	}
+	return append(buf, payload...), nil
+}
+
+// decodeVectorRecord reads a stored record, leaving out the vector unless
+// withVector is set
+func decodeVectorRecord(id, data []byte, withVector bool) (vectorRecord, error) {
+	r := vectorRecord{ID: string(id)}
+	if len(data) < 4 {
+		return r, fmt.Errorf("vector record %q is corrupt", id)
+	}
+	dims := int(binary.LittleEndian.Uint32(data))
+	if len(data) < 4+4*dims {
+		return r, fmt.Errorf("vector record %q is corrupt", id)
+	}
+	if withVector {
+		r.Vector = make([]float64, dims)
+		for i := range r.Vector {
+			r.Vector[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(data[4+4*i:])))
+		}
+	}
+	var p vectorPayload
+	if err := json.Unmarshal(data[4+4*dims:], &p); err != nil {
+		return r, fmt.Errorf("vector record %q is corrupt: %v", id, err)
+	}
+	r.Text, r.Meta = p.Text, p.Meta
+	return r, nil
+}
+
+// namespaceInfo reads the description of a namespace bucket
+func namespaceInfo(b *bolt.Bucket) (vectorNamespace, error) {
+	var info vectorNamespace
+	if data := b.Get(vectorInfoKey); data != nil {
+		if err := json.Unmarshal(data, &info); err != nil {
+			return info, err
+		}
+	}
+	return info, nil
+}
+
+// namespace returns the description of a namespace and whether it exists
+func (vs *vectorStore) namespace(name string) (vectorNamespace, bool, error) {
+	var info vectorNamespace
+	found := false
+	err := vs.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(name))
+		if b == nil {
+			return nil
+		}
+		found = true
+		var err error
+		info, err = namespaceInfo(b)
+		return err
+	})
+	return info, found, err
+}
+
+// updateNamespace changes the description of a namespace, creating it if
+// it doesn't exist
+func (vs *vectorStore) updateNamespace(name string, fn func(info *vectorNamespace)) error {
+	return vs.update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(name))
+		if err != nil {
+			return err
+		}
+		if _, err := b.CreateBucketIfNotExists(vectorRecordsBucket); err != nil {
+			return err
+		}
+		info, err := namespaceInfo(b)
+		if err != nil {
+			return err
+		}
+		fn(&info)
+		data, err := json.Marshal(info)
+		if err != nil {
+			return err
+		}
+		return b.Put(vectorInfoKey, data)
+	})
+}
+
+// drop deletes a namespace and its records
+func (vs *vectorStore) drop(name string) error {
+	return vs.update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(name)) == nil {
+			return nil
+		}
+		return tx.DeleteBucket([]byte(name))
+	})
+}
+
+// replace deletes the records with the removed IDs from a namespace and
+// stores records in one transaction, so readers see both or neither. Every
+// vector must have the namespace's dimensions.
+func (vs *vectorStore) replace(name string, removed []string, records []vectorRecord) error {
+	return vs.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(name))
+		if b == nil {
+			return fmt.Errorf("vector namespace %q does not exist", name)
+		}
+		rb := b.Bucket(vectorRecordsBucket)
+		for _, id := range removed {
+			if err := rb.Delete([]byte(id)); err != nil {
+				return err
+			}
+		}
+		if len(records) == 0 {
+			return nil
+		}
+		info, err := namespaceInfo(b)
+		if err != nil {
+			return err
+		}
+		dims := info.Dims
+		for _, r := range records {
+			if dims == 0 {
+				dims = len(r.Vector)
+			}
+			if len(r.Vector) == 0 || len(r.Vector) != dims {
+				return fmt.Errorf("vector of %q has %d dimensions, namespace %q holds %d", r.ID, len(r.Vector), name, dims)
+			}
+			data, err := encodeVectorRecord(r)
+			if err != nil {
+				return err
+			}
+			if err := rb.Put([]byte(r.ID), data); err != nil {
+				return err
+			}
+		}
+		if dims == info.Dims {
+			return nil
+		}
+		info.Dims = dims
+		data, err := json.Marshal(info)
+		if err != nil {
+			return err
+		}
+		return b.Put(vectorInfoKey, data)
+	})
+}
+
+// deletePrefix deletes the records whose IDs start with idPrefix from every
+// namespace whose name starts with nsPrefix, returning how many there were
+func (vs *vectorStore) deletePrefix(nsPrefix, idPrefix string) (int, error) {
+	deleted := 0
+	err := vs.update(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			if !strings.HasPrefix(string(name), nsPrefix) {
+				return nil
+			}
+			rb := b.Bucket(vectorRecordsBucket)
+			if rb == nil {
+				return nil
+			}
+			var ids [][]byte
+			c := rb.Cursor()
+			for k, _ := c.Seek([]byte(idPrefix)); k != nil && bytes.HasPrefix(k, []byte(idPrefix)); k, _ = c.Next() {
+				ids = append(ids, append([]byte(nil), k...))
+			}
+			for _, id := range ids {
+				if err := rb.Delete(id); err != nil {
+					return err
+				}
+			}
+			deleted += len(ids)
+			return nil
+		})
+	})
+	return deleted, err
+}
+
+// scan calls fn with every record of a namespace in ID order, with vectors
+// only when withVectors is set
+func (vs *vectorStore) scan(name string, withVectors bool, fn func(r vectorRecord) error) error {
+	return vs.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(name))
+		if b == nil {
+			return nil
+		}
+		rb := b.Bucket(vectorRecordsBucket)
+		if rb == nil {
+			return nil
+		}
+		return rb.ForEach(func(k, v []byte) error {
+			r, err := decodeVectorRecord(k, v, withVectors)
+			if err != nil {
+				return err
+			}
+			return fn(r)
+		})
+	})
+}
+
+// search returns the limit records of a namespace most similar to query by
+// cosine similarity, best first, leaving out those keep rejects
+func (vs *vectorStore) search(name string, query []float64, limit int, keep func(r vectorRecord) bool) ([]vectorMatch, error) {
+	var matches []vectorMatch
+	err := vs.scan(name, true, func(r vectorRecord) error {
+		if keep != nil && !keep(r) {
+			return nil
+		}
+		matches = append(matches, vectorMatch{vectorRecord: r, Score: cosineSimilarity(query, r.Vector)})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Records are scanned in ID order, which settles ties
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].Score > matches[j].Score })
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches, nil
+}
+
+// namespaces describes every namespace whose name starts with prefix
+func (vs *vectorStore) namespaces(prefix string) ([]VectorNamespaceStats, error) {
+	stats := []VectorNamespaceStats{}
+	err := vs.view(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			if !strings.HasPrefix(string(name), prefix) {
+				return nil
+			}
+			info, err := namespaceInfo(b)
+			if err != nil {
+				return err
+			}
+			st := VectorNamespaceStats{Name: string(name), Provider: info.Provider, Model: info.Model, Dims: info.Dims, UpdatedAt: info.UpdatedAt}
+			if rb := b.Bucket(vectorRecordsBucket); rb != nil {
+				st.Records = rb.Stats().KeyN
+			}
+			stats = append(stats, st)
+			return nil
+		})
+	})
+	return stats, err
+}
+
+// compact rewrites the database without the free pages deleted records
+// leave behind, returning the bytes reclaimed. The store is unavailable
+// while it runs.
+func (vs *vectorStore) compact() (int64, error) {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+
+	if vs.db == nil {
+		return 0, nil
+	}
+	before, err := os.Stat(vs.path)
+	if err != nil {
+		return 0, err
+	}
+	tmp := vs.path + ".compact"
+	os.Remove(tmp)
+	dst, err := bolt.Open(tmp, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return 0, err
+	}
+	if err := bolt.Compact(dst, vs.db, vectorCompactTxSize); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return 0, err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	if err := vs.db.Close(); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	renameErr := os.Rename(tmp, vs.path)
+	if renameErr != nil {
+		os.Remove(tmp)
+	}
+	// Reopen whichever file is now in place
+	db, err := bolt.Open(vs.path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		vs.db = nil
+		return 0, err
+	}
+	vs.db = db
+	if renameErr != nil {
+		return 0, renameErr
+	}
+	after, err := os.Stat(vs.path)
+	if err != nil {
+		return 0, err
+	}
+	return before.Size() - after.Size(), nil
+}
+
+// cosineSimilarity returns the cosine of the angle between two vectors
+func cosineSimilarity(a, b []float64) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += a[i] * b[i]
+		na += a[i] * a[i]
+		nb += b[i] * b[i]
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// watchPollInterval is how often watched files are checked for saves
+	watchPollInterval = 500 * time.Millisecond
+	// maxWatchedFiles bounds how many files a watch tracks
+	maxWatchedFiles = 5000
+	// maxWatchedFileBytes skips files too large to review
+	maxWatchedFileBytes = 256 << 10
+	// maxReviewDiffChars caps the diff sent for one review
+	maxReviewDiffChars = 16000
+	// reviewMaxTokens keeps reviews short and fast
+	reviewMaxTokens = 400
+	// diffContext is the number of unchanged lines around each change in a diff
+	diffContext = 3
+)
+
+const reviewInstructions = `Review this diff of code just saved. Only point out likely bugs, missed error handling, and clear improvements in the changed lines; ignore style nits.
+Reply with one suggestion per line as <path>:<line>: <suggestion>, using line numbers in the new file, at most five. Reply LGTM if there is nothing worth saying.
+
+`
+
+var reviewLinePattern = regexp.MustCompile(`^\s*[-*]?\s*` + "`?" + `([^\s:` + "`" + `]+):(\d+)` + "`?" + `:?\s*(.+)$`)
+
+// ReviewSuggestion is one inline suggestion from a watch mode review
+type ReviewSuggestion struct {
+	Path    string `json:"path"`
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+// WatchReview is a review of the files saved since the previous one
+type WatchReview struct {
+	ID          string             `json:"id"`
+	Files       []string           `json:"files"`
+	Suggestions []ReviewSuggestion `json:"suggestions"`
+	Provider    string             `json:"provider,omitempty"`
+	ReviewedAt  time.Time          `json:"reviewedAt"`
+	Error       string             `json:"error,omitempty"`
+}
+
+// WatchStatus reports watch mode
+type WatchStatus struct {
+	Watching bool   `json:"watching"`
+	Dir      string `json:"dir,omitempty"`
+	Files    int    `json:"files"`
+	Reviews  int    `json:"reviews"`
+}
+
+// watchedFile is a file as of the last review
+type watchedFile struct {
+	modTime time.Time
+	size    int64
+	content string
+}
+
+// fileWatch polls a directory for saved files and reviews what changed
+type fileWatch struct {
+	dir      string
+	interval time.Duration
+	cancel   context.CancelFunc
+	done     chan struct{}
+
+	mu      sync.Mutex
+	files   map[string]watchedFile
+	reviews int
+}
+
+// scan returns the text files under the watched directory, by relative path
+func (w *fileWatch) scan() map[string]fs.FileInfo {
+	found := make(map[string]fs.FileInfo)
+	filepath.WalkDir(w.dir, func(path string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if e.IsDir() {
+			if path != w.dir && (skipIndexDir(e.Name()) || strings.HasPrefix(e.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if len(found) >= maxWatchedFiles {
+			return filepath.SkipAll
+		}
+		info, err := e.Info()
+		if err != nil || !info.Mode().IsRegular() || info.Size() > maxWatchedFileBytes || strings.HasPrefix(e.Name(), ".") {
+			return nil
+		}
+		rel, _ := filepath.Rel(w.dir, path)
+		found[filepath.ToSlash(rel)] = info
+		return nil
+	})
+	return found
+}
+
+// readText returns a file's contents, or false for binary files
+func readText(path string) (string, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil || bytes.IndexByte(data, 0) != -1 || !utf8.Valid(data) {
+		return "", false
+	}
+	return string(data), true
+}
+
+// baseline records every file's current contents as reviewed
+func (w *fileWatch) baseline() {
+	files := make(map[string]watchedFile)
+	for rel, info := range w.scan() {
+		if content, ok := readText(filepath.Join(w.dir, filepath.FromSlash(rel))); ok {
+			files[rel] = watchedFile{modTime: info.ModTime(), size: info.Size(), content: content}
+		}
+	}
+	w.mu.Lock()
+	w.files = files
+	w.mu.Unlock()
+}
+
+// changes returns the diffs of files saved since the last review, without
+// recording them as reviewed
+func (w *fileWatch) changes() (map[string]watchedFile, []string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	updated := make(map[string]watchedFile)
+	var diffs []string
+	for rel, info := range w.scan() {
+		old, seen := w.files[rel]
+		if seen && old.modTime.Equal(info.ModTime()) && old.size == info.Size() {
+			continue
+		}
+		content, ok := readText(filepath.Join(w.dir, filepath.FromSlash(rel)))
+		if !ok {
+			continue
+		}
+		f := watchedFile{modTime: info.ModTime(), size: info.Size(), content: content}
+		updated[rel] = f
+		if content != old.content {
+			diffs = append(diffs, unifiedDiff(rel, old.content, content))
+		}
+	}
+	sort.Strings(diffs)
+	return updated, diffs
+}
+
+// record marks files as reviewed
+func (w *fileWatch) record(updated map[string]watchedFile, reviewed bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for rel, f := range updated {
+		w.files[rel] = f
+	}
+	if reviewed {
+		w.reviews++
+	}
+}
+
+// unifiedDiff renders the change to a file from old to new as a unified diff
+func unifiedDiff(path, old, new string) string {
+	return labeledDiff("a/"+path, "b/"+path, old, new)
+}
+
+// labeledDiff renders the change from old to new as a unified diff with the
+// given labels for the two sides
+func labeledDiff(oldLabel, newLabel, old, new string) string {
+	a, b := splitLines(old), splitLines(new)
+	match := matchLines(a, b)
+
+	// Pair each line of a with its match, marking the rest as removed or added
+	type op struct {
+		kind byte
+		line string
+		// ai and bi are the line's 0-based positions in a and b
+		ai, bi int
+	}
+	var ops []op
+	j := 0
+	for i, line := range a {
+		if match[i] == -1 {
+			ops = append(ops, op{'-', line, i, j})
+			continue
+		}
+		for ; j < match[i]; j++ {
+			ops = append(ops, op{'+', b[j], i, j})
+		}
+		ops = append(ops, op{' ', line, i, j})
+		j++
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, op{'+', b[j], len(a), j})
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", oldLabel, newLabel)
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+		// A hunk runs from diffContext lines before a change to diffContext
+		// lines after the last change within reach
+		start := max(0, i-diffContext)
+		end := i
+		for k := i; k < len(ops); k++ {
+			if ops[k].kind != ' ' {
+				end = k
+			} else if k-end > 2*diffContext {
+				break
+			}
+		}
+		end = min(len(ops), end+diffContext+1)
+		oldCount, newCount := 0, 0
+		for _, o := range ops[start:end] {
+			if o.kind != '+' {
+				oldCount++
+			}
+			if o.kind != '-' {
+				newCount++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", ops[start].ai+1, oldCount, ops[start].bi+1, newCount)
+		for _, o := range ops[start:end] {
+			out.WriteByte(o.kind)
+			out.WriteString(strings.TrimSuffix(o.line, "\n"))
+			out.WriteByte('\n')
+		}
+		i = end
+	}
+	return out.String()
+}
+
+// parseReview reads suggestions in <path>:<line>: <text> form from a reply
+func parseReview(reply string, files []string) []ReviewSuggestion {
+	known := make(map[string]bool)
+	for _, f := range files {
+		known[f] = true
+	}
+	var out []ReviewSuggestion
+	for _, line := range strings.Split(reply, "\n") {
+		m := reviewLinePattern.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		path := strings.TrimPrefix(m[1], "b/")
+		n, _ := strconv.Atoi(m[2])
+		// Suggestions about files that were not saved are made up
+		if !known[path] {
+			continue
+		}
+		out = append(out, ReviewSuggestion{Path: path, Line: n, Message: strings.TrimSpace(m[3])})
+	}
+	return out
+}
+
+// runWatch polls until ctx ends, reviewing saves once they have settled for a poll
+func (a *App) runWatch(ctx context.Context, w *fileWatch) {
+	defer close(w.done)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	pending := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		updated, diffs := w.changes()
+		if len(updated) != pending {
+			// Still being saved; wait for the files to settle
+			pending = len(updated)
+			continue
+		}
+		pending = 0
+		if len(updated) == 0 {
+			continue
+		}
+		if len(diffs) > 0 {
+			a.reviewChanges(ctx, updated, diffs)
+		}
+		w.record(updated, len(diffs) > 0)
+	}
+}
+
+// reviewChanges asks a cheap model to review saved diffs and emits the
+// suggestions as a "watch:review" event
+func (a *App) reviewChanges(ctx context.Context, updated map[string]watchedFile, diffs []string) {
+	review := WatchReview{ID: newID(), ReviewedAt: time.Now().UTC()}
+	for rel := range updated {
+		review.Files = append(review.Files, rel)
+	}
+	sort.Strings(review.Files)
+
+	diff := strings.Join(diffs, "")
+	if len(diff) > maxReviewDiffChars {
+		diff = diff[:maxReviewDiffChars] + "\n[diff truncated]\n"
+	}
+	temperature := 0.2
+	result, err := a.generate(generateRequest{
+		Prompt:      reviewInstructions + diff,
+		Temperature: &temperature,
+		MaxTokens:   reviewMaxTokens,
+		// Reviews go to the cheap model follow-ups use, preferably a local one
+		Provider: a.followUpProvider(),
+		Context:  ctx,
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		review.Error = err.Error()
+	} else {
+		review.Provider = result.Provider
+		review.Suggestions = parseReview(result.Response, review.Files)
+	}
+	a.emit(EventWatchReview, review)
+}
+
+// StartWatch turns on watch mode for dir (the workspace when empty): each
+// time files in it are saved, a fast model, preferably a local one, reviews
+// the diff since the last review and its suggestions arrive as
+// "watch:review" events. Reviews never block saving or editing. Starting a
+// watch replaces any running one.
+func (a *App) StartWatch(dir string) (WatchStatus, error) {
+	a.telemetry.recordFeature("watch_mode")
+	if dir == "" {
+		dir = a.GetWorkspace()
+	}
+	if dir == "" {
+		return WatchStatus{}, fmt.Errorf("no directory to watch; open a workspace or pass one")
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return WatchStatus{}, err
+	}
+	if info, err := os.Stat(abs); err != nil {
+		return WatchStatus{}, err
+	} else if !info.IsDir() {
+		return WatchStatus{}, fmt.Errorf("%s is not a directory", abs)
+	}
+
+	a.StopWatch()
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &fileWatch{dir: abs, interval: watchPollInterval, cancel: cancel, done: make(chan struct{})}
+	w.baseline()
+
+	a.watchMutex.Lock()
+	a.watch = w
+	a.watchMutex.Unlock()
+	go a.runWatch(ctx, w)
+	return a.GetWatchStatus(), nil
+}
+
+// StopWatch turns watch mode off, cancelling a review in progress
+func (a *App) StopWatch() {
+	a.watchMutex.Lock()
+	w := a.watch
+	a.watch = nil
+	a.watchMutex.Unlock()
+	if w != nil {
+		w.cancel()
+		<-w.done
+	}
+}
+
+// GetWatchStatus reports whether watch mode is on and what it watches
+func (a *App) GetWatchStatus() WatchStatus {
+	a.watchMutex.Lock()
+	w := a.watch
+	a.watchMutex.Unlock()
+	if w == nil {
+		return WatchStatus{}
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return WatchStatus{Watching: true, Dir: w.dir, Files: len(w.files), Reviews: w.reviews}
+}
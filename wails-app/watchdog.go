@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// defaultStallTimeout is how long a streamed reply may go without new text
+// before the watchdog reports it stalled. It is generous because a local
+// model can take a while to load before its first token.
+const defaultStallTimeout = 60 * time.Second
+
+// stallTimeout returns how long a provider's streamed replies may go
+// silent, or 0 when the watchdog is off for it
+func stallTimeout(config ProviderConfig) time.Duration {
+	switch {
+	case config.StallTimeoutSeconds < 0:
+		return 0
+	case config.StallTimeoutSeconds > 0:
+		return time.Duration(config.StallTimeoutSeconds) * time.Second
+	default:
+		return defaultStallTimeout
+	}
+}
+
+// stallWatch is the watchdog of one request. Each attempt arms it, every
+// streamed chunk resets it, and when it fires the user decides with
+// ResolveStall whether to keep waiting, retry or cancel.
+type stallWatch struct {
+	app     *App
+	rec     *RequestRecord
+	timeout time.Duration
+
+	mu        sync.Mutex
+	timer     *time.Timer
+	cancel    context.CancelFunc
+	stalledAt time.Time
+	streamed  bool
+	// decision is "retry" or "cancel" once the user has chosen to stop the attempt
+	decision string
+	// settled is set between an attempt ending and the next starting
+	settled bool
+}
+
+// watchStalls registers a watchdog for a streamed request, or returns nil
+// when the request is not streamed or the provider has it turned off
+func (a *App) watchStalls(provider Provider, req generateRequest, rec *RequestRecord) *stallWatch {
+	_, streaming := provider.(StreamingProvider)
+	timeout := stallTimeout(provider.GetConfig())
+	if !streaming || req.OnChunk == nil || req.unstreamed || timeout == 0 {
+		return nil
+	}
+	w := &stallWatch{app: a, rec: rec, timeout: timeout}
+	a.stallsMutex.Lock()
+	if a.stalls == nil {
+		a.stalls = make(map[string]*stallWatch)
+	}
+	a.stalls[rec.ID] = w
+	a.stallsMutex.Unlock()
+	return w
+}
+
+// attempt arms the watchdog for one send and returns the context and chunk
+// callback to send it with
+func (w *stallWatch) attempt(parent context.Context, req generateRequest) (context.Context, func(string)) {
+	if w == nil {
+		return parent, req.OnChunk
+	}
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithCancel(parent)
+
+	w.mu.Lock()
+	w.cancel = cancel
+	w.decision = ""
+	w.settled = false
+	w.streamed = false
+	w.stalledAt = time.Time{}
+	w.timer = time.AfterFunc(w.timeout, w.fire)
+	w.mu.Unlock()
+
+	onChunk := req.OnChunk
+	return ctx, func(chunk string) {
+		w.mu.Lock()
+		w.streamed = true
+		if !w.stalledAt.IsZero() {
+			w.recoveredLocked("resumed")
+		}
+		w.timer.Reset(w.timeout)
+		w.mu.Unlock()
+		onChunk(chunk)
+	}
+}
+
+func (w *stallWatch) fire() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.settled || w.decision != "" || !w.stalledAt.IsZero() {
+		return
+	}
+	w.stalledAt = time.Now()
+	w.rec.Stalls++
+	w.app.emit(EventRequestStalled, RequestStalledEvent{
+		RequestID:  w.rec.ID,
+		Provider:   w.rec.Provider,
+		ProviderID: w.rec.ProviderID,
+		SilentMs:   w.timeout.Milliseconds(),
+		// Retrying would repeat text that was already shown, so it is only offered before any arrives
+		Options: w.optionsLocked(),
+	})
+}
+
+func (w *stallWatch) optionsLocked() []string {
+	if w.streamed {
+		return []string{"wait", "cancel"}
+	}
+	return []string{"wait", "retry", "cancel"}
+}
+
+// recoveredLocked ends a stall and reports how it was resolved
+func (w *stallWatch) recoveredLocked(how string) {
+	w.rec.StalledMs += time.Since(w.stalledAt).Milliseconds()
+	w.stalledAt = time.Time{}
+	w.app.emit(EventRequestStallResolved, StallResolvedEvent{RequestID: w.rec.ID, Provider: w.rec.Provider, Action: how})
+}
+
+func (w *stallWatch) resolve(action string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.settled || w.stalledAt.IsZero() {
+		return fmt.Errorf("request %q is not stalled", w.rec.ID)
+	}
+	switch action {
+	case "wait":
+		w.recoveredLocked(action)
+		w.timer.Reset(w.timeout)
+	case "retry":
+		if w.streamed {
+			return fmt.Errorf("part of the reply has already arrived; cancel and send the prompt again instead")
+		}
+		fallthrough
+	case "cancel":
+		w.recoveredLocked(action)
+		w.decision = action
+		w.cancel()
+	default:
+		return fmt.Errorf("unknown stall action %q; use wait, retry or cancel", action)
+	}
+	return nil
+}
+
+// settle stops the watchdog after an attempt and reports whether the user
+// asked for the attempt to be retried
+func (w *stallWatch) settle() (retry bool) {
+	if w == nil {
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.settled = true
+	w.timer.Stop()
+	if !w.stalledAt.IsZero() {
+		w.rec.StalledMs += time.Since(w.stalledAt).Milliseconds()
+		w.stalledAt = time.Time{}
+	}
+	w.cancel()
+	return w.decision == "retry"
+}
+
+// stop unregisters the watchdog once the request is finished
+func (w *stallWatch) stop() {
+	if w == nil {
+		return
+	}
+	w.app.stallsMutex.Lock()
+	delete(w.app.stalls, w.rec.ID)
+	w.app.stallsMutex.Unlock()
+}
+
+// ResolveStall answers a "request:stalled" event: "wait" keeps waiting for
+// the reply, "retry" abandons the stalled attempt and sends the request
+// again, and "cancel" stops it with "request cancelled"
+func (a *App) ResolveStall(requestID string, action string) error {
+	a.stallsMutex.Lock()
+	w, ok := a.stalls[requestID]
+	a.stallsMutex.Unlock()
+	if !ok {
+		return fmt.Errorf("request %q is not in progress", requestID)
+	}
+	return w.resolve(action)
+}
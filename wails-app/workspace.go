@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// OpenWorkspace sets the project directory the app works against. With the
+// workspace digest on, a conversation summing up what changed since the
+// last session there is started in the background ("workspace:digest").
+func (a *App) OpenWorkspace(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", abs)
+	}
+
+	a.providersMutex.Lock()
+	defer a.providersMutex.Unlock()
+
+	if a.workspace == abs {
+		return nil
+	}
+	a.visits.leave(a.workspace)
+	a.workspace = abs
+	a.currentFile = ""
+	a.lastSession = a.visits.lastSeen(abs)
+	if a.workspaceDigest && !a.lastSession.IsZero() {
+		go a.startDigest(abs, a.lastSession)
+	}
+	return a.saveConfigLocked()
+}
+
+// resumeWorkspace picks up the workspace restored from the config at startup
+func (a *App) resumeWorkspace() {
+	a.providersMutex.Lock()
+	defer a.providersMutex.Unlock()
+
+	if a.workspace == "" {
+		return
+	}
+	a.lastSession = a.visits.lastSeen(a.workspace)
+	if a.workspaceDigest && !a.lastSession.IsZero() {
+		go a.startDigest(a.workspace, a.lastSession)
+	}
+}
+
+// GetWorkspace returns the open workspace directory, or "" if none
+func (a *App) GetWorkspace() string {
+	a.providersMutex.RLock()
+	defer a.providersMutex.RUnlock()
+	return a.workspace
+}
+
+// workspacePath resolves a slash-separated path relative to the active
+// conversation's directory, rejecting paths that would escape it
+func (a *App) workspacePath(rel string) (string, error) {
+	return a.sessionPath("", rel)
+}
+
+// sessionPath resolves a slash-separated path relative to a conversation's
+// directory (the active conversation when sessionID is empty), rejecting
+// paths that would escape it
+func (a *App) sessionPath(sessionID, rel string) (string, error) {
+	root := a.sessionRoot(sessionID)
+	if root == "" {
+		return "", fmt.Errorf("no workspace is open")
+	}
+	rel = filepath.FromSlash(rel)
+	if !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("path %q is outside the workspace", rel)
+	}
+	return filepath.Join(root, rel), nil
+}
+
+// sandboxedPath is sessionPath with symlinks resolved, also rejecting paths
+// that leave the conversation's directory through one. The path need not
+// exist yet.
+func (a *App) sandboxedPath(sessionID, rel string) (string, error) {
+	path, err := a.sessionPath(sessionID, rel)
+	if err != nil {
+		return "", err
+	}
+	root, err := filepath.EvalSymlinks(a.sessionRoot(sessionID))
+	if err != nil {
+		return "", err
+	}
+	// Resolve the longest part of the path that exists
+	resolved, missing := path, ""
+	for {
+		r, err := filepath.EvalSymlinks(resolved)
+		if err == nil {
+			resolved = filepath.Join(r, missing)
+			break
+		}
+		if !os.IsNotExist(err) || filepath.Dir(resolved) == resolved {
+			return "", fmt.Errorf("%s: %v", rel, fileError(err))
+		}
+		// A link to nowhere could still point out of the directory
+		if _, err := os.Lstat(resolved); err == nil {
+			return "", fmt.Errorf("path %q goes through a broken symlink", rel)
+		}
+		missing = filepath.Join(filepath.Base(resolved), missing)
+		resolved = filepath.Dir(resolved)
+	}
+	if r, err := filepath.Rel(root, resolved); err != nil || !filepath.IsLocal(r) {
+		return "", fmt.Errorf("path %q is outside the workspace", rel)
+	}
+	return resolved, nil
+}
+
+// sessionRoot returns the directory a conversation's tools work in: the one
+// it is pinned to, or else the open workspace
+func (a *App) sessionRoot(sessionID string) string {
+	if sessionID == "" {
+		sessionID = a.GetActiveSession()
+	}
+	if s, ok := a.sessions.get(sessionID); ok && s.WorkingDir != "" {
+		return s.WorkingDir
+	}
+	return a.GetWorkspace()
+}
+
+// SetConversationDirectory pins a conversation to a directory, so its file,
+// command and git tools work there whatever workspace is open. An empty
+// path unpins it.
+func (a *App) SetConversationDirectory(sessionID string, path string) error {
+	a.telemetry.recordFeature("conversation_directory")
+	dir := ""
+	if path != "" {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		info, err := os.Stat(abs)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", abs)
+		}
+		dir = abs
+	}
+	_, err := a.sessions.update(sessionID, func(s *Session) error {
+		s.WorkingDir = dir
+		return nil
+	})
+	return err
+}
+
+// GetConversationDirectory returns the directory a conversation's tools work
+// in: the one it is pinned to, else the open workspace, or "" if neither
+func (a *App) GetConversationDirectory(sessionID string) (string, error) {
+	if _, ok := a.sessions.get(sessionID); !ok {
+		return "", fmt.Errorf("session %q not found", sessionID)
+	}
+	return a.sessionRoot(sessionID), nil
+}